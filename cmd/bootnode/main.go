@@ -31,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
 func main() {
@@ -40,6 +41,7 @@ func main() {
 		nodeKeyFile = flag.String("nodekey", "", "private key filename")
 		nodeKeyHex  = flag.String("nodekeyhex", "", "private key as hex (for testing)")
 		natdesc     = flag.String("nat", "none", "port mapping mechanism (any|none|upnp|pmp|extip:<IP>)")
+		netrestrict = flag.String("netrestrict", "", "restrict network communication to the given IP networks (CIDR masks)")
 
 		nodeKey *ecdsa.PrivateKey
 		err     error
@@ -56,6 +58,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("-nat: %v", err)
 	}
+	var restrictList *netutil.Netlist
+	if *netrestrict != "" {
+		if restrictList, err = netutil.ParseNetlist(*netrestrict); err != nil {
+			log.Fatalf("-netrestrict: %v", err)
+		}
+	}
 	switch {
 	case *nodeKeyFile == "" && *nodeKeyHex == "":
 		log.Fatal("Use -nodekey or -nodekeyhex to specify a private key")
@@ -71,7 +79,7 @@ func main() {
 		}
 	}
 
-	if _, err := discover.ListenUDP(nodeKey, *listenAddr, natm, ""); err != nil {
+	if _, err := discover.ListenUDP(nodeKey, *listenAddr, natm, "", restrictList); err != nil {
 		log.Fatal(err)
 	}
 	select {}
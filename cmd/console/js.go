@@ -211,12 +211,14 @@ func (js *jsre) apiBindings(ipcpath string) {
 	// load only supported API's in javascript runtime
 	shortcuts := "var eth = web3.eth; "
 	for apiName, _ := range apis {
-		if apiName == api.Web3ApiName || apiName == api.EthApiName {
+		if apiName == api.Web3ApiName {
 			continue // manually mapped
 		}
 
 		if err = js.re.Compile(fmt.Sprintf("%s.js", apiName), api.Javascript(apiName)); err == nil {
-			shortcuts += fmt.Sprintf("var %s = web3.%s; ", apiName, apiName)
+			if apiName != api.EthApiName { // manually mapped
+				shortcuts += fmt.Sprintf("var %s = web3.%s; ", apiName, apiName)
+			}
 		} else {
 			utils.Fatalf("Error loading %s.js: %v", apiName, err)
 		}
@@ -22,6 +22,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/rpc/shared"
 	"github.com/ethereum/go-ethereum/xeth"
 	"github.com/robertkrimen/otto"
 	"gopkg.in/fatih/set.v0"
@@ -42,6 +43,12 @@ func (js *jsre) adminBindings() {
 	t, _ := js.re.Get("admin")
 	admin := t.Object()
 	admin.Set("addPeer", js.addPeer)
+	admin.Set("removePeer", js.removePeer)
+	admin.Set("addTrustedPeer", js.addTrustedPeer)
+	admin.Set("removeTrustedPeer", js.removeTrustedPeer)
+	admin.Set("banPeer", js.banPeer)
+	admin.Set("unbanPeer", js.unbanPeer)
+	admin.Set("bans", js.bans)
 	admin.Set("startRPC", js.startRPC)
 	admin.Set("stopRPC", js.stopRPC)
 	admin.Set("nodeInfo", js.nodeInfo)
@@ -487,6 +494,7 @@ func (js *jsre) startRPC(call otto.FunctionCall) otto.Value {
 		ListenAddress: addr,
 		ListenPort:    uint(port),
 		CorsDomain:    corsDomain,
+		BatchLimits:   shared.DefaultBatchLimits,
 	}
 
 	xeth := xeth.New(js.ethereum, nil)
@@ -507,19 +515,97 @@ func (js *jsre) stopRPC(call otto.FunctionCall) otto.Value {
 }
 
 func (js *jsre) addPeer(call otto.FunctionCall) otto.Value {
+	return js.modifyNodes(call, js.ethereum.AddPeer, js.ethereum.SaveStaticNodes)
+}
+
+func (js *jsre) removePeer(call otto.FunctionCall) otto.Value {
+	return js.modifyNodes(call, js.ethereum.RemovePeer, js.ethereum.SaveStaticNodes)
+}
+
+func (js *jsre) addTrustedPeer(call otto.FunctionCall) otto.Value {
+	return js.modifyNodes(call, js.ethereum.AddTrustedPeer, js.ethereum.SaveTrustedNodes)
+}
+
+func (js *jsre) removeTrustedPeer(call otto.FunctionCall) otto.Value {
+	return js.modifyNodes(call, js.ethereum.RemoveTrustedPeer, js.ethereum.SaveTrustedNodes)
+}
+
+// modifyNodes applies op to the node URL given as the first argument.
+// If the optional second argument is true, the modified node list is
+// written back to the data directory using save.
+func (js *jsre) modifyNodes(call otto.FunctionCall, op func(string) error, save func() error) otto.Value {
 	nodeURL, err := call.Argument(0).ToString()
 	if err != nil {
 		fmt.Println(err)
 		return otto.FalseValue()
 	}
-	err = js.ethereum.AddPeer(nodeURL)
+	if err := op(nodeURL); err != nil {
+		fmt.Println(err)
+		return otto.FalseValue()
+	}
+	if persist, _ := call.Argument(1).ToBoolean(); persist {
+		if err := save(); err != nil {
+			fmt.Println(err)
+			return otto.FalseValue()
+		}
+	}
+	return otto.TrueValue()
+}
+
+// banPeer bans the target given as the first argument. The optional
+// second and third arguments are the ban duration in seconds and the
+// reason. Without a duration, the ban never expires.
+func (js *jsre) banPeer(call otto.FunctionCall) otto.Value {
+	target, err := call.Argument(0).ToString()
 	if err != nil {
 		fmt.Println(err)
 		return otto.FalseValue()
 	}
+	var seconds int64
+	if call.Argument(1).IsDefined() {
+		if seconds, err = call.Argument(1).ToInteger(); err != nil {
+			fmt.Println(err)
+			return otto.FalseValue()
+		}
+		if seconds < 0 {
+			fmt.Println("ban duration must not be negative")
+			return otto.FalseValue()
+		}
+	}
+	var reason string
+	if call.Argument(2).IsDefined() {
+		if reason, err = call.Argument(2).ToString(); err != nil {
+			fmt.Println(err)
+			return otto.FalseValue()
+		}
+	}
+	if err := js.ethereum.BanPeer(target, time.Duration(seconds)*time.Second, reason); err != nil {
+		fmt.Println(err)
+		return otto.FalseValue()
+	}
 	return otto.TrueValue()
 }
 
+func (js *jsre) unbanPeer(call otto.FunctionCall) otto.Value {
+	target, err := call.Argument(0).ToString()
+	if err != nil {
+		fmt.Println(err)
+		return otto.FalseValue()
+	}
+	ok, err := js.ethereum.UnbanPeer(target)
+	if err != nil {
+		fmt.Println(err)
+		return otto.FalseValue()
+	}
+	v, _ := call.Otto.ToValue(ok)
+	return v
+}
+
+func (js *jsre) bans(call otto.FunctionCall) otto.Value {
+	v, _ := call.Otto.ToValue(js.ethereum.Bans())
+	return v
+}
+
 func (js *jsre) unlock(call otto.FunctionCall) otto.Value {
 	addr, err := call.Argument(0).ToString()
 	if err != nil {
@@ -220,8 +220,14 @@ JavaScript API. See https://github.com/ethereum/go-ethereum/wiki/Javascipt-Conso
 		utils.PasswordFileFlag,
 		utils.GenesisNonceFlag,
 		utils.BootnodesFlag,
+		utils.ForkCheckpointsFlag,
+		utils.CheckpointFlag,
+		utils.MaxReorgDepthFlag,
 		utils.DataDirFlag,
 		utils.BlockchainVersionFlag,
+		utils.FastSyncFlag,
+		utils.LightModeFlag,
+		utils.LightServFlag,
 		utils.JSpathFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
@@ -234,14 +240,23 @@ JavaScript API. See https://github.com/ethereum/go-ethereum/wiki/Javascipt-Conso
 		utils.NATFlag,
 		utils.NatspecEnabledFlag,
 		utils.NoDiscoverFlag,
+		utils.NetrestrictFlag,
+		utils.CaptureFileFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
 		utils.RPCEnabledFlag,
 		utils.RPCListenAddrFlag,
 		utils.RPCPortFlag,
+		utils.RPCBatchLimitFlag,
+		utils.RPCBatchResponseLimitFlag,
 		utils.IPCDisabledFlag,
 		utils.IPCApiFlag,
 		utils.IPCPathFlag,
+		utils.WSEnabledFlag,
+		utils.WSListenAddrFlag,
+		utils.WSPortFlag,
+		utils.WSApiFlag,
+		utils.WSAllowedOriginsFlag,
 		utils.WhisperEnabledFlag,
 		utils.VMDebugFlag,
 		utils.ProtocolVersionFlag,
@@ -392,6 +407,11 @@ func startEth(ctx *cli.Context, eth *eth.Ethereum) {
 			utils.Fatalf("Error string IPC: %v", err)
 		}
 	}
+	if ctx.GlobalBool(utils.WSEnabledFlag.Name) {
+		if err := utils.StartWS(eth, ctx); err != nil {
+			utils.Fatalf("Error starting WebSocket: %v", err)
+		}
+	}
 	if ctx.GlobalBool(utils.RPCEnabledFlag.Name) {
 		if err := utils.StartRPC(eth, ctx); err != nil {
 			utils.Fatalf("Error starting RPC: %v", err)
@@ -0,0 +1,131 @@
+/*
+	This file is part of go-ethereum
+
+	go-ethereum is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	go-ethereum is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with go-ethereum.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+var errNotFound = errors.New("not found")
+
+// overlayDatabase serves reads from a node's database but keeps all writes
+// in memory, so replaying a capture never modifies the data directory and
+// every replay starts from the same chain.
+type overlayDatabase struct {
+	base common.Database
+
+	lock    sync.RWMutex
+	written map[string][]byte
+	deleted map[string]bool
+}
+
+func newOverlayDatabase(base common.Database) *overlayDatabase {
+	return &overlayDatabase{
+		base:    base,
+		written: make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+}
+
+func (db *overlayDatabase) Put(key []byte, value []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.written[string(key)] = common.CopyBytes(value)
+	delete(db.deleted, string(key))
+}
+
+func (db *overlayDatabase) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if value, ok := db.written[string(key)]; ok {
+		return value, nil
+	}
+	if db.deleted[string(key)] {
+		return nil, errNotFound
+	}
+	return db.base.Get(key)
+}
+
+func (db *overlayDatabase) Delete(key []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.written, string(key))
+	db.deleted[string(key)] = true
+	return nil
+}
+
+func (db *overlayDatabase) Flush() error { return nil }
+func (db *overlayDatabase) Close()       { db.base.Close() }
+
+// replayChain is the chain the eth handler runs on during replay.
+type replayChain struct {
+	chain                     *core.ChainManager
+	mux                       *event.TypeMux
+	blockDb, stateDb, extraDb common.Database
+}
+
+// openChain loads the chain stored in the data directory of the node the
+// capture was taken on, so the handler's status matches the recorded one.
+// Without a data directory, the chain contains only the genesis block.
+func openChain(datadir string, genesisNonce uint64) (*replayChain, error) {
+	c := &replayChain{mux: new(event.TypeMux)}
+	if datadir == "" {
+		db, _ := ethdb.NewMemDatabase()
+		c.blockDb, c.stateDb, c.extraDb = db, db, db
+	} else {
+		dbs := make([]common.Database, 3)
+		for i, name := range []string{"blockchain", "state", "extra"} {
+			db, err := ethdb.NewLDBDatabase(filepath.Join(datadir, name))
+			if err != nil {
+				for _, db := range dbs[:i] {
+					db.Close()
+				}
+				return nil, err
+			}
+			dbs[i] = newOverlayDatabase(db)
+		}
+		c.blockDb, c.stateDb, c.extraDb = dbs[0], dbs[1], dbs[2]
+	}
+	genesis := core.GenesisBlock(genesisNonce, c.stateDb)
+	chain, err := core.NewChainManager(genesis, c.blockDb, c.stateDb, core.FakePow{}, c.mux)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	chain.SetProcessor(core.NewBlockProcessor(c.stateDb, c.extraDb, core.FakePow{}, chain, c.mux))
+	c.chain = chain
+	return c, nil
+}
+
+func (c *replayChain) close() {
+	c.blockDb.Close()
+	if c.stateDb != c.blockDb {
+		c.stateDb.Close()
+		c.extraDb.Close()
+	}
+}
@@ -0,0 +1,165 @@
+/*
+	This file is part of go-ethereum
+
+	go-ethereum is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	go-ethereum is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with go-ethereum.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// p2preplay replays protocol message captures recorded with geth --capture.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/whisper"
+)
+
+var (
+	listMode     = flag.Bool("list", false, "print the records of the capture and exit")
+	peerFlag     = flag.String("peer", "", "hex prefix of the node ID whose messages should be replayed (default: first peer)")
+	protoFlag    = flag.String("proto", "eth", "protocol handler to replay into (eth or shh)")
+	networkID    = flag.Int("networkid", eth.NetworkId, "network identifier of the eth handler")
+	datadir      = flag.String("datadir", "", "data directory of the node the capture was taken on (default: genesis-only chain)")
+	genesisNonce = flag.Int("genesisnonce", 42, "genesis nonce of the eth handler's chain")
+	idle         = flag.Duration("idle", 2*time.Second, "time to wait for handler output after the last message")
+	verbosity    = flag.Int("verbosity", 0, "log verbosity of the protocol handler")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[options] <capture file>")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, `
+Feeds the messages a peer sent to us, as recorded with geth --capture,
+into a fresh protocol handler and prints the messages it sends back.
+The eth handler runs on the chain in --datadir, which must be the chain the
+capture was taken against for the status handshake to succeed. The node must
+not be running. Changes made during replay are kept in memory only, so the
+data directory is left untouched. Without --datadir, the chain contains only
+the genesis block. Proof-of-work is not verified.`)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	glog.SetV(*verbosity)
+	glog.SetToStderr(true)
+
+	fd, err := os.Open(flag.Arg(0))
+	if err != nil {
+		die(err)
+	}
+	recs, err := p2p.ReadCapture(fd)
+	fd.Close()
+	if err != nil {
+		die("error reading capture:", err)
+	}
+	if *listMode {
+		for _, rec := range recs {
+			fmt.Println(rec)
+		}
+		return
+	}
+
+	peer, version, err := selectPeer(recs, *peerFlag, *protoFlag)
+	if err != nil {
+		die(err)
+	}
+	proto, stop, err := makeProtocol(*protoFlag, version, *datadir, uint64(*genesisNonce))
+	if err != nil {
+		die(err)
+	}
+	defer stop()
+	fmt.Printf("replaying %s/%d messages of peer %x\n", proto.Name, proto.Version, peer[:8])
+	out, err := p2p.Replay(recs, peer, proto, *idle)
+	for _, rec := range out {
+		fmt.Println(rec)
+	}
+	if err != nil {
+		stop()
+		die("handler returned:", err)
+	}
+}
+
+// selectPeer returns the first peer in the capture whose ID starts
+// with the given hex prefix and that ran the given protocol, along
+// with the protocol version recorded for it.
+func selectPeer(recs []*p2p.CaptureRecord, prefix, proto string) (discover.NodeID, uint, error) {
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, "0x"))
+	for _, rec := range recs {
+		if rec.Protocol == proto && strings.HasPrefix(hex.EncodeToString(rec.Peer[:]), prefix) {
+			return rec.Peer, rec.Version, nil
+		}
+	}
+	return discover.NodeID{}, 0, fmt.Errorf("no %s records for peer %q in capture", proto, prefix)
+}
+
+// makeProtocol creates the handler to replay into, running the given
+// protocol version. The returned function shuts it down and releases
+// its databases.
+func makeProtocol(name string, version uint, datadir string, genesisNonce uint64) (p2p.Protocol, func(), error) {
+	switch name {
+	case "eth":
+		c, err := openChain(datadir, genesisNonce)
+		if err != nil {
+			return p2p.Protocol{}, nil, err
+		}
+		var (
+			chain  = c.chain
+			txpool = core.NewTxPool(c.mux, chain.State, chain.GasLimit)
+			dl     = downloader.New(downloader.FullSync, c.stateDb, c.mux, chain.HasBlock, chain.GetBlock, chain.CurrentBlock, chain.VerifyHeaderPoW, nil, nil)
+			pm     = eth.NewProtocolManager(eth.ProtocolVersion, *networkID, c.mux, txpool, chain, c.stateDb, c.extraDb, dl, nil)
+		)
+		for _, proto := range pm.SubProtocols {
+			if proto.Version == version {
+				pm.Start()
+				stop := func() {
+					pm.Stop()
+					c.close()
+				}
+				return proto, stop, nil
+			}
+		}
+		c.close()
+		return p2p.Protocol{}, nil, fmt.Errorf("unsupported eth version %d", version)
+	case "shh":
+		shh := whisper.New()
+		if proto := shh.Protocol(); proto.Version != version {
+			return p2p.Protocol{}, nil, fmt.Errorf("unsupported shh version %d", version)
+		}
+		shh.Start()
+		return shh.Protocol(), shh.Stop, nil
+	default:
+		return p2p.Protocol{}, nil, fmt.Errorf("unknown protocol %q", name)
+	}
+}
+
+func die(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(1)
+}
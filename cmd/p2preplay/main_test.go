@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// makeDatadir writes a chain of n blocks into a node data directory,
+// returning the status the node would announce.
+func makeDatadir(t *testing.T, dir string, n int) (td *big.Int, head, genesis common.Hash) {
+	var dbs []common.Database
+	for _, name := range []string{"blockchain", "state", "extra"} {
+		db, err := ethdb.NewLDBDatabase(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to create database: %v", err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+	var (
+		mux        = new(event.TypeMux)
+		gblock     = core.GenesisBlock(42, dbs[1])
+		chain, err = core.NewChainManager(gblock, dbs[0], dbs[1], core.FakePow{}, mux)
+	)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	proc := core.NewBlockProcessor(dbs[1], dbs[2], core.FakePow{}, chain, mux)
+	chain.SetProcessor(proc)
+	if _, err := chain.InsertChain(core.MakeChain(proc, gblock, n, dbs[1], core.CanonicalSeed)); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return chain.Status()
+}
+
+func statusRecord(peer discover.NodeID, td *big.Int, head, genesis common.Hash) *p2p.CaptureRecord {
+	payload, _ := rlp.EncodeToBytes([]interface{}{uint32(eth.ProtocolVersion), uint32(eth.NetworkId), td, head, genesis})
+	return &p2p.CaptureRecord{Direction: p2p.CaptureIngress, Peer: peer, Protocol: "eth", Version: eth.ProtocolVersion, Code: eth.StatusMsg, Payload: payload}
+}
+
+// Tests that an eth status exchange recorded against a node's chain can be
+// replayed on top of its data directory.
+func TestReplayEthStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p2preplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	td, head, genesis := makeDatadir(t, dir, 5)
+
+	var peer discover.NodeID
+	peer[0] = 1
+	query, _ := rlp.EncodeToBytes([]interface{}{uint64(5), uint64(1), uint64(0), false})
+	recs := []*p2p.CaptureRecord{
+		statusRecord(peer, td, head, genesis),
+		{Direction: p2p.CaptureIngress, Peer: peer, Protocol: "eth", Version: eth.ProtocolVersion, Code: eth.GetBlockHeadersMsg, Payload: query},
+	}
+
+	proto, stop, err := makeProtocol("eth", eth.ProtocolVersion, dir, 42)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	out, err := p2p.Replay(recs, peer, proto, 500*time.Millisecond)
+	stop()
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if len(out) < 2 {
+		t.Fatalf("handler output too short: %v", out)
+	}
+	// The handler announces the stored chain and serves its headers
+	var status struct {
+		ProtocolVersion, NetworkId uint32
+		TD                         *big.Int
+		Head, Genesis              common.Hash
+	}
+	if out[0].Code != eth.StatusMsg || rlp.DecodeBytes(out[0].Payload, &status) != nil {
+		t.Fatalf("expected status, got %v", out[0])
+	}
+	if status.TD.Cmp(td) != 0 || status.Head != head || status.Genesis != genesis {
+		t.Errorf("status mismatch: have td %v head %x genesis %x, want td %v head %x genesis %x", status.TD, status.Head, status.Genesis, td, head, genesis)
+	}
+	var headers []*types.Header
+	if out[1].Code != eth.BlockHeadersMsg || rlp.DecodeBytes(out[1].Payload, &headers) != nil {
+		t.Fatalf("expected headers, got %v", out[1])
+	}
+	if len(headers) != 1 || headers[0].Hash() != head {
+		t.Errorf("header mismatch: have %v, want %x", headers, head)
+	}
+
+	// A handler on a different genesis rejects the recorded status
+	proto, stop, err = makeProtocol("eth", eth.ProtocolVersion, "", 43)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer stop()
+	if _, err := p2p.Replay(recs[:1], peer, proto, 500*time.Millisecond); err == nil {
+		t.Errorf("status with mismatching genesis accepted")
+	}
+}
+
+// Tests that a capture of a peer running an older eth version is replayed
+// into a handler of the recorded version.
+func TestReplayEthVersion(t *testing.T) {
+	var (
+		peer    discover.NodeID
+		version = eth.ProtocolVersions[len(eth.ProtocolVersions)-1]
+	)
+	peer[0] = 1
+	proto, stop, err := makeProtocol("eth", version, "", 42)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer stop()
+
+	// Capture the status the handler announces and record it as the peer's
+	out, err := p2p.Replay(nil, peer, proto, 100*time.Millisecond)
+	if err != nil || len(out) == 0 {
+		t.Fatalf("handler did not announce its status: %v", err)
+	}
+	recs := []*p2p.CaptureRecord{
+		{Direction: p2p.CaptureIngress, Peer: peer, Protocol: "eth", Version: version, Code: eth.StatusMsg, Payload: out[0].Payload},
+	}
+	have, haveVersion, err := selectPeer(recs, "", "eth")
+	if err != nil {
+		t.Fatalf("failed to select peer: %v", err)
+	}
+	if have != peer || haveVersion != version {
+		t.Fatalf("selected peer mismatch: have %x/%d, want %x/%d", have[:8], haveVersion, peer[:8], version)
+	}
+	proto, stop, err = makeProtocol("eth", haveVersion, "", 42)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer stop()
+	if proto.Version != version {
+		t.Fatalf("handler version mismatch: have %d, want %d", proto.Version, version)
+	}
+	if _, err := p2p.Replay(recs, peer, proto, 500*time.Millisecond); err != nil {
+		t.Errorf("replay of eth/%d status failed: %v", version, err)
+	}
+	if _, _, err := makeProtocol("eth", 1, "", 42); err == nil {
+		t.Errorf("handler created for unsupported version")
+	}
+}
+
+// Tests that replaying never writes to the node's data directory.
+func TestOverlayDatabase(t *testing.T) {
+	base, _ := ethdb.NewMemDatabase()
+	base.Put([]byte("a"), []byte("1"))
+	base.Put([]byte("b"), []byte("2"))
+
+	db := newOverlayDatabase(base)
+	db.Put([]byte("a"), []byte("3"))
+	db.Delete([]byte("b"))
+
+	if v, _ := db.Get([]byte("a")); string(v) != "3" {
+		t.Errorf("overlay value mismatch: have %q, want %q", v, "3")
+	}
+	if _, err := db.Get([]byte("b")); err == nil {
+		t.Errorf("deleted key still present in overlay")
+	}
+	if v, _ := base.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("base modified: have %q, want %q", v, "1")
+	}
+	if v, _ := base.Get([]byte("b")); string(v) != "2" {
+		t.Errorf("base key deleted")
+	}
+}
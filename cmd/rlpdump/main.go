@@ -28,6 +28,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -35,23 +36,40 @@ import (
 )
 
 var (
-	hexMode = flag.String("hex", "", "dump given hex data")
-	noASCII = flag.Bool("noascii", false, "don't print ASCII strings readably")
+	hexMode    = flag.String("hex", "", "dump given hex data")
+	noASCII    = flag.Bool("noascii", false, "don't print ASCII strings readably")
+	typeMode   = flag.String("type", "", "decode input as block, header, tx, receipt or p2p and print JSON")
+	encodeMode = flag.Bool("encode", false, "read a JSON object of the given -type and print its RLP encoding as hex")
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[-noascii] [-hex <data>] [filename]")
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[-noascii] [-hex <data>] [-type <type> [-encode]] [filename]")
 		flag.PrintDefaults()
 		fmt.Fprintln(os.Stderr, `
 Dumps RLP data from the given file in readable form.
-If the filename is omitted, data is read from stdin.`)
+If the filename is omitted, data is read from stdin.
+
+With -type, the input is read as hex text (e.g. the output of
+debug_getBlockRlp) and printed as JSON with named fields. With
+-type and -encode, the input is a JSON object which is converted
+back to RLP and printed as hex.`)
 	}
 }
 
 func main() {
 	flag.Parse()
 
+	if *typeMode != "" {
+		typedMain()
+		return
+	}
+	if *encodeMode {
+		fmt.Fprintln(os.Stderr, "Error: -encode requires -type")
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	var r io.Reader
 	switch {
 	case *hexMode != "":
@@ -90,6 +108,61 @@ func main() {
 	}
 }
 
+// typedMain handles the -type mode.
+func typedMain() {
+	var input []byte
+	switch {
+	case *hexMode != "":
+		input = []byte(*hexMode)
+	default:
+		r := openInput()
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			die(err)
+		}
+		input = data
+	}
+
+	if *encodeMode {
+		enc, err := encodeTyped(*typeMode, input)
+		if err != nil {
+			die(err)
+		}
+		fmt.Printf("0x%x\n", enc)
+		return
+	}
+	data, err := decodeHex(string(input))
+	if err != nil {
+		die("invalid hex input:", err)
+	}
+	out, err := decodeTyped(*typeMode, data)
+	if err != nil {
+		die(err)
+	}
+	fmt.Println(string(out))
+}
+
+// openInput returns the file named on the command line or stdin.
+// The caller must close it.
+func openInput() io.ReadCloser {
+	switch flag.NArg() {
+	case 0:
+		return ioutil.NopCloser(os.Stdin)
+	case 1:
+		fd, err := os.Open(flag.Arg(0))
+		if err != nil {
+			die(err)
+		}
+		return fd
+	default:
+		fmt.Fprintln(os.Stderr, "Error: too many arguments")
+		flag.Usage()
+		os.Exit(2)
+		return nil
+	}
+}
+
 func dump(s *rlp.Stream, depth int) error {
 	kind, size, err := s.Kind()
 	if err != nil {
@@ -0,0 +1,469 @@
+/*
+	This file is part of go-ethereum
+
+	go-ethereum is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	go-ethereum is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with go-ethereum.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The types in this file are JSON views of the core/types objects.
+// RLP is always decoded into and encoded from the core types, so the
+// wire format can't drift from the one used by the client. The views
+// only add named fields, so the output can be edited and turned back
+// into RLP without loss.
+
+// hexBytes is a byte slice that marshals to JSON as 0x-prefixed hex.
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal("0x" + hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(input []byte) error {
+	var s string
+	if err := json.Unmarshal(input, &s); err != nil {
+		return err
+	}
+	dec, err := decodeHex(s)
+	if err != nil {
+		return err
+	}
+	*b = dec
+	return nil
+}
+
+// copyFixed copies a JSON value into a fixed size field of a core type.
+func copyFixed(dst []byte, b hexBytes, field string) error {
+	if len(b) != len(dst) {
+		return fmt.Errorf("invalid %s: have %d bytes, want %d", field, len(b), len(dst))
+	}
+	copy(dst, b)
+	return nil
+}
+
+type jsonHeader struct {
+	ParentHash  hexBytes `json:"parentHash"`
+	UncleHash   hexBytes `json:"sha3Uncles"`
+	Coinbase    hexBytes `json:"miner"`
+	Root        hexBytes `json:"stateRoot"`
+	TxHash      hexBytes `json:"transactionsRoot"`
+	ReceiptHash hexBytes `json:"receiptsRoot"`
+	Bloom       hexBytes `json:"logsBloom"`
+	Difficulty  *big.Int `json:"difficulty"`
+	Number      *big.Int `json:"number"`
+	GasLimit    *big.Int `json:"gasLimit"`
+	GasUsed     *big.Int `json:"gasUsed"`
+	Time        uint64   `json:"timestamp"`
+	Extra       hexBytes `json:"extraData"`
+	MixDigest   hexBytes `json:"mixHash"`
+	Nonce       hexBytes `json:"nonce"`
+}
+
+func newJSONHeader(h *types.Header) *jsonHeader {
+	return &jsonHeader{
+		ParentHash:  h.ParentHash[:],
+		UncleHash:   h.UncleHash[:],
+		Coinbase:    h.Coinbase[:],
+		Root:        h.Root[:],
+		TxHash:      h.TxHash[:],
+		ReceiptHash: h.ReceiptHash[:],
+		Bloom:       h.Bloom[:],
+		Difficulty:  h.Difficulty,
+		Number:      h.Number,
+		GasLimit:    h.GasLimit,
+		GasUsed:     h.GasUsed,
+		Time:        h.Time,
+		Extra:       h.Extra,
+		MixDigest:   h.MixDigest[:],
+		Nonce:       h.Nonce[:],
+	}
+}
+
+func (j *jsonHeader) toHeader() (*types.Header, error) {
+	h := &types.Header{
+		Difficulty: j.Difficulty,
+		Number:     j.Number,
+		GasLimit:   j.GasLimit,
+		GasUsed:    j.GasUsed,
+		Time:       j.Time,
+		Extra:      j.Extra,
+	}
+	fixed := []struct {
+		dst   []byte
+		val   hexBytes
+		field string
+	}{
+		{h.ParentHash[:], j.ParentHash, "parentHash"},
+		{h.UncleHash[:], j.UncleHash, "sha3Uncles"},
+		{h.Coinbase[:], j.Coinbase, "miner"},
+		{h.Root[:], j.Root, "stateRoot"},
+		{h.TxHash[:], j.TxHash, "transactionsRoot"},
+		{h.ReceiptHash[:], j.ReceiptHash, "receiptsRoot"},
+		{h.Bloom[:], j.Bloom, "logsBloom"},
+		{h.MixDigest[:], j.MixDigest, "mixHash"},
+		{h.Nonce[:], j.Nonce, "nonce"},
+	}
+	for _, f := range fixed {
+		if err := copyFixed(f.dst, f.val, f.field); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+type jsonTransaction struct {
+	AccountNonce uint64   `json:"nonce"`
+	Price        *big.Int `json:"gasPrice"`
+	GasLimit     *big.Int `json:"gas"`
+	Recipient    hexBytes `json:"to"` // empty for contract creation
+	Amount       *big.Int `json:"value"`
+	Payload      hexBytes `json:"input"`
+	V            byte     `json:"v"`
+	R            *big.Int `json:"r"`
+	S            *big.Int `json:"s"`
+}
+
+func newJSONTransaction(tx *types.Transaction) *jsonTransaction {
+	j := &jsonTransaction{
+		AccountNonce: tx.AccountNonce,
+		Price:        tx.Price,
+		GasLimit:     tx.GasLimit,
+		Recipient:    hexBytes{},
+		Amount:       tx.Amount,
+		Payload:      tx.Payload,
+		V:            tx.V,
+		R:            tx.R,
+		S:            tx.S,
+	}
+	if tx.Recipient != nil {
+		j.Recipient = tx.Recipient[:]
+	}
+	return j
+}
+
+func (j *jsonTransaction) toTransaction() (*types.Transaction, error) {
+	tx := &types.Transaction{
+		AccountNonce: j.AccountNonce,
+		Price:        j.Price,
+		GasLimit:     j.GasLimit,
+		Amount:       j.Amount,
+		Payload:      j.Payload,
+		V:            j.V,
+		R:            j.R,
+		S:            j.S,
+	}
+	if len(j.Recipient) > 0 {
+		tx.Recipient = new(common.Address)
+		if err := copyFixed(tx.Recipient[:], j.Recipient, "to"); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+type jsonBlock struct {
+	Header *jsonHeader        `json:"header"`
+	Txs    []*jsonTransaction `json:"transactions"`
+	Uncles []*jsonHeader      `json:"uncles"`
+}
+
+func newJSONBlock(b *types.Block) *jsonBlock {
+	j := &jsonBlock{
+		Header: newJSONHeader(b.Header()),
+		Txs:    make([]*jsonTransaction, len(b.Transactions())),
+		Uncles: make([]*jsonHeader, len(b.Uncles())),
+	}
+	for i, tx := range b.Transactions() {
+		j.Txs[i] = newJSONTransaction(tx)
+	}
+	for i, uncle := range b.Uncles() {
+		j.Uncles[i] = newJSONHeader(uncle)
+	}
+	return j
+}
+
+func (j *jsonBlock) toBlock() (*types.Block, error) {
+	if j.Header == nil {
+		return nil, fmt.Errorf("missing block header")
+	}
+	header, err := j.Header.toHeader()
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*types.Transaction, len(j.Txs))
+	for i, jtx := range j.Txs {
+		if txs[i], err = jtx.toTransaction(); err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	uncles := make([]*types.Header, len(j.Uncles))
+	for i, juncle := range j.Uncles {
+		if uncles[i], err = juncle.toHeader(); err != nil {
+			return nil, fmt.Errorf("uncle %d: %v", i, err)
+		}
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs, uncles), nil
+}
+
+type jsonLog struct {
+	Address hexBytes   `json:"address"`
+	Topics  []hexBytes `json:"topics"`
+	Data    hexBytes   `json:"data"`
+}
+
+type jsonReceipt struct {
+	PostState         hexBytes   `json:"root"`
+	CumulativeGasUsed *big.Int   `json:"cumulativeGasUsed"`
+	Bloom             hexBytes   `json:"logsBloom"`
+	Logs              []*jsonLog `json:"logs"`
+}
+
+func newJSONReceipt(r *types.Receipt) *jsonReceipt {
+	j := &jsonReceipt{
+		PostState:         r.PostState,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		Bloom:             r.Bloom[:],
+		Logs:              make([]*jsonLog, len(r.Logs())),
+	}
+	for i, log := range r.Logs() {
+		jlog := &jsonLog{Address: log.Address[:], Topics: make([]hexBytes, len(log.Topics)), Data: log.Data}
+		for k, topic := range log.Topics {
+			jlog.Topics[k] = common.CopyBytes(topic[:])
+		}
+		j.Logs[i] = jlog
+	}
+	return j
+}
+
+func (j *jsonReceipt) toReceipt() (*types.Receipt, error) {
+	r := &types.Receipt{PostState: j.PostState, CumulativeGasUsed: j.CumulativeGasUsed}
+	if err := copyFixed(r.Bloom[:], j.Bloom, "logsBloom"); err != nil {
+		return nil, err
+	}
+	logs := make(state.Logs, len(j.Logs))
+	for i, jlog := range j.Logs {
+		var addr common.Address
+		if err := copyFixed(addr[:], jlog.Address, "log address"); err != nil {
+			return nil, err
+		}
+		topics := make([]common.Hash, len(jlog.Topics))
+		for k, topic := range jlog.Topics {
+			if err := copyFixed(topics[k][:], topic, "log topic"); err != nil {
+				return nil, err
+			}
+		}
+		logs[i] = state.NewLog(addr, topics, jlog.Data, 0)
+	}
+	r.SetLogs(logs)
+	return r, nil
+}
+
+// jsonMsg is a p2p message: the RLP-encoded message code
+// followed by the payload value.
+type jsonMsg struct {
+	Code    uint64      `json:"code"`
+	Payload interface{} `json:"payload"`
+}
+
+func unknownType(typ string) error {
+	return fmt.Errorf("unknown type %q (want block, header, tx, receipt or p2p)", typ)
+}
+
+// decodeTyped decodes RLP input as the named type
+// and returns its indented JSON representation.
+func decodeTyped(typ string, input []byte) ([]byte, error) {
+	var view interface{}
+	switch typ {
+	case "block":
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(input, block); err != nil {
+			return nil, err
+		}
+		view = newJSONBlock(block)
+	case "header":
+		header := new(types.Header)
+		if err := rlp.DecodeBytes(input, header); err != nil {
+			return nil, err
+		}
+		view = newJSONHeader(header)
+	case "tx":
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(input, tx); err != nil {
+			return nil, err
+		}
+		view = newJSONTransaction(tx)
+	case "receipt":
+		receipt := new(types.Receipt)
+		if err := rlp.DecodeBytes(input, receipt); err != nil {
+			return nil, err
+		}
+		view = newJSONReceipt(receipt)
+	case "p2p":
+		msg := new(jsonMsg)
+		if err := decodeMsg(msg, input); err != nil {
+			return nil, err
+		}
+		view = msg
+	default:
+		return nil, unknownType(typ)
+	}
+	return json.MarshalIndent(view, "", "  ")
+}
+
+// encodeTyped parses a JSON object of the named type and
+// returns its RLP encoding.
+func encodeTyped(typ string, input []byte) ([]byte, error) {
+	var (
+		val interface{}
+		err error
+	)
+	switch typ {
+	case "block":
+		view := new(jsonBlock)
+		if err := json.Unmarshal(input, view); err != nil {
+			return nil, err
+		}
+		val, err = view.toBlock()
+	case "header":
+		view := new(jsonHeader)
+		if err := json.Unmarshal(input, view); err != nil {
+			return nil, err
+		}
+		val, err = view.toHeader()
+	case "tx":
+		view := new(jsonTransaction)
+		if err := json.Unmarshal(input, view); err != nil {
+			return nil, err
+		}
+		val, err = view.toTransaction()
+	case "receipt":
+		view := new(jsonReceipt)
+		if err := json.Unmarshal(input, view); err != nil {
+			return nil, err
+		}
+		val, err = view.toReceipt()
+	case "p2p":
+		msg := new(jsonMsg)
+		if err := json.Unmarshal(input, msg); err != nil {
+			return nil, err
+		}
+		return encodeMsg(msg)
+	default:
+		return nil, unknownType(typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(val)
+}
+
+func decodeMsg(msg *jsonMsg, input []byte) error {
+	_, code, rest, err := rlp.Split(input)
+	if err != nil {
+		return fmt.Errorf("invalid message code: %v", err)
+	}
+	if len(code) > 8 {
+		return fmt.Errorf("message code too large")
+	}
+	for _, b := range code {
+		msg.Code = msg.Code<<8 | uint64(b)
+	}
+	if msg.Payload, err = rlpToJSON(rest); err != nil {
+		return fmt.Errorf("invalid message payload: %v", err)
+	}
+	return nil
+}
+
+func encodeMsg(msg *jsonMsg) ([]byte, error) {
+	payload, err := jsonToRLP(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return append(rlp.AppendUint64(nil, msg.Code), payload...), nil
+}
+
+// rlpToJSON converts a single RLP value into its generic JSON
+// form: lists become arrays, strings become 0x-prefixed hex.
+func rlpToJSON(b []byte) (interface{}, error) {
+	kind, content, rest, err := rlp.Split(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after value", len(rest))
+	}
+	if kind != rlp.List {
+		return hexBytes(content), nil
+	}
+	elems := []interface{}{}
+	for len(content) > 0 {
+		_, _, r, err := rlp.Split(content)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := rlpToJSON(content[:len(content)-len(r)])
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+		content = r
+	}
+	return elems, nil
+}
+
+// jsonToRLP is the inverse of rlpToJSON.
+func jsonToRLP(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		b, err := decodeHex(v)
+		if err != nil {
+			return nil, err
+		}
+		return rlp.EncodeToBytes(b)
+	case []interface{}:
+		var content []byte
+		for _, elem := range v {
+			enc, err := jsonToRLP(elem)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, enc...)
+		}
+		return append(rlp.AppendListHeader(nil, len(content)), content...), nil
+	default:
+		return nil, fmt.Errorf("unsupported JSON value %v (want hex string or array)", v)
+	}
+}
+
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.Trim(s, `"`)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	return hex.DecodeString(s)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func testHeader(number int64) *types.Header {
+	h := &types.Header{
+		ParentHash:  common.HexToHash("0x01"),
+		UncleHash:   common.HexToHash("0x02"),
+		Coinbase:    common.HexToAddress("0x03"),
+		Root:        common.HexToHash("0x04"),
+		TxHash:      common.HexToHash("0x05"),
+		ReceiptHash: common.HexToHash("0x06"),
+		Difficulty:  big.NewInt(131072),
+		Number:      big.NewInt(number),
+		GasLimit:    big.NewInt(3141592),
+		GasUsed:     big.NewInt(21000),
+		Time:        1438269973,
+		Extra:       []byte("extra"),
+		MixDigest:   common.HexToHash("0x07"),
+	}
+	h.Bloom[0] = 0x08
+	h.SetNonce(0x0102030405060708)
+	return h
+}
+
+func testTransactions() []*types.Transaction {
+	to := common.HexToAddress("0x0a")
+	return []*types.Transaction{
+		{AccountNonce: 1, Price: big.NewInt(50), GasLimit: big.NewInt(21000), Recipient: &to, Amount: big.NewInt(10), Payload: []byte{}, V: 27, R: big.NewInt(1), S: big.NewInt(2)},
+		{AccountNonce: 2, Price: big.NewInt(50), GasLimit: big.NewInt(90000), Amount: big.NewInt(0), Payload: []byte{0x60, 0x60}, V: 28, R: big.NewInt(3), S: big.NewInt(4)},
+	}
+}
+
+func testReceipt() *types.Receipt {
+	receipt := types.NewReceipt([]byte{0x0b}, big.NewInt(42000))
+	receipt.Bloom[255] = 0x0c
+	receipt.SetLogs(state.Logs{
+		state.NewLog(common.HexToAddress("0x0d"), []common.Hash{common.HexToHash("0x0e"), common.HexToHash("0x0f")}, []byte{0x10}, 0),
+		state.NewLog(common.HexToAddress("0x11"), []common.Hash{}, []byte{}, 0),
+	})
+	return receipt
+}
+
+// Tests that every supported type survives a typed decode to JSON and back
+// to the exact same RLP.
+func TestTypedRoundTrip(t *testing.T) {
+	block := types.NewBlockWithHeader(testHeader(10)).WithBody(testTransactions(), []*types.Header{testHeader(9)})
+	msg, _ := rlp.EncodeToBytes([]interface{}{uint(61), []byte{0x12}})
+
+	tests := []struct {
+		typ string
+		val interface{}
+		enc []byte
+	}{
+		{typ: "header", val: testHeader(1)},
+		{typ: "tx", val: testTransactions()[0]},
+		{typ: "tx", val: testTransactions()[1]},
+		{typ: "block", val: block},
+		{typ: "block", val: types.NewBlockWithHeader(testHeader(1)).WithBody(nil, nil)},
+		{typ: "receipt", val: testReceipt()},
+		{typ: "receipt", val: types.NewReceipt(nil, big.NewInt(0))},
+		{typ: "p2p", enc: append(rlp.AppendUint64(nil, 0x13), msg...)},
+	}
+	for i, tt := range tests {
+		enc := tt.enc
+		if tt.val != nil {
+			var err error
+			if enc, err = rlp.EncodeToBytes(tt.val); err != nil {
+				t.Fatalf("test %d: failed to encode %s: %v", i, tt.typ, err)
+			}
+		}
+		js, err := decodeTyped(tt.typ, enc)
+		if err != nil {
+			t.Errorf("test %d: failed to decode %s: %v", i, tt.typ, err)
+			continue
+		}
+		out, err := encodeTyped(tt.typ, js)
+		if err != nil {
+			t.Errorf("test %d: failed to re-encode %s: %v\n%s", i, tt.typ, err, js)
+			continue
+		}
+		if !bytes.Equal(out, enc) {
+			t.Errorf("test %d: %s RLP mismatch:\nhave %x\nwant %x", i, tt.typ, out, enc)
+		}
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	tests := []struct {
+		typ, input, err string
+	}{
+		{"header", `{"parentHash": "0x01"}`, "invalid parentHash: have 1 bytes, want 32"},
+		{"tx", `{"to": "0x0102"}`, "invalid to: have 2 bytes, want 20"},
+		{"block", `{}`, "missing block header"},
+		{"unknown", `{}`, `unknown type "unknown"`},
+	}
+	for i, tt := range tests {
+		if _, err := encodeTyped(tt.typ, []byte(tt.input)); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %q", i, err, tt.err)
+		}
+	}
+}
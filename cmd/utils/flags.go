@@ -22,11 +22,13 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/xeth"
 	"github.com/ethereum/go-ethereum/rpc/api"
 	"github.com/ethereum/go-ethereum/rpc/comms"
 	"github.com/ethereum/go-ethereum/rpc/codec"
+	"github.com/ethereum/go-ethereum/rpc/shared"
 )
 
 func init() {
@@ -96,6 +98,18 @@ var (
 		Usage: "Blockchain version (integer)",
 		Value: core.BlockChainVersion,
 	}
+	FastSyncFlag = cli.BoolFlag{
+		Name:  "fast",
+		Usage: "Enables fast syncing through state downloads",
+	}
+	LightModeFlag = cli.BoolFlag{
+		Name:  "light",
+		Usage: "Enables light client mode (headers only, state retrieved on demand from light servers)",
+	}
+	LightServFlag = cli.BoolFlag{
+		Name:  "lightserv",
+		Usage: "Serves light clients over the les protocol",
+	}
 	GenesisNonceFlag = cli.IntFlag{
 		Name:  "genesisnonce",
 		Usage: "Sets the genesis nonce",
@@ -209,6 +223,16 @@ var (
 		Usage: "Domain on which to send Access-Control-Allow-Origin header",
 		Value: "",
 	}
+	RPCBatchLimitFlag = cli.IntFlag{
+		Name:  "rpcbatchlimit",
+		Usage: "Maximum number of calls in a JSON-RPC batch request (0 = unlimited)",
+		Value: shared.DefaultBatchRequestLimit,
+	}
+	RPCBatchResponseLimitFlag = cli.IntFlag{
+		Name:  "rpcbatchresponselimit",
+		Usage: "Maximum size in bytes of a JSON-RPC batch response (0 = unlimited)",
+		Value: shared.DefaultBatchResponseLimit,
+	}
 	IPCDisabledFlag = cli.BoolFlag{
 		Name:  "ipcdisable",
 		Usage: "Disable the IPC-RPC server",
@@ -223,6 +247,30 @@ var (
 		Usage: "Filename for IPC socket/pipe",
 		Value: DirectoryString{common.DefaultIpcPath()},
 	}
+	WSEnabledFlag = cli.BoolFlag{
+		Name:  "ws",
+		Usage: "Enable the WebSocket-RPC server",
+	}
+	WSListenAddrFlag = cli.StringFlag{
+		Name:  "wsaddr",
+		Usage: "Listening address for the WebSocket-RPC server",
+		Value: "127.0.0.1",
+	}
+	WSPortFlag = cli.IntFlag{
+		Name:  "wsport",
+		Usage: "Port on which the WebSocket-RPC server should listen",
+		Value: 8546,
+	}
+	WSApiFlag = cli.StringFlag{
+		Name:  "wsapi",
+		Usage: "Specify the API's which are offered over the WebSocket interface",
+		Value: api.DefaultWsApis,
+	}
+	WSAllowedOriginsFlag = cli.StringFlag{
+		Name:  "wsorigins",
+		Usage: "Space separated list of browser origins allowed to connect over WebSocket (* for any)",
+		Value: "",
+	}
 	// Network Settings
 	MaxPeersFlag = cli.IntFlag{
 		Name:  "maxpeers",
@@ -244,6 +292,21 @@ var (
 		Usage: "Space-separated enode URLs for p2p discovery bootstrap",
 		Value: "",
 	}
+	ForkCheckpointsFlag = cli.StringFlag{
+		Name:  "forkcheckpoints",
+		Usage: "Space-separated number=hash blocks the chains of peers must contain",
+		Value: "",
+	}
+	CheckpointFlag = cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "Trusted number=hash block the chain must contain",
+		Value: "",
+	}
+	MaxReorgDepthFlag = cli.IntFlag{
+		Name:  "maxreorg",
+		Usage: "Maximum number of blocks a chain reorganisation may replace (0 = no limit)",
+		Value: 0,
+	}
 	NodeKeyFileFlag = cli.StringFlag{
 		Name:  "nodekey",
 		Usage: "P2P node key file",
@@ -261,6 +324,14 @@ var (
 		Name:  "nodiscover",
 		Usage: "Disables the peer discovery mechanism (manual peer addition)",
 	}
+	NetrestrictFlag = cli.StringFlag{
+		Name:  "netrestrict",
+		Usage: "Restricts network communication to the given IP networks (comma-separated CIDR masks)",
+	}
+	CaptureFileFlag = cli.StringFlag{
+		Name:  "capture",
+		Usage: "Records all eth and shh protocol messages to the given file (for offline replay)",
+	}
 	WhisperEnabledFlag = cli.BoolFlag{
 		Name:  "shh",
 		Usage: "Enable whisper",
@@ -287,6 +358,19 @@ func MakeNAT(ctx *cli.Context) nat.Interface {
 	return natif
 }
 
+// MakeNetRestrict creates the network allowlist from set command line flags.
+func MakeNetRestrict(ctx *cli.Context) *netutil.Netlist {
+	s := ctx.GlobalString(NetrestrictFlag.Name)
+	if s == "" {
+		return nil
+	}
+	list, err := netutil.ParseNetlist(s)
+	if err != nil {
+		Fatalf("Option %s: %v", NetrestrictFlag.Name, err)
+	}
+	return list
+}
+
 // MakeNodeKey creates a node key from set command line flags.
 func MakeNodeKey(ctx *cli.Context) (key *ecdsa.PrivateKey) {
 	hex, file := ctx.GlobalString(NodeKeyHexFlag.Name), ctx.GlobalString(NodeKeyFileFlag.Name)
@@ -319,6 +403,9 @@ func MakeEthConfig(clientID, version string, ctx *cli.Context) *eth.Config {
 		GenesisNonce:       ctx.GlobalInt(GenesisNonceFlag.Name),
 		BlockChainVersion:  ctx.GlobalInt(BlockchainVersionFlag.Name),
 		SkipBcVersionCheck: false,
+		FastSync:           ctx.GlobalBool(FastSyncFlag.Name),
+		LightMode:          ctx.GlobalBool(LightModeFlag.Name),
+		LightServ:          ctx.GlobalBool(LightServFlag.Name),
 		NetworkId:          ctx.GlobalInt(NetworkIdFlag.Name),
 		LogFile:            ctx.GlobalString(LogFileFlag.Name),
 		Verbosity:          ctx.GlobalInt(VerbosityFlag.Name),
@@ -333,10 +420,15 @@ func MakeEthConfig(clientID, version string, ctx *cli.Context) *eth.Config {
 		NAT:                MakeNAT(ctx),
 		NatSpec:            ctx.GlobalBool(NatspecEnabledFlag.Name),
 		Discovery:          !ctx.GlobalBool(NoDiscoverFlag.Name),
+		NetRestrict:        MakeNetRestrict(ctx),
 		NodeKey:            MakeNodeKey(ctx),
 		Shh:                ctx.GlobalBool(WhisperEnabledFlag.Name),
 		Dial:               true,
 		BootNodes:          ctx.GlobalString(BootnodesFlag.Name),
+		ForkCheckpoints:    ctx.GlobalString(ForkCheckpointsFlag.Name),
+		Checkpoint:         ctx.GlobalString(CheckpointFlag.Name),
+		MaxReorgDepth:      ctx.GlobalInt(MaxReorgDepthFlag.Name),
+		CaptureFile:        ctx.GlobalString(CaptureFileFlag.Name),
 		GasPrice:           common.String2Big(ctx.GlobalString(GasPriceFlag.Name)),
 		SolcPath:           ctx.GlobalString(SolcPathFlag.Name),
 		AutoDAG:            ctx.GlobalBool(AutoDAGFlag.Name) || ctx.GlobalBool(MiningEnabledFlag.Name),
@@ -404,9 +496,18 @@ func IpcSocketPath(ctx *cli.Context) (ipcpath string) {
 	return
 }
 
+// MakeBatchLimits creates the batch request limits shared by all RPC transports.
+func MakeBatchLimits(ctx *cli.Context) shared.BatchLimits {
+	return shared.BatchLimits{
+		Requests:     ctx.GlobalInt(RPCBatchLimitFlag.Name),
+		ResponseSize: ctx.GlobalInt(RPCBatchResponseLimitFlag.Name),
+	}
+}
+
 func StartIPC(eth *eth.Ethereum, ctx *cli.Context) error {
 	config := comms.IpcConfig{
-		Endpoint: IpcSocketPath(ctx),
+		Endpoint:    IpcSocketPath(ctx),
+		BatchLimits: MakeBatchLimits(ctx),
 	}
 
 	xeth := xeth.New(eth, nil)
@@ -420,11 +521,31 @@ func StartIPC(eth *eth.Ethereum, ctx *cli.Context) error {
 	return comms.StartIpc(config, codec, apis...)
 }
 
+func StartWS(eth *eth.Ethereum, ctx *cli.Context) error {
+	config := comms.WsConfig{
+		ListenAddress: ctx.GlobalString(WSListenAddrFlag.Name),
+		ListenPort:    uint(ctx.GlobalInt(WSPortFlag.Name)),
+		Origins:       ctx.GlobalString(WSAllowedOriginsFlag.Name),
+		BatchLimits:   MakeBatchLimits(ctx),
+	}
+
+	xeth := xeth.New(eth, nil)
+	codec := codec.JSON
+
+	apis, err := api.ParseApiString(ctx.GlobalString(WSApiFlag.Name), codec, xeth, eth)
+	if err != nil {
+		return err
+	}
+
+	return comms.StartWs(config, codec, eth.EventMux(), apis...)
+}
+
 func StartRPC(eth *eth.Ethereum, ctx *cli.Context) error {
 	config := rpc.RpcConfig{
 		ListenAddress: ctx.GlobalString(RPCListenAddrFlag.Name),
 		ListenPort:    uint(ctx.GlobalInt(RPCPortFlag.Name)),
 		CorsDomain:    ctx.GlobalString(RPCCORSDomainFlag.Name),
+		BatchLimits:   MakeBatchLimits(ctx),
 	}
 
 	xeth := xeth.New(eth, nil)
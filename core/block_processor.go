@@ -247,7 +247,7 @@ func (sm *BlockProcessor) processWithParent(block, parent *types.Block) (logs st
 	}
 
 	// store the receipts
-	err = putReceipts(sm.extraDb, block.Hash(), receipts)
+	err = PutBlockReceipts(sm.extraDb, block.Hash(), receipts)
 	if err != nil {
 		return nil, err
 	}
@@ -266,6 +266,12 @@ func (sm *BlockProcessor) processWithParent(block, parent *types.Block) (logs st
 // See YP section 4.3.4. "Block Header Validity"
 // Validates a block. Returns an error if the block is invalid.
 func (sm *BlockProcessor) ValidateHeader(block, parent *types.Header, checkPow bool) error {
+	return ValidateHeader(sm.Pow, block, parent, checkPow)
+}
+
+// ValidateHeader checks a header against its parent, verifying its
+// proof-of-work with pow if checkPow is set.
+func ValidateHeader(pow pow.PoW, block, parent *types.Header, checkPow bool) error {
 	if big.NewInt(int64(len(block.Extra))).Cmp(params.MaximumExtraDataSize) == 1 {
 		return fmt.Errorf("Block extra data too long (%d)", len(block.Extra))
 	}
@@ -296,7 +302,7 @@ func (sm *BlockProcessor) ValidateHeader(block, parent *types.Header, checkPow b
 
 	if checkPow {
 		// Verify the nonce of the block. Return an error if it's not valid
-		if !sm.Pow.Verify(types.NewBlockWithHeader(block)) {
+		if !pow.Verify(types.NewBlockWithHeader(block)) {
 			return ValidationError("Block's nonce is invalid (= %x)", block.Nonce)
 		}
 	}
@@ -369,9 +375,87 @@ func (sm *BlockProcessor) VerifyUncles(statedb *state.StateDB, block, parent *ty
 	return nil
 }
 
+// InsertReceiptChain imports a batch of blocks along with their receipts without
+// executing their transactions. It's used by fast sync to fill in the chain below
+// the block whose state is retrieved directly. Every block must link to a known
+// parent and its receipts must match the header's receipt root. The head of the
+// chain is not moved. On failure the index of the offending block is returned.
+func (sm *BlockProcessor) InsertReceiptChain(chain types.Blocks, receipts []types.Receipts) (int, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if len(chain) != len(receipts) {
+		return 0, fmt.Errorf("receipt count mismatch: %d blocks, %d receipt lists", len(chain), len(receipts))
+	}
+	for i, block := range chain {
+		parent := sm.bc.GetBlock(block.ParentHash())
+		if parent == nil {
+			return i, ParentError(block.ParentHash())
+		}
+		header := block.Header()
+		if receiptSha := types.DeriveSha(receipts[i]); receiptSha != header.ReceiptHash {
+			return i, fmt.Errorf("invalid receipt root hash. received=%x calculated=%x", header.ReceiptHash, receiptSha)
+		}
+		block.Td = CalcTD(block, parent)
+
+		// Fill in the log metadata not transferred over the network
+		txs, index := block.Transactions(), uint(0)
+		for j, receipt := range receipts[i] {
+			for _, log := range receipt.Logs() {
+				log.Number = block.NumberU64()
+				log.BlockHash = block.Hash()
+				log.TxIndex = uint(j)
+				if j < len(txs) {
+					log.TxHash = txs[j].Hash()
+				}
+				log.Index = index
+				index++
+			}
+		}
+		if err := PutBlockReceipts(sm.extraDb, block.Hash(), receipts[i]); err != nil {
+			return i, err
+		}
+		for j, tx := range txs {
+			putTx(sm.extraDb, tx, block, uint64(j))
+		}
+		sm.bc.writeCanonical(block)
+	}
+	return 0, nil
+}
+
+// InsertHeaderChain imports a batch of headers without their block bodies, as
+// done by light clients. Every header must link to a known parent and pass
+// header validation, including the proof-of-work, and must respect the trusted
+// checkpoint and reorg limit. Headers on a chain heavier than the current one
+// move the head of the chain, but no state is processed. On failure the index
+// of the offending header is returned.
+func (sm *BlockProcessor) InsertHeaderChain(chain []*types.Header) (int, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for i, header := range chain {
+		if sm.bc.HasBlock(header.Hash()) {
+			continue
+		}
+		parent := sm.bc.GetBlock(header.ParentHash)
+		if parent == nil {
+			return i, ParentError(header.ParentHash)
+		}
+		if err := sm.ValidateHeader(header, parent.Header(), true); err != nil {
+			return i, err
+		}
+		block := types.NewBlockWithHeader(header)
+		block.Td = CalcTD(block, parent)
+		if err := sm.bc.writeHeader(block); err != nil {
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
 // GetBlockReceipts returns the receipts beloniging to the block hash
 func (sm *BlockProcessor) GetBlockReceipts(bhash common.Hash) (receipts types.Receipts, err error) {
-	return getBlockReceipts(sm.extraDb, bhash)
+	return GetBlockReceipts(sm.extraDb, bhash)
 }
 
 // GetLogs returns the logs of the given block. This method is using a two step approach
@@ -398,14 +482,22 @@ func (sm *BlockProcessor) GetLogs(block *types.Block) (logs state.Logs, err erro
 	return state.Logs(), nil
 }
 
-func getBlockReceipts(db common.Database, bhash common.Hash) (receipts types.Receipts, err error) {
-	var rdata []byte
-	rdata, err = db.Get(append(receiptsPre, bhash[:]...))
-
-	if err == nil {
-		err = rlp.DecodeBytes(rdata, &receipts)
+// GetBlockReceipts retrieves the receipts of the block with the given hash from
+// the database.
+func GetBlockReceipts(db common.Database, bhash common.Hash) (types.Receipts, error) {
+	rdata, err := db.Get(append(receiptsPre, bhash[:]...))
+	if err != nil {
+		return nil, err
 	}
-	return
+	var storageReceipts []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(rdata, &storageReceipts); err != nil {
+		return nil, err
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	for i, receipt := range storageReceipts {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts, nil
 }
 
 func putTx(db common.Database, tx *types.Transaction, block *types.Block, i uint64) {
@@ -432,7 +524,9 @@ func putTx(db common.Database, tx *types.Transaction, block *types.Block, i uint
 	db.Put(append(tx.Hash().Bytes(), 0x0001), rlpMeta)
 }
 
-func putReceipts(db common.Database, hash common.Hash, receipts types.Receipts) error {
+// PutBlockReceipts stores the receipts of the block with the given hash into the
+// database.
+func PutBlockReceipts(db common.Database, hash common.Hash, receipts types.Receipts) error {
 	storageReceipts := make([]*types.ReceiptForStorage, len(receipts))
 	for i, receipt := range receipts {
 		storageReceipts[i] = (*types.ReceiptForStorage)(receipt)
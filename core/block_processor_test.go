@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/pow/ezp"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 func proc() (*BlockProcessor, *ChainManager) {
@@ -63,8 +64,8 @@ func TestPutReceipt(t *testing.T) {
 		Index:     0,
 	}})
 
-	putReceipts(db, hash, types.Receipts{receipt})
-	receipts, err := getBlockReceipts(db, hash)
+	PutBlockReceipts(db, hash, types.Receipts{receipt})
+	receipts, err := GetBlockReceipts(db, hash)
 	if err != nil {
 		t.Error("got err:", err)
 	}
@@ -72,3 +73,97 @@ func TestPutReceipt(t *testing.T) {
 		t.Error("expected to get 1 receipt, got", len(receipts))
 	}
 }
+
+// Tests that blocks imported along with their receipts are stored without moving
+// the chain head, and that the head can only be moved to a block whose state is
+// present.
+func TestInsertReceiptChain(t *testing.T) {
+	srcDb, _ := ethdb.NewMemDatabase()
+	src, err := newCanonical(5, srcDb)
+	if err != nil {
+		t.Fatalf("failed to create source chain: %v", err)
+	}
+	blocks := make(types.Blocks, 5)
+	for i := range blocks {
+		blocks[i] = src.bc.GetBlockByNumber(uint64(i + 1))
+	}
+	dstDb, _ := ethdb.NewMemDatabase()
+	dst, _ := newCanonical(0, dstDb)
+	genesis := dst.bc.CurrentBlock()
+
+	// Receipts not matching the headers must be rejected
+	receipts := make([]types.Receipts, len(blocks))
+	receipts[2] = types.Receipts{types.NewReceipt(nil, big.NewInt(1))}
+	if index, err := dst.InsertReceiptChain(blocks, receipts); err == nil || index != 2 {
+		t.Fatalf("receipt mismatch not detected: index %d, error %v", index, err)
+	}
+	// Valid receipts must import the blocks, but leave the head untouched
+	receipts[2] = nil
+	if _, err := dst.InsertReceiptChain(blocks[2:], receipts[2:]); err != nil {
+		t.Fatalf("failed to insert receipt chain: %v", err)
+	}
+	for i, block := range blocks {
+		if have := dst.bc.GetBlockByNumber(uint64(i + 1)); have == nil || have.Hash() != block.Hash() {
+			t.Errorf("block #%d not imported", i+1)
+		}
+	}
+	if head := dst.bc.CurrentBlock(); head.Hash() != genesis.Hash() {
+		t.Errorf("head moved to #%d", head.Number())
+	}
+	// The head may only be moved once the block state is present
+	head := blocks[len(blocks)-1]
+	if err := dst.bc.FastSyncCommitHead(head.Hash()); err == nil {
+		t.Fatalf("head committed without state")
+	}
+	sched := state.NewStateSync(head.Root(), dstDb)
+	for missing := sched.Missing(0); len(missing) > 0; missing = sched.Missing(0) {
+		results := make([]trie.SyncResult, len(missing))
+		for i, hash := range missing {
+			data, _ := srcDb.Get(hash.Bytes())
+			results[i] = trie.SyncResult{Hash: hash, Data: data}
+		}
+		if _, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to sync state: %v", err)
+		}
+	}
+	if err := dst.bc.FastSyncCommitHead(head.Hash()); err != nil {
+		t.Fatalf("failed to commit head: %v", err)
+	}
+	if current := dst.bc.CurrentBlock(); current.Hash() != head.Hash() {
+		t.Errorf("head mismatch: have #%d, want #%d", current.Number(), head.Number())
+	}
+}
+
+// Tests that header chains are imported without their bodies and move the head
+// of the chain, and that headers without a known parent are rejected.
+func TestInsertHeaderChain(t *testing.T) {
+	srcDb, _ := ethdb.NewMemDatabase()
+	src, err := newCanonical(5, srcDb)
+	if err != nil {
+		t.Fatalf("failed to create source chain: %v", err)
+	}
+	headers := make([]*types.Header, 5)
+	for i := range headers {
+		headers[i] = src.bc.GetBlockByNumber(uint64(i + 1)).Header()
+	}
+	dstDb, _ := ethdb.NewMemDatabase()
+	dst, _ := newCanonical(0, dstDb)
+
+	if index, err := dst.InsertHeaderChain(headers[1:]); err == nil || index != 0 {
+		t.Fatalf("unknown parent not detected: index %d, error %v", index, err)
+	}
+	if _, err := dst.InsertHeaderChain(headers); err != nil {
+		t.Fatalf("failed to insert header chain: %v", err)
+	}
+	for i, header := range headers {
+		if have := dst.bc.GetBlockByNumber(uint64(i + 1)); have == nil || have.Hash() != header.Hash() {
+			t.Errorf("header #%d not imported", i+1)
+		}
+	}
+	if head := dst.bc.CurrentBlock(); head.Hash() != headers[4].Hash() {
+		t.Errorf("head mismatch: have #%d, want #5", head.Number())
+	}
+	if td := dst.bc.Td(); td.Cmp(src.bc.Td()) != 0 {
+		t.Errorf("td mismatch: have %v, want %v", td, src.bc.Td())
+	}
+}
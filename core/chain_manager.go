@@ -101,6 +101,10 @@ type ChainManager struct {
 	cache        *BlockCache
 	futureBlocks *BlockCache
 
+	checkpoint     uint64      // Number of the trusted checkpoint block
+	checkpointHash common.Hash // Hash of the trusted checkpoint block, zero if unset
+	maxReorgDepth  uint64      // Maximum number of canonical blocks a reorg may replace, zero if unlimited
+
 	quit chan struct{}
 	// procInterrupt must be atomically called
 	procInterrupt int32 // interrupt signaler for block processing
@@ -209,6 +213,18 @@ func (self *ChainManager) Status() (td *big.Int, currentBlock common.Hash, genes
 	return new(big.Int).Set(self.td), self.currentBlock.Hash(), self.genesisBlock.Hash()
 }
 
+// SetCheckpoint sets a trusted checkpoint block. Chains containing a different
+// block at its number are rejected, as are reorgs forking off below it.
+func (self *ChainManager) SetCheckpoint(number uint64, hash common.Hash) {
+	self.checkpoint, self.checkpointHash = number, hash
+}
+
+// SetMaxReorgDepth limits the number of canonical blocks a reorg may replace.
+// Zero means no limit.
+func (self *ChainManager) SetMaxReorgDepth(depth uint64) {
+	self.maxReorgDepth = depth
+}
+
 func (self *ChainManager) SetProcessor(proc types.BlockProcessor) {
 	self.processor = proc
 }
@@ -392,6 +408,102 @@ func (bc *ChainManager) insert(block *types.Block) {
 	bc.lastBlockHash = block.Hash()
 }
 
+// writeCanonical stores a block into the database and the canonical number index
+// without moving the head of the chain. It's used to import blocks whose state
+// is not available locally.
+func (bc *ChainManager) writeCanonical(block *types.Block) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.write(block)
+	key := append(blockNumPre, block.Number().Bytes()...)
+	bc.blockDb.Put(key, block.Hash().Bytes())
+}
+
+// FastSyncCommitHead sets the current head block to the one defined by the hash,
+// irrespective of the chain contents. The state of the block must already be
+// present in the database, as no transactions are executed.
+func (self *ChainManager) FastSyncCommitHead(hash common.Hash) error {
+	block := self.GetBlock(hash)
+	if block == nil {
+		return fmt.Errorf("non existent block %x", hash[:4])
+	}
+	if data, _ := self.stateDb.Get(block.Root().Bytes()); len(data) == 0 {
+		return fmt.Errorf("non existent state %x for block #%d", block.Root().Bytes()[:4], block.Number())
+	}
+	self.mu.Lock()
+	self.setTotalDifficulty(block.Td)
+	self.insert(block)
+	self.currentGasLimit = CalcGasLimit(block)
+	self.mu.Unlock()
+
+	self.setTransState(state.New(block.Root(), self.stateDb))
+	self.txState.SetState(state.New(block.Root(), self.stateDb))
+
+	glog.V(logger.Info).Infof("committed block #%d (%x...) as new head", block.Number(), hash[:4])
+	go self.eventMux.Post(ChainHeadEvent{block})
+
+	return nil
+}
+
+// writeHeader stores a header-only block. If it's heavier than the current head,
+// it becomes the new head and the canonical number index is rewritten down to
+// the common ancestor of the two chains. No state is processed. Headers are
+// subject to the trusted checkpoint and reorg limit just like full blocks.
+func (self *ChainManager) writeHeader(block *types.Block) error {
+	if self.checkpointHash != (common.Hash{}) && block.NumberU64() == self.checkpoint && block.Hash() != self.checkpointHash {
+		err := CheckpointError("header #%d [%x] conflicts with trusted checkpoint [%x]", self.checkpoint, block.Hash().Bytes()[:4], self.checkpointHash[:4])
+		self.rejectChain(block, err)
+		return err
+	}
+	self.mu.Lock()
+	if block.Td.Cmp(self.td) <= 0 {
+		self.write(block)
+		self.mu.Unlock()
+		return nil
+	}
+	// Find where the new chain joins the canonical one
+	ancestor := self.GetBlock(block.ParentHash())
+	for ancestor != nil {
+		if canon := self.getBlockByNumber(ancestor.NumberU64()); canon != nil && canon.Hash() == ancestor.Hash() {
+			break
+		}
+		ancestor = self.GetBlock(ancestor.ParentHash())
+	}
+	if ancestor == nil {
+		self.mu.Unlock()
+		return ParentError(block.ParentHash())
+	}
+	if err := self.checkReorg(self.currentBlock, ancestor.NumberU64()); err != nil {
+		self.mu.Unlock()
+		self.rejectChain(block, err)
+		return err
+	}
+	self.write(block)
+	// Drop the canonical entries of a longer but lighter old chain
+	for n := block.NumberU64() + 1; n <= self.currentBlock.NumberU64(); n++ {
+		self.blockDb.Delete(append(blockNumPre, new(big.Int).SetUint64(n).Bytes()...))
+	}
+	// Index the new chain until it joins the old one
+	for b := block; b != nil; b = self.GetBlock(b.ParentHash()) {
+		key := append(blockNumPre, b.Number().Bytes()...)
+		if hash, _ := self.blockDb.Get(key); bytes.Equal(hash, b.Hash().Bytes()) {
+			break
+		}
+		self.blockDb.Put(key, b.Hash().Bytes())
+	}
+	self.setTotalDifficulty(block.Td)
+	self.insert(block)
+	self.currentGasLimit = CalcGasLimit(block)
+	self.mu.Unlock()
+
+	self.setTransState(state.New(block.Root(), self.stateDb))
+	self.txState.SetState(state.New(block.Root(), self.stateDb))
+
+	go self.eventMux.Post(ChainHeadEvent{block})
+	return nil
+}
+
 func (bc *ChainManager) write(block *types.Block) {
 	enc, _ := rlp.EncodeToBytes((*types.StorageBlock)(block))
 	key := append(blockHashPre, block.Hash().Bytes()...)
@@ -411,6 +523,22 @@ func (bc *ChainManager) HasBlock(hash common.Hash) bool {
 	return len(data) != 0
 }
 
+// VerifyHeaderPoW checks the proof-of-work of a block header without
+// requiring the rest of the block.
+func (self *ChainManager) VerifyHeaderPoW(header *types.Header) bool {
+	return self.pow.Verify(types.NewBlockWithHeader(header))
+}
+
+// VerifyHeader checks a header against its parent, which must be known. The
+// body and the state transition of the block aren't verified.
+func (self *ChainManager) VerifyHeader(header *types.Header) error {
+	parent := self.GetBlock(header.ParentHash)
+	if parent == nil {
+		return ParentError(header.ParentHash)
+	}
+	return ValidateHeader(self.pow, header, parent.Header(), true)
+}
+
 func (self *ChainManager) GetBlockHashesFromHash(hash common.Hash, max uint64) (chain []common.Hash) {
 	block := self.GetBlock(hash)
 	if block == nil {
@@ -595,6 +723,11 @@ func (self *ChainManager) InsertChain(chain types.Blocks) (int, error) {
 			blockErr(block, err)
 			return i, err
 		}
+		if self.checkpointHash != (common.Hash{}) && block.NumberU64() == self.checkpoint && block.Hash() != self.checkpointHash {
+			err := CheckpointError("block #%d [%x] conflicts with trusted checkpoint [%x]", self.checkpoint, block.Hash().Bytes()[:4], self.checkpointHash[:4])
+			self.rejectChain(block, err)
+			return i, err
+		}
 
 		// Setting block.Td regardless of error (known for example) prevents errors down the line
 		// in the protocol handler
@@ -646,6 +779,9 @@ func (self *ChainManager) InsertChain(chain types.Blocks) (int, error) {
 				// during split we merge two different chains and create the new canonical chain
 				err := self.merge(cblock, block)
 				if err != nil {
+					if IsReorgErr(err) {
+						self.rejectChain(block, err)
+					}
 					return i, err
 				}
 
@@ -762,6 +898,11 @@ func (self *ChainManager) merge(oldBlock, newBlock *types.Block) error {
 	if err != nil {
 		return fmt.Errorf("chain reorg failed: %v", err)
 	}
+	if len(newChain) > 0 {
+		if err := self.checkReorg(oldBlock, newChain[len(newChain)-1].NumberU64()-1); err != nil {
+			return err
+		}
+	}
 
 	// insert blocks. Order does not matter. Last block will be written in ImportChain itself which creates the new head properly
 	self.mu.Lock()
@@ -773,6 +914,26 @@ func (self *ChainManager) merge(oldBlock, newBlock *types.Block) error {
 	return nil
 }
 
+// checkReorg verifies that replacing the canonical chain from the given head
+// down to the common ancestor respects the trusted checkpoint and reorg limit.
+func (self *ChainManager) checkReorg(head *types.Block, ancestor uint64) error {
+	depth := head.NumberU64() - ancestor
+	if self.checkpointHash != (common.Hash{}) && ancestor < self.checkpoint && head.NumberU64() >= self.checkpoint {
+		return CheckpointError("reorg of %d blocks from #%d is below trusted checkpoint #%d", depth, ancestor, self.checkpoint)
+	}
+	if self.maxReorgDepth > 0 && depth > self.maxReorgDepth {
+		return ReorgError("reorg of %d blocks from #%d exceeds limit of %d", depth, ancestor, self.maxReorgDepth)
+	}
+	return nil
+}
+
+// rejectChain reports a chain refused because of the trusted checkpoint or
+// the reorg limit.
+func (self *ChainManager) rejectChain(block *types.Block, err error) {
+	glog.V(logger.Warn).Infof("Rejected chain at #%d [%x]: %v\n", block.Number(), block.Hash().Bytes()[:4], err)
+	go self.eventMux.Post(ReorgRejectedEvent{block, err})
+}
+
 func (self *ChainManager) update() {
 	events := self.eventMux.Subscribe(queueEvent{})
 	futureTimer := time.Tick(5 * time.Second)
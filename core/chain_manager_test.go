@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/ethereum/ethash"
 	"github.com/ethereum/go-ethereum/common"
@@ -504,3 +505,66 @@ func (pow failpow) GetHashrate() int64 {
 }
 func (pow failpow) Turbo(bool) {
 }
+
+// Tests that chains conflicting with the trusted checkpoint and reorgs deeper
+// than the limit are refused and reported, both for full blocks and headers.
+func TestReorgLimits(t *testing.T) {
+	tests := []struct {
+		checkpoint uint64 // Canonical block to trust, 0 for none
+		maxDepth   uint64
+		refused    bool
+	}{
+		{refused: false},
+		{checkpoint: 2, refused: false}, // fork above the checkpoint
+		{checkpoint: 5, refused: true},  // fork conflicting with the checkpoint
+		{maxDepth: 7, refused: false},
+		{maxDepth: 6, refused: true},
+	}
+	for i, tt := range tests {
+		for _, headers := range []bool{false, true} {
+			db, _ := ethdb.NewMemDatabase()
+			bman, err := newCanonical(10, db)
+			if err != nil {
+				t.Fatalf("test %d: failed to create canonical chain: %v", i, err)
+			}
+			bc := bman.bc
+			if tt.checkpoint > 0 {
+				bc.SetCheckpoint(tt.checkpoint, bc.GetBlockByNumber(tt.checkpoint).Hash())
+			}
+			bc.SetMaxReorgDepth(tt.maxDepth)
+			sub := bc.eventMux.Subscribe(ReorgRejectedEvent{})
+
+			// Create a longer fork of the canonical chain off block #3 in a separate database
+			forkDb, _ := ethdb.NewMemDatabase()
+			forkBman, _ := newCanonical(3, forkDb)
+			fork := makeChain(forkBman, forkBman.bc.CurrentBlock(), 10, forkDb, ForkSeed)
+
+			if headers {
+				chain := make([]*types.Header, len(fork))
+				for j, block := range fork {
+					chain[j] = block.Header()
+				}
+				_, err = bman.InsertHeaderChain(chain)
+			} else {
+				_, err = bc.InsertChain(fork)
+			}
+			if IsReorgErr(err) != tt.refused {
+				t.Errorf("test %d (headers %v): reorg error mismatch: have %v, want refused %v", i, headers, err, tt.refused)
+			}
+			if IsCheckpointErr(err) != (tt.refused && tt.checkpoint > 0) {
+				t.Errorf("test %d (headers %v): checkpoint error mismatch: have %v", i, headers, err)
+			}
+			if head := fork[len(fork)-1].Hash(); (bc.CurrentBlock().Hash() != head) != tt.refused {
+				t.Errorf("test %d (headers %v): head mismatch: have #%d, refused %v", i, headers, bc.CurrentBlock().Number(), tt.refused)
+			}
+			if tt.refused {
+				select {
+				case <-sub.Chan():
+				case <-time.After(time.Second):
+					t.Errorf("test %d (headers %v): refused reorg not reported", i, headers)
+				}
+			}
+			sub.Unsubscribe()
+		}
+	}
+}
@@ -34,6 +34,41 @@ func IsParentErr(err error) bool {
 	return ok
 }
 
+// Reorg error. In case the chain would be reorganised below the trusted
+// checkpoint or deeper than the reorg limit this error will be thrown
+type ReorgErr struct {
+	Message    string
+	Checkpoint bool // whether the chain conflicts with the trusted checkpoint
+}
+
+func (err *ReorgErr) Error() string {
+	return err.Message
+}
+
+func ReorgError(format string, v ...interface{}) error {
+	return &ReorgErr{Message: fmt.Sprintf(format, v...)}
+}
+
+func IsReorgErr(err error) bool {
+	_, ok := err.(*ReorgErr)
+
+	return ok
+}
+
+// CheckpointError creates a reorg error for a chain conflicting with the
+// trusted checkpoint, as opposed to one merely exceeding the reorg limit.
+func CheckpointError(format string, v ...interface{}) error {
+	return &ReorgErr{Message: fmt.Sprintf(format, v...), Checkpoint: true}
+}
+
+// IsCheckpointErr reports whether err is a reorg error caused by a chain
+// conflicting with the trusted checkpoint.
+func IsCheckpointErr(err error) bool {
+	reorgErr, ok := err.(*ReorgErr)
+
+	return ok && reorgErr.Checkpoint
+}
+
 type UncleErr struct {
 	Message string
 }
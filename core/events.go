@@ -48,6 +48,13 @@ type ChainUncleEvent struct {
 
 type ChainHeadEvent struct{ Block *types.Block }
 
+// ReorgRejectedEvent is posted when a chain is refused because it conflicts
+// with the trusted checkpoint or switching to it exceeds the reorg limit.
+type ReorgRejectedEvent struct {
+	Block *types.Block
+	Err   error
+}
+
 type GasPriceChanged struct{ Price *big.Int }
 
 // Mining operation events
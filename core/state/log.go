@@ -28,6 +28,19 @@ func (self *Log) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, []interface{}{self.Address, self.Topics, self.Data})
 }
 
+func (self *Log) DecodeRLP(s *rlp.Stream) error {
+	var log struct {
+		Address common.Address
+		Topics  []common.Hash
+		Data    []byte
+	}
+	if err := s.Decode(&log); err != nil {
+		return err
+	}
+	self.Address, self.Topics, self.Data = log.Address, log.Topics, log.Data
+	return nil
+}
+
 func (self *Log) String() string {
 	return fmt.Sprintf(`log: %x %x %x %x %d %x %d`, self.Address, self.Topics, self.Data, self.TxHash, self.TxIndex, self.BlockHash, self.Index)
 }
@@ -48,3 +61,21 @@ func (self *LogForStorage) EncodeRLP(w io.Writer) error {
 		self.Index,
 	})
 }
+
+func (self *LogForStorage) DecodeRLP(s *rlp.Stream) error {
+	var log struct {
+		Address   common.Address
+		Topics    []common.Hash
+		Data      []byte
+		Number    uint64
+		TxHash    common.Hash
+		TxIndex   uint
+		BlockHash common.Hash
+		Index     uint
+	}
+	if err := s.Decode(&log); err != nil {
+		return err
+	}
+	*self = LogForStorage(log)
+	return nil
+}
@@ -296,6 +296,21 @@ func (s *StateDB) Trie() *trie.SecureTrie {
 	return s.trie
 }
 
+// Error returns the first failure to retrieve missing trie nodes from the
+// network, which can only happen on light clients. State read after such a
+// failure may be incomplete.
+func (s *StateDB) Error() error {
+	if err := s.trie.Error(); err != nil {
+		return err
+	}
+	for _, stateObject := range s.stateObjects {
+		if err := stateObject.State.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Resets the trie and all siblings
 func (s *StateDB) Reset() {
 	s.trie.Reset()
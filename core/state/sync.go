@@ -0,0 +1,53 @@
+package state
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// StateSync is the main state synchronisation scheduler, which provides yet the
+// unknown state hashes to retrieve, accepts node data associated with said hashes
+// and reconstructs the state database step by step until all is done.
+type StateSync trie.Sync
+
+// NewStateSync create a new state trie download scheduler.
+func NewStateSync(root common.Hash, database common.Database) *StateSync {
+	var syncer *trie.Sync
+
+	callback := func(leaf []byte, parent common.Hash) error {
+		var obj struct {
+			Nonce    uint64
+			Balance  *big.Int
+			Root     common.Hash
+			CodeHash []byte
+		}
+		if err := rlp.DecodeBytes(leaf, &obj); err != nil {
+			return err
+		}
+		syncer.AddSubTrie(obj.Root, 64, parent, nil)
+		syncer.AddRawEntry(common.BytesToHash(obj.CodeHash), 64, parent)
+
+		return nil
+	}
+	syncer = trie.NewSync(root, database, callback)
+	return (*StateSync)(syncer)
+}
+
+// Missing retrieves the known missing nodes from the state trie for retrieval.
+func (s *StateSync) Missing(max int) []common.Hash {
+	return (*trie.Sync)(s).Missing(max)
+}
+
+// Process injects a batch of retrieved trie nodes data, returning the index of
+// the first one that failed processing, if any.
+func (s *StateSync) Process(list []trie.SyncResult) (int, error) {
+	return (*trie.Sync)(s).Process(list)
+}
+
+// Pending returns the number of state entries currently pending for download.
+func (s *StateSync) Pending() int {
+	return (*trie.Sync)(s).Pending()
+}
@@ -175,6 +175,17 @@ func NewBlockWithHeader(header *Header) *Block {
 	return &Block{header: header}
 }
 
+// WithBody returns a new block with the header of self and the given
+// transactions and uncles. The header is not updated, so the body should
+// match its TxHash and UncleHash.
+func (self *Block) WithBody(transactions []*Transaction, uncles []*Header) *Block {
+	return &Block{
+		header:       self.header,
+		transactions: transactions,
+		uncles:       uncles,
+	}
+}
+
 func (self *Block) ValidateFields() error {
 	if self.header == nil {
 		return fmt.Errorf("header is nil")
@@ -236,7 +247,13 @@ func (self *Block) Uncles() []*Header {
 }
 
 func (self *Block) CalculateUnclesHash() common.Hash {
-	return rlpHash(self.uncles)
+	return CalcUncleHash(self.uncles)
+}
+
+// CalcUncleHash computes the uncle hash of a list of uncle headers,
+// as it appears in the UncleHash field of the including block.
+func CalcUncleHash(uncles []*Header) common.Hash {
+	return rlpHash(uncles)
 }
 
 func (self *Block) SetUncles(uncleHeaders []*Header) {
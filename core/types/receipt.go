@@ -59,6 +59,24 @@ func (self *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, []interface{}{self.PostState, self.CumulativeGasUsed, self.Bloom, storageLogs})
 }
 
+func (self *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
+	var r struct {
+		PostState         []byte
+		CumulativeGasUsed *big.Int
+		Bloom             Bloom
+		Logs              []*state.LogForStorage
+	}
+	if err := s.Decode(&r); err != nil {
+		return err
+	}
+	self.PostState, self.CumulativeGasUsed, self.Bloom = r.PostState, r.CumulativeGasUsed, r.Bloom
+	self.logs = make(state.Logs, len(r.Logs))
+	for i, log := range r.Logs {
+		self.logs[i] = (*state.Log)(log)
+	}
+	return nil
+}
+
 func (self *Receipt) RlpEncode() []byte {
 	bytes, err := rlp.EncodeToBytes(self)
 	if err != nil {
@@ -8,7 +8,10 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/ethash"
@@ -22,12 +25,14 @@ import (
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/les"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/miner"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 	"github.com/ethereum/go-ethereum/whisper"
 )
 
@@ -62,6 +67,9 @@ type Config struct {
 
 	BlockChainVersion  int
 	SkipBcVersionCheck bool // e.g. blockchain export
+	FastSync           bool // Enables the state download based fast synchronisation algorithm
+	LightMode          bool // Synchronises headers only, retrieving state on demand from light servers
+	LightServ          bool // Serves light clients over the les protocol
 
 	DataDir   string
 	LogFile   string
@@ -76,9 +84,34 @@ type Config struct {
 	Discovery       bool
 	Port            string
 
+	// If set, connections are restricted to these IP networks.
+	NetRestrict *netutil.Netlist
+
+	// Subnet limits of the discovery table. If nil, the defaults apply.
+	DiscoveryIPLimits *discover.IPLimits
+
+	// External address detection of the discovery table.
+	// If nil, the default policy applies.
+	DiscoveryEndpointPolicy *discover.EndpointPolicy
+
 	// Space-separated list of discovery node URLs
 	BootNodes string
 
+	// Space-separated list of number=hash fork checkpoints. Peers whose
+	// chains contain a different block at any of these are dropped.
+	ForkCheckpoints string
+
+	// Trusted checkpoint as number=hash. Chains not containing it aren't
+	// synchronised and reorgs below it are refused.
+	Checkpoint string
+
+	// Maximum number of canonical blocks a reorg may replace, 0 for no limit.
+	MaxReorgDepth int
+
+	// If set, all protocol messages are recorded to this file.
+	// See p2p.CaptureWriter for the format.
+	CaptureFile string
+
 	// This key is used to identify the node on the network.
 	// If nil, an ephemeral key is used.
 	NodeKey *ecdsa.PrivateKey
@@ -117,6 +150,39 @@ func (cfg *Config) parseBootNodes() []*discover.Node {
 	return ns
 }
 
+func (cfg *Config) parseForkCheckpoints() map[uint64]common.Hash {
+	checkpoints := make(map[uint64]common.Hash)
+	for _, checkpoint := range strings.Split(cfg.ForkCheckpoints, " ") {
+		if checkpoint == "" {
+			continue
+		}
+		number, hash, err := parseCheckpoint(checkpoint)
+		if err != nil {
+			glog.V(logger.Error).Infof("Fork checkpoint %s: %v\n", checkpoint, err)
+			continue
+		}
+		checkpoints[number] = hash
+	}
+	return checkpoints
+}
+
+// parseCheckpoint parses a block given as number=hash.
+func parseCheckpoint(checkpoint string) (uint64, common.Hash, error) {
+	parts := strings.Split(checkpoint, "=")
+	if len(parts) != 2 {
+		return 0, common.Hash{}, fmt.Errorf("want number=hash")
+	}
+	number, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	hash := common.FromHex(parts[1])
+	if len(hash) != len(common.Hash{}) {
+		return 0, common.Hash{}, fmt.Errorf("invalid hash")
+	}
+	return number, common.BytesToHash(hash), nil
+}
+
 // parseNodes parses a list of discovery node URLs loaded from a .json file.
 func (cfg *Config) parseNodes(file string) []*discover.Node {
 	// Short circuit if no node config is present
@@ -194,12 +260,21 @@ type Ethereum struct {
 	pow             *ethash.Ethash
 	protocolManager *ProtocolManager
 	downloader      *downloader.Downloader
+	lightClient     *les.Client // nil unless running in light mode
+	lightServer     *les.Server // nil unless serving light clients
 	SolcPath        string
 	solc            *compiler.Solidity
 
 	net      *p2p.Server
 	eventMux *event.TypeMux
 	miner    *miner.Miner
+	capture  *os.File // protocol message capture, may be nil
+
+	// Static and trusted nodes, as written to
+	// static-nodes.json and trusted-nodes.json.
+	nodesMu      sync.Mutex
+	staticNodes  map[discover.NodeID]*discover.Node
+	trustedNodes map[discover.NodeID]*discover.Node
 
 	// logger logger.LogSystem
 
@@ -243,12 +318,23 @@ func New(config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, fmt.Errorf("extra db err: %v", err)
 	}
+	// Light clients retrieve missing state from the network
+	var odrDb *les.OdrDatabase
+	if config.LightMode {
+		odrDb = les.NewOdrDatabase(stateDb)
+		stateDb = odrDb
+	}
 	nodeDb := filepath.Join(config.DataDir, "nodes")
 
 	// Perform database sanity checks
 	d, _ := blockDb.Get([]byte("ProtocolVersion"))
 	protov := int(common.NewValue(d).Uint())
-	if protov != config.ProtocolVersion && protov != 0 {
+	compatible := protov == 0 || protov == config.ProtocolVersion
+	for _, version := range ProtocolVersions {
+		// Wire protocol upgrades don't change the database layout
+		compatible = compatible || protov == int(version)
+	}
+	if !compatible {
 		path := filepath.Join(config.DataDir, "blockchain")
 		return nil, fmt.Errorf("Database version mismatch. Protocol(%d / %d). `rm -rf %s`", protov, config.ProtocolVersion, path)
 	}
@@ -290,14 +376,38 @@ func New(config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
-	eth.downloader = downloader.New(eth.EventMux(), eth.chainManager.HasBlock, eth.chainManager.GetBlock)
 	eth.txPool = core.NewTxPool(eth.EventMux(), eth.chainManager.State, eth.chainManager.GasLimit)
 	eth.blockProcessor = core.NewBlockProcessor(stateDb, extraDb, eth.pow, eth.chainManager, eth.EventMux())
 	eth.chainManager.SetProcessor(eth.blockProcessor)
+
+	syncMode := downloader.FullSync
+	if config.FastSync {
+		syncMode = downloader.FastSync
+	}
+	eth.downloader = downloader.New(syncMode, stateDb, eth.EventMux(), eth.chainManager.HasBlock, eth.chainManager.GetBlock, eth.chainManager.CurrentBlock,
+		eth.chainManager.VerifyHeaderPoW, eth.blockProcessor.InsertReceiptChain, eth.chainManager.FastSyncCommitHead)
+
+	if config.Checkpoint != "" {
+		number, hash, err := parseCheckpoint(config.Checkpoint)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %v", config.Checkpoint, err)
+		}
+		eth.chainManager.SetCheckpoint(number, hash)
+		eth.downloader.SetCheckpoint(number, hash)
+	}
+	eth.chainManager.SetMaxReorgDepth(uint64(config.MaxReorgDepth))
 	eth.miner = miner.New(eth, eth.EventMux(), eth.pow)
 	eth.miner.SetGasPrice(config.GasPrice)
 
-	eth.protocolManager = NewProtocolManager(config.ProtocolVersion, config.NetworkId, eth.eventMux, eth.txPool, eth.chainManager, eth.downloader)
+	if config.LightMode {
+		eth.lightClient = les.NewClient(config.NetworkId, eth.chainManager, eth.blockProcessor, extraDb)
+		odrDb.SetClient(eth.lightClient)
+	} else {
+		eth.protocolManager = NewProtocolManager(config.ProtocolVersion, config.NetworkId, eth.eventMux, eth.txPool, eth.chainManager, stateDb, extraDb, eth.downloader, config.parseForkCheckpoints())
+		if config.LightServ {
+			eth.lightServer = les.NewServer(config.NetworkId, eth.eventMux, eth.chainManager, stateDb, extraDb)
+		}
+	}
 	if config.Shh {
 		eth.whisper = whisper.New()
 		eth.shhVersionId = int(eth.whisper.Version())
@@ -307,23 +417,48 @@ func New(config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
-	protocols := []p2p.Protocol{eth.protocolManager.SubProtocol}
+	var protocols []p2p.Protocol
+	if eth.lightClient != nil {
+		protocols = append(protocols, eth.lightClient.Protocol())
+	} else {
+		protocols = append(protocols, eth.protocolManager.SubProtocols...)
+	}
+	if eth.lightServer != nil {
+		protocols = append(protocols, eth.lightServer.Protocol())
+	}
 	if config.Shh {
 		protocols = append(protocols, eth.whisper.Protocol())
 	}
+	if config.CaptureFile != "" {
+		eth.capture, err = os.OpenFile(config.CaptureFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("capture file err: %v", err)
+		}
+		cw := p2p.NewCaptureWriter(eth.capture)
+		for i := range protocols {
+			protocols[i] = cw.Protocol(protocols[i])
+		}
+		glog.V(logger.Info).Infof("Recording protocol messages to %s", config.CaptureFile)
+	}
+	static, trusted := config.parseNodes(staticNodes), config.parseNodes(trustedNodes)
+	eth.staticNodes, eth.trustedNodes = nodeMap(static), nodeMap(trusted)
 	eth.net = &p2p.Server{
-		PrivateKey:      netprv,
-		Name:            config.Name,
-		MaxPeers:        config.MaxPeers,
-		MaxPendingPeers: config.MaxPendingPeers,
-		Discovery:       config.Discovery,
-		Protocols:       protocols,
-		NAT:             config.NAT,
-		NoDial:          !config.Dial,
-		BootstrapNodes:  config.parseBootNodes(),
-		StaticNodes:     config.parseNodes(staticNodes),
-		TrustedNodes:    config.parseNodes(trustedNodes),
-		NodeDatabase:    nodeDb,
+		PrivateKey:              netprv,
+		Name:                    config.Name,
+		MaxPeers:                config.MaxPeers,
+		MaxPendingPeers:         config.MaxPendingPeers,
+		Discovery:               config.Discovery,
+		Protocols:               protocols,
+		NAT:                     config.NAT,
+		NoDial:                  !config.Dial,
+		NetRestrict:             config.NetRestrict,
+		DiscoveryIPLimits:       config.DiscoveryIPLimits,
+		DiscoveryEndpointPolicy: config.DiscoveryEndpointPolicy,
+		EventMux:                eth.eventMux,
+		BootstrapNodes:          config.parseBootNodes(),
+		StaticNodes:             static,
+		TrustedNodes:            trusted,
+		NodeDatabase:            nodeDb,
 	}
 	if len(config.Port) > 0 {
 		eth.net.ListenAddr = ":" + config.Port
@@ -343,6 +478,7 @@ type NodeInfo struct {
 	TCPPort    int // TCP listening port for RLPx
 	Td         string
 	ListenAddr string
+	Traffic    *TrafficInfo // aggregate over all connections
 }
 
 func (s *Ethereum) NodeInfo() *NodeInfo {
@@ -357,15 +493,36 @@ func (s *Ethereum) NodeInfo() *NodeInfo {
 		TCPPort:    int(node.TCP),
 		ListenAddr: s.net.ListenAddr,
 		Td:         s.ChainManager().Td().String(),
+		Traffic:    newTrafficInfo(s.net.Traffic()),
 	}
 }
 
+// TrafficInfo describes the traffic of a peer or of the whole node.
+// Messages is keyed by protocol name and message code.
+type TrafficInfo struct {
+	p2p.TrafficStats
+	Messages map[string]map[string]p2p.TrafficStats
+}
+
+func newTrafficInfo(t p2p.Traffic) *TrafficInfo {
+	info := &TrafficInfo{TrafficStats: t.Total, Messages: make(map[string]map[string]p2p.TrafficStats)}
+	for proto, codes := range t.Protocols {
+		m := make(map[string]p2p.TrafficStats, len(codes))
+		for code, stats := range codes {
+			m[fmt.Sprintf("%#x", code)] = stats
+		}
+		info.Messages[proto] = m
+	}
+	return info
+}
+
 type PeerInfo struct {
 	ID            string
 	Name          string
 	Caps          string
 	RemoteAddress string
 	LocalAddress  string
+	Traffic       *TrafficInfo
 }
 
 func newPeerInfo(peer *p2p.Peer) *PeerInfo {
@@ -379,6 +536,7 @@ func newPeerInfo(peer *p2p.Peer) *PeerInfo {
 		Caps:          strings.Join(caps, ", "),
 		RemoteAddress: peer.RemoteAddr().String(),
 		LocalAddress:  peer.LocalAddr().String(),
+		Traffic:       newTrafficInfo(peer.Traffic()),
 	}
 }
 
@@ -392,6 +550,79 @@ func (s *Ethereum) PeersInfo() (peersinfo []*PeerInfo) {
 	return
 }
 
+type BanInfo struct {
+	Target  string // node ID or network
+	Expires string // empty for bans that never expire
+	Reason  string
+}
+
+// Bans returns the active peer bans.
+func (s *Ethereum) Bans() []*BanInfo {
+	var bans []*BanInfo
+	for _, b := range s.net.Bans() {
+		info := &BanInfo{Target: b.Target(), Reason: b.Reason}
+		if !b.Expires.IsZero() {
+			info.Expires = b.Expires.String()
+		}
+		bans = append(bans, info)
+	}
+	return bans
+}
+
+// DiscoveryInfo contains statistics about the discovery table.
+type DiscoveryInfo struct {
+	Buckets       map[string]int  // number of nodes in each non-empty bucket, keyed by log distance
+	Subnets       map[string]uint // number of nodes in each subnet
+	BucketRejects uint64          // nodes rejected because of the bucket subnet limit
+	TableRejects  uint64          // nodes rejected because of the table subnet limit
+}
+
+// DiscoveryStats returns statistics about the discovery table,
+// or nil if discovery is disabled.
+func (s *Ethereum) DiscoveryStats() *DiscoveryInfo {
+	stats := s.net.DiscoveryStats()
+	if stats == nil {
+		return nil
+	}
+	info := &DiscoveryInfo{
+		Buckets:       make(map[string]int),
+		Subnets:       stats.Subnets,
+		BucketRejects: stats.BucketRejects,
+		TableRejects:  stats.TableRejects,
+	}
+	for dist, n := range stats.Buckets {
+		if n > 0 {
+			info.Buckets[strconv.Itoa(dist)] = n
+		}
+	}
+	return info
+}
+
+// BanPeer bans a node, IP address or network from connecting. The target
+// may be an enode URL, a node ID, an IP address or a network in CIDR
+// notation. A zero duration bans the target permanently.
+func (s *Ethereum) BanPeer(target string, d time.Duration, reason string) error {
+	b, err := discover.ParseBan(target)
+	if err != nil {
+		return err
+	}
+	if d > 0 {
+		b.Expires = time.Now().Add(d)
+	}
+	b.Reason = reason
+	return s.net.Ban(b)
+}
+
+// UnbanPeer lifts the ban of the given target. It returns
+// false if the target was not banned.
+func (s *Ethereum) UnbanPeer(target string) (bool, error) {
+	b, err := discover.ParseBan(target)
+	if err != nil {
+		return false, err
+	}
+	return s.net.Unban(b)
+}
+
 func (s *Ethereum) ResetWithGenesisBlock(gb *types.Block) {
 	s.chainManager.ResetWithGenesisBlock(gb)
 }
@@ -448,6 +679,7 @@ func (s *Ethereum) EthVersion() int                      { return s.ethVersionId
 func (s *Ethereum) NetVersion() int                      { return s.netVersionId }
 func (s *Ethereum) ShhVersion() int                      { return s.shhVersionId }
 func (s *Ethereum) Downloader() *downloader.Downloader   { return s.downloader }
+func (s *Ethereum) LightClient() *les.Client             { return s.lightClient }
 
 // Start the ethereum
 func (s *Ethereum) Start() error {
@@ -466,7 +698,12 @@ func (s *Ethereum) Start() error {
 		s.StartAutoDAG()
 	}
 
-	s.protocolManager.Start()
+	if s.protocolManager != nil {
+		s.protocolManager.Start()
+	}
+	if s.lightServer != nil {
+		s.lightServer.Start()
+	}
 
 	if s.whisper != nil {
 		s.whisper.Start()
@@ -521,19 +758,112 @@ func (self *Ethereum) AddPeer(nodeURL string) error {
 	if err != nil {
 		return fmt.Errorf("invalid node URL: %v", err)
 	}
+	self.nodesMu.Lock()
+	self.staticNodes[n.ID] = n
+	self.nodesMu.Unlock()
 	self.net.AddPeer(n)
 	return nil
 }
 
+// RemovePeer disconnects from the given node and stops
+// maintaining the connection.
+func (self *Ethereum) RemovePeer(nodeURL string) error {
+	n, err := discover.ParseNode(nodeURL)
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.nodesMu.Lock()
+	delete(self.staticNodes, n.ID)
+	self.nodesMu.Unlock()
+	self.net.RemovePeer(n)
+	return nil
+}
+
+// AddTrustedPeer allows the given node to connect even
+// if the peer limit has been reached.
+func (self *Ethereum) AddTrustedPeer(nodeURL string) error {
+	n, err := discover.ParseNode(nodeURL)
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.nodesMu.Lock()
+	self.trustedNodes[n.ID] = n
+	self.nodesMu.Unlock()
+	self.net.AddTrustedPeer(n)
+	return nil
+}
+
+// RemoveTrustedPeer removes the given node from the trusted node list.
+func (self *Ethereum) RemoveTrustedPeer(nodeURL string) error {
+	n, err := discover.ParseNode(nodeURL)
+	if err != nil {
+		return fmt.Errorf("invalid node URL: %v", err)
+	}
+	self.nodesMu.Lock()
+	delete(self.trustedNodes, n.ID)
+	self.nodesMu.Unlock()
+	self.net.RemoveTrustedPeer(n)
+	return nil
+}
+
+// SaveStaticNodes writes the current static node list
+// to static-nodes.json in the data directory.
+func (self *Ethereum) SaveStaticNodes() error {
+	self.nodesMu.Lock()
+	defer self.nodesMu.Unlock()
+	return saveNodes(filepath.Join(self.DataDir, staticNodes), self.staticNodes)
+}
+
+// SaveTrustedNodes writes the current trusted node list
+// to trusted-nodes.json in the data directory.
+func (self *Ethereum) SaveTrustedNodes() error {
+	self.nodesMu.Lock()
+	defer self.nodesMu.Unlock()
+	return saveNodes(filepath.Join(self.DataDir, trustedNodes), self.trustedNodes)
+}
+
+func nodeMap(nodes []*discover.Node) map[discover.NodeID]*discover.Node {
+	m := make(map[discover.NodeID]*discover.Node, len(nodes))
+	for _, n := range nodes {
+		m[n.ID] = n
+	}
+	return m
+}
+
+// saveNodes writes a node list in the format read by Config.parseNodes.
+func saveNodes(path string, nodes map[discover.NodeID]*discover.Node) error {
+	urls := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		urls = append(urls, n.String())
+	}
+	sort.Strings(urls)
+	blob, err := json.MarshalIndent(urls, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, blob, 0644)
+}
+
 func (s *Ethereum) Stop() {
 	s.net.Stop()
 	s.chainManager.Stop()
-	s.protocolManager.Stop()
+	if s.protocolManager != nil {
+		s.protocolManager.Stop()
+	}
+	if s.lightServer != nil {
+		s.lightServer.Stop()
+	}
+	if s.lightClient != nil {
+		s.lightClient.Stop()
+	}
 	s.txPool.Stop()
 	s.eventMux.Stop()
 	if s.whisper != nil {
 		s.whisper.Stop()
 	}
+	if s.capture != nil {
+		s.capture.Close()
+	}
 	s.StopAutoDAG()
 
 	close(s.shutdownChan)
@@ -0,0 +1,60 @@
+package eth
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+func testNodeURL(t *testing.T, port uint16) string {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &discover.Node{ID: discover.PubkeyID(&key.PublicKey), IP: net.ParseIP("127.0.0.1"), UDP: port, TCP: port}
+	return n.String()
+}
+
+// Tests that trusted nodes modified at runtime are written to
+// trusted-nodes.json and loaded from it again.
+func TestTrustedNodesPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eth-nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The p2p server is not started, trusted nodes must be accepted anyway
+	eth := &Ethereum{
+		DataDir:      dir,
+		net:          &p2p.Server{},
+		staticNodes:  nodeMap(nil),
+		trustedNodes: nodeMap(nil),
+	}
+	keep, drop := testNodeURL(t, 30303), testNodeURL(t, 30304)
+	for _, url := range []string{keep, drop} {
+		if err := eth.AddTrustedPeer(url); err != nil {
+			t.Fatalf("failed to add trusted peer: %v", err)
+		}
+	}
+	if err := eth.RemoveTrustedPeer(drop); err != nil {
+		t.Fatalf("failed to remove trusted peer: %v", err)
+	}
+	if err := eth.SaveTrustedNodes(); err != nil {
+		t.Fatalf("failed to save trusted nodes: %v", err)
+	}
+
+	config := &Config{DataDir: dir}
+	nodes := config.parseNodes(trustedNodes)
+	if len(nodes) != 1 || nodes[0].String() != keep {
+		t.Errorf("loaded trusted nodes mismatch: have %v, want [%s]", nodes, keep)
+	}
+	if nodes := config.parseNodes(staticNodes); len(nodes) != 0 {
+		t.Errorf("static nodes written: %v", nodes)
+	}
+}
@@ -17,42 +17,70 @@ import (
 	"gopkg.in/fatih/set.v0"
 )
 
+const (
+	eth60 = 60 // Protocol version retrieving blocks through hash chains
+	eth62 = 62 // Protocol version supporting header and block body retrieval
+	eth63 = 63 // Protocol version supporting receipt and state trie node retrieval
+)
+
 var (
-	MinHashFetch  = 512  // Minimum amount of hashes to not consider a peer stalling
-	MaxHashFetch  = 2048 // Amount of hashes to be fetched per retrieval request
-	MaxBlockFetch = 128  // Amount of blocks to be fetched per retrieval request
+	MinHashFetch    = 512  // Minimum amount of hashes to not consider a peer stalling
+	MaxHashFetch    = 2048 // Amount of hashes to be fetched per retrieval request
+	MaxBlockFetch   = 128  // Amount of blocks to be fetched per retrieval request
+	MaxHeaderFetch  = 192  // Amount of block headers to be fetched per retrieval request
+	MaxBodyFetch    = 128  // Amount of block bodies to be fetched per retrieval request
+	MaxReceiptFetch = 256  // Amount of transaction receipts to allow fetching per request
+	MaxStateFetch   = 384  // Amount of node state values to allow fetching per request
+
+	maxQueuedHeaders = 32 * MaxHeaderFetch // Maximum number of headers awaiting body retrieval before throttling
 
 	hashTTL         = 5 * time.Second  // Time it takes for a hash request to time out
+	headerTTL       = 5 * time.Second  // Time it takes for a header request to time out
 	blockSoftTTL    = 3 * time.Second  // Request completion threshold for increasing or decreasing a peer's bandwidth
 	blockHardTTL    = 3 * blockSoftTTL // Maximum time allowance before a block request is considered expired
 	crossCheckCycle = time.Second      // Period after which to check for expired cross checks
 
 	maxBannedHashes = 4096 // Number of bannable hashes before phasing old ones out
+
+	fsMinFullBlocks = 64 // Number of blocks to retrieve fully even in fast sync
+
+	progressCycle = time.Second // Period of sync progress events while synchronising
 )
 
 var (
-	errLowTd            = errors.New("peers TD is too low")
-	ErrBusy             = errors.New("busy")
-	errUnknownPeer      = errors.New("peer is unknown or unhealthy")
-	ErrBadPeer          = errors.New("action from bad peer ignored")
-	ErrStallingPeer     = errors.New("peer is stalling")
-	errBannedHead       = errors.New("peer head hash already banned")
-	errNoPeers          = errors.New("no peers to keep download active")
-	ErrPendingQueue     = errors.New("pending items in queue")
-	ErrTimeout          = errors.New("timeout")
-	ErrEmptyHashSet     = errors.New("empty hash set by peer")
-	errPeersUnavailable = errors.New("no peers available or all peers tried for block download process")
-	errAlreadyInPool    = errors.New("hash already in pool")
-	ErrInvalidChain     = errors.New("retrieved hash chain is invalid")
-	ErrCrossCheckFailed = errors.New("block cross-check failed")
-	errCancelHashFetch  = errors.New("hash fetching cancelled (requested)")
-	errCancelBlockFetch = errors.New("block downloading cancelled (requested)")
-	errNoSyncActive     = errors.New("no sync active")
+	errLowTd              = errors.New("peers TD is too low")
+	ErrBusy               = errors.New("busy")
+	errUnknownPeer        = errors.New("peer is unknown or unhealthy")
+	ErrBadPeer            = errors.New("action from bad peer ignored")
+	ErrStallingPeer       = errors.New("peer is stalling")
+	errBannedHead         = errors.New("peer head hash already banned")
+	errNoPeers            = errors.New("no peers to keep download active")
+	ErrPendingQueue       = errors.New("pending items in queue")
+	ErrTimeout            = errors.New("timeout")
+	ErrEmptyHashSet       = errors.New("empty hash set by peer")
+	errPeersUnavailable   = errors.New("no peers available or all peers tried for block download process")
+	errAlreadyInPool      = errors.New("hash already in pool")
+	ErrInvalidChain       = errors.New("retrieved hash chain is invalid")
+	ErrCrossCheckFailed   = errors.New("block cross-check failed")
+	ErrCheckpointMismatch = errors.New("chain does not contain the trusted checkpoint")
+	errCancelHashFetch    = errors.New("hash fetching cancelled (requested)")
+	errCancelBlockFetch   = errors.New("block downloading cancelled (requested)")
+	errCancelHeaderFetch  = errors.New("header fetching cancelled (requested)")
+	errCancelBodyFetch    = errors.New("block body downloading cancelled (requested)")
+	errCancelReceiptFetch = errors.New("receipt downloading cancelled (requested)")
+	errCancelStateFetch   = errors.New("state data downloading cancelled (requested)")
+	errCancelFastProcess  = errors.New("fast block processing cancelled (requested)")
+	errPivotMismatch      = errors.New("fast sync pivot block mismatch")
+	errNoSyncActive       = errors.New("no sync active")
 )
 
 type hashCheckFn func(common.Hash) bool
 type getBlockFn func(common.Hash) *types.Block
+type headBlockFn func() *types.Block
+type headerCheckFn func(*types.Header) bool
 type chainInsertFn func(types.Blocks) (int, error)
+type receiptChainInsertFn func(types.Blocks, []types.Receipts) (int, error)
+type headCommitFn func(common.Hash) error
 type hashIterFn func() (common.Hash, error)
 
 type blockPack struct {
@@ -65,28 +93,79 @@ type hashPack struct {
 	hashes []common.Hash
 }
 
+type headerPack struct {
+	peerId  string
+	headers []*types.Header
+}
+
+// dataPack is a data message returned by a peer for some query, which is passed
+// on to one of the part fetchers.
+type dataPack interface {
+	PeerId() string
+	Items() int
+}
+
+type bodyPack struct {
+	peerId       string
+	transactions [][]*types.Transaction
+	uncles       [][]*types.Header
+}
+
+func (p bodyPack) PeerId() string { return p.peerId }
+func (p bodyPack) Items() int     { return len(p.transactions) }
+
+type receiptPack struct {
+	peerId   string
+	receipts [][]*types.Receipt
+}
+
+func (p receiptPack) PeerId() string { return p.peerId }
+func (p receiptPack) Items() int     { return len(p.receipts) }
+
+type statePack struct {
+	peerId string
+	states [][]byte
+}
+
+func (p statePack) PeerId() string { return p.peerId }
+func (p statePack) Items() int     { return len(p.states) }
+
 type crossCheck struct {
 	expire time.Time
 	parent common.Hash
 }
 
 type Downloader struct {
-	mux *event.TypeMux
+	mode    SyncMode        // Synchronisation mode defining the strategy used
+	stateDb common.Database // Database to state sync into (and deduplicate via)
+	mux     *event.TypeMux
 
 	queue  *queue                      // Scheduler for selecting the hashes to download
 	peers  *peerSet                    // Set of active peers from which download can proceed
 	checks map[common.Hash]*crossCheck // Pending cross checks to verify a hash chain
 	banned *set.Set                    // Set of hashes we've received and banned
 
+	checkpoint     uint64      // Number of the trusted checkpoint block, see SetCheckpoint
+	checkpointHash common.Hash // Hash of the trusted checkpoint block, zero if unset
+
 	// Statistics
 	importStart time.Time // Instance when the last blocks were taken from the cache
 	importQueue []*Block  // Previously taken blocks to check import progress
 	importDone  int       // Number of taken blocks already imported from the last batch
 	importLock  sync.Mutex
 
+	syncStatsOrigin uint64       // Block number the current or last unfinished sync started at
+	syncStatsHeight uint64       // Highest block number known to be available
+	syncStatsLock   sync.RWMutex // Lock protecting the sync progress fields
+
 	// Callbacks
-	hasBlock hashCheckFn
-	getBlock getBlockFn
+	hasBlock     hashCheckFn
+	getBlock     getBlockFn
+	headBlock    headBlockFn   // Retrieves the head of the local chain
+	verifyHeader headerCheckFn // Checks the proof-of-work of a header
+
+	insertReceipts receiptChainInsertFn // Imports a batch of blocks and their receipts without executing them
+	commitHead     headCommitFn         // Sets the head of the chain to a fast synced block
 
 	// Status
 	synchronising int32
@@ -96,6 +175,10 @@ type Downloader struct {
 	newPeerCh chan *peer
 	hashCh    chan hashPack
 	blockCh   chan blockPack
+	headerCh  chan headerPack
+	bodyCh    chan dataPack
+	receiptCh chan dataPack
+	stateCh   chan dataPack
 
 	cancelCh   chan struct{} // Channel to cancel mid-flight syncs
 	cancelLock sync.RWMutex  // Lock to protect the cancel channel in delivers
@@ -107,17 +190,31 @@ type Block struct {
 	OriginPeer string
 }
 
-func New(mux *event.TypeMux, hasBlock hashCheckFn, getBlock getBlockFn) *Downloader {
+// New creates a new downloader to fetch hashes and blocks from remote peers. In
+// fast sync mode the state of a recent pivot block is downloaded into stateDb,
+// and the blocks below it are imported along with their receipts.
+func New(mode SyncMode, stateDb common.Database, mux *event.TypeMux, hasBlock hashCheckFn, getBlock getBlockFn, headBlock headBlockFn,
+	verifyHeader headerCheckFn, insertReceipts receiptChainInsertFn, commitHead headCommitFn) *Downloader {
 	// Create the base downloader
 	downloader := &Downloader{
-		mux:       mux,
-		queue:     newQueue(),
-		peers:     newPeerSet(),
-		hasBlock:  hasBlock,
-		getBlock:  getBlock,
-		newPeerCh: make(chan *peer, 1),
-		hashCh:    make(chan hashPack, 1),
-		blockCh:   make(chan blockPack, 1),
+		mode:           mode,
+		stateDb:        stateDb,
+		mux:            mux,
+		queue:          newQueue(),
+		peers:          newPeerSet(),
+		hasBlock:       hasBlock,
+		getBlock:       getBlock,
+		headBlock:      headBlock,
+		verifyHeader:   verifyHeader,
+		insertReceipts: insertReceipts,
+		commitHead:     commitHead,
+		newPeerCh:      make(chan *peer, 1),
+		hashCh:         make(chan hashPack, 1),
+		blockCh:        make(chan blockPack, 1),
+		headerCh:       make(chan headerPack, 1),
+		bodyCh:         make(chan dataPack, 1),
+		receiptCh:      make(chan dataPack, 1),
+		stateCh:        make(chan dataPack, 1),
 	}
 	// Inject all the known bad hashes
 	downloader.banned = set.New()
@@ -150,14 +247,103 @@ func (d *Downloader) Stats() (pending int, cached int, importing int, estimate t
 	return
 }
 
+// SetCheckpoint sets a trusted checkpoint block. Chains forking off the local
+// one below the checkpoint are only synchronised if they contain it.
+func (d *Downloader) SetCheckpoint(number uint64, hash common.Hash) {
+	d.checkpoint, d.checkpointHash = number, hash
+}
+
+// checkCheckpoint verifies that a remote chain, forking off the local one after
+// block ancestor and ending at block head, contains the trusted checkpoint if
+// it reaches it. Chains not reaching it are rejected too if the local chain
+// passed it, as they would reorganise it away.
+func (d *Downloader) checkCheckpoint(ancestor, head uint64, contains func() (bool, error)) error {
+	if d.checkpointHash == (common.Hash{}) || ancestor >= d.checkpoint {
+		return nil
+	}
+	if head < d.checkpoint {
+		if d.headBlock().NumberU64() >= d.checkpoint {
+			glog.V(logger.Warn).Infof("Rejected chain forking off at #%d below trusted checkpoint #%d", ancestor, d.checkpoint)
+			return ErrCheckpointMismatch
+		}
+		return nil
+	}
+	ok, err := contains()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		glog.V(logger.Warn).Infof("Rejected chain without trusted checkpoint #%d [%x]", d.checkpoint, d.checkpointHash[:4])
+		return ErrCheckpointMismatch
+	}
+	return nil
+}
+
+// Progress retrieves the boundaries of the current or last synchronisation:
+// the block number it started at, the local head and the highest block known
+// to be available. A sync interrupted before reaching its height keeps its
+// origin in the next one, so catching up shows as a single sync.
+func (d *Downloader) Progress() SyncProgress {
+	d.syncStatsLock.RLock()
+	defer d.syncStatsLock.RUnlock()
+
+	return SyncProgress{
+		Origin:  d.syncStatsOrigin,
+		Current: d.headBlock().NumberU64(),
+		Height:  d.syncStatsHeight,
+	}
+}
+
+// setHeight sets the highest known block number to the head of the syncing peer.
+func (d *Downloader) setHeight(number uint64) {
+	d.syncStatsLock.Lock()
+	defer d.syncStatsLock.Unlock()
+
+	d.syncStatsHeight = number
+}
+
+// raiseHeight raises the highest known block number if blocks above it arrive.
+func (d *Downloader) raiseHeight(number uint64) {
+	d.syncStatsLock.Lock()
+	defer d.syncStatsLock.Unlock()
+
+	if number > d.syncStatsHeight {
+		d.syncStatsHeight = number
+	}
+}
+
+// reportProgress posts a ProgressEvent every progressCycle in which the sync
+// progressed, until stop is closed.
+func (d *Downloader) reportProgress(stop chan struct{}) {
+	ticker := time.NewTicker(progressCycle)
+	defer ticker.Stop()
+
+	last := d.Progress()
+	for {
+		select {
+		case <-ticker.C:
+			if progress := d.Progress(); progress != last {
+				d.mux.Post(ProgressEvent{progress})
+				last = progress
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
 // Synchronising returns the state of the downloader
 func (d *Downloader) Synchronising() bool {
 	return atomic.LoadInt32(&d.synchronising) > 0
 }
 
 // RegisterPeer injects a new download peer into the set of block source to be
-// used for fetching hashes and blocks from.
-func (d *Downloader) RegisterPeer(id string, head common.Hash, getHashes hashFetcherFn, getBlocks blockFetcherFn) error {
+// used for fetching hashes and blocks from. Peers running eth/62 or later must
+// also provide header and block body retrieval methods, and peers running eth/63
+// receipt and state trie node retrieval methods too.
+func (d *Downloader) RegisterPeer(id string, version int, head common.Hash, getHashes hashFetcherFn, getBlocks blockFetcherFn,
+	getHeaders headerFetcherFn, getHeadersByHash headerHashFetcherFn, getBodies bodyFetcherFn,
+	getReceipts receiptFetcherFn, getNodeData stateFetcherFn) error {
 	// If the peer wants to send a banned hash, reject
 	if d.banned.Has(head) {
 		glog.V(logger.Debug).Infoln("Register rejected, head hash banned:", id)
@@ -165,7 +351,7 @@ func (d *Downloader) RegisterPeer(id string, head common.Hash, getHashes hashFet
 	}
 	// Otherwise try to construct and register the peer
 	glog.V(logger.Detail).Infoln("Registering peer", id)
-	if err := d.peers.Register(newPeer(id, head, getHashes, getBlocks)); err != nil {
+	if err := d.peers.Register(newPeer(id, version, head, getHashes, getBlocks, getHeaders, getHeadersByHash, getBodies, getReceipts, getNodeData)); err != nil {
 		glog.V(logger.Error).Infoln("Register failed:", err)
 		return err
 	}
@@ -207,6 +393,17 @@ func (d *Downloader) Synchronise(id string, hash common.Hash) error {
 	d.cancelCh = make(chan struct{})
 	d.cancelLock.Unlock()
 
+	// Release any deliveries still blocked on a fetcher once the sync ends
+	defer func() {
+		d.cancelLock.Lock()
+		select {
+		case <-d.cancelCh:
+		default:
+			close(d.cancelCh)
+		}
+		d.cancelLock.Unlock()
+	}()
+
 	// Abort if the queue still contains some leftover data
 	if _, cached := d.queue.Size(); cached > 0 && d.queue.GetHeadBlock() != nil {
 		return ErrPendingQueue
@@ -247,23 +444,39 @@ func (d *Downloader) Has(hash common.Hash) bool {
 // syncWithPeer starts a block synchronization based on the hash chain from the
 // specified peer and head hash.
 func (d *Downloader) syncWithPeer(p *peer, hash common.Hash) (err error) {
-	d.mux.Post(StartEvent{})
+	// Start a new sync unless the last one was interrupted before its height
+	d.syncStatsLock.Lock()
+	if current := d.headBlock().NumberU64(); current >= d.syncStatsHeight {
+		d.syncStatsOrigin, d.syncStatsHeight = current, current
+	}
+	d.syncStatsLock.Unlock()
+
+	d.mux.Post(StartEvent{d.Progress()})
+	stop := make(chan struct{})
+	go d.reportProgress(stop)
 	defer func() {
+		close(stop)
 		// reset on error
 		if err != nil {
 			d.Cancel()
 			d.mux.Post(FailedEvent{err})
 		} else {
-			d.mux.Post(DoneEvent{})
+			d.mux.Post(DoneEvent{d.Progress()})
 		}
 	}()
 
-	glog.V(logger.Debug).Infoln("Synchronizing with the network using:", p.id)
-	if err = d.fetchHashes(p, hash); err != nil {
-		return err
-	}
-	if err = d.fetchBlocks(); err != nil {
-		return err
+	glog.V(logger.Debug).Infof("Synchronizing with the network using: %s, eth/%d", p.id, p.version)
+	if p.version >= eth62 {
+		if err = d.fetchHeadersFirst(p, hash); err != nil {
+			return err
+		}
+	} else {
+		if err = d.fetchHashes(p, hash); err != nil {
+			return err
+		}
+		if err = d.fetchBlocks(); err != nil {
+			return err
+		}
 	}
 	glog.V(logger.Debug).Infoln("Synchronization completed")
 
@@ -398,6 +611,15 @@ func (d *Downloader) fetchHashes(p *peer, h common.Hash) error {
 				offset = int(block.NumberU64() + 1)
 			}
 			d.queue.Prepare(offset)
+			if pending := d.queue.Pending(); pending > 0 {
+				err := d.checkCheckpoint(uint64(offset-1), uint64(offset+pending-1), func() (bool, error) {
+					return d.queue.Has(d.checkpointHash), nil
+				})
+				if err != nil {
+					return err
+				}
+				d.setHeight(uint64(offset + pending - 1))
+			}
 			finished = true
 
 		case blockPack := <-d.blockCh:
@@ -472,6 +694,10 @@ out:
 		case <-d.hashCh:
 			// Out of bounds hashes received, ignore them
 
+		case <-d.headerCh:
+		case <-d.bodyCh:
+			// Out of bounds headers or bodies received, ignore them
+
 		case blockPack := <-d.blockCh:
 			// Short circuit if it's a stale cross check
 			if len(blockPack.blocks) == 1 {
@@ -496,6 +722,9 @@ out:
 						break
 					}
 					// All was successful, promote the peer
+					for _, block := range blockPack.blocks {
+						d.raiseHeight(block.NumberU64())
+					}
 					peer.Promote()
 					peer.SetIdle()
 					glog.V(logger.Detail).Infof("%s: delivered %d blocks", peer, len(blockPack.blocks))
@@ -588,6 +817,648 @@ out:
 	return nil
 }
 
+// fetchHeadersFirst synchronises with a peer supporting header retrieval. The
+// header chain is downloaded from the origin peer and verified before any body
+// is requested, while the block bodies are filled in concurrently from all the
+// capable peers.
+//
+// In fast sync mode, if the local chain is empty and the peer supports eth/63,
+// the chain is first fast synced up to a pivot block near the remote head, and
+// only the remaining blocks are processed fully.
+func (d *Downloader) fetchHeadersFirst(p *peer, head common.Hash) error {
+	number, err := d.findAncestor(p)
+	if err != nil {
+		return err
+	}
+	// Retrieve the remote head header to find out the chain height
+	latest, err := d.fetchHeader(p, func() { p.getHeadersByHash(head, 1, 0, false) }, func(header *types.Header) bool {
+		return header.Hash() == head
+	})
+	if err != nil {
+		return err
+	}
+	d.setHeight(latest.Number.Uint64())
+
+	// Make sure the remote chain contains the trusted checkpoint
+	err = d.checkCheckpoint(number, latest.Number.Uint64(), func() (bool, error) {
+		header, err := d.fetchHeader(p, func() { p.getHeaders(d.checkpoint, 1, 0, false) }, func(header *types.Header) bool {
+			return header.Number.Uint64() == d.checkpoint
+		})
+		if err != nil {
+			return false, err
+		}
+		return header.Hash() == d.checkpointHash, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if d.mode == FastSync && p.version >= eth63 && d.headBlock().NumberU64() == 0 {
+		pivot, err := d.fetchPivot(p, latest, number)
+		if err != nil {
+			return err
+		}
+		if pivot != nil {
+			if err := d.fetchFast(p, number, pivot); err != nil {
+				return err
+			}
+			number = pivot.Number.Uint64()
+
+			// Drop any late fast sync replies while the head is synchronised
+			stop := make(chan struct{})
+			defer close(stop)
+			go d.discardFast(stop)
+		}
+	}
+	d.queue.Prepare(int(number + 1))
+
+	// Run the header and body fetchers, aborting both if either fails
+	headersDone := make(chan struct{})
+	errc := make(chan error, 2)
+	go func() {
+		err := d.fetchHeaders(p, number+1, nil)
+		if err == nil {
+			close(headersDone)
+		}
+		errc <- err
+	}()
+	go func() { errc <- d.fetchBodies(headersDone) }()
+
+	var fail error
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil && fail == nil {
+			fail = err
+			d.Cancel()
+		}
+	}
+	return fail
+}
+
+// fetchPivot retrieves the header of the fast sync pivot block, located
+// fsMinFullBlocks below the remote head. If the remote chain isn't long enough
+// above the common ancestor, nil is returned and the chain is fully synced.
+func (d *Downloader) fetchPivot(p *peer, latest *types.Header, ancestor uint64) (*types.Header, error) {
+	height := latest.Number.Uint64()
+	if height <= ancestor+uint64(fsMinFullBlocks) {
+		glog.V(logger.Debug).Infof("%v: remote head #%d too close to #%d, skipping fast sync", p, height, ancestor)
+		return nil, nil
+	}
+	// Retrieve the header of the pivot block by number
+	number := height - uint64(fsMinFullBlocks)
+	pivot, err := d.fetchHeader(p, func() { p.getHeaders(number, 1, 0, false) }, func(header *types.Header) bool {
+		return header.Number.Uint64() == number
+	})
+	if err != nil {
+		return nil, err
+	}
+	glog.V(logger.Debug).Infof("%v: fast syncing up to pivot #%d [%x]", p, number, pivot.Hash().Bytes()[:4])
+	return pivot, nil
+}
+
+// fetchHeader sends a single header request to the peer and waits for a reply
+// accepted by the match function, ignoring any stale replies.
+func (d *Downloader) fetchHeader(p *peer, request func(), match func(*types.Header) bool) (*types.Header, error) {
+	request()
+
+	timeout := time.After(headerTTL)
+	for {
+		select {
+		case <-d.cancelCh:
+			return nil, errCancelHeaderFetch
+
+		case headerPack := <-d.headerCh:
+			if headerPack.peerId != p.id {
+				break
+			}
+			headers := headerPack.headers
+			if len(headers) == 0 {
+				glog.V(logger.Debug).Infof("%v: requested header not served", p)
+				return nil, ErrBadPeer
+			}
+			if len(headers) == 1 && match(headers[0]) {
+				return headers[0], nil
+			}
+			// Stale reply to an earlier request, keep waiting
+
+		case <-timeout:
+			glog.V(logger.Debug).Infof("%v: header request timed out", p)
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// fetchFast synchronises the chain up to the pivot block without executing any
+// transactions. The headers are retrieved from the origin peer, while the block
+// bodies, receipts and the state trie of the pivot are fetched concurrently
+// from all the capable peers. The blocks are imported along with their receipts
+// as they are completed, and once the state is complete too, the pivot becomes
+// the new head of the local chain.
+func (d *Downloader) fetchFast(p *peer, ancestor uint64, pivot *types.Header) error {
+	d.queue.Prepare(int(ancestor + 1))
+	d.queue.PrepareFast(pivot, d.stateDb)
+	defer d.queue.FinishFast()
+
+	// Run the data fetchers and the block importer, aborting all if any fails
+	headersDone, fetched := make(chan struct{}), make(chan struct{})
+	fetchErrc, processErrc := make(chan error, 4), make(chan error, 1)
+
+	go func() {
+		err := d.fetchHeaders(p, ancestor+1, pivot)
+		if err == nil {
+			close(headersDone)
+		}
+		fetchErrc <- err
+	}()
+	go func() { fetchErrc <- d.fetchBodies(headersDone) }()
+	go func() { fetchErrc <- d.fetchReceipts(headersDone) }()
+	go func() { fetchErrc <- d.fetchNodeData() }()
+	go func() { processErrc <- d.processFast(fetched) }()
+
+	var fail error
+	for fetchers, running := 4, 5; running > 0; running-- {
+		var err error
+		select {
+		case err = <-fetchErrc:
+			if fetchers--; fetchers == 0 && fail == nil && err == nil {
+				close(fetched)
+			}
+		case err = <-processErrc:
+		}
+		if err != nil && fail == nil {
+			fail = err
+			d.Cancel()
+		}
+	}
+	if fail != nil {
+		return fail
+	}
+	// All the blocks up to the pivot and its state are present, switch the head
+	return d.commitHead(pivot.Hash())
+}
+
+// processFast takes the completed blocks from the queue along with their
+// receipts, and imports them into the local chain without execution. It runs
+// until the fetched channel is closed and no more blocks remain.
+func (d *Downloader) processFast(fetched <-chan struct{}) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for done := false; ; {
+		if blocks, receipts := d.queue.TakeFastBlocks(); len(blocks) > 0 {
+			glog.V(logger.Debug).Infof("Inserting fast chain with %d blocks (#%v - #%v)", len(blocks), blocks[0].Number(), blocks[len(blocks)-1].Number())
+			if index, err := d.insertReceipts(blocks, receipts); err != nil {
+				glog.V(logger.Debug).Infof("Fast block #%d import failed: %v", blocks[index].NumberU64(), err)
+				return err
+			}
+			continue
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-d.cancelCh:
+			return errCancelFastProcess
+		case <-fetched:
+			done = true
+		case <-ticker.C:
+		}
+	}
+}
+
+// discardFast drops any receipt or state deliveries arriving after the fast
+// sync completed, so the delivering peers don't block until the stop channel
+// is closed.
+func (d *Downloader) discardFast(stop <-chan struct{}) {
+	for {
+		select {
+		case <-d.receiptCh:
+		case <-d.stateCh:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// findAncestor retrieves the number of the most recent block shared with the
+// remote peer. The headers right below our own head are checked first, and if
+// none of them are known, the ancestor is located with a binary search.
+func (d *Downloader) findAncestor(p *peer) (uint64, error) {
+	head := d.headBlock().NumberU64()
+	from := int64(head) - int64(MaxHeaderFetch) + 1
+	if from < 0 {
+		from = 0
+	}
+	glog.V(logger.Debug).Infof("%v: looking for common ancestor from #%d", p, from)
+	p.getHeaders(uint64(from), MaxHeaderFetch, 0, false)
+
+	timeout := time.After(headerTTL)
+	for finished := false; !finished; {
+		select {
+		case <-d.cancelCh:
+			return 0, errCancelHeaderFetch
+
+		case headerPack := <-d.headerCh:
+			if headerPack.peerId != p.id {
+				glog.V(logger.Debug).Infof("Received headers from incorrect peer(%s)", headerPack.peerId)
+				break
+			}
+			// Check the headers from the top, the first known one is the ancestor
+			headers := headerPack.headers
+			for i := len(headers) - 1; i >= 0; i-- {
+				if headers[i].Number.Uint64() != uint64(from)+uint64(i) {
+					return 0, ErrBadPeer
+				}
+				if d.hasBlock(headers[i].Hash()) {
+					glog.V(logger.Debug).Infof("%v: found common ancestor #%d", p, headers[i].Number)
+					return headers[i].Number.Uint64(), nil
+				}
+			}
+			finished = true
+
+		case <-timeout:
+			glog.V(logger.Debug).Infof("%v: ancestor header request timed out", p)
+			return 0, ErrTimeout
+		}
+	}
+	// None of the recent headers are known, binary search for the ancestor.
+	// The genesis block is always shared, as the handshake checks it.
+	start, end := uint64(0), head
+	for start+1 < end {
+		check := (start + end) / 2
+		p.getHeaders(check, 1, 0, false)
+
+		timeout := time.After(headerTTL)
+		for arrived := false; !arrived; {
+			select {
+			case <-d.cancelCh:
+				return 0, errCancelHeaderFetch
+
+			case headerPack := <-d.headerCh:
+				if headerPack.peerId != p.id {
+					break
+				}
+				headers := headerPack.headers
+				switch {
+				case len(headers) == 0:
+					// The peer's chain is shorter than the checked number
+					end = check
+				case len(headers) == 1 && headers[0].Number.Uint64() == check:
+					if d.hasBlock(headers[0].Hash()) {
+						start = check
+					} else {
+						end = check
+					}
+				default:
+					// Stale reply to an earlier request, keep waiting
+					continue
+				}
+				arrived = true
+
+			case <-timeout:
+				glog.V(logger.Debug).Infof("%v: ancestor search request timed out", p)
+				return 0, ErrTimeout
+			}
+		}
+	}
+	glog.V(logger.Debug).Infof("%v: found common ancestor #%d", p, start)
+	return start, nil
+}
+
+// fetchHeaders retrieves the header chain of the origin peer, starting at the
+// given block number. Each batch is checked for correct numbering, parent
+// linkage and proof-of-work before its bodies are scheduled for retrieval. The
+// fetch is throttled while too many headers are awaiting their bodies.
+//
+// If a fast sync pivot is given, the retrieval stops at the pivot block, which
+// must match the given header, and the receipts are scheduled for retrieval too.
+func (d *Downloader) fetchHeaders(p *peer, from uint64, pivot *types.Header) error {
+	glog.V(logger.Debug).Infof("%v: downloading headers from #%d", p, from)
+	start := time.Now()
+
+	var (
+		parent    common.Hash // Hash of the last verified header (zero until the first batch)
+		throttled bool        // Whether the header fetch is paused for the queue to drain
+		timeout   = time.NewTimer(0)
+		ticker    = time.NewTicker(100 * time.Millisecond)
+	)
+	defer timeout.Stop()
+	defer ticker.Stop()
+	<-timeout.C // timeout channel should be initially empty.
+
+	getHeaders := func(from uint64) {
+		p.getHeaders(from, MaxHeaderFetch, 0, false)
+		timeout.Reset(headerTTL)
+	}
+	getHeaders(from)
+
+	// Throttle while too many bodies (or receipts in fast sync) are pending
+	queued := func() bool {
+		return d.queue.Pending() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders
+	}
+
+	for {
+		select {
+		case <-d.cancelCh:
+			return errCancelHeaderFetch
+
+		case headerPack := <-d.headerCh:
+			// Make sure the active peer is giving us the headers
+			if headerPack.peerId != p.id {
+				glog.V(logger.Debug).Infof("Received headers from incorrect peer(%s)", headerPack.peerId)
+				break
+			}
+			headers := headerPack.headers
+
+			// An empty reply means the peer has no more headers to give
+			if len(headers) == 0 {
+				if pivot != nil {
+					glog.V(logger.Debug).Infof("%v: header chain ended before pivot #%d", p, pivot.Number)
+					return errPivotMismatch
+				}
+				glog.V(logger.Debug).Infof("Downloaded headers up to #%d in %v", from-1, time.Since(start))
+				return nil
+			}
+			// Ignore stale replies to earlier requests
+			if headers[0].Number.Uint64() != from {
+				glog.V(logger.Detail).Infof("%v: stale headers from #%d, want #%d", p, headers[0].Number, from)
+				break
+			}
+			timeout.Stop()
+
+			// If the batch doesn't continue our header chain, the remote chain
+			// was reorganised mid-sync. Import what we have, the next sync cycle
+			// will pick up the new chain.
+			if (parent == common.Hash{} && !d.hasBlock(headers[0].ParentHash)) || (parent != common.Hash{} && headers[0].ParentHash != parent) {
+				glog.V(logger.Debug).Infof("%v: header chain reorganised at #%d", p, from)
+				if pivot != nil {
+					return errPivotMismatch
+				}
+				return nil
+			}
+			// During fast sync, don't retrieve anything beyond the pivot
+			if pivot != nil && from+uint64(len(headers)) > pivot.Number.Uint64()+1 {
+				headers = headers[:pivot.Number.Uint64()+1-from]
+			}
+			// Verify the linkage and proof-of-work of the entire batch
+			for i, header := range headers {
+				hash := header.Hash()
+				if i > 0 && (header.Number.Uint64() != from+uint64(i) || header.ParentHash != headers[i-1].Hash()) {
+					glog.V(logger.Debug).Infof("%v: invalid header chain at #%d", p, header.Number)
+					return ErrInvalidChain
+				}
+				if d.banned.Has(hash) {
+					glog.V(logger.Debug).Infof("%v: sent a known invalid header %x", p, hash[:4])
+					return ErrInvalidChain
+				}
+				if !d.verifyHeader(header) {
+					glog.V(logger.Debug).Infof("%v: invalid proof-of-work on header #%d", p, header.Number)
+					return ErrInvalidChain
+				}
+				if d.checkpointHash != (common.Hash{}) && header.Number.Uint64() == d.checkpoint && hash != d.checkpointHash {
+					glog.V(logger.Debug).Infof("%v: trusted checkpoint #%d mismatch", p, header.Number)
+					return ErrCheckpointMismatch
+				}
+			}
+			// If the fast sync pivot was reached, make sure it's the expected one
+			last := headers[len(headers)-1]
+			if pivot != nil && last.Number.Cmp(pivot.Number) == 0 && last.Hash() != pivot.Hash() {
+				glog.V(logger.Debug).Infof("%v: pivot #%d mismatch", p, pivot.Number)
+				return errPivotMismatch
+			}
+			d.queue.InsertHeaders(headers, pivot != nil)
+			d.raiseHeight(last.Number.Uint64())
+
+			parent = last.Hash()
+			from += uint64(len(headers))
+
+			if pivot != nil && last.Number.Cmp(pivot.Number) == 0 {
+				glog.V(logger.Debug).Infof("Downloaded headers up to pivot #%d in %v", pivot.Number, time.Since(start))
+				return nil
+			}
+			// Request the next batch, unless the queue needs to drain first
+			if throttled = queued(); !throttled {
+				getHeaders(from)
+			}
+
+		case <-ticker.C:
+			if throttled && !queued() {
+				throttled = false
+				getHeaders(from)
+			}
+
+		case <-timeout.C:
+			glog.V(logger.Debug).Infof("%v: header request timed out", p)
+			return ErrTimeout
+		}
+	}
+}
+
+// fetchBodies iteratively downloads the bodies of the scheduled headers from
+// all idle peers supporting body retrieval, until the header fetcher signals
+// completion and all the bodies have arrived.
+func (d *Downloader) fetchBodies(headersDone <-chan struct{}) error {
+	glog.V(logger.Debug).Infoln("Downloading block bodies")
+	start := time.Now()
+
+	var (
+		deliver = func(packet dataPack) error {
+			pack := packet.(bodyPack)
+			return d.queue.DeliverBodies(pack.peerId, pack.transactions, pack.uncles)
+		}
+		fetch   = func(p *peer, req *fetchRequest) error { return p.FetchBodies(req) }
+		capable = func(p *peer) bool { return p.version >= eth62 }
+	)
+	err := d.fetchParts(errCancelBodyFetch, d.bodyCh, deliver, headersDone, d.queue.Expire, d.queue.Pending,
+		d.queue.InFlight, d.queue.Throttle, d.queue.Reserve, fetch, d.queue.Cancel, capable, "bodies")
+	if err == nil {
+		glog.V(logger.Detail).Infoln("Downloaded block bodies in", time.Since(start))
+	}
+	return err
+}
+
+// fetchReceipts iteratively downloads the receipts of the scheduled headers
+// from all idle peers supporting receipt retrieval, until the header fetcher
+// signals completion and all the receipts have arrived.
+func (d *Downloader) fetchReceipts(headersDone <-chan struct{}) error {
+	glog.V(logger.Debug).Infoln("Downloading receipts")
+	start := time.Now()
+
+	var (
+		deliver = func(packet dataPack) error {
+			pack := packet.(receiptPack)
+			return d.queue.DeliverReceipts(pack.peerId, pack.receipts)
+		}
+		throttle = func() bool { return false }
+		fetch    = func(p *peer, req *fetchRequest) error { return p.FetchReceipts(req) }
+		capable  = func(p *peer) bool { return p.version >= eth63 }
+	)
+	err := d.fetchParts(errCancelReceiptFetch, d.receiptCh, deliver, headersDone, d.queue.ExpireReceipts, d.queue.PendingReceipts,
+		d.queue.InFlightReceipts, throttle, d.queue.ReserveReceipts, fetch, d.queue.CancelReceipts, capable, "receipts")
+	if err == nil {
+		glog.V(logger.Detail).Infoln("Downloaded receipts in", time.Since(start))
+	}
+	return err
+}
+
+// fetchNodeData iteratively downloads the state trie of the fast sync pivot
+// block from all idle peers supporting node data retrieval, until the state
+// scheduler runs out of missing nodes.
+func (d *Downloader) fetchNodeData() error {
+	glog.V(logger.Debug).Infoln("Downloading node state data")
+	start := time.Now()
+
+	var (
+		deliver = func(packet dataPack) error {
+			pack := packet.(statePack)
+			return d.queue.DeliverNodeData(pack.peerId, pack.states)
+		}
+		throttle = func() bool { return false }
+		fetch    = func(p *peer, req *fetchRequest) error { return p.FetchNodeData(req) }
+		capable  = func(p *peer) bool { return p.version >= eth63 }
+		done     = make(chan struct{})
+	)
+	close(done) // the state trie has no external completion dependency
+
+	err := d.fetchParts(errCancelStateFetch, d.stateCh, deliver, done, d.queue.ExpireNodeData, d.queue.PendingNodeData,
+		d.queue.InFlightNodeData, throttle, d.queue.ReserveNodeData, fetch, d.queue.CancelNodeData, capable, "states")
+	if err == nil {
+		glog.V(logger.Detail).Infoln("Downloaded node state data in", time.Since(start))
+	}
+	return err
+}
+
+// fetchParts iteratively downloads scheduled block parts, taking any available
+// peers, reserving a chunk of fetch requests for each, waiting for delivery and
+// also periodically checking for timeouts. It terminates once the done channel
+// is closed and nothing remains pending or in flight.
+func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliver func(dataPack) error, done <-chan struct{},
+	expire func(time.Duration) []string, pending func() int, inFlight func() int, throttle func() bool,
+	reserve func(*peer, int) *fetchRequest, fetch func(*peer, *fetchRequest) error, cancel func(*fetchRequest),
+	capable func(*peer) bool, kind string) error {
+
+	// Start a ticker to continue throttled downloads and check for bad peers
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.cancelCh:
+			return errCancel
+
+		case <-d.hashCh:
+		case <-d.blockCh:
+			// Out of bounds hashes or blocks received, ignore them
+
+		case packet := <-deliveryCh:
+			// If the peer was previously banned and failed to deliver it's pack
+			// in a reasonable time frame, ignore it's message.
+			if peer := d.peers.Peer(packet.PeerId()); peer != nil {
+				// Deliver the received chunk of data, and demote in case of errors
+				err := deliver(packet)
+				switch err {
+				case nil:
+					// If no data was delivered, demote the peer (need the delivery above)
+					if packet.Items() == 0 {
+						peer.Demote()
+						peer.SetIdle()
+						glog.V(logger.Detail).Infof("%s: no %s delivered", peer, kind)
+						break
+					}
+					// All was successful, promote the peer
+					peer.Promote()
+					peer.SetIdle()
+					glog.V(logger.Detail).Infof("%s: delivered %d %s", peer, packet.Items(), kind)
+
+				case ErrInvalidChain:
+					// The header chain is invalid (data doesn't fit), abort
+					return err
+
+				case errNoFetchesPending:
+					// Peer probably timed out with its delivery but came through
+					// in the end, demote, but allow to to pull from this peer.
+					peer.Demote()
+					peer.SetIdle()
+					glog.V(logger.Detail).Infof("%s: out of bound %s delivery", peer, kind)
+
+				case errStaleDelivery:
+					// Delivered something completely else than requested, usually
+					// caused by a timeout and delivery during a new sync cycle.
+					// Don't set it to idle as the original request should still be
+					// in flight.
+					peer.Demote()
+					glog.V(logger.Detail).Infof("%s: stale %s delivery", peer, kind)
+
+				default:
+					// Peer did something semi-useful, demote but keep it around
+					peer.Demote()
+					peer.SetIdle()
+					glog.V(logger.Detail).Infof("%s: %s delivery partially failed: %v", peer, kind, err)
+				}
+			}
+
+		case <-ticker.C:
+			// Short circuit if we lost all our peers
+			if d.peers.Len() == 0 {
+				return errNoPeers
+			}
+			// Check for fetch request timeouts and demote the responsible peers
+			for _, pid := range expire(blockHardTTL) {
+				if peer := d.peers.Peer(pid); peer != nil {
+					peer.Demote()
+					glog.V(logger.Detail).Infof("%s: %s delivery timeout", peer, kind)
+				}
+			}
+			// If there's nothing more to fetch, we're done once the completion
+			// signal arrived and nothing is in flight any more
+			if pending() == 0 {
+				select {
+				case <-done:
+					if inFlight() == 0 {
+						return nil
+					}
+				default:
+				}
+				break
+			}
+			// Throttle the download if the cache is full and waiting processing
+			if throttle() {
+				break
+			}
+			// Send a download request to all idle peers capable of serving the data,
+			// until throttled. Peers busy with other fetches are not considered.
+			idle := false
+			for _, peer := range d.peers.IdlePeers() {
+				if !capable(peer) {
+					continue
+				}
+				idle = true
+
+				// Short circuit if throttling activated since above
+				if throttle() {
+					break
+				}
+				request := reserve(peer, peer.Capacity())
+				if request == nil {
+					continue
+				}
+				if glog.V(logger.Detail) {
+					glog.Infof("%s: requesting %d %s", peer, len(request.Hashes), kind)
+				}
+				if err := fetch(peer, request); err != nil {
+					// Another fetcher grabbed the peer since it was listed as idle
+					glog.V(logger.Detail).Infof("%s: %s fetch assignment failed: %v", peer, kind, err)
+					cancel(request)
+				}
+			}
+			// Make sure that we have peers available for fetching. If all idle
+			// peers have been tried and all failed throw an error
+			if idle && inFlight() == 0 && !throttle() {
+				return errPeersUnavailable
+			}
+		}
+	}
+}
+
 // banBlocks retrieves a batch of blocks from a peer feeding us invalid hashes,
 // and bans the head of the retrieved batch.
 //
@@ -716,3 +1587,80 @@ func (d *Downloader) DeliverHashes(id string, hashes []common.Hash) error {
 		return errNoSyncActive
 	}
 }
+
+// DeliverHeaders injects a new batch of block headers received from a remote
+// node. This is usually invoked through the BlockHeadersMsg by the protocol
+// handler.
+func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) error {
+	// Make sure the downloader is active
+	if atomic.LoadInt32(&d.synchronising) == 0 {
+		return errNoSyncActive
+	}
+	// Deliver or abort if the sync is canceled while queuing
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	select {
+	case d.headerCh <- headerPack{id, headers}:
+		return nil
+
+	case <-cancel:
+		return errNoSyncActive
+	}
+}
+
+// DeliverBodies injects a new batch of block bodies received from a remote
+// node. This is usually invoked through the BlockBodiesMsg by the protocol
+// handler.
+func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) error {
+	// Make sure the downloader is active
+	if atomic.LoadInt32(&d.synchronising) == 0 {
+		return errNoSyncActive
+	}
+	// Deliver or abort if the sync is canceled while queuing
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	select {
+	case d.bodyCh <- bodyPack{id, transactions, uncles}:
+		return nil
+
+	case <-cancel:
+		return errNoSyncActive
+	}
+}
+
+// DeliverReceipts injects a new batch of receipts received from a remote node.
+// This is usually invoked through the ReceiptsMsg by the protocol handler.
+func (d *Downloader) DeliverReceipts(id string, receipts [][]*types.Receipt) error {
+	return d.deliver(d.receiptCh, receiptPack{id, receipts})
+}
+
+// DeliverNodeData injects a new batch of state trie nodes received from a remote
+// node. This is usually invoked through the NodeDataMsg by the protocol handler.
+func (d *Downloader) DeliverNodeData(id string, data [][]byte) error {
+	return d.deliver(d.stateCh, statePack{id, data})
+}
+
+// deliver injects a new batch of data received from a remote node into the
+// given fetcher channel.
+func (d *Downloader) deliver(destCh chan dataPack, packet dataPack) error {
+	// Make sure the downloader is active
+	if atomic.LoadInt32(&d.synchronising) == 0 {
+		return errNoSyncActive
+	}
+	// Deliver or abort if the sync is canceled while queuing
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	select {
+	case destCh <- packet:
+		return nil
+
+	case <-cancel:
+		return errNoSyncActive
+	}
+}
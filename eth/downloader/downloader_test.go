@@ -2,13 +2,18 @@ package downloader
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 )
 
@@ -55,6 +60,16 @@ type downloadTester struct {
 	blocks map[common.Hash]*types.Block // Blocks associated with the hashes
 	chain  []common.Hash                // Block-chain being constructed
 
+	headers []*types.Header      // Remote header chain served to eth/62 peers, indexed by number
+	local   []*types.Header      // Local header chain, used to find the common ancestor
+	badPoW  map[common.Hash]bool // Headers failing the proof-of-work check
+
+	receipts map[common.Hash]types.Receipts // Remote receipts served to eth/63 peers
+	peerDb   *ethdb.MemDatabase             // Remote state database served to eth/63 peers
+	stateDb  *ethdb.MemDatabase             // Local state database the fast sync fills
+	pending  []*types.Header                // Fast synced headers not yet committed as head
+	lock     sync.RWMutex                   // Protects the local chain against the fast importer
+
 	maxHashFetch int // Overrides the maximum number of retrieved hashes
 
 	t            *testing.T
@@ -70,10 +85,14 @@ func newTester(t *testing.T, hashes []common.Hash, blocks map[common.Hash]*types
 		blocks: blocks,
 		chain:  []common.Hash{knownHash},
 
-		done: make(chan bool),
+		done:   make(chan bool),
+		badPoW: make(map[common.Hash]bool),
 	}
+	tester.stateDb, _ = ethdb.NewMemDatabase()
+
 	var mux event.TypeMux
-	downloader := New(&mux, tester.hasBlock, tester.getBlock)
+	downloader := New(FullSync, tester.stateDb, &mux, tester.hasBlock, tester.getBlock, tester.headBlock, tester.verifyHeader,
+		tester.insertReceipts, tester.commitHead)
 	tester.downloader = downloader
 
 	return tester
@@ -116,6 +135,9 @@ func (dl *downloadTester) syncTake(peerId string, head common.Hash) ([]*Block, e
 }
 
 func (dl *downloadTester) hasBlock(hash common.Hash) bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
 	for _, h := range dl.chain {
 		if h == hash {
 			return true
@@ -128,6 +150,57 @@ func (dl *downloadTester) getBlock(hash common.Hash) *types.Block {
 	return dl.blocks[knownHash]
 }
 
+func (dl *downloadTester) headBlock() *types.Block {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if len(dl.local) == 0 {
+		return createBlock(0, common.Hash{}, knownHash)
+	}
+	return types.NewBlockWithHeader(dl.local[len(dl.local)-1])
+}
+
+func (dl *downloadTester) verifyHeader(header *types.Header) bool {
+	return !dl.badPoW[header.Hash()]
+}
+
+// insertReceipts imports a batch of fast synced blocks, verifying that they link
+// to known blocks and that their receipts match the headers.
+func (dl *downloadTester) insertReceipts(blocks types.Blocks, receipts []types.Receipts) (int, error) {
+	for i, block := range blocks {
+		if !dl.hasBlock(block.ParentHash()) {
+			return i, errors.New("unknown parent")
+		}
+		if types.DeriveSha(receipts[i]) != block.Header().ReceiptHash {
+			return i, errors.New("receipt root mismatch")
+		}
+		dl.lock.Lock()
+		dl.chain = append(dl.chain, block.Hash())
+		dl.pending = append(dl.pending, block.Header())
+		dl.lock.Unlock()
+	}
+	return 0, nil
+}
+
+// commitHead moves the local head to a previously fast synced block, requiring
+// its state to be present.
+func (dl *downloadTester) commitHead(hash common.Hash) error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	for i, header := range dl.pending {
+		if header.Hash() == hash {
+			if data, _ := dl.stateDb.Get(header.Root.Bytes()); len(data) == 0 {
+				return fmt.Errorf("state root %x missing", header.Root[:4])
+			}
+			dl.local = append(dl.local, dl.pending[:i+1]...)
+			dl.pending = dl.pending[i+1:]
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown block %x", hash[:4])
+}
+
 // getHashes retrieves a batch of hashes for reconstructing the chain.
 func (dl *downloadTester) getHashes(head common.Hash) error {
 	limit := MaxHashFetch
@@ -171,7 +244,7 @@ func (dl *downloadTester) getBlocks(id string) func([]common.Hash) error {
 
 // newPeer registers a new block download source into the syncer.
 func (dl *downloadTester) newPeer(id string, td *big.Int, hash common.Hash) error {
-	return dl.downloader.RegisterPeer(id, hash, dl.getHashes, dl.getBlocks(id))
+	return dl.downloader.RegisterPeer(id, eth60, hash, dl.getHashes, dl.getBlocks(id), nil, nil, nil, nil, nil)
 }
 
 // Tests that simple synchronization, without throttling from a good peer works.
@@ -610,3 +683,439 @@ func TestBannedChainMemoryExhaustionAttack(t *testing.T) {
 		}
 	}
 }
+
+// makeHeaderChain creates a linked header chain of the given length on top of
+// the parent, along with the block bodies and receipts. The seed differentiates
+// forks, every third block carries an uncle to make the bodies distinct, and
+// every second block has a receipt. All blocks share the parent's state root.
+func makeHeaderChain(parent *types.Header, n int, seed byte) ([]*types.Header, map[common.Hash]*types.Block, map[common.Hash]types.Receipts) {
+	headers := make([]*types.Header, n)
+	blocks := make(map[common.Hash]*types.Block)
+	receipts := make(map[common.Hash]types.Receipts)
+	for i := 0; i < n; i++ {
+		var uncles []*types.Header
+		if i%3 == 0 {
+			uncles = []*types.Header{{Number: big.NewInt(int64(i)), Extra: []byte{seed}}}
+		}
+		var list types.Receipts
+		if i%2 == 0 {
+			list = types.Receipts{types.NewReceipt([]byte{seed, byte(i), byte(i >> 8)}, big.NewInt(int64(i)))}
+		}
+		header := &types.Header{
+			ParentHash:  parent.Hash(),
+			Root:        parent.Root,
+			Number:      new(big.Int).Add(parent.Number, big.NewInt(1)),
+			TxHash:      types.DeriveSha(types.Transactions(nil)),
+			ReceiptHash: types.DeriveSha(list),
+			UncleHash:   types.CalcUncleHash(uncles),
+			Extra:       []byte{seed},
+		}
+		headers[i] = header
+		blocks[header.Hash()] = types.NewBlockWithHeader(header).WithBody(nil, uncles)
+		receipts[header.Hash()] = list
+		parent = header
+	}
+	return headers, blocks, receipts
+}
+
+// newHeaderTester creates a tester whose local chain consists of the genesis
+// and local headers, serving the genesis and remote headers to eth/62 peers.
+func newHeaderTester(t *testing.T, genesis *types.Header, local, remote []*types.Header, blocks map[common.Hash]*types.Block) *downloadTester {
+	tester := newTester(t, nil, blocks)
+	tester.local = append([]*types.Header{genesis}, local...)
+	tester.headers = append([]*types.Header{genesis}, remote...)
+	for _, header := range tester.local {
+		tester.chain = append(tester.chain, header.Hash())
+	}
+	return tester
+}
+
+// getHeaders serves a batch of headers from the remote chain by number.
+func (dl *downloadTester) getHeaders(id string) func(uint64, int, int, bool) error {
+	return func(from uint64, amount int, skip int, reverse bool) error {
+		headers := []*types.Header{}
+		for n := int64(from); n >= 0 && n < int64(len(dl.headers)) && len(headers) < amount; {
+			headers = append(headers, dl.headers[n])
+			if reverse {
+				n -= int64(skip) + 1
+			} else {
+				n += int64(skip) + 1
+			}
+		}
+		go dl.downloader.DeliverHeaders(id, headers)
+		return nil
+	}
+}
+
+// getHeadersByHash serves a batch of headers from the remote chain by hash.
+func (dl *downloadTester) getHeadersByHash(id string) func(common.Hash, int, int, bool) error {
+	return func(origin common.Hash, amount int, skip int, reverse bool) error {
+		for _, header := range dl.headers {
+			if header.Hash() == origin {
+				return dl.getHeaders(id)(header.Number.Uint64(), amount, skip, reverse)
+			}
+		}
+		go dl.downloader.DeliverHeaders(id, nil)
+		return nil
+	}
+}
+
+// getBodies serves a batch of block bodies from the remote blocks.
+func (dl *downloadTester) getBodies(id string) func([]common.Hash) error {
+	return func(hashes []common.Hash) error {
+		txs, uncles := [][]*types.Transaction{}, [][]*types.Header{}
+		for _, hash := range hashes {
+			if block, ok := dl.blocks[hash]; ok {
+				txs = append(txs, block.Transactions())
+				uncles = append(uncles, block.Uncles())
+			}
+		}
+		go dl.downloader.DeliverBodies(id, txs, uncles)
+		return nil
+	}
+}
+
+// getReceipts serves a batch of block receipts from the remote chain.
+func (dl *downloadTester) getReceipts(id string) func([]common.Hash) error {
+	return func(hashes []common.Hash) error {
+		receipts := [][]*types.Receipt{}
+		for _, hash := range hashes {
+			if list, ok := dl.receipts[hash]; ok {
+				receipts = append(receipts, list)
+			}
+		}
+		go dl.downloader.DeliverReceipts(id, receipts)
+		return nil
+	}
+}
+
+// getNodeData serves a batch of state trie nodes from the remote database.
+func (dl *downloadTester) getNodeData(id string) func([]common.Hash) error {
+	return func(hashes []common.Hash) error {
+		data := [][]byte{}
+		for _, hash := range hashes {
+			if blob, _ := dl.peerDb.Get(hash.Bytes()); len(blob) > 0 {
+				data = append(data, blob)
+			}
+		}
+		go dl.downloader.DeliverNodeData(id, data)
+		return nil
+	}
+}
+
+// newHeaderPeer registers a new eth/62 download source into the syncer.
+func (dl *downloadTester) newHeaderPeer(id string, head common.Hash) error {
+	return dl.downloader.RegisterPeer(id, eth62, head, dl.getHashes, dl.getBlocks(id), dl.getHeaders(id), dl.getHeadersByHash(id),
+		dl.getBodies(id), nil, nil)
+}
+
+// newFastPeer registers a new eth/63 download source into the syncer.
+func (dl *downloadTester) newFastPeer(id string, head common.Hash) error {
+	return dl.downloader.RegisterPeer(id, eth63, head, dl.getHashes, dl.getBlocks(id), dl.getHeaders(id), dl.getHeadersByHash(id),
+		dl.getBodies(id), dl.getReceipts(id), dl.getNodeData(id))
+}
+
+// checkTaken verifies that the taken blocks continue the chain at the given
+// number and match the remote headers and bodies.
+func checkTaken(t *testing.T, took []*Block, remote []*types.Header, blocks map[common.Hash]*types.Block, from int) {
+	if len(took) != len(remote)-from {
+		t.Fatalf("taken block count mismatch: have %d, want %d", len(took), len(remote)-from)
+	}
+	for i, block := range took {
+		want := remote[from+i]
+		if block.RawBlock.Hash() != want.Hash() {
+			t.Fatalf("block %d: hash mismatch: have %x, want %x", i, block.RawBlock.Hash(), want.Hash())
+		}
+		if uncles := blocks[want.Hash()].Uncles(); len(block.RawBlock.Uncles()) != len(uncles) {
+			t.Fatalf("block %d: uncle count mismatch: have %d, want %d", i, len(block.RawBlock.Uncles()), len(uncles))
+		}
+	}
+}
+
+// Tests that a header-first synchronisation from an eth/62 peer retrieves and
+// assembles the entire chain, even if it exceeds the block cache.
+func TestHeadersFirstSynchronisation(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, blocks, _ := makeHeaderChain(genesis, 4*blockCacheLimit, 1)
+
+	tester := newHeaderTester(t, genesis, nil, remote, blocks)
+	tester.newHeaderPeer("peer", remote[len(remote)-1].Hash())
+
+	took, err := tester.syncTake("peer", remote[len(remote)-1].Hash())
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, remote, blocks, 0)
+}
+
+// Tests that the bodies are retrieved from all the eth/62 peers, but never
+// from older peers which cannot serve them.
+func TestHeadersFirstMultiPeerBodies(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, blocks, _ := makeHeaderChain(genesis, blockCacheLimit-15, 1)
+	head := remote[len(remote)-1].Hash()
+
+	tester := newHeaderTester(t, genesis, nil, remote, blocks)
+	tester.newHeaderPeer("peer 1", head)
+	tester.newHeaderPeer("peer 2", head)
+	tester.newPeer("old peer", big.NewInt(10000), head)
+
+	took, err := tester.syncTake("peer 1", head)
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, remote, blocks, 0)
+
+	origins := make(map[string]int)
+	for _, block := range took {
+		origins[block.OriginPeer]++
+	}
+	if origins["peer 1"] == 0 || origins["peer 2"] == 0 {
+		t.Errorf("bodies not spread across peers: %v", origins)
+	}
+	if origins["old peer"] != 0 {
+		t.Errorf("bodies retrieved from eth/60 peer: %v", origins)
+	}
+}
+
+// Tests that the common ancestor is found both among the headers right below
+// the local head and, failing that, by binary search.
+func TestHeadersFirstAncestor(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	shared, blocks, _ := makeHeaderChain(genesis, 300, 1)
+
+	// The remote chain extends the local one
+	remote, extension, _ := makeHeaderChain(shared[len(shared)-1], 100, 1)
+	for hash, block := range extension {
+		blocks[hash] = block
+	}
+	remote = append(shared, remote...)
+
+	tester := newHeaderTester(t, genesis, shared, remote, blocks)
+	tester.newHeaderPeer("peer", remote[len(remote)-1].Hash())
+	took, err := tester.syncTake("peer", remote[len(remote)-1].Hash())
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, append([]*types.Header{genesis}, remote...), blocks, len(shared)+1)
+
+	// The local chain forked off long ago
+	fork, _, _ := makeHeaderChain(shared[len(shared)-1], 2*MaxHeaderFetch, 2)
+	local := append(append([]*types.Header{}, shared...), fork...)
+
+	tester = newHeaderTester(t, genesis, local, remote, blocks)
+	tester.newHeaderPeer("peer", remote[len(remote)-1].Hash())
+	took, err = tester.syncTake("peer", remote[len(remote)-1].Hash())
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, append([]*types.Header{genesis}, remote...), blocks, len(shared)+1)
+}
+
+// Tests that header chains with broken linkage or invalid proof-of-work are
+// rejected before any of their bodies are imported.
+func TestHeadersFirstInvalidChain(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, blocks, _ := makeHeaderChain(genesis, 2*MaxHeaderFetch, 1)
+	head := remote[len(remote)-1].Hash()
+
+	// Break the linkage inside a header batch
+	forged := make([]*types.Header, len(remote))
+	copy(forged, remote)
+	broken := *forged[MaxHeaderFetch+10]
+	broken.ParentHash = common.Hash{0xff}
+	forged[MaxHeaderFetch+10] = &broken
+
+	tester := newHeaderTester(t, genesis, nil, forged, blocks)
+	tester.newHeaderPeer("attack", head)
+	if _, err := tester.syncTake("attack", head); err != ErrInvalidChain {
+		t.Fatalf("synchronisation error mismatch: have %v, want %v", err, ErrInvalidChain)
+	}
+	// Fail the proof-of-work of a single header
+	tester = newHeaderTester(t, genesis, nil, remote, blocks)
+	tester.badPoW[remote[MaxHeaderFetch/2].Hash()] = true
+	tester.newHeaderPeer("attack", head)
+	if _, err := tester.syncTake("attack", head); err != ErrInvalidChain {
+		t.Fatalf("synchronisation error mismatch: have %v, want %v", err, ErrInvalidChain)
+	}
+	// Ensure that a valid chain can still pass sync
+	delete(tester.badPoW, remote[MaxHeaderFetch/2].Hash())
+	tester.newHeaderPeer("valid", head)
+	took, err := tester.syncTake("valid", head)
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, remote, blocks, 0)
+}
+
+// makeTestState creates a sample state with a few accounts, some of them with
+// code and storage, to be retrieved by fast sync.
+func makeTestState() (*ethdb.MemDatabase, common.Hash) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb := state.New(common.Hash{}, db)
+	for i := byte(0); i < 64; i++ {
+		obj := statedb.GetOrNewStateObject(common.BytesToAddress([]byte{i}))
+		obj.AddBalance(big.NewInt(int64(i) + 1))
+		if i%4 == 0 {
+			obj.SetCode([]byte{i, i, i})
+		}
+		if i%8 == 0 {
+			obj.SetState(common.Hash{i}, common.NewValue([]byte{i, 0x42}))
+		}
+		statedb.UpdateStateObject(obj)
+	}
+	statedb.Update()
+	root := statedb.Root()
+	statedb.Sync()
+
+	return db, root
+}
+
+// Tests that fast sync imports the blocks below the pivot along with their
+// receipts, retrieves the pivot state, and fully processes only the blocks
+// above the pivot.
+func TestFastSynchronisation(t *testing.T) {
+	peerDb, root := makeTestState()
+	genesis := &types.Header{Number: big.NewInt(0), Root: root}
+	remote, blocks, receipts := makeHeaderChain(genesis, 2*blockCacheLimit, 1)
+	head := remote[len(remote)-1].Hash()
+
+	tester := newHeaderTester(t, genesis, nil, remote, blocks)
+	tester.downloader.mode = FastSync
+	tester.receipts, tester.peerDb = receipts, peerDb
+	tester.newFastPeer("fast peer 1", head)
+	tester.newFastPeer("fast peer 2", head)
+
+	took, err := tester.syncTake("fast peer 1", head)
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	// Only the blocks above the pivot must have been fully processed
+	pivot := len(remote) - fsMinFullBlocks
+	checkTaken(t, took, remote, blocks, pivot)
+
+	// The pivot must be the head of the fast synced chain, with its state present
+	if len(tester.pending) != 0 {
+		t.Errorf("uncommitted fast blocks: %d", len(tester.pending))
+	}
+	if have, want := tester.local[len(tester.local)-1].Hash(), remote[pivot-1].Hash(); have != want {
+		t.Errorf("fast sync head mismatch: have %x, want %x", have[:4], want[:4])
+	}
+	statedb := state.New(root, tester.stateDb)
+	for i := byte(0); i < 64; i++ {
+		if balance := statedb.GetBalance(common.BytesToAddress([]byte{i})); balance.Cmp(big.NewInt(int64(i)+1)) != 0 {
+			t.Errorf("account %d: balance mismatch: have %v, want %v", i, balance, i+1)
+		}
+	}
+}
+
+// Tests that fast sync falls back to full synchronisation with peers that can't
+// serve receipts and state.
+func TestFastSyncFallback(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, blocks, _ := makeHeaderChain(genesis, blockCacheLimit-15, 1)
+	head := remote[len(remote)-1].Hash()
+
+	tester := newHeaderTester(t, genesis, nil, remote, blocks)
+	tester.downloader.mode = FastSync
+	tester.newHeaderPeer("peer", head)
+
+	took, err := tester.syncTake("peer", head)
+	if err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	checkTaken(t, took, remote, blocks, 0)
+}
+
+// Tests that the sync progress tracks the origin and height of a sync, keeps
+// the origin of an unfinished one and reports the boundaries in the events.
+func TestSyncProgress(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, blocks, _ := makeHeaderChain(genesis, 2*MaxHeaderFetch, 1)
+	head := remote[len(remote)-1].Hash()
+
+	tester := newHeaderTester(t, genesis, nil, remote, blocks)
+	sub := tester.downloader.mux.Subscribe(StartEvent{}, DoneEvent{})
+	defer sub.Unsubscribe()
+
+	events := make(chan interface{}, 4)
+	go func() {
+		for ev := range sub.Chan() {
+			events <- ev
+		}
+	}()
+	tester.newHeaderPeer("peer", head)
+	if _, err := tester.syncTake("peer", head); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	want := SyncProgress{Origin: 0, Current: 0, Height: uint64(len(remote))}
+	if progress := tester.downloader.Progress(); progress != want {
+		t.Errorf("progress mismatch: have %+v, want %+v", progress, want)
+	}
+	for i, expect := range []interface{}{StartEvent{SyncProgress{}}, DoneEvent{want}} {
+		select {
+		case ev := <-events:
+			if ev != expect {
+				t.Errorf("event %d mismatch: have %+v, want %+v", i, ev, expect)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not posted", i)
+		}
+	}
+	// The local head didn't reach the height, so the next sync continues this one
+	tester.local = append(tester.local, remote[:MaxHeaderFetch]...)
+	if _, err := tester.syncTake("peer", head); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if origin := tester.downloader.Progress().Origin; origin != 0 {
+		t.Errorf("origin of unfinished sync reset: have %d, want 0", origin)
+	}
+	// Once the height is reached, a new sync starts from the head
+	tester.local = append(tester.local, remote[MaxHeaderFetch:]...)
+	if _, err := tester.syncTake("peer", head); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	want = SyncProgress{Origin: uint64(len(remote)), Current: uint64(len(remote)), Height: uint64(len(remote))}
+	if progress := tester.downloader.Progress(); progress != want {
+		t.Errorf("progress mismatch: have %+v, want %+v", progress, want)
+	}
+}
+
+// Tests that hash and header chains not containing the trusted checkpoint are
+// rejected.
+func TestCheckpointSynchronisation(t *testing.T) {
+	hashes := createHashes(0, MaxHashFetch)
+	blocks := createBlocksFromHashes(hashes)
+
+	genesis := &types.Header{Number: big.NewInt(0)}
+	remote, headerBlocks, _ := makeHeaderChain(genesis, MaxHeaderFetch, 1)
+
+	for i, valid := range []bool{true, false} {
+		want := error(nil)
+		if !valid {
+			want = ErrCheckpointMismatch
+		}
+		// Hash chains of eth/61 peers, block #n is hashes[len(hashes)-n]
+		tester := newTester(t, hashes, blocks)
+		if valid {
+			tester.downloader.SetCheckpoint(10, hashes[len(hashes)-10])
+		} else {
+			tester.downloader.SetCheckpoint(10, common.Hash{1})
+		}
+		tester.newPeer("peer", big.NewInt(10000), hashes[0])
+		if _, err := tester.syncTake("peer", hashes[0]); err != want {
+			t.Errorf("test %d: hash chain sync error mismatch: have %v, want %v", i, err, want)
+		}
+		// Header chains of eth/62 peers
+		tester = newHeaderTester(t, genesis, nil, remote, headerBlocks)
+		if valid {
+			tester.downloader.SetCheckpoint(10, remote[9].Hash())
+		} else {
+			tester.downloader.SetCheckpoint(10, common.Hash{1})
+		}
+		tester.newHeaderPeer("peer", remote[len(remote)-1].Hash())
+		if _, err := tester.syncTake("peer", remote[len(remote)-1].Hash()); err != want {
+			t.Errorf("test %d: header chain sync error mismatch: have %v, want %v", i, err, want)
+		}
+	}
+}
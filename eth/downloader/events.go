@@ -1,5 +1,13 @@
 package downloader
 
-type DoneEvent struct{}
-type StartEvent struct{}
+// SyncProgress describes the boundaries of a block synchronisation.
+type SyncProgress struct {
+	Origin  uint64 // Block number the synchronisation started at
+	Current uint64 // Block number of the local chain head
+	Height  uint64 // Highest block number known to be available
+}
+
+type DoneEvent struct{ SyncProgress }
+type StartEvent struct{ SyncProgress }
+type ProgressEvent struct{ SyncProgress }
 type FailedEvent struct{ Err error }
@@ -0,0 +1,21 @@
+package downloader
+
+// SyncMode represents the synchronisation mode of the downloader.
+type SyncMode int
+
+const (
+	FullSync SyncMode = iota // Synchronise the entire block-chain history from full blocks
+	FastSync                 // Quickly download the headers, full sync only at the chain head
+)
+
+// String implements fmt.Stringer.
+func (mode SyncMode) String() string {
+	switch mode {
+	case FullSync:
+		return "full"
+	case FastSync:
+		return "fast"
+	default:
+		return "unknown"
+	}
+}
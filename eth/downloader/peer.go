@@ -17,6 +17,11 @@ import (
 
 type hashFetcherFn func(common.Hash) error
 type blockFetcherFn func([]common.Hash) error
+type headerFetcherFn func(from uint64, amount int, skip int, reverse bool) error
+type headerHashFetcherFn func(origin common.Hash, amount int, skip int, reverse bool) error
+type bodyFetcherFn func([]common.Hash) error
+type receiptFetcherFn func([]common.Hash) error
+type stateFetcherFn func([]common.Hash) error
 
 var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
@@ -26,8 +31,9 @@ var (
 
 // peer represents an active peer from which hashes and blocks are retrieved.
 type peer struct {
-	id   string      // Unique identifier of the peer
-	head common.Hash // Hash of the peers latest known block
+	id      string      // Unique identifier of the peer
+	version int         // Eth protocol version number to switch strategies
+	head    common.Hash // Hash of the peers latest known block
 
 	idle int32 // Current activity state of the peer (idle = 0, active = 1)
 	rep  int32 // Simple peer reputation
@@ -37,20 +43,33 @@ type peer struct {
 
 	ignored *set.Set // Set of hashes not to request (didn't have previously)
 
-	getHashes hashFetcherFn  // Method to retrieve a batch of hashes (mockable for testing)
-	getBlocks blockFetcherFn // Method to retrieve a batch of blocks (mockable for testing)
+	getHashes        hashFetcherFn       // Method to retrieve a batch of hashes (mockable for testing)
+	getBlocks        blockFetcherFn      // Method to retrieve a batch of blocks (mockable for testing)
+	getHeaders       headerFetcherFn     // Method to retrieve a batch of headers by number (eth/62+ only)
+	getHeadersByHash headerHashFetcherFn // Method to retrieve a batch of headers by origin hash (eth/62+ only)
+	getBodies        bodyFetcherFn       // Method to retrieve a batch of block bodies (eth/62+ only)
+	getReceipts      receiptFetcherFn    // Method to retrieve a batch of block receipts (eth/63+ only)
+	getNodeData      stateFetcherFn      // Method to retrieve a batch of state trie nodes (eth/63+ only)
 }
 
-// newPeer create a new downloader peer, with specific hash and block retrieval
-// mechanisms.
-func newPeer(id string, head common.Hash, getHashes hashFetcherFn, getBlocks blockFetcherFn) *peer {
+// newPeer create a new downloader peer, with specific hash, block, header, body,
+// receipt and state retrieval mechanisms.
+func newPeer(id string, version int, head common.Hash, getHashes hashFetcherFn, getBlocks blockFetcherFn,
+	getHeaders headerFetcherFn, getHeadersByHash headerHashFetcherFn, getBodies bodyFetcherFn,
+	getReceipts receiptFetcherFn, getNodeData stateFetcherFn) *peer {
 	return &peer{
-		id:        id,
-		head:      head,
-		capacity:  1,
-		getHashes: getHashes,
-		getBlocks: getBlocks,
-		ignored:   set.New(),
+		id:               id,
+		version:          version,
+		head:             head,
+		capacity:         1,
+		getHashes:        getHashes,
+		getBlocks:        getBlocks,
+		getHeaders:       getHeaders,
+		getHeadersByHash: getHeadersByHash,
+		getBodies:        getBodies,
+		getReceipts:      getReceipts,
+		getNodeData:      getNodeData,
+		ignored:          set.New(),
 	}
 }
 
@@ -63,6 +82,27 @@ func (p *peer) Reset() {
 
 // Fetch sends a block retrieval request to the remote peer.
 func (p *peer) Fetch(request *fetchRequest) error {
+	return p.fetch(request, p.getBlocks)
+}
+
+// FetchBodies sends a block body retrieval request to the remote peer.
+func (p *peer) FetchBodies(request *fetchRequest) error {
+	return p.fetch(request, p.getBodies)
+}
+
+// FetchReceipts sends a receipt retrieval request to the remote peer.
+func (p *peer) FetchReceipts(request *fetchRequest) error {
+	return p.fetch(request, p.getReceipts)
+}
+
+// FetchNodeData sends a state trie node retrieval request to the remote peer.
+func (p *peer) FetchNodeData(request *fetchRequest) error {
+	return p.fetch(request, p.getNodeData)
+}
+
+// fetch marks the peer busy and requests the hashes of the given request
+// through the retrieval method.
+func (p *peer) fetch(request *fetchRequest, retrieve func([]common.Hash) error) error {
 	// Short circuit if the peer is already fetching
 	if !atomic.CompareAndSwapInt32(&p.idle, 0, 1) {
 		return errAlreadyFetching
@@ -74,7 +114,7 @@ func (p *peer) Fetch(request *fetchRequest) error {
 	for hash, _ := range request.Hashes {
 		hashes = append(hashes, hash)
 	}
-	p.getBlocks(hashes)
+	retrieve(hashes)
 
 	return nil
 }
@@ -10,9 +10,12 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/trie"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
@@ -20,6 +23,10 @@ var (
 	blockCacheLimit = 8 * MaxBlockFetch // Maximum number of blocks to cache before throttling the download
 )
 
+var (
+	emptyReceiptRoot = types.DeriveSha(types.Receipts(nil)) // Receipt root of a block without transactions
+)
+
 var (
 	errNoFetchesPending = errors.New("no fetches pending")
 	errStaleDelivery    = errors.New("stale delivery")
@@ -38,12 +45,26 @@ type queue struct {
 	hashQueue   *prque.Prque        // Priority queue of the block hashes to fetch
 	hashCounter int                 // Counter indexing the added hashes to ensure retrieval order
 
+	headerPool map[common.Hash]*types.Header // Verified headers whose block bodies are pending retrieval
+
 	pendPool map[string]*fetchRequest // Currently pending block retrieval operations
 
 	blockPool   map[common.Hash]int // Hash-set of the downloaded data blocks, mapping to cache indexes
 	blockCache  []*Block            // Downloaded but not yet delivered blocks
 	blockOffset int                 // Offset of the first cached block in the block-chain
 
+	receiptTaskPool  map[common.Hash]*types.Header  // Headers whose receipts are pending retrieval (fast sync)
+	receiptTaskQueue *prque.Prque                   // Priority queue of the headers to fetch the receipts for
+	receiptPendPool  map[string]*fetchRequest       // Currently pending receipt retrieval operations
+	receiptDonePool  map[common.Hash]types.Receipts // Retrieved receipts awaiting their blocks to be taken
+
+	stateScheduler *state.StateSync         // State trie synchronisation scheduler of the fast sync pivot
+	stateTaskPool  map[common.Hash]int      // Pending state node hashes, mapping to their priority
+	stateTaskQueue *prque.Prque             // Priority queue of the state node hashes to fetch
+	statePendPool  map[string]*fetchRequest // Currently pending node data retrieval operations
+
+	fastPivot uint64 // Block number of the fast sync pivot (0 = not fast syncing)
+
 	lock sync.RWMutex
 }
 
@@ -52,9 +73,19 @@ func newQueue() *queue {
 	return &queue{
 		hashPool:   make(map[common.Hash]int),
 		hashQueue:  prque.New(),
+		headerPool: make(map[common.Hash]*types.Header),
 		pendPool:   make(map[string]*fetchRequest),
 		blockPool:  make(map[common.Hash]int),
 		blockCache: make([]*Block, blockCacheLimit),
+
+		receiptTaskPool:  make(map[common.Hash]*types.Header),
+		receiptTaskQueue: prque.New(),
+		receiptPendPool:  make(map[string]*fetchRequest),
+		receiptDonePool:  make(map[common.Hash]types.Receipts),
+
+		stateTaskPool:  make(map[common.Hash]int),
+		stateTaskQueue: prque.New(),
+		statePendPool:  make(map[string]*fetchRequest),
 	}
 }
 
@@ -67,11 +98,25 @@ func (q *queue) Reset() {
 	q.hashQueue.Reset()
 	q.hashCounter = 0
 
+	q.headerPool = make(map[common.Hash]*types.Header)
+
 	q.pendPool = make(map[string]*fetchRequest)
 
 	q.blockPool = make(map[common.Hash]int)
 	q.blockOffset = 0
 	q.blockCache = make([]*Block, blockCacheLimit)
+
+	q.receiptTaskPool = make(map[common.Hash]*types.Header)
+	q.receiptTaskQueue.Reset()
+	q.receiptPendPool = make(map[string]*fetchRequest)
+	q.receiptDonePool = make(map[common.Hash]types.Receipts)
+
+	q.stateScheduler = nil
+	q.stateTaskPool = make(map[common.Hash]int)
+	q.stateTaskQueue.Reset()
+	q.statePendPool = make(map[string]*fetchRequest)
+
+	q.fastPivot = 0
 }
 
 // Size retrieves the number of hashes in the queue, returning separately for
@@ -91,6 +136,24 @@ func (q *queue) Pending() int {
 	return q.hashQueue.Size()
 }
 
+// PendingReceipts retrieves the number of block receipts pending for retrieval.
+func (q *queue) PendingReceipts() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.receiptTaskQueue.Size()
+}
+
+// PendingNodeData retrieves the number of state node hashes pending for
+// retrieval, including the ones the state scheduler did not hand out yet.
+func (q *queue) PendingNodeData() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.scheduleNodeData()
+	return q.stateTaskQueue.Size()
+}
+
 // InFlight retrieves the number of fetch requests currently in flight.
 func (q *queue) InFlight() int {
 	q.lock.RLock()
@@ -99,6 +162,24 @@ func (q *queue) InFlight() int {
 	return len(q.pendPool)
 }
 
+// InFlightReceipts retrieves the number of receipt fetch requests currently
+// in flight.
+func (q *queue) InFlightReceipts() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return len(q.receiptPendPool)
+}
+
+// InFlightNodeData retrieves the number of node data fetch requests currently
+// in flight.
+func (q *queue) InFlightNodeData() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return len(q.statePendPool)
+}
+
 // Throttle checks if the download should be throttled (active block fetches
 // exceed block cache).
 func (q *queue) Throttle() bool {
@@ -152,6 +233,42 @@ func (q *queue) Insert(hashes []common.Hash) []common.Hash {
 	return inserts
 }
 
+// InsertHeaders adds a batch of verified headers to the queue, scheduling the
+// retrieval of their block bodies, and during fast sync also of their receipts.
+// Lower block numbers are scheduled first. It returns the headers newly
+// scheduled.
+func (q *queue) InsertHeaders(headers []*types.Header, fast bool) []*types.Header {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	inserts := make([]*types.Header, 0, len(headers))
+	for _, header := range headers {
+		// Skip anything we already have
+		hash := header.Hash()
+		if old, ok := q.hashPool[hash]; ok {
+			glog.V(logger.Warn).Infof("Header %x already scheduled at index %v", hash, old)
+			continue
+		}
+		inserts = append(inserts, header)
+
+		index := -int(header.Number.Uint64())
+		q.hashPool[hash] = index
+		q.headerPool[hash] = header
+		q.hashQueue.Push(hash, float32(index))
+
+		// Blocks without transactions have no receipts to retrieve
+		if fast {
+			if header.ReceiptHash == emptyReceiptRoot {
+				q.receiptDonePool[hash] = nil
+			} else {
+				q.receiptTaskPool[hash] = header
+				q.receiptTaskQueue.Push(hash, float32(index))
+			}
+		}
+	}
+	return inserts
+}
+
 // GetHeadBlock retrieves the first block from the cache, or nil if it hasn't
 // been downloaded yet (or simply non existent).
 func (q *queue) GetHeadBlock() *Block {
@@ -182,10 +299,15 @@ func (q *queue) GetBlock(hash common.Hash) *Block {
 }
 
 // TakeBlocks retrieves and permanently removes a batch of blocks from the cache.
+// During fast sync no blocks are handed out, as they are taken along with their
+// receipts by TakeFastBlocks instead.
 func (q *queue) TakeBlocks() []*Block {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
+	if q.fastPivot != 0 {
+		return nil
+	}
 	// Accumulate all available blocks
 	blocks := []*Block{}
 	for _, block := range q.blockCache {
@@ -195,43 +317,100 @@ func (q *queue) TakeBlocks() []*Block {
 		blocks = append(blocks, block)
 		delete(q.blockPool, block.RawBlock.Hash())
 	}
-	// Delete the blocks from the slice and let them be garbage collected
-	// without this slice trick the blocks would stay in memory until nil
-	// would be assigned to q.blocks
-	copy(q.blockCache, q.blockCache[len(blocks):])
-	for k, n := len(q.blockCache)-len(blocks), len(q.blockCache); k < n; k++ {
-		q.blockCache[k] = nil
-	}
-	q.blockOffset += len(blocks)
+	q.shiftCache(len(blocks))
 
 	return blocks
 }
 
+// TakeFastBlocks retrieves and permanently removes a batch of blocks from the
+// cache, along with their receipts. Only blocks whose receipts have already
+// been retrieved are returned.
+func (q *queue) TakeFastBlocks() (types.Blocks, []types.Receipts) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Accumulate all available blocks with their receipts
+	blocks, receipts := types.Blocks{}, []types.Receipts{}
+	for _, block := range q.blockCache {
+		if block == nil {
+			break
+		}
+		hash := block.RawBlock.Hash()
+		list, ok := q.receiptDonePool[hash]
+		if !ok {
+			break
+		}
+		blocks, receipts = append(blocks, block.RawBlock), append(receipts, list)
+
+		delete(q.blockPool, hash)
+		delete(q.receiptDonePool, hash)
+	}
+	q.shiftCache(len(blocks))
+
+	return blocks, receipts
+}
+
+// shiftCache removes the first count blocks from the cache. Without the slice
+// trick the blocks would stay in memory until nil would be assigned to them.
+func (q *queue) shiftCache(count int) {
+	copy(q.blockCache, q.blockCache[count:])
+	for k, n := len(q.blockCache)-count, len(q.blockCache); k < n; k++ {
+		q.blockCache[k] = nil
+	}
+	q.blockOffset += count
+}
+
 // Reserve reserves a set of hashes for the given peer, skipping any previously
 // failed download.
 func (q *queue) Reserve(p *peer, count int) *fetchRequest {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
+	// Calculate an upper limit on the hashes we might fetch (i.e. throttling)
+	space := len(q.blockCache) - len(q.blockPool)
+	for _, request := range q.pendPool {
+		space -= len(request.Hashes)
+	}
+	return q.reserve(p, count, space, q.hashQueue, q.pendPool)
+}
+
+// ReserveReceipts reserves a set of receipt fetches for the given peer, skipping
+// any previously failed download.
+func (q *queue) ReserveReceipts(p *peer, count int) *fetchRequest {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.reserve(p, count, count, q.receiptTaskQueue, q.receiptPendPool)
+}
+
+// ReserveNodeData reserves a set of state node fetches for the given peer,
+// skipping any previously failed download.
+func (q *queue) ReserveNodeData(p *peer, count int) *fetchRequest {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.scheduleNodeData()
+	return q.reserve(p, count, count, q.stateTaskQueue, q.statePendPool)
+}
+
+// reserve pops a batch of at most count hashes from the task queue, considering
+// at most space of them, and assigns them to the given peer. Previously failed
+// hashes are skipped. The method assumes the queue lock is held.
+func (q *queue) reserve(p *peer, count, space int, taskQueue *prque.Prque, pendPool map[string]*fetchRequest) *fetchRequest {
 	// Short circuit if the pool has been depleted, or if the peer's already
 	// downloading something (sanity check not to corrupt state)
-	if q.hashQueue.Empty() {
+	if taskQueue.Empty() {
 		return nil
 	}
-	if _, ok := q.pendPool[p.id]; ok {
+	if _, ok := pendPool[p.id]; ok {
 		return nil
 	}
-	// Calculate an upper limit on the hashes we might fetch (i.e. throttling)
-	space := len(q.blockCache) - len(q.blockPool)
-	for _, request := range q.pendPool {
-		space -= len(request.Hashes)
-	}
 	// Retrieve a batch of hashes, skipping previously failed ones
 	send := make(map[common.Hash]int)
 	skip := make(map[common.Hash]int)
 
-	for proc := 0; proc < space && len(send) < count && !q.hashQueue.Empty(); proc++ {
-		hash, priority := q.hashQueue.Pop()
+	for proc := 0; proc < space && len(send) < count && !taskQueue.Empty(); proc++ {
+		hash, priority := taskQueue.Pop()
 		if p.ignored.Has(hash) {
 			skip[hash.(common.Hash)] = int(priority)
 		} else {
@@ -240,9 +419,9 @@ func (q *queue) Reserve(p *peer, count int) *fetchRequest {
 	}
 	// Merge all the skipped hashes back
 	for hash, index := range skip {
-		q.hashQueue.Push(hash, float32(index))
+		taskQueue.Push(hash, float32(index))
 	}
-	// Assemble and return the block download request
+	// Assemble and return the download request
 	if len(send) == 0 {
 		return nil
 	}
@@ -251,41 +430,82 @@ func (q *queue) Reserve(p *peer, count int) *fetchRequest {
 		Hashes: send,
 		Time:   time.Now(),
 	}
-	q.pendPool[p.id] = request
+	pendPool[p.id] = request
 
 	return request
 }
 
 // Cancel aborts a fetch request, returning all pending hashes to the queue.
 func (q *queue) Cancel(request *fetchRequest) {
+	q.cancel(request, func() (*prque.Prque, map[string]*fetchRequest) { return q.hashQueue, q.pendPool })
+}
+
+// CancelReceipts aborts a receipt fetch request, returning all pending hashes
+// to the queue.
+func (q *queue) CancelReceipts(request *fetchRequest) {
+	q.cancel(request, func() (*prque.Prque, map[string]*fetchRequest) { return q.receiptTaskQueue, q.receiptPendPool })
+}
+
+// CancelNodeData aborts a node data fetch request, returning all pending hashes
+// to the queue.
+func (q *queue) CancelNodeData(request *fetchRequest) {
+	q.cancel(request, func() (*prque.Prque, map[string]*fetchRequest) { return q.stateTaskQueue, q.statePendPool })
+}
+
+// cancel aborts a fetch request, returning all pending hashes to the task queue.
+// The task queue and pending pool are picked by the selector under the lock.
+func (q *queue) cancel(request *fetchRequest, selector func() (*prque.Prque, map[string]*fetchRequest)) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
+	taskQueue, pendPool := selector()
 	for hash, index := range request.Hashes {
-		q.hashQueue.Push(hash, float32(index))
+		taskQueue.Push(hash, float32(index))
 	}
-	delete(q.pendPool, request.Peer.id)
+	delete(pendPool, request.Peer.id)
 }
 
 // Expire checks for in flight requests that exceeded a timeout allowance,
 // canceling them and returning the responsible peers for penalization.
 func (q *queue) Expire(timeout time.Duration) []string {
+	return q.expire(timeout, func() (*prque.Prque, map[string]*fetchRequest) { return q.hashQueue, q.pendPool })
+}
+
+// ExpireReceipts checks for in flight receipt requests that exceeded a timeout
+// allowance, canceling them and returning the responsible peers for penalization.
+func (q *queue) ExpireReceipts(timeout time.Duration) []string {
+	return q.expire(timeout, func() (*prque.Prque, map[string]*fetchRequest) { return q.receiptTaskQueue, q.receiptPendPool })
+}
+
+// ExpireNodeData checks for in flight node data requests that exceeded a timeout
+// allowance, canceling them and returning the responsible peers for penalization.
+func (q *queue) ExpireNodeData(timeout time.Duration) []string {
+	return q.expire(timeout, func() (*prque.Prque, map[string]*fetchRequest) { return q.stateTaskQueue, q.statePendPool })
+}
+
+// expire checks the pending pool for requests that exceeded a timeout allowance,
+// returning their hashes to the task queue and the responsible peers for
+// penalization. The task queue and pending pool are picked by the selector while
+// the lock is held, as a concurrent reset replaces them.
+func (q *queue) expire(timeout time.Duration, selector func() (*prque.Prque, map[string]*fetchRequest)) []string {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
+	taskQueue, pendPool := selector()
+
 	// Iterate over the expired requests and return each to the queue
 	peers := []string{}
-	for id, request := range q.pendPool {
+	for id, request := range pendPool {
 		if time.Since(request.Time) > timeout {
 			for hash, index := range request.Hashes {
-				q.hashQueue.Push(hash, float32(index))
+				taskQueue.Push(hash, float32(index))
 			}
 			peers = append(peers, id)
 		}
 	}
 	// Remove the expired requests from the pending pool
 	for _, id := range peers {
-		delete(q.pendPool, id)
+		delete(pendPool, id)
 	}
 	return peers
 }
@@ -345,6 +565,223 @@ func (q *queue) Deliver(id string, blocks []*types.Block) (err error) {
 	return nil
 }
 
+// DeliverBodies injects a block body retrieval response into the download
+// queue. Bodies are matched to the requested headers by their transaction and
+// uncle hashes, as the peer may reply in any order.
+func (q *queue) DeliverBodies(id string, txLists [][]*types.Transaction, uncleLists [][]*types.Header) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Short circuit if the bodies were never requested
+	request := q.pendPool[id]
+	if request == nil {
+		return errNoFetchesPending
+	}
+	delete(q.pendPool, id)
+
+	// If no bodies were retrieved, mark them as unavailable for the origin peer
+	if len(txLists) == 0 {
+		for hash, _ := range request.Hashes {
+			request.Peer.ignored.Add(hash)
+		}
+	}
+	// Iterate over the downloaded bodies and assemble a block from each
+	errs := make([]error, 0)
+	for i, txs := range txLists {
+		var uncles []*types.Header
+		if i < len(uncleLists) {
+			uncles = uncleLists[i]
+		}
+		txHash, uncleHash := types.DeriveSha(types.Transactions(txs)), types.CalcUncleHash(uncles)
+
+		// Find the requested header this body belongs to
+		var (
+			header *types.Header
+			hash   common.Hash
+		)
+		for h, _ := range request.Hashes {
+			if candidate := q.headerPool[h]; candidate != nil && candidate.TxHash == txHash && candidate.UncleHash == uncleHash {
+				header, hash = candidate, h
+				break
+			}
+		}
+		if header == nil {
+			errs = append(errs, fmt.Errorf("non-requested body %d", i))
+			continue
+		}
+		// If a requested block falls out of the range, the header chain is invalid
+		index := int(header.Number.Uint64()) - q.blockOffset
+		if index >= len(q.blockCache) || index < 0 {
+			return ErrInvalidChain
+		}
+		// Otherwise merge the block and mark the hash done
+		q.blockCache[index] = &Block{
+			RawBlock:   types.NewBlockWithHeader(header).WithBody(txs, uncles),
+			OriginPeer: id,
+		}
+		delete(request.Hashes, hash)
+		delete(q.hashPool, hash)
+		delete(q.headerPool, hash)
+		q.blockPool[hash] = int(header.Number.Uint64())
+	}
+	// Return all failed or missing fetches to the queue
+	for hash, index := range request.Hashes {
+		q.hashQueue.Push(hash, float32(index))
+	}
+	// If none of the bodies were good, it's a stale delivery
+	if len(errs) != 0 {
+		if len(errs) == len(txLists) {
+			return errStaleDelivery
+		}
+		return fmt.Errorf("multiple failures: %v", errs)
+	}
+	return nil
+}
+
+// DeliverReceipts injects a receipt retrieval response into the download queue.
+// Receipts are matched to the requested headers by their receipt root hashes, as
+// the peer may reply in any order.
+func (q *queue) DeliverReceipts(id string, receiptLists [][]*types.Receipt) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Short circuit if the receipts were never requested
+	request := q.receiptPendPool[id]
+	if request == nil {
+		return errNoFetchesPending
+	}
+	delete(q.receiptPendPool, id)
+
+	// If no receipts were retrieved, mark them as unavailable for the origin peer
+	if len(receiptLists) == 0 {
+		for hash, _ := range request.Hashes {
+			request.Peer.ignored.Add(hash)
+		}
+	}
+	// Iterate over the downloaded receipts and match each to its header
+	errs := make([]error, 0)
+	for i, receipts := range receiptLists {
+		root := types.DeriveSha(types.Receipts(receipts))
+
+		var hash common.Hash
+		for h, _ := range request.Hashes {
+			if header := q.receiptTaskPool[h]; header != nil && header.ReceiptHash == root {
+				hash = h
+				break
+			}
+		}
+		if hash == (common.Hash{}) {
+			errs = append(errs, fmt.Errorf("non-requested receipts %d", i))
+			continue
+		}
+		q.receiptDonePool[hash] = receipts
+
+		delete(request.Hashes, hash)
+		delete(q.receiptTaskPool, hash)
+	}
+	// Return all failed or missing fetches to the queue
+	for hash, index := range request.Hashes {
+		q.receiptTaskQueue.Push(hash, float32(index))
+	}
+	// If none of the receipts were good, it's a stale delivery
+	if len(errs) != 0 {
+		if len(errs) == len(receiptLists) {
+			return errStaleDelivery
+		}
+		return fmt.Errorf("multiple failures: %v", errs)
+	}
+	return nil
+}
+
+// DeliverNodeData injects a state node retrieval response into the download
+// queue, passing the requested nodes on to the state scheduler.
+func (q *queue) DeliverNodeData(id string, data [][]byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// Short circuit if the data was never requested
+	request := q.statePendPool[id]
+	if request == nil {
+		return errNoFetchesPending
+	}
+	delete(q.statePendPool, id)
+
+	// If no data was retrieved, mark it as unavailable for the origin peer
+	if len(data) == 0 {
+		for hash, _ := range request.Hashes {
+			request.Peer.ignored.Add(hash)
+		}
+	}
+	// Gather all the requested nodes, dropping anything else
+	errs := make([]error, 0)
+	results := make([]trie.SyncResult, 0, len(data))
+	for _, blob := range data {
+		hash := crypto.Sha3Hash(blob)
+		if _, ok := request.Hashes[hash]; !ok {
+			errs = append(errs, fmt.Errorf("non-requested state node %x", hash))
+			continue
+		}
+		results = append(results, trie.SyncResult{Hash: hash, Data: blob})
+
+		delete(request.Hashes, hash)
+		delete(q.stateTaskPool, hash)
+	}
+	// Feed the retrieved nodes to the scheduler; a failure means the nodes
+	// hashing to the requested values are malformed, so the state is invalid
+	if _, err := q.stateScheduler.Process(results); err != nil {
+		glog.V(logger.Debug).Infof("state node processing failed: %v", err)
+		return ErrInvalidChain
+	}
+	// Return all failed or missing fetches to the queue
+	for hash, index := range request.Hashes {
+		q.stateTaskQueue.Push(hash, float32(index))
+	}
+	// If none of the data was good, it's a stale delivery
+	if len(errs) != 0 {
+		if len(errs) == len(data) {
+			return errStaleDelivery
+		}
+		return fmt.Errorf("multiple failures: %v", errs)
+	}
+	return nil
+}
+
+// scheduleNodeData moves all the state node hashes known to be missing from the
+// state scheduler into the task queue. The method assumes the queue lock is held.
+func (q *queue) scheduleNodeData() {
+	if q.stateScheduler == nil {
+		return
+	}
+	for _, hash := range q.stateScheduler.Missing(0) {
+		if _, ok := q.stateTaskPool[hash]; ok {
+			continue
+		}
+		index := len(q.stateTaskPool)
+		q.stateTaskPool[hash] = index
+		q.stateTaskQueue.Push(hash, float32(-index))
+	}
+}
+
+// PrepareFast configures the queue for fast synchronisation up to the pivot
+// block, scheduling the retrieval of the pivot state into the given database.
+func (q *queue) PrepareFast(pivot *types.Header, stateDb common.Database) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.fastPivot = pivot.Number.Uint64()
+	q.stateScheduler = state.NewStateSync(pivot.Root, stateDb)
+}
+
+// FinishFast terminates the fast synchronisation mode, allowing blocks to be
+// taken for full processing again.
+func (q *queue) FinishFast() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.fastPivot = 0
+	q.stateScheduler = nil
+}
+
 // Prepare configures the block cache offset to allow accepting inbound blocks.
 func (q *queue) Prepare(offset int) {
 	q.lock.Lock()
@@ -0,0 +1,193 @@
+package eth
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+var (
+	// forkChallengeTimeout is the time a new peer has to answer the fork
+	// challenge before it's dropped.
+	forkChallengeTimeout = 15 * time.Second
+
+	forkCacheLimit  = 1024      // Maximum number of peers whose fork checks are remembered
+	forkCacheExpiry = time.Hour // Time after which a peer is challenged again
+)
+
+// forkRecord holds the fork checkpoints a peer passed or failed.
+type forkRecord struct {
+	checks  map[uint64]bool
+	expires time.Time
+}
+
+// challengeFork starts the fork challenge of a new peer: its headers at all the
+// fork checkpoints must match the checkpoint hashes. Each checkpoint header is
+// requested by number, the replies are processed by handleForkHeaders. Peers
+// which failed a checkpoint before are rejected right away, eth/60 peers can't
+// serve headers and aren't challenged at all.
+func (pm *ProtocolManager) challengeFork(p *peer) error {
+	if p.protv < eth62 {
+		return nil
+	}
+	pm.forkLock.Lock()
+	checks := pm.forkChecks(p.id)
+	for number := range pm.checkpoints {
+		passed, known := checks[number]
+		if known && !passed {
+			pm.forkLock.Unlock()
+			return errResp(ErrForkMismatch, "failed checkpoint #%d before", number)
+		}
+		if !known {
+			p.forkChecks = append(p.forkChecks, number)
+		}
+	}
+	pm.forkLock.Unlock()
+
+	if len(p.forkChecks) == 0 {
+		return nil
+	}
+	p.forkDrop = time.AfterFunc(forkChallengeTimeout, func() {
+		// Don't drop a newer connection of the same node
+		if pm.peers.Peer(p.id) == p {
+			glog.V(logger.Debug).Infof("[%s] fork challenge timed out\n", p.id)
+			pm.removePeer(p.id)
+		}
+	})
+	for _, number := range p.forkChecks {
+		if err := p.requestHeadersByNumber(number, 1, 0, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleForkHeaders processes a header reply during the fork challenge of a
+// peer. A checkpoint header with the wrong hash fails the challenge. An empty
+// reply claims the peer's chain didn't reach the checkpoint yet, which is only
+// accepted if the total difficulty of the peer is below the checkpoint's, or
+// if its head header is below the checkpoint and has a valid proof-of-work.
+func (pm *ProtocolManager) handleForkHeaders(p *peer, headers []*types.Header) error {
+	if len(headers) > 1 {
+		return nil
+	}
+	switch {
+	case len(p.forkChecks) > 0:
+		// Replies arrive in request order, an empty one answers the oldest request
+		index := 0
+		if len(headers) == 1 {
+			for index < len(p.forkChecks) && p.forkChecks[index] != headers[0].Number.Uint64() {
+				index++
+			}
+			if index == len(p.forkChecks) {
+				return nil
+			}
+		}
+		number := p.forkChecks[index]
+		p.forkChecks = append(p.forkChecks[:index], p.forkChecks[index+1:]...)
+		hash := pm.checkpoints[number]
+
+		if len(headers) == 1 {
+			pm.cacheForkCheck(p.id, number, headers[0].Hash() == hash)
+			if headers[0].Hash() != hash {
+				return errResp(ErrForkMismatch, "checkpoint #%d: %x (!= %x)", number, headers[0].Hash().Bytes()[:4], hash[:4])
+			}
+			break
+		}
+		if block := pm.chainman.GetBlock(hash); block != nil && block.Td != nil {
+			if td := p.Td(); td.Cmp(block.Td) >= 0 {
+				pm.cacheForkCheck(p.id, number, false)
+				return errResp(ErrForkMismatch, "checkpoint #%d missing with td %v (>= %v)", number, td, block.Td)
+			}
+			break
+		}
+		// The checkpoint isn't known locally, the peer's head has to prove its height
+		if p.forkHead == (common.Hash{}) {
+			p.forkHead, p.forkBelow = p.Head(), number
+			if err := p.requestHeadersByHash(p.forkHead, 1, 0, false); err != nil {
+				return err
+			}
+		} else if number < p.forkBelow {
+			p.forkBelow = number
+		}
+
+	case p.forkHead != (common.Hash{}):
+		if len(headers) == 0 {
+			pm.cacheForkCheck(p.id, p.forkBelow, false)
+			return errResp(ErrForkMismatch, "head %x missing", p.forkHead[:4])
+		}
+		header := headers[0]
+		if header.Hash() != p.forkHead {
+			return nil
+		}
+		if header.Number.Uint64() >= p.forkBelow || !pm.chainman.VerifyHeaderPoW(header) {
+			pm.cacheForkCheck(p.id, p.forkBelow, false)
+			return errResp(ErrForkMismatch, "head #%d doesn't prove checkpoint #%d unreached", header.Number, p.forkBelow)
+		}
+		p.forkHead = common.Hash{}
+
+	default:
+		return nil
+	}
+	if len(p.forkChecks) == 0 && p.forkHead == (common.Hash{}) {
+		glog.V(logger.Detail).Infof("[%s] passed fork challenge\n", p.id)
+		p.forkDrop.Stop()
+		p.forkDrop = nil
+		return pm.registerDownloader(p)
+	}
+	return nil
+}
+
+// stopForkChallenge cancels the fork challenge of a disconnecting peer.
+func (pm *ProtocolManager) stopForkChallenge(p *peer) {
+	if p.forkDrop != nil {
+		p.forkDrop.Stop()
+	}
+}
+
+// forkChecks returns the cached fork checkpoint results of a peer, dropping
+// them if expired. The fork lock must be held.
+func (pm *ProtocolManager) forkChecks(id string) map[uint64]bool {
+	record := pm.forkCache[id]
+	if record == nil {
+		return nil
+	}
+	if time.Now().After(record.expires) {
+		delete(pm.forkCache, id)
+		return nil
+	}
+	return record.checks
+}
+
+// cacheForkCheck records whether a peer passed a fork checkpoint, so it isn't
+// challenged with it again when reconnecting. If the cache is full, expired
+// records are dropped, and if none expired the oldest one.
+func (pm *ProtocolManager) cacheForkCheck(id string, number uint64, passed bool) {
+	pm.forkLock.Lock()
+	defer pm.forkLock.Unlock()
+
+	now := time.Now()
+	record := pm.forkCache[id]
+	if record == nil {
+		if len(pm.forkCache) >= forkCacheLimit {
+			var oldest string
+			for id, record := range pm.forkCache {
+				if now.After(record.expires) {
+					delete(pm.forkCache, id)
+				} else if oldest == "" || record.expires.Before(pm.forkCache[oldest].expires) {
+					oldest = id
+				}
+			}
+			if len(pm.forkCache) >= forkCacheLimit {
+				delete(pm.forkCache, oldest)
+			}
+		}
+		record = &forkRecord{checks: make(map[uint64]bool)}
+		pm.forkCache[id] = record
+	}
+	record.checks[number] = passed
+	record.expires = now.Add(forkCacheExpiry)
+}
@@ -23,6 +23,10 @@ import (
 // if a single block is larger than the limit.
 const maxBlockRespSize = 2 * 1024 * 1024
 
+// Peers that send invalid blocks or hash chains are
+// banned from reconnecting for this amount of time.
+const violationBanTime = time.Hour
+
 func errResp(code errCode, format string, v ...interface{}) error {
 	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
 }
@@ -41,24 +45,32 @@ func (ep extProt) GetHashes(hash common.Hash) error    { return ep.getHashes(has
 func (ep extProt) GetBlock(hashes []common.Hash) error { return ep.getBlocks(hashes) }
 
 type ProtocolManager struct {
-	protVer, netId int
+	protVer, netId int // Highest offered protocol version and the network id
 	txpool         txPool
 	chainman       *core.ChainManager
+	stateDb        common.Database // State database to serve node data from
+	extraDb        common.Database // Extra database to serve receipts from
 	downloader     *downloader.Downloader
 	peers          *peerSet
 
-	SubProtocol p2p.Protocol
+	checkpoints map[uint64]common.Hash // Fork checkpoints the chains of peers must contain
+	forkCache   map[string]*forkRecord // Fork checkpoints passed or failed by recent peers
+	forkLock    sync.Mutex             // Lock protecting the fork cache
+
+	SubProtocols []p2p.Protocol
 
 	eventMux      *event.TypeMux
 	txSub         event.Subscription
 	minedBlockSub event.Subscription
 
 	// channels for fetcher, syncer, txsyncLoop
-	newPeerCh  chan *peer
-	newHashCh  chan []*blockAnnounce
-	newBlockCh chan chan []*types.Block
-	txsyncCh   chan *txsync
-	quitSync   chan struct{}
+	newPeerCh   chan *peer
+	newHashCh   chan []*blockAnnounce
+	newBlockCh  chan chan []*types.Block
+	newHeaderCh chan chan *headerFilterTask
+	newBodyCh   chan chan *bodyFilterTask
+	txsyncCh    chan *txsync
+	quitSync    chan struct{}
 
 	// wait group is used for graceful shutdowns during downloading
 	// and processing
@@ -67,31 +79,48 @@ type ProtocolManager struct {
 }
 
 // NewProtocolManager returns a new ethereum sub protocol manager. The Ethereum sub protocol manages peers capable
-// with the ethereum network.
-func NewProtocolManager(protocolVersion, networkId int, mux *event.TypeMux, txpool txPool, chainman *core.ChainManager, downloader *downloader.Downloader) *ProtocolManager {
+// with the ethereum network. Every supported protocol version up to protocolVersion is offered, and each peer
+// runs the highest version it shares with us. Peers whose chains don't contain the given fork checkpoints,
+// mapping block numbers to hashes, are dropped.
+func NewProtocolManager(protocolVersion, networkId int, mux *event.TypeMux, txpool txPool, chainman *core.ChainManager,
+	stateDb, extraDb common.Database, downloader *downloader.Downloader, checkpoints map[uint64]common.Hash) *ProtocolManager {
 	manager := &ProtocolManager{
-		eventMux:   mux,
-		txpool:     txpool,
-		chainman:   chainman,
-		downloader: downloader,
-		peers:      newPeerSet(),
-		newPeerCh:  make(chan *peer, 1),
-		newHashCh:  make(chan []*blockAnnounce, 1),
-		newBlockCh: make(chan chan []*types.Block),
-		txsyncCh:   make(chan *txsync),
-		quitSync:   make(chan struct{}),
+		protVer:     protocolVersion,
+		netId:       networkId,
+		eventMux:    mux,
+		txpool:      txpool,
+		chainman:    chainman,
+		stateDb:     stateDb,
+		extraDb:     extraDb,
+		downloader:  downloader,
+		peers:       newPeerSet(),
+		checkpoints: checkpoints,
+		forkCache:   make(map[string]*forkRecord),
+		newPeerCh:   make(chan *peer, 1),
+		newHashCh:   make(chan []*blockAnnounce, 1),
+		newBlockCh:  make(chan chan []*types.Block),
+		newHeaderCh: make(chan chan *headerFilterTask),
+		newBodyCh:   make(chan chan *bodyFilterTask),
+		txsyncCh:    make(chan *txsync),
+		quitSync:    make(chan struct{}),
 	}
-	manager.SubProtocol = p2p.Protocol{
-		Name:    "eth",
-		Version: uint(protocolVersion),
-		Length:  ProtocolLength,
-		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
-			peer := manager.newPeer(protocolVersion, networkId, p, rw)
-
-			manager.newPeerCh <- peer
-
-			return manager.handle(peer)
-		},
+	for i, version := range ProtocolVersions {
+		if version > uint(protocolVersion) {
+			continue
+		}
+		version := version // closure for the run function
+		manager.SubProtocols = append(manager.SubProtocols, p2p.Protocol{
+			Name:    "eth",
+			Version: version,
+			Length:  ProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				peer := manager.newPeer(int(version), networkId, p, rw)
+
+				manager.newPeerCh <- peer
+
+				return manager.handle(peer)
+			},
+		})
 	}
 
 	return manager
@@ -116,6 +145,16 @@ func (pm *ProtocolManager) removePeer(id string) {
 	}
 }
 
+// banPeer bans a peer for violating the protocol, which also
+// disconnects it. The peer is removed from the peer set.
+func (pm *ProtocolManager) banPeer(id string, reason error) {
+	if peer := pm.peers.Peer(id); peer != nil {
+		glog.V(logger.Debug).Infof("Banning peer %v: %v", id, reason)
+		peer.Peer.Ban(violationBanTime, reason.Error())
+	}
+	pm.removePeer(id)
+}
+
 func (pm *ProtocolManager) Start() {
 	// broadcast transactions
 	pm.txSub = pm.eventMux.Subscribe(core.TxPreEvent{})
@@ -157,6 +196,11 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	if err := p.handleStatus(); err != nil {
 		return err
 	}
+	// Make sure the peer follows our fork of the network
+	if err := pm.challengeFork(p); err != nil {
+		return err
+	}
+	defer pm.stopForkChallenge(p)
 
 	// Register the peer locally.
 	glog.V(logger.Detail).Infoln("Adding peer", p.id)
@@ -166,10 +210,12 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 	defer pm.removePeer(p.id)
 
-	// Register the peer in the downloader. If the downloader
-	// considers it banned, we disconnect.
-	if err := pm.downloader.RegisterPeer(p.id, p.Head(), p.requestHashes, p.requestBlocks); err != nil {
-		return err
+	// Register the peer in the downloader, unless it has yet to pass
+	// the fork challenge. If the downloader considers it banned, we disconnect.
+	if p.forkDrop == nil {
+		if err := pm.registerDownloader(p); err != nil {
+			return err
+		}
 	}
 
 	// Propagate existing transactions. new transactions appearing
@@ -185,6 +231,13 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	return nil
 }
 
+// registerDownloader registers a peer in the downloader, making it a source
+// for synchronisation.
+func (pm *ProtocolManager) registerDownloader(p *peer) error {
+	return pm.downloader.RegisterPeer(p.id, p.protv, p.Head(), p.requestHashes, p.requestBlocks, p.requestHeadersByNumber,
+		p.requestHeadersByHash, p.requestBodies, p.requestReceipts, p.requestNodeData)
+}
+
 func (self *ProtocolManager) handleMsg(p *peer) error {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
@@ -196,11 +249,12 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 	// make sure that the payload has been fully consumed
 	defer msg.Discard()
 
-	switch msg.Code {
-	case StatusMsg:
+	// Handle the message depending on its contents and the protocol version
+	switch {
+	case msg.Code == StatusMsg:
 		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
 
-	case TxMsg:
+	case msg.Code == TxMsg:
 		// TODO: rework using lazy RLP stream
 		var txs []*types.Transaction
 		if err := msg.Decode(&txs); err != nil {
@@ -217,7 +271,7 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 		}
 		self.txpool.AddTransactions(txs)
 
-	case GetBlockHashesMsg:
+	case p.protv < eth62 && msg.Code == GetBlockHashesMsg:
 		var request getBlockHashesMsgData
 		if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "->msg %v: %v", msg, err)
@@ -238,7 +292,7 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 		// returns either requested hashes or nothing (i.e. not found)
 		return p.sendBlockHashes(hashes)
 
-	case BlockHashesMsg:
+	case p.protv < eth62 && msg.Code == BlockHashesMsg:
 		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
 
 		var hashes []common.Hash
@@ -250,7 +304,7 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 			glog.V(logger.Debug).Infoln(err)
 		}
 
-	case GetBlocksMsg:
+	case p.protv < eth62 && msg.Code == GetBlocksMsg:
 		var blocks []*types.Block
 
 		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
@@ -282,7 +336,7 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 		}
 		return p.sendBlocks(blocks)
 
-	case BlocksMsg:
+	case p.protv < eth62 && msg.Code == BlocksMsg:
 		// Decode the arrived block message
 		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
 
@@ -307,13 +361,183 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 
-	case NewBlockHashesMsg:
+	case p.protv >= eth62 && msg.Code == GetBlockHeadersMsg:
+		// Decode the complex header query
+		var query getBlockHeadersData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		return p.sendBlockHeaders(self.getBlockHeaders(&query))
+
+	case p.protv >= eth62 && msg.Code == BlockHeadersMsg:
+		// A batch of headers arrived to one of our previous requests
+		var headers []*types.Header
+		if err := msg.Decode(&headers); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Until the fork challenge is passed, the peer isn't known to the downloader
+		if p.forkDrop != nil {
+			return self.handleForkHeaders(p, headers)
+		}
+		// Single headers may be explicit fetches of announced blocks
+		filter := len(headers) == 1
+		if filter {
+			headers = self.filterHeaders(p, headers)
+		}
+		if len(headers) > 0 || !filter {
+			if err := self.downloader.DeliverHeaders(p.id, headers); err != nil {
+				glog.V(logger.Debug).Infoln(err)
+			}
+		}
+
+	case p.protv >= eth62 && msg.Code == GetBlockBodiesMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather bodies until the fetch or network limits are reached
+		var (
+			hash      common.Hash
+			totalsize common.StorageSize
+			bodies    []*blockBody
+		)
+		for len(bodies) < downloader.MaxBodyFetch && totalsize < maxBlockRespSize {
+			// Retrieve the hash of the next block
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested block body, stopping if enough was found
+			if block := self.chainman.GetBlock(hash); block != nil {
+				bodies = append(bodies, &blockBody{Transactions: block.Transactions(), Uncles: block.Uncles()})
+				totalsize += block.Size()
+			}
+		}
+		return p.sendBlockBodies(bodies)
+
+	case p.protv >= eth62 && msg.Code == BlockBodiesMsg:
+		// A batch of block bodies arrived to one of our previous requests
+		var request []*blockBody
+		if err := msg.Decode(&request); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Filter out any explicitly requested bodies, deliver the rest to the downloader
+		filter := len(request) > 0
+		if filter {
+			request = self.filterBodies(p, request)
+		}
+		if len(request) > 0 || !filter {
+			transactions := make([][]*types.Transaction, len(request))
+			uncles := make([][]*types.Header, len(request))
+			for i, body := range request {
+				transactions[i] = body.Transactions
+				uncles[i] = body.Uncles
+			}
+			if err := self.downloader.DeliverBodies(p.id, transactions, uncles); err != nil {
+				glog.V(logger.Debug).Infoln(err)
+			}
+		}
+
+	case p.protv >= eth63 && msg.Code == GetNodeDataMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather state data until the fetch or network limits are reached
+		var (
+			hash  common.Hash
+			bytes int
+			data  [][]byte
+		)
+		for len(data) < downloader.MaxStateFetch && bytes < maxBlockRespSize {
+			// Retrieve the hash of the next state entry
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested state entry, stopping if enough was found
+			if entry, err := self.stateDb.Get(hash.Bytes()); err == nil && len(entry) > 0 {
+				data = append(data, entry)
+				bytes += len(entry)
+			}
+		}
+		return p.sendNodeData(data)
+
+	case p.protv >= eth63 && msg.Code == NodeDataMsg:
+		// A batch of node state data arrived to one of our previous requests
+		var data [][]byte
+		if err := msg.Decode(&data); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := self.downloader.DeliverNodeData(p.id, data); err != nil {
+			glog.V(logger.Debug).Infoln(err)
+		}
+
+	case p.protv >= eth63 && msg.Code == GetReceiptsMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather receipts until the fetch or network limits are reached
+		var (
+			hash     common.Hash
+			bytes    int
+			receipts []types.Receipts
+		)
+		for len(receipts) < downloader.MaxReceiptFetch && bytes < maxBlockRespSize {
+			// Retrieve the hash of the next block
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested block's receipts, skipping if unknown to us
+			results, err := core.GetBlockReceipts(self.extraDb, hash)
+			if err != nil {
+				continue
+			}
+			if encoded, err := rlp.EncodeToBytes(results); err != nil {
+				glog.V(logger.Error).Infof("failed to encode receipt: %v", err)
+			} else {
+				receipts = append(receipts, results)
+				bytes += len(encoded)
+			}
+		}
+		return p.sendReceipts(receipts)
+
+	case p.protv >= eth63 && msg.Code == ReceiptsMsg:
+		// A batch of receipts arrived to one of our previous requests
+		var receipts [][]*types.Receipt
+		if err := msg.Decode(&receipts); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := self.downloader.DeliverReceipts(p.id, receipts); err != nil {
+			glog.V(logger.Debug).Infoln(err)
+		}
+
+	case msg.Code == NewBlockHashesMsg:
 		// Retrieve and deseralize the remote new block hashes notification
 		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
 
 		var hashes []common.Hash
-		if err := msgStream.Decode(&hashes); err != nil {
-			break
+		if p.protv < eth62 {
+			if err := msgStream.Decode(&hashes); err != nil {
+				break
+			}
+		} else {
+			// From eth/62 on, announcements carry the block numbers too
+			var announces newBlockHashesData
+			if err := msgStream.Decode(&announces); err != nil {
+				break
+			}
+			for _, announce := range announces {
+				hashes = append(hashes, announce.Hash)
+			}
 		}
 		// Mark the hashes as present at the remote node
 		for _, hash := range hashes {
@@ -342,13 +566,15 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 
-	case NewBlockMsg:
+	case msg.Code == NewBlockMsg:
 		var request newBlockMsgData
 		if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "%v: %v", msg, err)
 		}
 		if err := request.Block.ValidateFields(); err != nil {
-			return errResp(ErrDecode, "block validation %v: %v", msg, err)
+			err = errResp(ErrDecode, "block validation %v: %v", msg, err)
+			p.Ban(violationBanTime, err.Error())
+			return err
 		}
 		request.Block.ReceivedAt = msg.ReceivedAt
 
@@ -362,6 +588,93 @@ func (self *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// getBlockHeaders gathers the headers matching a header query from the local
+// chain. Queries by number walk the canonical chain, while reverse queries by
+// hash follow the parent links until they reach the canonical chain, so they
+// work on side chains too. Skips that would move past the genesis block or
+// overflow the block number end the query.
+func (pm *ProtocolManager) getBlockHeaders(query *getBlockHeadersData) []*types.Header {
+	var (
+		headers  []*types.Header
+		unknown  bool
+		hashMode = query.Origin.Hash != (common.Hash{})
+	)
+	for !unknown && len(headers) < int(query.Amount) && len(headers) < downloader.MaxHeaderFetch {
+		// Retrieve the next header satisfying the query
+		var origin *types.Block
+		if hashMode {
+			origin = pm.chainman.GetBlock(query.Origin.Hash)
+		} else {
+			origin = pm.chainman.GetBlockByNumber(query.Origin.Number)
+		}
+		if origin == nil {
+			break
+		}
+		number := origin.NumberU64()
+		headers = append(headers, origin.Header())
+
+		// Advance to the next header of the query
+		switch {
+		case query.Reverse && hashMode:
+			// Hash based traversal towards the genesis block
+			if number <= query.Skip {
+				unknown = true
+				break
+			}
+			if next := pm.reverseAncestor(origin, query.Skip+1); next != nil {
+				query.Origin.Hash = next.Hash()
+			} else {
+				unknown = true
+			}
+		case query.Reverse:
+			// Number based traversal towards the genesis block
+			if query.Origin.Number > query.Skip {
+				query.Origin.Number -= query.Skip + 1
+			} else {
+				unknown = true
+			}
+		case hashMode:
+			// Hash based traversal towards the leaf block, only on the canonical chain
+			next := number + query.Skip + 1
+			if next <= number {
+				unknown = true
+			} else if canon := pm.chainman.GetBlockByNumber(number); canon == nil || canon.Hash() != query.Origin.Hash {
+				unknown = true
+			} else if block := pm.chainman.GetBlockByNumber(next); block != nil {
+				query.Origin.Hash = block.Hash()
+			} else {
+				unknown = true
+			}
+		default:
+			// Number based traversal towards the leaf block
+			next := query.Origin.Number + query.Skip + 1
+			if next <= query.Origin.Number {
+				unknown = true
+			} else {
+				query.Origin.Number = next
+			}
+		}
+	}
+	return headers
+}
+
+// reverseAncestor returns the ancestor of block that is distance blocks
+// closer to the genesis block. Parent links are followed only while the
+// block is on a side chain, the rest of the distance is looked up on the
+// canonical chain directly. The distance must not exceed the block number.
+func (pm *ProtocolManager) reverseAncestor(block *types.Block, distance uint64) *types.Block {
+	for ; distance > 0; distance-- {
+		number := block.NumberU64()
+		if canon := pm.chainman.GetBlockByNumber(number); canon != nil && canon.Hash() == block.Hash() {
+			return pm.chainman.GetBlockByNumber(number - distance)
+		}
+		if block = pm.chainman.GetBlock(block.ParentHash()); block == nil {
+			return nil
+		}
+	}
+	return block
+}
+
 // importBlocks injects a new block retrieved from the given peer into the chain
 // manager.
 func (pm *ProtocolManager) importBlock(p *peer, block *types.Block, td *big.Int) error {
@@ -392,7 +705,12 @@ func (pm *ProtocolManager) importBlock(p *peer, block *types.Block, td *big.Int)
 		return nil
 	}
 	// Attempt to insert the newly received block and propagate to our peers
-	if pm.chainman.HasBlock(block.ParentHash()) {
+	if parent := pm.chainman.GetBlock(block.ParentHash()); parent != nil {
+		// Propagate the block as soon as its header checks out, before import
+		if err := pm.chainman.VerifyHeader(block.Header()); err == nil {
+			block.Td = core.CalcTD(block, parent)
+			pm.BroadcastBlock(hash, block, true)
+		}
 		if _, err := pm.chainman.InsertChain(types.Blocks{block}); err != nil {
 			glog.V(logger.Error).Infoln("removed peer (", p.id, ") due to block error", err)
 			return err
@@ -402,7 +720,7 @@ func (pm *ProtocolManager) importBlock(p *peer, block *types.Block, td *big.Int)
 			glog.V(logger.Error).Infoln(err)
 			return err
 		}
-		pm.BroadcastBlock(hash, block)
+		pm.BroadcastBlock(hash, block, false)
 		return nil
 	}
 	// Parent of the block is unknown, try to sync with this peer if it seems to be good
@@ -412,26 +730,27 @@ func (pm *ProtocolManager) importBlock(p *peer, block *types.Block, td *big.Int)
 	return nil
 }
 
-// BroadcastBlock will propagate the block to a subset of its connected peers,
-// only notifying the rest of the block's appearance.
-func (pm *ProtocolManager) BroadcastBlock(hash common.Hash, block *types.Block) {
-	// Retrieve all the target peers and split between full broadcast or only notification
+// BroadcastBlock will either propagate a block to a subset of its connected
+// peers, or only notify the peers not knowing about it of its appearance. The
+// full block is sent to the square root of the peers lacking it, which should
+// be done as soon as the header is verified. The hash is announced to the rest
+// once the block is imported, so they can retrieve it from us. Sent blocks and
+// hashes are marked as known, so no peer gets the same block twice.
+func (pm *ProtocolManager) BroadcastBlock(hash common.Hash, block *types.Block, propagate bool) {
 	peers := pm.peers.PeersWithoutBlock(hash)
-	split := int(math.Sqrt(float64(len(peers))))
 
-	transfer := peers[:split]
-	notify := peers[split:]
-
-	// Send out the data transfers and the notifications
-	for _, peer := range notify {
-		peer.sendNewBlockHashes([]common.Hash{hash})
+	if propagate {
+		transfer := peers[:int(math.Sqrt(float64(len(peers))))]
+		for _, peer := range transfer {
+			peer.sendNewBlock(block)
+		}
+		glog.V(logger.Detail).Infoln("broadcast block to", len(transfer), "peers. Total processing time:", time.Since(block.ReceivedAt))
+		return
 	}
-	glog.V(logger.Detail).Infoln("broadcast hash to", len(notify), "peers.")
-
-	for _, peer := range transfer {
-		peer.sendNewBlock(block)
+	for _, peer := range peers {
+		peer.sendNewBlockHashes([]common.Hash{hash}, []uint64{block.NumberU64()})
 	}
-	glog.V(logger.Detail).Infoln("broadcast block to", len(transfer), "peers. Total processing time:", time.Since(block.ReceivedAt))
+	glog.V(logger.Detail).Infoln("broadcast hash to", len(peers), "peers.")
 }
 
 // BroadcastTx will propagate the block to its connected peers. It will sort
@@ -453,7 +772,8 @@ func (self *ProtocolManager) minedBroadcastLoop() {
 	for obj := range self.minedBlockSub.Chan() {
 		switch ev := obj.(type) {
 		case core.NewMinedBlockEvent:
-			self.BroadcastBlock(ev.Block.Hash(), ev.Block)
+			self.BroadcastBlock(ev.Block.Hash(), ev.Block, true)
+			self.BroadcastBlock(ev.Block.Hash(), ev.Block, false)
 		}
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -51,6 +52,11 @@ type peer struct {
 
 	txHashes    *set.Set
 	blockHashes *set.Set
+
+	forkChecks []uint64    // Fork checkpoints whose headers were requested, in request order
+	forkHead   common.Hash // Head hash whose header the fork challenge awaits
+	forkBelow  uint64      // Lowest checkpoint the peer claims its chain didn't reach yet
+	forkDrop   *time.Timer // Timer dropping the peer unless the fork challenge completes
 }
 
 func newPeer(protv, netid int, genesis, head common.Hash, td *big.Int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
@@ -123,11 +129,43 @@ func (p *peer) sendBlocks(blocks []*types.Block) error {
 	return p2p.Send(p.rw, BlocksMsg, blocks)
 }
 
-func (p *peer) sendNewBlockHashes(hashes []common.Hash) error {
+// sendBlockHeaders sends a batch of block headers to the remote peer.
+func (p *peer) sendBlockHeaders(headers []*types.Header) error {
+	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+}
+
+// sendBlockBodies sends a batch of block contents to the remote peer.
+func (p *peer) sendBlockBodies(bodies []*blockBody) error {
+	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+}
+
+// sendNodeData sends a batch of arbitrary internal data, corresponding to the
+// hashes requested.
+func (p *peer) sendNodeData(data [][]byte) error {
+	return p2p.Send(p.rw, NodeDataMsg, data)
+}
+
+// sendReceipts sends a batch of transaction receipts, corresponding to the ones
+// requested.
+func (p *peer) sendReceipts(receipts []types.Receipts) error {
+	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+}
+
+// sendNewBlockHashes announces the availability of a number of blocks. From
+// eth/62 on, the announcement carries the block numbers along the hashes.
+func (p *peer) sendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
 	for _, hash := range hashes {
 		p.blockHashes.Add(hash)
 	}
-	return p2p.Send(p.rw, NewBlockHashesMsg, hashes)
+	if p.protv < eth62 {
+		return p2p.Send(p.rw, NewBlockHashesMsg, hashes)
+	}
+	request := make(newBlockHashesData, len(hashes))
+	for i := 0; i < len(hashes); i++ {
+		request[i].Hash = hashes[i]
+		request[i].Number = numbers[i]
+	}
+	return p2p.Send(p.rw, NewBlockHashesMsg, request)
 }
 
 func (p *peer) sendNewBlock(block *types.Block) error {
@@ -152,6 +190,40 @@ func (p *peer) requestBlocks(hashes []common.Hash) error {
 	return p2p.Send(p.rw, GetBlocksMsg, hashes)
 }
 
+// requestHeadersByHash fetches a batch of blocks' headers corresponding to the
+// specified header query, based on the hash of an origin block.
+func (p *peer) requestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+	glog.V(logger.Debug).Infof("[%s] fetching %d headers from %x, skipping %d (reverse = %v)\n", p.id, amount, origin[:4], skip, reverse)
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// requestHeadersByNumber fetches a batch of blocks' headers corresponding to the
+// specified header query, based on the number of an origin block.
+func (p *peer) requestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+	glog.V(logger.Debug).Infof("[%s] fetching %d headers from #%d, skipping %d (reverse = %v)\n", p.id, amount, origin, skip, reverse)
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// requestBodies fetches a batch of blocks' bodies corresponding to the hashes
+// specified.
+func (p *peer) requestBodies(hashes []common.Hash) error {
+	glog.V(logger.Debug).Infof("[%s] fetching %d block bodies\n", p.id, len(hashes))
+	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+}
+
+// requestNodeData fetches a batch of arbitrary data from a node's known state
+// data, corresponding to the specified hashes.
+func (p *peer) requestNodeData(hashes []common.Hash) error {
+	glog.V(logger.Debug).Infof("[%s] fetching %v state data\n", p.id, len(hashes))
+	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+}
+
+// requestReceipts fetches a batch of transaction receipts from a remote node.
+func (p *peer) requestReceipts(hashes []common.Hash) error {
+	glog.V(logger.Debug).Infof("[%s] fetching %v receipts\n", p.id, len(hashes))
+	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+}
+
 func (p *peer) handleStatus() error {
 	errc := make(chan error, 1)
 	go func() {
@@ -2,7 +2,10 @@ package eth
 
 import (
 	"crypto/rand"
+	"fmt"
+	"math"
 	"math/big"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -16,6 +19,7 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func init() {
@@ -41,7 +45,7 @@ func TestStatusMsgErrors(t *testing.T) {
 		},
 		{
 			code: StatusMsg, data: statusMsgData{10, NetworkId, td, currentBlock, genesis},
-			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= 0)"),
+			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= %d)", ProtocolVersion),
 		},
 		{
 			code: StatusMsg, data: statusMsgData{ProtocolVersion, 999, td, currentBlock, genesis},
@@ -154,6 +158,103 @@ func TestSendTransactions(t *testing.T) {
 	wg.Wait()
 }
 
+// Tests that a block is sent in full to the square root of the peers lacking
+// it and only announced to the others, with no peer receiving it twice.
+func TestBroadcastBlock(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+
+	const npeers = 9
+	peers := make([]*testPeer, npeers)
+	for i := range peers {
+		peers[i], _ = newTestPeer(pm)
+		peers[i].handshake(t)
+		defer peers[i].close()
+	}
+	for start := time.Now(); pm.peers.Len() < npeers; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("peers not registered: have %d, want %d", pm.peers.Len(), npeers)
+		}
+	}
+	block := core.NewBlockFromParent(common.Address{1}, pm.chainman.Genesis())
+	done := make(chan struct{})
+	go func() {
+		pm.BroadcastBlock(block.Hash(), block, true)
+		pm.BroadcastBlock(block.Hash(), block, false)
+		close(done)
+	}()
+
+	// Every peer receives exactly one message, a second send would block
+	codes := make(chan uint64, npeers)
+	for _, p := range peers {
+		go func(p *testPeer) {
+			msg, err := p.ReadMsg()
+			if err != nil {
+				t.Errorf("%v: read error: %v", p.Peer, err)
+			}
+			msg.Discard()
+			codes <- msg.Code
+		}(p)
+	}
+	var full, announced int
+	for i := 0; i < npeers; i++ {
+		switch <-codes {
+		case NewBlockMsg:
+			full++
+		case NewBlockHashesMsg:
+			announced++
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("block sent more than once to some peer")
+	}
+	if full != 3 || announced != 6 {
+		t.Errorf("propagation mismatch: %d full blocks and %d announcements, want 3 and 6", full, announced)
+	}
+}
+
+// Tests that blocks announced by eth/62 peers are fetched through header and
+// body requests, as the hash chain messages are gone from the protocol.
+func TestFetchAnnouncedBlock(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer, pm.netId = eth62, NetworkId
+
+	p, _ := newTestPeer(pm)
+	p.handshake(t)
+	defer p.close()
+
+	block := core.NewBlockFromParent(common.Address{1}, pm.chainman.Genesis())
+	announce := newBlockHashesData{{Hash: block.Hash(), Number: block.NumberU64()}}
+	if err := p2p.Send(p, NewBlockHashesMsg, announce); err != nil {
+		t.Fatalf("failed to announce block: %v", err)
+	}
+	if err := expectMsg(p, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: block.Hash()}, Amount: 1}, 2*time.Second); err != nil {
+		t.Fatalf("header not requested: %v", err)
+	}
+	if err := p2p.Send(p, BlockHeadersMsg, []*types.Header{block.Header()}); err != nil {
+		t.Fatalf("failed to send header: %v", err)
+	}
+	if err := expectMsg(p, GetBlockBodiesMsg, []common.Hash{block.Hash()}, time.Second); err != nil {
+		t.Fatalf("body not requested: %v", err)
+	}
+}
+
+// expectMsg is like p2p.ExpectMsg, but fails instead of blocking if no
+// message arrives within the timeout.
+func expectMsg(r p2p.MsgReader, code uint64, content interface{}, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- p2p.ExpectMsg(r, code, content) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no message with code %d within %v", code, timeout)
+	}
+}
+
 // testPeer wraps all peer-related data for tests.
 type testPeer struct {
 	p2p.MsgReadWriter                // writing to the test peer feeds the protocol
@@ -168,8 +269,8 @@ func newProtocolManagerForTesting(txAdded chan<- []*types.Transaction) *Protocol
 		db, _    = ethdb.NewMemDatabase()
 		chain, _ = core.NewChainManager(core.GenesisBlock(0, db), db, db, core.FakePow{}, em)
 		txpool   = &fakeTxPool{added: txAdded}
-		dl       = downloader.New(em, chain.HasBlock, chain.GetBlock)
-		pm       = NewProtocolManager(ProtocolVersion, 0, em, txpool, chain, dl)
+		dl       = downloader.New(downloader.FullSync, db, em, chain.HasBlock, chain.GetBlock, chain.CurrentBlock, chain.VerifyHeaderPoW, nil, nil)
+		pm       = NewProtocolManager(ProtocolVersion, 0, em, txpool, chain, db, db, dl, nil)
 	)
 	pm.Start()
 	return pm
@@ -178,6 +279,10 @@ func newProtocolManagerForTesting(txAdded chan<- []*types.Transaction) *Protocol
 func newTestPeer(pm *ProtocolManager) (*testPeer, <-chan error) {
 	var id discover.NodeID
 	rand.Read(id[:])
+	return newTestPeerWithID(pm, id)
+}
+
+func newTestPeerWithID(pm *ProtocolManager, id discover.NodeID) (*testPeer, <-chan error) {
 	rw1, rw2 := p2p.MsgPipe()
 	peer := pm.newPeer(pm.protVer, pm.netId, p2p.NewPeer(id, "test peer", nil), rw2)
 	errc := make(chan error, 1)
@@ -240,3 +345,347 @@ func newtx(from *crypto.Key, nonce uint64, datasize int) *types.Transaction {
 	tx.SetNonce(nonce)
 	return tx
 }
+
+// Tests that header queries are encoded and decoded with their origin as
+// either a hash or a number.
+func TestGetBlockHeadersDataEncoding(t *testing.T) {
+	tests := []getBlockHeadersData{
+		{Origin: hashOrNumber{Number: 314}, Amount: 3, Skip: 1},
+		{Origin: hashOrNumber{Hash: common.Hash{1, 2, 3}}, Amount: 192, Reverse: true},
+	}
+	for i, want := range tests {
+		enc, err := rlp.EncodeToBytes(&want)
+		if err != nil {
+			t.Fatalf("test %d: failed to encode query: %v", i, err)
+		}
+		var got getBlockHeadersData
+		if err := rlp.DecodeBytes(enc, &got); err != nil {
+			t.Fatalf("test %d: failed to decode query: %v", i, err)
+		}
+		if got != want {
+			t.Errorf("test %d: query mismatch: have %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+// Tests that header queries are answered from the local chain.
+func TestGetBlockHeaders(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	bproc, err := core.NewCanonical(10, db)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	pm := &ProtocolManager{chainman: bproc.ChainManager()}
+	hash := func(n uint64) common.Hash { return pm.chainman.GetBlockByNumber(n).Hash() }
+
+	// Add a side chain forking off at block 3
+	fork := core.MakeChain(bproc, pm.chainman.GetBlockByNumber(3), 3, db, core.CanonicalSeed+1)
+	if _, err := pm.chainman.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	if fork[2].Hash() == hash(6) {
+		t.Fatalf("side chain is canonical")
+	}
+
+	tests := []struct {
+		query getBlockHeadersData
+		want  []uint64
+	}{
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3}, []uint64{2, 3, 4}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3, Skip: 2}, []uint64{2, 5, 8}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 8}, Amount: 5}, []uint64{8, 9, 10}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 5}, Amount: 3, Reverse: true}, []uint64{5, 4, 3}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 3}, Amount: 5, Skip: 1, Reverse: true}, []uint64{3, 1}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(4)}, Amount: 3, Skip: 1}, []uint64{4, 6, 8}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(4)}, Amount: 10, Reverse: true}, []uint64{4, 3, 2, 1, 0}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: common.Hash{1}}, Amount: 3}, nil},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 11}, Amount: 3}, nil},
+		// Reverse hash queries jump along the canonical chain, and along the side chain until it joins
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(10)}, Amount: 3, Skip: 3, Reverse: true}, []uint64{10, 6, 2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: fork[2].Hash()}, Amount: 3, Skip: 1, Reverse: true}, []uint64{6, 4, 2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(4)}, Amount: 3, Skip: 4, Reverse: true}, []uint64{4}},
+		// Skips overflowing the block number end the query
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3, Skip: math.MaxUint64}, []uint64{2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3, Skip: math.MaxUint64 - 1}, []uint64{2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3, Skip: math.MaxUint64, Reverse: true}, []uint64{2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(2)}, Amount: 3, Skip: math.MaxUint64}, []uint64{2}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: hash(2)}, Amount: 3, Skip: math.MaxUint64, Reverse: true}, []uint64{2}},
+	}
+	for i, tt := range tests {
+		query := tt.query
+		headers := pm.getBlockHeaders(&query)
+		if len(headers) != len(tt.want) {
+			t.Errorf("test %d: header count mismatch: have %d, want %d", i, len(headers), len(tt.want))
+			continue
+		}
+		for j, header := range headers {
+			if header.Number.Uint64() != tt.want[j] {
+				t.Errorf("test %d: header %d number mismatch: have %v, want %d", i, j, header.Number, tt.want[j])
+			}
+		}
+	}
+	// Make sure the side chain query was answered from the side chain
+	query := getBlockHeadersData{Origin: hashOrNumber{Hash: fork[2].Hash()}, Amount: 3, Skip: 1, Reverse: true}
+	headers := pm.getBlockHeaders(&query)
+	if len(headers) != 3 || headers[1].Hash() != fork[0].Hash() || headers[2].Hash() != hash(2) {
+		t.Errorf("side chain headers mismatch: have %v", headers)
+	}
+}
+
+// Tests that state trie nodes are served by hash, skipping unknown ones.
+func TestGetNodeData(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer = eth63
+	p, _ := newTestPeer(pm)
+	defer p.close()
+	p.handshake(t)
+
+	root := pm.chainman.Genesis().Root()
+	data, err := pm.stateDb.Get(root.Bytes())
+	if err != nil {
+		t.Fatalf("failed to retrieve genesis state root: %v", err)
+	}
+	if err := p2p.Send(p, GetNodeDataMsg, []common.Hash{root, {1}}); err != nil {
+		t.Fatalf("failed to send node data query: %v", err)
+	}
+	if err := expectMsg(p, NodeDataMsg, [][]byte{data}, time.Second); err != nil {
+		t.Errorf("node data mismatch: %v", err)
+	}
+}
+
+// Tests that eth/63 state retrieval is rejected from eth/62 peers.
+func TestGetNodeDataEth62(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer = eth62
+	p, errc := newTestPeer(pm)
+	defer p.close()
+	p.handshake(t)
+
+	if err := p2p.Send(p, GetNodeDataMsg, []common.Hash{pm.chainman.Genesis().Root()}); err != nil {
+		t.Fatalf("failed to send node data query: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("peer not dropped for eth/63 message")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("eth/63 message accepted from eth/62 peer")
+	}
+}
+
+// Tests that block receipts are served by block hash, skipping unknown ones.
+func TestGetReceipts(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer = eth63
+	p, _ := newTestPeer(pm)
+	defer p.close()
+	p.handshake(t)
+
+	hash := pm.chainman.Genesis().Hash()
+	receipts := types.Receipts{types.NewReceipt([]byte{1}, big.NewInt(21000))}
+	if err := core.PutBlockReceipts(pm.extraDb, hash, receipts); err != nil {
+		t.Fatalf("failed to store receipts: %v", err)
+	}
+	if err := p2p.Send(p, GetReceiptsMsg, []common.Hash{hash, {1}}); err != nil {
+		t.Fatalf("failed to send receipt query: %v", err)
+	}
+	if err := expectMsg(p, ReceiptsMsg, []types.Receipts{receipts}, time.Second); err != nil {
+		t.Errorf("receipts mismatch: %v", err)
+	}
+}
+
+// startForkPeer connects a peer with the given id, announcing the given total
+// difficulty and head in its status.
+func startForkPeer(t *testing.T, pm *ProtocolManager, id discover.NodeID, td *big.Int, head common.Hash) (*testPeer, <-chan error) {
+	p, errc := newTestPeerWithID(pm, id)
+	ourTd, current, genesis := pm.chainman.Status()
+	if err := p2p.ExpectMsg(p, StatusMsg, &statusMsgData{eth63, NetworkId, ourTd, current, genesis}); err != nil {
+		t.Fatalf("status recv: %v", err)
+	}
+	if err := p2p.Send(p, StatusMsg, &statusMsgData{eth63, NetworkId, td, head, genesis}); err != nil {
+		t.Fatalf("status send: %v", err)
+	}
+	return p, errc
+}
+
+// expectForkRequests checks that the given checkpoint headers are requested by
+// number, in any order.
+func expectForkRequests(t *testing.T, p *testPeer, numbers ...uint64) {
+	want := make(map[uint64]bool)
+	for _, number := range numbers {
+		want[number] = true
+	}
+	for range numbers {
+		msg, err := p.ReadMsg()
+		if err != nil {
+			t.Fatalf("checkpoint challenge: %v", err)
+		}
+		var query getBlockHeadersData
+		if msg.Code != GetBlockHeadersMsg || msg.Decode(&query) != nil {
+			t.Fatalf("checkpoint challenge: unexpected message %v", msg)
+		}
+		if !want[query.Origin.Number] || query.Origin.Hash != (common.Hash{}) || query.Amount != 1 {
+			t.Fatalf("checkpoint challenge: unexpected query %+v", query)
+		}
+		delete(want, query.Origin.Number)
+	}
+}
+
+// Tests that new peers are challenged with the fork checkpoints, and dropped if
+// they reply with a different header, can't prove their chain is below a missing
+// checkpoint or don't reply at all.
+func TestForkChallenge(t *testing.T) {
+	defer func(timeout time.Duration) { forkChallengeTimeout = timeout }(forkChallengeTimeout)
+	forkChallengeTimeout = 100 * time.Millisecond
+
+	head := &types.Header{Number: big.NewInt(10)}
+	liar := &types.Header{Number: big.NewInt(30)}
+	checkpoint := &types.Header{Number: big.NewInt(5)}
+	forked := &types.Header{Number: big.NewInt(5), Extra: []byte("fork")}
+
+	tests := []struct {
+		head    *types.Header // Head announced by the peer, proving checkpoint #20 unreached
+		reply   *types.Header // Header replied to the checkpoint #5 challenge, nil for none
+		proof   bool          // Whether the head header is requested
+		passed  bool
+		dropped bool
+	}{
+		{head: head, reply: checkpoint, proof: true, passed: true},
+		{head: head, reply: forked},
+		{head: liar, reply: checkpoint, proof: true},
+		{head: head, reply: nil, dropped: true},
+	}
+	for i, tt := range tests {
+		pm := newProtocolManagerForTesting(nil)
+		pm.protVer = eth63
+		// Checkpoint #20 isn't known locally, the peer's head must prove it's below
+		pm.checkpoints = map[uint64]common.Hash{5: checkpoint.Hash(), 20: common.Hash{1}}
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p, errc := startForkPeer(t, pm, id, pm.chainman.Td(), tt.head.Hash())
+		expectForkRequests(t, p, 5, 20)
+		if tt.reply != nil {
+			p2p.Send(p, BlockHeadersMsg, []*types.Header{tt.reply})
+		}
+		if tt.proof {
+			p2p.Send(p, BlockHeadersMsg, []*types.Header{})
+			if err := p2p.ExpectMsg(p, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: tt.head.Hash()}, Amount: 1}); err != nil {
+				t.Fatalf("test %d: head challenge: %v", i, err)
+			}
+			p2p.Send(p, BlockHeadersMsg, []*types.Header{tt.head})
+		}
+		switch {
+		case tt.passed:
+			time.Sleep(2 * forkChallengeTimeout)
+			if pm.peers.Peer(p.id) == nil {
+				t.Errorf("test %d: peer passing the challenge dropped", i)
+			}
+		case tt.dropped:
+			time.Sleep(2 * forkChallengeTimeout)
+			if pm.peers.Peer(p.id) != nil {
+				t.Errorf("test %d: peer not answering the challenge kept", i)
+			}
+		default:
+			select {
+			case err := <-errc:
+				if want := errResp(ErrForkMismatch, "").Error(); err == nil || !strings.HasPrefix(err.Error(), want) {
+					t.Errorf("test %d: wrong error: have %v, want %q", i, err, want)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("test %d: peer failing the challenge kept", i)
+			}
+			// Reconnecting is refused based on the cached result
+			if err := pm.challengeFork(pm.newPeer(eth63, NetworkId, p.Peer, nil)); err == nil {
+				t.Errorf("test %d: reconnecting peer accepted after failed challenge", i)
+			}
+		}
+		p.close()
+		pm.Stop()
+	}
+}
+
+// Tests that a peer claiming not to have reached a locally known checkpoint is
+// only accepted if its total difficulty is below the checkpoint's.
+func TestForkChallengeTd(t *testing.T) {
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer = eth63
+
+	proc := core.NewBlockProcessor(pm.stateDb, pm.extraDb, core.FakePow{}, pm.chainman, new(event.TypeMux))
+	pm.chainman.SetProcessor(proc)
+	blocks := core.MakeChain(proc, pm.chainman.GetBlockByNumber(0), 5, pm.stateDb, core.CanonicalSeed)
+	if _, err := pm.chainman.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	checkpoint := pm.chainman.GetBlock(blocks[4].Hash())
+	pm.checkpoints = map[uint64]common.Hash{5: checkpoint.Hash()}
+
+	tests := []struct {
+		td     *big.Int
+		passed bool
+	}{
+		{td: new(big.Int).Sub(checkpoint.Td, common.Big1), passed: true},
+		{td: checkpoint.Td},
+	}
+	for i, tt := range tests {
+		var id discover.NodeID
+		rand.Read(id[:])
+		p, errc := startForkPeer(t, pm, id, tt.td, common.Hash{1})
+		expectForkRequests(t, p, 5)
+		p2p.Send(p, BlockHeadersMsg, []*types.Header{})
+
+		select {
+		case err := <-errc:
+			if tt.passed {
+				t.Errorf("test %d: peer below the checkpoint dropped: %v", i, err)
+			} else if want := errResp(ErrForkMismatch, "").Error(); err == nil || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("test %d: wrong error: have %v, want %q", i, err, want)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if !tt.passed {
+				t.Errorf("test %d: peer past the checkpoint kept", i)
+			}
+		}
+		p.close()
+	}
+}
+
+// Tests that the fork challenge timeout of a disconnected peer doesn't drop a
+// new connection of the same node.
+func TestForkChallengeReconnect(t *testing.T) {
+	defer func(timeout time.Duration) { forkChallengeTimeout = timeout }(forkChallengeTimeout)
+	forkChallengeTimeout = 100 * time.Millisecond
+
+	pm := newProtocolManagerForTesting(nil)
+	defer pm.Stop()
+	pm.protVer = eth63
+
+	checkpoint := &types.Header{Number: big.NewInt(5)}
+	pm.checkpoints = map[uint64]common.Hash{5: checkpoint.Hash()}
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	td := pm.chainman.Td()
+
+	// Disconnect without answering the challenge
+	p, errc := startForkPeer(t, pm, id, td, common.Hash{1})
+	expectForkRequests(t, p, 5)
+	p.close()
+	<-errc
+
+	// Reconnect and pass the challenge before the first one times out
+	p, _ = startForkPeer(t, pm, id, td, common.Hash{1})
+	defer p.close()
+	expectForkRequests(t, p, 5)
+	p2p.Send(p, BlockHeadersMsg, []*types.Header{checkpoint})
+
+	time.Sleep(2 * forkChallengeTimeout)
+	if pm.peers.Peer(p.id) != p.peer {
+		t.Errorf("reconnected peer dropped by the timeout of the previous connection")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/logger"
@@ -36,6 +37,20 @@ type blockAnnounce struct {
 	time time.Time
 }
 
+// headerFilterTask is a batch of headers the fetcher may claim as replies to
+// its explicit header requests, returning the rest to the downloader.
+type headerFilterTask struct {
+	peer    *peer
+	headers []*types.Header
+}
+
+// bodyFilterTask is a batch of block bodies the fetcher may claim as replies to
+// its explicit body requests, returning the rest to the downloader.
+type bodyFilterTask struct {
+	peer   *peer
+	bodies []*blockBody
+}
+
 type txsync struct {
 	p   *peer
 	txs []*types.Transaction
@@ -130,6 +145,7 @@ func (pm *ProtocolManager) fetcher() {
 	announces := make(map[common.Hash][]*blockAnnounce)
 	request := make(map[*peer][]common.Hash)
 	pending := make(map[common.Hash]*blockAnnounce)
+	fetched := make(map[common.Hash]*types.Header)
 	cycle := time.Tick(notifyCheckCycle)
 	done := make(chan common.Hash)
 
@@ -151,12 +167,14 @@ func (pm *ProtocolManager) fetcher() {
 		case hash := <-done:
 			// A pending import finished, remove all traces
 			delete(pending, hash)
+			delete(fetched, hash)
 
 		case <-cycle:
 			// Clean up any expired block fetches
 			for hash, announce := range pending {
 				if time.Since(announce.time) > notifyFetchTimeout {
 					delete(pending, hash)
+					delete(fetched, hash)
 				}
 			}
 			// Check if any notified blocks failed to arrive
@@ -173,13 +191,89 @@ func (pm *ProtocolManager) fetcher() {
 			if len(request) == 0 {
 				break
 			}
-			// Send out all block requests
+			// Send out all block requests, or header requests from eth/62 on
 			for peer, hashes := range request {
+				if peer.protv >= eth62 {
+					glog.V(logger.Debug).Infof("Explicitly fetching %d headers from %s", len(hashes), peer.id)
+					for _, hash := range hashes {
+						peer.requestHeadersByHash(hash, 1, 0, false)
+					}
+					continue
+				}
 				glog.V(logger.Debug).Infof("Explicitly fetching %d blocks from %s", len(hashes), peer.id)
 				peer.requestBlocks(hashes)
 			}
 			request = make(map[*peer][]common.Hash)
 
+		case filter := <-pm.newHeaderCh:
+			// Headers arrived, claim the explicitly requested ones and fetch their bodies
+			var task *headerFilterTask
+			select {
+			case task = <-filter:
+			case <-pm.quitSync:
+				return
+			}
+			unknown := []*types.Header{}
+			bodies := []common.Hash{}
+			for _, header := range task.headers {
+				hash := header.Hash()
+				if announce := pending[hash]; announce != nil && announce.peer == task.peer && fetched[hash] == nil {
+					// Discard if already imported by other means
+					if pm.chainman.HasBlock(hash) {
+						delete(pending, hash)
+						continue
+					}
+					fetched[hash] = header
+					bodies = append(bodies, hash)
+				} else {
+					unknown = append(unknown, header)
+				}
+			}
+			select {
+			case filter <- &headerFilterTask{peer: task.peer, headers: unknown}:
+			case <-pm.quitSync:
+				return
+			}
+			if len(bodies) > 0 {
+				glog.V(logger.Debug).Infof("Explicitly fetching %d block bodies from %s", len(bodies), task.peer.id)
+				task.peer.requestBodies(bodies)
+			}
+
+		case filter := <-pm.newBodyCh:
+			// Bodies arrived, match them to the explicitly fetched headers, return all else
+			var task *bodyFilterTask
+			select {
+			case task = <-filter:
+			case <-pm.quitSync:
+				return
+			}
+			explicit, unknown := []*types.Block{}, []*blockBody{}
+			for _, body := range task.bodies {
+				txHash, uncleHash := types.DeriveSha(types.Transactions(body.Transactions)), types.CalcUncleHash(body.Uncles)
+
+				matched := false
+				for hash, header := range fetched {
+					if announce := pending[hash]; announce != nil && announce.peer == task.peer && header.TxHash == txHash && header.UncleHash == uncleHash {
+						block := types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+						block.ReceivedAt = time.Now()
+						explicit = append(explicit, block)
+
+						delete(fetched, hash)
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					unknown = append(unknown, body)
+				}
+			}
+			select {
+			case filter <- &bodyFilterTask{peer: task.peer, bodies: unknown}:
+			case <-pm.quitSync:
+				return
+			}
+			pm.importExplicit(explicit, pending, done)
+
 		case filter := <-pm.newBlockCh:
 			// Blocks arrived, extract any explicit fetches, return all else
 			var blocks types.Blocks
@@ -211,40 +305,7 @@ func (pm *ProtocolManager) fetcher() {
 			case <-pm.quitSync:
 				return
 			}
-			// Create a closure with the retrieved blocks and origin peers
-			peers := make([]*peer, 0, len(explicit))
-			blocks = make([]*types.Block, 0, len(explicit))
-			for _, block := range explicit {
-				hash := block.Hash()
-				if announce := pending[hash]; announce != nil {
-					// Drop the block if it surely cannot fit
-					if pm.chainman.HasBlock(hash) || !pm.chainman.HasBlock(block.ParentHash()) {
-						delete(pending, hash)
-						continue
-					}
-					// Otherwise accumulate for import
-					peers = append(peers, announce.peer)
-					blocks = append(blocks, block)
-				}
-			}
-			// If any explicit fetches were replied to, import them
-			if count := len(blocks); count > 0 {
-				glog.V(logger.Debug).Infof("Importing %d explicitly fetched blocks", len(blocks))
-				go func() {
-					// Make sure all hashes are cleaned up
-					for _, block := range blocks {
-						hash := block.Hash()
-						defer func() { done <- hash }()
-					}
-					// Try and actually import the blocks
-					for i := 0; i < len(blocks); i++ {
-						if err := pm.importBlock(peers[i], blocks[i], nil); err != nil {
-							glog.V(logger.Detail).Infof("Failed to import explicitly fetched block: %v", err)
-							return
-						}
-					}
-				}()
-			}
+			pm.importExplicit(explicit, pending, done)
 
 		case <-pm.quitSync:
 			return
@@ -252,6 +313,90 @@ func (pm *ProtocolManager) fetcher() {
 	}
 }
 
+// importExplicit imports the explicitly fetched blocks of hash announcements
+// in the background, dropping the ones that surely cannot fit. The hashes of
+// the blocks handed over for import are sent on done once they are processed.
+func (pm *ProtocolManager) importExplicit(explicit []*types.Block, pending map[common.Hash]*blockAnnounce, done chan common.Hash) {
+	// Create a closure with the retrieved blocks and origin peers
+	peers := make([]*peer, 0, len(explicit))
+	blocks := make([]*types.Block, 0, len(explicit))
+	for _, block := range explicit {
+		hash := block.Hash()
+		if announce := pending[hash]; announce != nil {
+			// Drop the block if it surely cannot fit
+			if pm.chainman.HasBlock(hash) || !pm.chainman.HasBlock(block.ParentHash()) {
+				delete(pending, hash)
+				continue
+			}
+			// Otherwise accumulate for import
+			peers = append(peers, announce.peer)
+			blocks = append(blocks, block)
+		}
+	}
+	// If any explicit fetches were replied to, import them
+	if count := len(blocks); count > 0 {
+		glog.V(logger.Debug).Infof("Importing %d explicitly fetched blocks", len(blocks))
+		go func() {
+			// Make sure all hashes are cleaned up
+			for _, block := range blocks {
+				hash := block.Hash()
+				defer func() { done <- hash }()
+			}
+			// Try and actually import the blocks
+			for i := 0; i < len(blocks); i++ {
+				if err := pm.importBlock(peers[i], blocks[i], nil); err != nil {
+					glog.V(logger.Detail).Infof("Failed to import explicitly fetched block: %v", err)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// filterHeaders hands a batch of headers to the fetcher, returning the ones it
+// didn't claim as replies to its explicit header requests.
+func (pm *ProtocolManager) filterHeaders(p *peer, headers []*types.Header) []*types.Header {
+	filter := make(chan *headerFilterTask)
+	select {
+	case pm.newHeaderCh <- filter:
+	case <-pm.quitSync:
+		return nil
+	}
+	select {
+	case filter <- &headerFilterTask{peer: p, headers: headers}:
+	case <-pm.quitSync:
+		return nil
+	}
+	select {
+	case task := <-filter:
+		return task.headers
+	case <-pm.quitSync:
+		return nil
+	}
+}
+
+// filterBodies hands a batch of block bodies to the fetcher, returning the ones
+// it didn't claim as replies to its explicit body requests.
+func (pm *ProtocolManager) filterBodies(p *peer, bodies []*blockBody) []*blockBody {
+	filter := make(chan *bodyFilterTask)
+	select {
+	case pm.newBodyCh <- filter:
+	case <-pm.quitSync:
+		return nil
+	}
+	select {
+	case filter <- &bodyFilterTask{peer: p, bodies: bodies}:
+	case <-pm.quitSync:
+		return nil
+	}
+	select {
+	case task := <-filter:
+		return task.bodies
+	case <-pm.quitSync:
+		return nil
+	}
+}
+
 // syncer is responsible for periodically synchronising with the network, both
 // downloading hashes and blocks as well as retrieving cached ones.
 func (pm *ProtocolManager) syncer() {
@@ -312,7 +457,12 @@ func (pm *ProtocolManager) processBlocks() error {
 		index, err := pm.chainman.InsertChain(raw)
 		if err != nil {
 			glog.V(logger.Debug).Infoln("Downloaded block import failed:", err)
-			pm.removePeer(blocks[index].OriginPeer)
+			// Exceeding the local reorg limit is no protocol violation, don't ban for it
+			if core.IsReorgErr(err) && !core.IsCheckpointErr(err) {
+				pm.removePeer(blocks[index].OriginPeer)
+			} else {
+				pm.banPeer(blocks[index].OriginPeer, err)
+			}
 			pm.downloader.Cancel()
 			return err
 		}
@@ -351,10 +501,14 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 	case downloader.ErrBusy:
 		glog.V(logger.Detail).Infof("Synchronisation already in progress")
 
-	case downloader.ErrTimeout, downloader.ErrBadPeer, downloader.ErrEmptyHashSet, downloader.ErrInvalidChain, downloader.ErrCrossCheckFailed:
+	case downloader.ErrTimeout, downloader.ErrBadPeer, downloader.ErrEmptyHashSet:
 		glog.V(logger.Debug).Infof("Removing peer %v: %v", peer.id, err)
 		pm.removePeer(peer.id)
 
+	case downloader.ErrInvalidChain, downloader.ErrCrossCheckFailed, downloader.ErrCheckpointMismatch:
+		// The peer sent us a known bad or inconsistent hash chain.
+		pm.banPeer(peer.id, err)
+
 	case downloader.ErrPendingQueue:
 		glog.V(logger.Debug).Infoln("Synchronisation aborted:", err)
 
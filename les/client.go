@@ -0,0 +1,277 @@
+package les
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// requestTimeout is the time a server has to reply to a request before it's
+// sent to the next one.
+var requestTimeout = 5 * time.Second
+
+var (
+	errNoValidReply = errors.New("no light server delivered a valid reply")
+	errInvalidReply = errors.New("invalid reply")
+	errTimeout      = errors.New("request timed out")
+	errTerminated   = errors.New("terminated")
+)
+
+// pendingReq is a request waiting for the reply of a server.
+type pendingReq struct {
+	peer  string
+	code  uint64 // Message code of the expected reply
+	reply chan rlp.RawValue
+}
+
+// Client runs the les protocol of a light node. It keeps the header chain in
+// sync with the connected servers and retrieves everything else on demand,
+// see OdrDatabase.
+type Client struct {
+	networkId int
+	chainman  *core.ChainManager
+	processor *core.BlockProcessor
+	extraDb   common.Database // Extra database to store retrieved receipts in
+	peers     *peerSet
+
+	reqID   uint64 // Id of the last request, accessed atomically
+	pending map[uint64]*pendingReq
+	lock    sync.Mutex
+
+	syncing int32 // Flag whether a header sync is running, accessed atomically
+	quit    chan struct{}
+}
+
+// NewClient creates a light client importing headers into the given chain.
+func NewClient(networkId int, chainman *core.ChainManager, processor *core.BlockProcessor, extraDb common.Database) *Client {
+	return &Client{
+		networkId: networkId,
+		chainman:  chainman,
+		processor: processor,
+		extraDb:   extraDb,
+		peers:     newPeerSet(),
+		pending:   make(map[uint64]*pendingReq),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Protocol returns the les sub-protocol run by the client.
+func (c *Client) Protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    ProtocolName,
+		Version: ProtocolVersion,
+		Length:  ProtocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return c.handle(newPeer(ProtocolVersion, c.networkId, p, rw))
+		},
+	}
+}
+
+// Stop aborts all pending requests and header synchronisation.
+func (c *Client) Stop() {
+	close(c.quit)
+	glog.V(logger.Info).Infoln("Light client stopped")
+}
+
+// PeerCount returns the number of connected light servers.
+func (c *Client) PeerCount() int {
+	return c.peers.Len()
+}
+
+func (c *Client) handle(p *peer) error {
+	head := c.chainman.CurrentBlock()
+	if err := p.handshake(c.chainman.Td(), head.Hash(), head.NumberU64(), c.chainman.Genesis().Hash(), 0, 0, nil); err != nil {
+		return err
+	}
+	if !p.serving() {
+		p.Disconnect(p2p.DiscUselessPeer)
+		return errResp(ErrNotServing, "%s", p.id)
+	}
+	glog.V(logger.Detail).Infoln("Adding light server", p.id)
+	if err := c.peers.Register(p); err != nil {
+		glog.V(logger.Error).Infoln("Addition failed:", err)
+		return err
+	}
+	defer c.peers.Unregister(p.id)
+
+	if p.Td().Cmp(c.chainman.Td()) > 0 {
+		go c.synchronise(p)
+	}
+	for {
+		if err := c.handleMsg(p); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) handleMsg(p *peer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// make sure that the payload has been fully consumed
+	defer msg.Discard()
+
+	switch msg.Code {
+	case StatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case AnnounceMsg:
+		var announce announceData
+		if err := msg.Decode(&announce); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if announce.TD == nil {
+			return errResp(ErrDecode, "missing total difficulty")
+		}
+		p.SetHead(announce.Hash, announce.Number, announce.TD)
+		if announce.TD.Cmp(c.chainman.Td()) > 0 {
+			go c.synchronise(p)
+		}
+
+	case BlockHeadersMsg, BlockBodiesMsg, ReceiptsMsg, ProofsMsg, CodeMsg:
+		var reply struct {
+			ReqID, BV uint64
+			Data      rlp.RawValue
+		}
+		if err := msg.Decode(&reply); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		p.buffer.lower(reply.BV)
+		c.deliver(p, msg.Code, reply.ReqID, reply.Data)
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// deliver hands a reply to the request waiting for it. Replies nobody waits for
+// any more are dropped.
+func (c *Client) deliver(p *peer, code, reqID uint64, data rlp.RawValue) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	req, ok := c.pending[reqID]
+	if !ok || req.peer != p.id || req.code != code {
+		glog.V(logger.Debug).Infof("[%s] unrequested reply %d (code %d)\n", p.id, reqID, code)
+		return
+	}
+	select {
+	case req.reply <- data:
+	default:
+	}
+}
+
+// request sends a request to the given servers in turn until one of them
+// delivers a reply which passes validation. Servers are only asked if their
+// flow control buffer allows the request within the request timeout.
+func (c *Client) request(peers []*peer, code uint64, items int, data interface{}, validate func(rlp.RawValue) error) error {
+	for _, p := range peers {
+		if wait := p.buffer.wait(p.costs.cost(code, items)); wait > requestTimeout {
+			continue
+		} else if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-c.quit:
+				return errTerminated
+			}
+		}
+		reqID := atomic.AddUint64(&c.reqID, 1)
+		req := &pendingReq{peer: p.id, code: code + 1, reply: make(chan rlp.RawValue, 1)}
+
+		c.lock.Lock()
+		c.pending[reqID] = req
+		c.lock.Unlock()
+
+		err := p.sendRequest(code, reqID, items, data)
+		if err == nil {
+			select {
+			case reply := <-req.reply:
+				err = validate(reply)
+			case <-time.After(requestTimeout):
+				err = errTimeout
+			case <-c.quit:
+				err = errTerminated
+			}
+		}
+		c.lock.Lock()
+		delete(c.pending, reqID)
+		c.lock.Unlock()
+
+		if err == nil {
+			return nil
+		}
+		if err == errTerminated {
+			return err
+		}
+		glog.V(logger.Debug).Infof("[%s] request %d (code %d) failed: %v\n", p.id, reqID, code, err)
+	}
+	return errNoValidReply
+}
+
+// synchronise imports the header chain of a server with a higher total
+// difficulty. Only one synchronisation runs at a time.
+func (c *Client) synchronise(p *peer) {
+	if !atomic.CompareAndSwapInt32(&c.syncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.syncing, 0)
+
+	glog.V(logger.Debug).Infof("[%s] synchronising headers\n", p.id)
+	from := c.chainman.CurrentBlock().NumberU64() + 1
+	for p.Td().Cmp(c.chainman.Td()) > 0 {
+		headers, err := c.fetchHeaders(p, from)
+		if err != nil {
+			glog.V(logger.Debug).Infof("[%s] header sync failed: %v\n", p.id, err)
+			return
+		}
+		if len(headers) == 0 {
+			return
+		}
+		if n, err := c.processor.InsertHeaderChain(headers); err != nil {
+			if core.IsParentErr(err) && n == 0 && from > 1 {
+				// The server is on a fork, step back to the common ancestor
+				if from > MaxHeaderFetch {
+					from -= MaxHeaderFetch
+				} else {
+					from = 1
+				}
+				continue
+			}
+			glog.V(logger.Debug).Infof("[%s] header #%v import failed: %v\n", p.id, headers[n].Number, err)
+			return
+		}
+		from = headers[len(headers)-1].Number.Uint64() + 1
+	}
+}
+
+// fetchHeaders retrieves a batch of consecutive canonical headers from a server.
+func (c *Client) fetchHeaders(p *peer, from uint64) ([]*types.Header, error) {
+	var headers []*types.Header
+	query := &getBlockHeadersData{Origin: from, Amount: MaxHeaderFetch}
+	err := c.request([]*peer{p}, GetBlockHeadersMsg, MaxHeaderFetch, query, func(data rlp.RawValue) error {
+		if err := rlp.DecodeBytes(data, &headers); err != nil {
+			return err
+		}
+		for i, header := range headers {
+			if header.Number == nil || header.Number.Uint64() != from+uint64(i) {
+				return errInvalidReply
+			}
+		}
+		return nil
+	})
+	return headers, err
+}
@@ -0,0 +1,115 @@
+package les
+
+import (
+	"sync"
+	"time"
+)
+
+// Default flow control parameters of servers. Costs are in abstract units,
+// a client can spend up to defaultBufLimit of them at once and regains
+// defaultMinRecharge every second.
+const (
+	defaultBufLimit    = 300000
+	defaultMinRecharge = 50000
+)
+
+// requestCost is the cost of a request type: a base cost plus a cost per
+// requested item.
+type requestCost struct {
+	MsgCode  uint64
+	BaseCost uint64
+	ReqCost  uint64
+}
+
+// costTable lists the cost of every request type. It's announced by servers
+// in the handshake, so clients can track their buffer.
+type costTable []requestCost
+
+var defaultCostTable = costTable{
+	{GetBlockHeadersMsg, 1000, 100},
+	{GetBlockBodiesMsg, 1000, 2000},
+	{GetReceiptsMsg, 1000, 2000},
+	{GetProofsMsg, 1000, 2500},
+	{GetCodeMsg, 1000, 2000},
+}
+
+// cost returns the cost of a request of the given type for the given number
+// of items. Unknown request types cost the whole buffer.
+func (t costTable) cost(code uint64, items int) uint64 {
+	for _, c := range t {
+		if c.MsgCode == code {
+			return c.BaseCost + uint64(items)*c.ReqCost
+		}
+	}
+	return defaultBufLimit
+}
+
+// flowBuffer is a buffer of request costs which recharges at a fixed rate up
+// to its limit. Servers keep one per client, charging every request against
+// it and rejecting requests exceeding it. Clients keep one per server,
+// estimating their buffer at the server so they don't send such requests.
+type flowBuffer struct {
+	limit    uint64 // Maximum value of the buffer
+	recharge uint64 // Value regained per second
+	value    uint64 // Value at the last update
+	updated  time.Time
+	lock     sync.Mutex
+}
+
+func newFlowBuffer(limit, recharge uint64) *flowBuffer {
+	return &flowBuffer{limit: limit, recharge: recharge, value: limit, updated: time.Now()}
+}
+
+// update recharges the buffer for the time passed since the last update.
+func (b *flowBuffer) update() {
+	now := time.Now()
+	if b.value < b.limit {
+		b.value += uint64(now.Sub(b.updated).Seconds() * float64(b.recharge))
+		if b.value > b.limit {
+			b.value = b.limit
+		}
+	}
+	b.updated = now
+}
+
+// charge deducts cost from the buffer if it's large enough. It returns the
+// remaining buffer value and whether the cost was accepted.
+func (b *flowBuffer) charge(cost uint64) (uint64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.update()
+	if cost > b.value {
+		return b.value, false
+	}
+	b.value -= cost
+	return b.value, true
+}
+
+// wait returns how long it takes until the buffer can pay cost.
+func (b *flowBuffer) wait(cost uint64) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.update()
+	if cost <= b.value {
+		return 0
+	}
+	if cost > b.limit || b.recharge == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(float64(cost-b.value) / float64(b.recharge) * float64(time.Second))
+}
+
+// lower reduces the buffer value to the one reported by the server if the
+// estimate is higher. Requests still in flight are already deducted from the
+// estimate, so a lower estimate is kept.
+func (b *flowBuffer) lower(value uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.update()
+	if value < b.value {
+		b.value = value
+	}
+}
@@ -0,0 +1,226 @@
+package les
+
+import (
+	"crypto/rand"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// newTestServer creates a server on top of a canonical chain of n blocks.
+func newTestServer(t *testing.T, n int) (*Server, common.Database) {
+	var (
+		mux      = new(event.TypeMux)
+		db, _    = ethdb.NewMemDatabase()
+		genesis  = core.GenesisBlock(0, db)
+		chain, _ = core.NewChainManager(genesis, db, db, core.FakePow{}, mux)
+		proc     = core.NewBlockProcessor(db, db, core.FakePow{}, chain, mux)
+	)
+	chain.SetProcessor(proc)
+	if _, err := chain.InsertChain(core.MakeChain(proc, genesis, n, db, core.CanonicalSeed)); err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	return NewServer(0, mux, chain, db, db), db
+}
+
+// newTestClient creates a light client sharing the genesis block of the servers.
+func newTestClient() (*Client, *core.ChainManager, *OdrDatabase) {
+	var (
+		mux      = new(event.TypeMux)
+		db, _    = ethdb.NewMemDatabase()
+		odrDb    = NewOdrDatabase(db)
+		chain, _ = core.NewChainManager(core.GenesisBlock(0, odrDb), db, odrDb, core.FakePow{}, mux)
+		proc     = core.NewBlockProcessor(odrDb, db, core.FakePow{}, chain, mux)
+	)
+	chain.SetProcessor(proc)
+	client := NewClient(0, chain, proc, db)
+	odrDb.SetClient(client)
+	return client, chain, odrDb
+}
+
+func newTestPeer(rw p2p.MsgReadWriter) *peer {
+	var id discover.NodeID
+	rand.Read(id[:])
+	return newPeer(ProtocolVersion, 0, p2p.NewPeer(id, "test peer", nil), rw)
+}
+
+// testHandshake runs the handshake of a client with the server on the other
+// end of the pipe.
+func testHandshake(t *testing.T, s *Server, rw p2p.MsgReadWriter) {
+	head := s.chainman.CurrentBlock()
+	status := &statusData{
+		ProtocolVersion: ProtocolVersion,
+		TD:              s.chainman.Td(),
+		Head:            head.Hash(),
+		HeadNum:         head.NumberU64(),
+		Genesis:         s.chainman.Genesis().Hash(),
+		BufLimit:        s.bufLimit,
+		MinRecharge:     s.minRecharge,
+		CostTable:       s.costs,
+	}
+	if err := p2p.ExpectMsg(rw, StatusMsg, status); err != nil {
+		t.Fatalf("status recv: %v", err)
+	}
+	status.BufLimit, status.MinRecharge, status.CostTable = 0, 0, nil
+	if err := p2p.Send(rw, StatusMsg, status); err != nil {
+		t.Fatalf("status send: %v", err)
+	}
+}
+
+func TestServerReplies(t *testing.T) {
+	s, db := newTestServer(t, 10)
+	rw1, rw2 := p2p.MsgPipe()
+	defer rw1.Close()
+	go s.handle(newTestPeer(rw2))
+	testHandshake(t, s, rw1)
+
+	// Headers are served from the canonical chain
+	p2p.Send(rw1, GetBlockHeadersMsg, []interface{}{uint64(1), &getBlockHeadersData{Origin: 2, Amount: 3, Skip: 1}})
+	var headers struct {
+		ReqID, BV uint64
+		Headers   []*types.Header
+	}
+	if err := readReply(rw1, BlockHeadersMsg, &headers); err != nil {
+		t.Fatal(err)
+	}
+	if headers.ReqID != 1 || len(headers.Headers) != 3 {
+		t.Fatalf("header reply mismatch: id %d, %d headers", headers.ReqID, len(headers.Headers))
+	}
+	for i, header := range headers.Headers {
+		if want := s.chainman.GetBlockByNumber(uint64(2 + 2*i)).Hash(); header.Hash() != want {
+			t.Errorf("header %d mismatch: have %x, want %x", i, header.Hash(), want)
+		}
+	}
+	if cost := s.costs.cost(GetBlockHeadersMsg, 3); headers.BV > s.bufLimit-cost {
+		t.Errorf("request not charged: buffer value %d, cost %d", headers.BV, cost)
+	}
+
+	// Account proofs verify against the state root
+	var addr common.Address
+	addr[0] = byte(core.CanonicalSeed)
+	root := s.chainman.CurrentBlock().Root()
+	p2p.Send(rw1, GetProofsMsg, []interface{}{uint64(2), []proofReq{{Root: root, Key: crypto.Sha3(addr[:])}}})
+	var proofs struct {
+		ReqID, BV uint64
+		Proofs    [][][]byte
+	}
+	if err := readReply(rw1, ProofsMsg, &proofs); err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs.Proofs) != 1 {
+		t.Fatalf("proof count mismatch: have %d, want 1", len(proofs.Proofs))
+	}
+	val, err := trie.VerifyProof(root, crypto.Sha3(addr[:]), proofs.Proofs[0])
+	if err != nil {
+		t.Fatalf("invalid proof: %v", err)
+	}
+	if want := trie.NewSecure(root[:], db).Get(addr[:]); len(val) == 0 || string(val) != string(want) {
+		t.Errorf("proven value mismatch: have %x, want %x", val, want)
+	}
+}
+
+// Tests that header queries with skips overflowing the block number stop at
+// the origin instead of repeating it or wrapping around.
+func TestServerHeaderSkipOverflow(t *testing.T) {
+	s, _ := newTestServer(t, 10)
+
+	tests := []getBlockHeadersData{
+		{Origin: 2, Amount: 3, Skip: math.MaxUint64},
+		{Origin: 2, Amount: 3, Skip: math.MaxUint64, Reverse: true},
+		{Origin: 2, Amount: 3, Skip: math.MaxUint64 - 1},
+		{Origin: 2, Amount: 3, Skip: math.MaxUint64 - 2},
+	}
+	for i, query := range tests {
+		query := query
+		headers := s.getBlockHeaders(&query)
+		if len(headers) != 1 || headers[0].Number.Uint64() != 2 {
+			t.Errorf("test %d: headers mismatch: have %v, want only #2", i, headers)
+		}
+	}
+}
+
+func TestServerFlowControl(t *testing.T) {
+	s, _ := newTestServer(t, 1)
+	rw1, rw2 := p2p.MsgPipe()
+	defer rw1.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- s.handle(newTestPeer(rw2)) }()
+	testHandshake(t, s, rw1)
+
+	// Send maximal proof requests until the buffer runs dry
+	reqs := make([]proofReq, MaxProofsFetch)
+	cost := s.costs.cost(GetProofsMsg, MaxProofsFetch)
+	for i := uint64(0); i < s.bufLimit/cost; i++ {
+		p2p.Send(rw1, GetProofsMsg, []interface{}{i, reqs})
+		if err := readReply(rw1, ProofsMsg, new(rlp.RawValue)); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	go p2p.Send(rw1, GetProofsMsg, []interface{}{uint64(100), reqs})
+	select {
+	case err := <-errc:
+		if want := errResp(ErrRequestRejected, "").Error(); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("wrong error: have %v, want %q", err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("client exceeding its buffer was not disconnected")
+	}
+}
+
+func TestClientSyncAndOdr(t *testing.T) {
+	s, db := newTestServer(t, 20)
+	client, chain, odrDb := newTestClient()
+	defer client.Stop()
+
+	rw1, rw2 := p2p.MsgPipe()
+	defer rw1.Close()
+	go s.handle(newTestPeer(rw1))
+	go client.handle(newTestPeer(rw2))
+
+	// The client imports the header chain of the server
+	want := s.chainman.CurrentBlock()
+	for start := time.Now(); chain.CurrentBlock().Hash() != want.Hash(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("header sync timed out at #%d, want #%d", chain.CurrentBlock().Number(), want.Number())
+		}
+	}
+	// State is retrieved on demand
+	for i := 0; i < 20; i++ {
+		var addr common.Address
+		addr[0], addr[19] = byte(core.CanonicalSeed), byte(i)
+
+		have := state.New(want.Root(), odrDb).GetBalance(addr)
+		if exp := state.New(want.Root(), db).GetBalance(addr); have.Cmp(exp) != 0 {
+			t.Errorf("balance mismatch for %x: have %v, want %v", addr, have, exp)
+		}
+	}
+	// Receipts are retrieved and verified against the header
+	if _, err := client.RetrieveReceipts(chain.GetBlockByNumber(5)); err != nil {
+		t.Errorf("failed to retrieve receipts: %v", err)
+	}
+}
+
+func readReply(rw p2p.MsgReader, code uint64, reply interface{}) error {
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+	if msg.Code != code {
+		return errResp(ErrInvalidMsgCode, "have %d, want %d", msg.Code, code)
+	}
+	return msg.Decode(reply)
+}
@@ -0,0 +1,169 @@
+package les
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// OdrDatabase is the state database of a light client. Entries missing from the
+// local database are retrieved on demand (ODR) from the light servers: trie
+// nodes as Merkle proofs of the accessed keys, everything else, like contract
+// code, by its hash. All retrieved data is verified and stored locally.
+type OdrDatabase struct {
+	common.Database
+	client *Client
+}
+
+// NewOdrDatabase wraps a local database. Retrieval is disabled until a client
+// is set.
+func NewOdrDatabase(db common.Database) *OdrDatabase {
+	return &OdrDatabase{Database: db}
+}
+
+// SetClient sets the client to retrieve missing entries with.
+func (db *OdrDatabase) SetClient(client *Client) {
+	db.client = client
+}
+
+// Get returns the local value of key. Missing entries keyed by a hash are
+// retrieved from the network.
+func (db *OdrDatabase) Get(key []byte) ([]byte, error) {
+	value, err := db.Database.Get(key)
+	if len(value) > 0 || db.client == nil || len(key) != len(common.Hash{}) {
+		return value, err
+	}
+	if value, err = db.client.RetrieveCode(common.BytesToHash(key)); err != nil {
+		return nil, err
+	}
+	db.Database.Put(key, value)
+	return value, nil
+}
+
+// RetrieveProof makes the nodes on the path of key in the trie with the given
+// root available locally, see trie.OdrBackend.
+func (db *OdrDatabase) RetrieveProof(root, key []byte) error {
+	if blob, _ := db.Database.Get(root); len(blob) > 0 {
+		if _, err := trie.New(root, db.Database).Prove(key); err == nil {
+			return nil
+		}
+	}
+	if db.client == nil {
+		return fmt.Errorf("missing trie node %x", root)
+	}
+	proof, err := db.client.RetrieveProof(common.BytesToHash(root), key)
+	if err != nil {
+		return err
+	}
+	for _, node := range proof {
+		db.Database.Put(crypto.Sha3(node), node)
+	}
+	return nil
+}
+
+// Hashes of empty block bodies, which don't need to be retrieved.
+var (
+	emptyTxHash    = types.DeriveSha(types.Transactions{})
+	emptyUncleHash = types.CalcUncleHash(nil)
+)
+
+func (c *Client) servers() []*peer {
+	return c.peers.AllPeers()
+}
+
+// RetrieveProof retrieves the Merkle proof of key in the trie with the given
+// root, verified against the root.
+func (c *Client) RetrieveProof(root common.Hash, key []byte) ([][]byte, error) {
+	var proof [][]byte
+	req := []proofReq{{Root: root, Key: key}}
+	err := c.request(c.servers(), GetProofsMsg, 1, req, func(data rlp.RawValue) error {
+		var proofs [][][]byte
+		if err := rlp.DecodeBytes(data, &proofs); err != nil {
+			return err
+		}
+		if len(proofs) != 1 {
+			return errInvalidReply
+		}
+		if _, err := trie.VerifyProof(root, key, proofs[0]); err != nil {
+			return err
+		}
+		proof = proofs[0]
+		return nil
+	})
+	return proof, err
+}
+
+// RetrieveCode retrieves a database entry, usually contract code, by its hash.
+func (c *Client) RetrieveCode(hash common.Hash) ([]byte, error) {
+	var code []byte
+	err := c.request(c.servers(), GetCodeMsg, 1, []common.Hash{hash}, func(data rlp.RawValue) error {
+		var entries [][]byte
+		if err := rlp.DecodeBytes(data, &entries); err != nil {
+			return err
+		}
+		if len(entries) != 1 || crypto.Sha3Hash(entries[0]) != hash {
+			return errInvalidReply
+		}
+		code = entries[0]
+		return nil
+	})
+	return code, err
+}
+
+// RetrieveBlock retrieves the body of a header-only block, verified against the
+// transaction and uncle hashes of the header. Blocks with empty bodies are
+// returned without retrieval.
+func (c *Client) RetrieveBlock(block *types.Block) (*types.Block, error) {
+	header := block.Header()
+	if header.TxHash == emptyTxHash && header.UncleHash == emptyUncleHash {
+		return block, nil
+	}
+	var full *types.Block
+	err := c.request(c.servers(), GetBlockBodiesMsg, 1, []common.Hash{block.Hash()}, func(data rlp.RawValue) error {
+		var bodies []*blockBody
+		if err := rlp.DecodeBytes(data, &bodies); err != nil {
+			return err
+		}
+		if len(bodies) != 1 {
+			return errInvalidReply
+		}
+		body := bodies[0]
+		if types.DeriveSha(types.Transactions(body.Transactions)) != header.TxHash || types.CalcUncleHash(body.Uncles) != header.UncleHash {
+			return errInvalidReply
+		}
+		full = types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+		full.Td = block.Td
+		return nil
+	})
+	return full, err
+}
+
+// RetrieveReceipts retrieves the receipts of a block, verified against the
+// receipt hash of its header, and stores them in the extra database.
+func (c *Client) RetrieveReceipts(block *types.Block) (types.Receipts, error) {
+	if receipts, err := core.GetBlockReceipts(c.extraDb, block.Hash()); err == nil {
+		return receipts, nil
+	}
+	var receipts types.Receipts
+	err := c.request(c.servers(), GetReceiptsMsg, 1, []common.Hash{block.Hash()}, func(data rlp.RawValue) error {
+		var lists []types.Receipts
+		if err := rlp.DecodeBytes(data, &lists); err != nil {
+			return err
+		}
+		if len(lists) != 1 || types.DeriveSha(lists[0]) != block.Header().ReceiptHash {
+			return errInvalidReply
+		}
+		receipts = lists[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	core.PutBlockReceipts(c.extraDb, block.Hash(), receipts)
+	return receipts, nil
+}
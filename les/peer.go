@@ -0,0 +1,236 @@
+package les
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+var (
+	errAlreadyRegistered = errors.New("peer is already registered")
+	errNotRegistered     = errors.New("peer is not registered")
+)
+
+type peer struct {
+	*p2p.Peer
+
+	rw p2p.MsgReadWriter
+
+	version, network int
+
+	id string
+
+	head    common.Hash
+	headNum uint64
+	td      *big.Int
+	lock    sync.RWMutex
+
+	// Flow control of requests to the peer, nil unless the peer serves.
+	// The buffer is our estimate of the one kept by the peer for us.
+	buffer *flowBuffer
+	costs  costTable
+}
+
+func newPeer(version, network int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	id := p.ID()
+
+	return &peer{
+		Peer:    p,
+		rw:      rw,
+		version: version,
+		network: network,
+		id:      fmt.Sprintf("%x", id[:8]),
+	}
+}
+
+// Head retrieves the current head hash and number of the peer.
+func (p *peer) Head() (common.Hash, uint64) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.head, p.headNum
+}
+
+// Td retrieves the current total difficulty of the peer.
+func (p *peer) Td() *big.Int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return new(big.Int).Set(p.td)
+}
+
+// SetHead updates the head of the peer.
+func (p *peer) SetHead(hash common.Hash, number uint64, td *big.Int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.head, p.headNum, p.td = hash, number, new(big.Int).Set(td)
+}
+
+// serving reports whether the peer is a light server.
+func (p *peer) serving() bool {
+	return p.buffer != nil
+}
+
+// sendAnnounce notifies the peer of a new chain head.
+func (p *peer) sendAnnounce(hash common.Hash, number uint64, td *big.Int) error {
+	return p2p.Send(p.rw, AnnounceMsg, &announceData{Hash: hash, Number: number, TD: td})
+}
+
+// sendReply sends the reply to a request, along with the remaining value of
+// the flow control buffer of the peer.
+func (p *peer) sendReply(code uint64, reqID, bv uint64, data interface{}) error {
+	return p2p.Send(p.rw, code, []interface{}{reqID, bv, data})
+}
+
+// sendRequest sends a request to the peer, charging its cost against our
+// estimate of the peer's flow control buffer.
+func (p *peer) sendRequest(code uint64, reqID uint64, items int, data interface{}) error {
+	glog.V(logger.Debug).Infof("[%s] sending request %d (code %d, %d items)\n", p.id, reqID, code, items)
+	p.buffer.charge(p.costs.cost(code, items))
+	return p2p.Send(p.rw, code, []interface{}{reqID, data})
+}
+
+// handshake exchanges status messages with the peer. Servers pass their flow
+// control parameters, clients leave them zero.
+func (p *peer) handshake(td *big.Int, head common.Hash, headNum uint64, genesis common.Hash, bufLimit, minRecharge uint64, costs costTable) error {
+	status := &statusData{
+		ProtocolVersion: uint32(p.version),
+		NetworkId:       uint32(p.network),
+		TD:              td,
+		Head:            head,
+		HeadNum:         headNum,
+		Genesis:         genesis,
+		BufLimit:        bufLimit,
+		MinRecharge:     minRecharge,
+		CostTable:       costs,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p2p.Send(p.rw, StatusMsg, status)
+	}()
+
+	// read and handle remote status
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	var remote statusData
+	if err := msg.Decode(&remote); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if remote.Genesis != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", remote.Genesis, genesis)
+	}
+	if int(remote.NetworkId) != p.network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", remote.NetworkId, p.network)
+	}
+	if int(remote.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", remote.ProtocolVersion, p.version)
+	}
+	if remote.TD == nil {
+		return errResp(ErrDecode, "missing total difficulty")
+	}
+	p.head, p.headNum, p.td = remote.Head, remote.HeadNum, remote.TD
+	if remote.BufLimit > 0 {
+		p.buffer = newFlowBuffer(remote.BufLimit, remote.MinRecharge)
+		p.costs = remote.CostTable
+	}
+	return <-errc
+}
+
+// peerSet represents the collection of active peers participating in the les
+// sub-protocol.
+type peerSet struct {
+	peers map[string]*peer
+	lock  sync.RWMutex
+}
+
+// newPeerSet creates a new peer set to track the active participants.
+func newPeerSet() *peerSet {
+	return &peerSet{
+		peers: make(map[string]*peer),
+	}
+}
+
+// Register injects a new peer into the working set, or returns an error if the
+// peer is already known.
+func (ps *peerSet) Register(p *peer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[p.id]; ok {
+		return errAlreadyRegistered
+	}
+	ps.peers[p.id] = p
+	return nil
+}
+
+// Unregister removes a remote peer from the active set.
+func (ps *peerSet) Unregister(id string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[id]; !ok {
+		return errNotRegistered
+	}
+	delete(ps.peers, id)
+	return nil
+}
+
+// Peer retrieves the registered peer with the given id.
+func (ps *peerSet) Peer(id string) *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.peers[id]
+}
+
+// Len returns the current number of peers in the set.
+func (ps *peerSet) Len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.peers)
+}
+
+// AllPeers retrieves a list of all registered peers.
+func (ps *peerSet) AllPeers() []*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	return list
+}
+
+// BestPeer retrieves the known peer with the currently highest total difficulty.
+func (ps *peerSet) BestPeer() *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		bestPeer *peer
+		bestTd   *big.Int
+	)
+	for _, p := range ps.peers {
+		if td := p.Td(); bestPeer == nil || td.Cmp(bestTd) > 0 {
+			bestPeer, bestTd = p, td
+		}
+	}
+	return bestPeer
+}
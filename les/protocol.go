@@ -0,0 +1,133 @@
+// Package les implements the Light Ethereum Subprotocol.
+//
+// Light clients don't process blocks. They synchronise block headers only and
+// retrieve block bodies, receipts and state from light servers on demand,
+// verifying every reply against the header chain. Servers charge each request
+// against a per-client buffer which recharges over time, so a single client
+// can't monopolise a server.
+package les
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Supported versions of the les protocol.
+const (
+	lpv1 = 1 // initial version
+)
+
+const (
+	ProtocolName       = "les"
+	ProtocolVersion    = lpv1
+	ProtocolLength     = 12
+	ProtocolMaxMsgSize = 10 * 1024 * 1024
+)
+
+// les protocol message codes
+const (
+	StatusMsg = iota
+	AnnounceMsg
+	GetBlockHeadersMsg
+	BlockHeadersMsg
+	GetBlockBodiesMsg
+	BlockBodiesMsg
+	GetReceiptsMsg
+	ReceiptsMsg
+	GetProofsMsg
+	ProofsMsg
+	GetCodeMsg
+	CodeMsg
+)
+
+// Limits of the number of items retrieved by a single request.
+const (
+	MaxHeaderFetch  = 192
+	MaxBodyFetch    = 32
+	MaxReceiptFetch = 128
+	MaxProofsFetch  = 64
+	MaxCodeFetch    = 64
+)
+
+type errCode int
+
+const (
+	ErrMsgTooLarge = iota
+	ErrDecode
+	ErrInvalidMsgCode
+	ErrProtocolVersionMismatch
+	ErrNetworkIdMismatch
+	ErrGenesisBlockMismatch
+	ErrNoStatusMsg
+	ErrExtraStatusMsg
+	ErrRequestRejected
+	ErrNotServing
+)
+
+func (e errCode) String() string {
+	return errorToString[int(e)]
+}
+
+var errorToString = map[int]string{
+	ErrMsgTooLarge:             "Message too long",
+	ErrDecode:                  "Invalid message",
+	ErrInvalidMsgCode:          "Invalid message code",
+	ErrProtocolVersionMismatch: "Protocol version mismatch",
+	ErrNetworkIdMismatch:       "NetworkId mismatch",
+	ErrGenesisBlockMismatch:    "Genesis block mismatch",
+	ErrNoStatusMsg:             "No status message",
+	ErrExtraStatusMsg:          "Extra status message",
+	ErrRequestRejected:         "Request exceeds flow control buffer",
+	ErrNotServing:              "Peer is not a light server",
+}
+
+func errResp(code errCode, format string, v ...interface{}) error {
+	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+}
+
+// statusData is the handshake message. The flow control parameters are only
+// sent by servers, their presence marks a peer as serving.
+type statusData struct {
+	ProtocolVersion uint32
+	NetworkId       uint32
+	TD              *big.Int
+	Head            common.Hash
+	HeadNum         uint64
+	Genesis         common.Hash
+
+	BufLimit    uint64    `rlp:"optional"` // Buffer capacity of each client
+	MinRecharge uint64    `rlp:"optional"` // Buffer recharge per second
+	CostTable   costTable `rlp:"optional"` // Cost of each request type
+}
+
+// announceData is sent by servers whenever their chain head changes.
+type announceData struct {
+	Hash   common.Hash
+	Number uint64
+	TD     *big.Int
+}
+
+// getBlockHeadersData represents a header query on the canonical chain.
+type getBlockHeadersData struct {
+	Origin  uint64 // Number of the first block to retrieve the header of
+	Amount  uint64 // Maximum number of headers to retrieve
+	Skip    uint64 // Blocks to skip between consecutive headers
+	Reverse bool   // Query direction (false = rising towards latest, true = falling towards genesis)
+}
+
+// proofReq requests the Merkle proof of a key in the trie with the given root.
+// Account proofs are requested from state roots, storage proofs from the
+// storage roots of accounts. Keys are hashed, as in the state tries.
+type proofReq struct {
+	Root common.Hash
+	Key  []byte
+}
+
+// blockBody represents the data content of a single block.
+type blockBody struct {
+	Transactions []*types.Transaction
+	Uncles       []*types.Header
+}
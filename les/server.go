@@ -0,0 +1,279 @@
+package les
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Server answers the requests of light clients from the local chain, charging
+// every request against the flow control buffer of the requesting client.
+type Server struct {
+	networkId int
+	chainman  *core.ChainManager
+	stateDb   common.Database // State database to serve proofs and code from
+	extraDb   common.Database // Extra database to serve receipts from
+	peers     *peerSet
+
+	bufLimit, minRecharge uint64
+	costs                 costTable
+
+	eventMux *event.TypeMux
+	headSub  event.Subscription
+}
+
+// NewServer creates a light server on top of a fully synchronising node.
+func NewServer(networkId int, mux *event.TypeMux, chainman *core.ChainManager, stateDb, extraDb common.Database) *Server {
+	return &Server{
+		networkId:   networkId,
+		chainman:    chainman,
+		stateDb:     stateDb,
+		extraDb:     extraDb,
+		peers:       newPeerSet(),
+		bufLimit:    defaultBufLimit,
+		minRecharge: defaultMinRecharge,
+		costs:       defaultCostTable,
+		eventMux:    mux,
+	}
+}
+
+// Protocol returns the les sub-protocol run by the server.
+func (s *Server) Protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    ProtocolName,
+		Version: ProtocolVersion,
+		Length:  ProtocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return s.handle(newPeer(ProtocolVersion, s.networkId, p, rw))
+		},
+	}
+}
+
+// Start starts announcing new chain heads to the connected clients.
+func (s *Server) Start() {
+	s.headSub = s.eventMux.Subscribe(core.ChainHeadEvent{})
+	go s.announceLoop()
+}
+
+// Stop stops the head announcements.
+func (s *Server) Stop() {
+	s.headSub.Unsubscribe()
+	glog.V(logger.Info).Infoln("Light server stopped")
+}
+
+func (s *Server) announceLoop() {
+	for obj := range s.headSub.Chan() {
+		block := obj.(core.ChainHeadEvent).Block
+		td := block.Td
+		if td == nil {
+			td = s.chainman.Td()
+		}
+		for _, p := range s.peers.AllPeers() {
+			p.sendAnnounce(block.Hash(), block.NumberU64(), td)
+		}
+	}
+}
+
+func (s *Server) handle(p *peer) error {
+	head := s.chainman.CurrentBlock()
+	td := s.chainman.Td()
+	if err := p.handshake(td, head.Hash(), head.NumberU64(), s.chainman.Genesis().Hash(), s.bufLimit, s.minRecharge, s.costs); err != nil {
+		return err
+	}
+	glog.V(logger.Detail).Infoln("Adding light client", p.id)
+	if err := s.peers.Register(p); err != nil {
+		glog.V(logger.Error).Infoln("Addition failed:", err)
+		return err
+	}
+	defer s.peers.Unregister(p.id)
+
+	// Every client gets a fresh buffer, charged for each of its requests
+	buffer := newFlowBuffer(s.bufLimit, s.minRecharge)
+	for {
+		if err := s.handleMsg(p, buffer); err != nil {
+			return err
+		}
+	}
+}
+
+// charge deducts the cost of a request from the client's buffer, returning the
+// remaining buffer value. Clients exceeding their buffer are disconnected.
+func (s *Server) charge(p *peer, buffer *flowBuffer, code uint64, items int) (uint64, error) {
+	bv, ok := buffer.charge(s.costs.cost(code, items))
+	if !ok {
+		return 0, errResp(ErrRequestRejected, "request code %d for %d items, buffer %d", code, items, bv)
+	}
+	return bv, nil
+}
+
+func (s *Server) handleMsg(p *peer, buffer *flowBuffer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	// make sure that the payload has been fully consumed
+	defer msg.Discard()
+
+	switch msg.Code {
+	case StatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case AnnounceMsg:
+		var announce announceData
+		if err := msg.Decode(&announce); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if announce.TD == nil {
+			return errResp(ErrDecode, "missing total difficulty")
+		}
+		p.SetHead(announce.Hash, announce.Number, announce.TD)
+
+	case GetBlockHeadersMsg:
+		var req struct {
+			ReqID uint64
+			Query getBlockHeadersData
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if req.Query.Amount > MaxHeaderFetch {
+			req.Query.Amount = MaxHeaderFetch
+		}
+		bv, err := s.charge(p, buffer, msg.Code, int(req.Query.Amount))
+		if err != nil {
+			return err
+		}
+		return p.sendReply(BlockHeadersMsg, req.ReqID, bv, s.getBlockHeaders(&req.Query))
+
+	case GetBlockBodiesMsg:
+		var req struct {
+			ReqID  uint64
+			Hashes []common.Hash
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxBodyFetch {
+			req.Hashes = req.Hashes[:MaxBodyFetch]
+		}
+		bv, err := s.charge(p, buffer, msg.Code, len(req.Hashes))
+		if err != nil {
+			return err
+		}
+		// Unknown blocks are answered with empty bodies, keeping the positions
+		bodies := make([]*blockBody, len(req.Hashes))
+		for i, hash := range req.Hashes {
+			bodies[i] = new(blockBody)
+			if block := s.chainman.GetBlock(hash); block != nil {
+				bodies[i].Transactions, bodies[i].Uncles = block.Transactions(), block.Uncles()
+			}
+		}
+		return p.sendReply(BlockBodiesMsg, req.ReqID, bv, bodies)
+
+	case GetReceiptsMsg:
+		var req struct {
+			ReqID  uint64
+			Hashes []common.Hash
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxReceiptFetch {
+			req.Hashes = req.Hashes[:MaxReceiptFetch]
+		}
+		bv, err := s.charge(p, buffer, msg.Code, len(req.Hashes))
+		if err != nil {
+			return err
+		}
+		receipts := make([]types.Receipts, len(req.Hashes))
+		for i, hash := range req.Hashes {
+			receipts[i], _ = core.GetBlockReceipts(s.extraDb, hash)
+		}
+		return p.sendReply(ReceiptsMsg, req.ReqID, bv, receipts)
+
+	case GetProofsMsg:
+		var req struct {
+			ReqID  uint64
+			Proofs []proofReq
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if len(req.Proofs) > MaxProofsFetch {
+			req.Proofs = req.Proofs[:MaxProofsFetch]
+		}
+		bv, err := s.charge(p, buffer, msg.Code, len(req.Proofs))
+		if err != nil {
+			return err
+		}
+		// Proofs of unknown roots are left empty
+		proofs := make([][][]byte, len(req.Proofs))
+		for i, preq := range req.Proofs {
+			if blob, _ := s.stateDb.Get(preq.Root[:]); len(blob) == 0 {
+				continue
+			}
+			proofs[i], _ = trie.New(preq.Root[:], s.stateDb).Prove(preq.Key)
+		}
+		return p.sendReply(ProofsMsg, req.ReqID, bv, proofs)
+
+	case GetCodeMsg:
+		var req struct {
+			ReqID  uint64
+			Hashes []common.Hash
+		}
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxCodeFetch {
+			req.Hashes = req.Hashes[:MaxCodeFetch]
+		}
+		bv, err := s.charge(p, buffer, msg.Code, len(req.Hashes))
+		if err != nil {
+			return err
+		}
+		code := make([][]byte, len(req.Hashes))
+		for i, hash := range req.Hashes {
+			code[i], _ = s.stateDb.Get(hash[:])
+		}
+		return p.sendReply(CodeMsg, req.ReqID, bv, code)
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// getBlockHeaders retrieves the canonical headers matching a query.
+func (s *Server) getBlockHeaders(query *getBlockHeadersData) []*types.Header {
+	var headers []*types.Header
+	for number := query.Origin; uint64(len(headers)) < query.Amount; {
+		block := s.chainman.GetBlockByNumber(number)
+		if block == nil {
+			break
+		}
+		headers = append(headers, block.Header())
+
+		// Advance to the next header, stopping if the skip would overflow
+		if query.Reverse {
+			if number <= query.Skip {
+				break
+			}
+			number -= query.Skip + 1
+		} else {
+			next := number + query.Skip + 1
+			if next <= number {
+				break
+			}
+			number = next
+		}
+	}
+	return headers
+}
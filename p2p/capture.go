@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Capture record directions.
+const (
+	CaptureIngress = 0 // message was received from the peer
+	CaptureEgress  = 1 // message was sent to the peer
+)
+
+// CaptureRecord is a single message in a capture file.
+type CaptureRecord struct {
+	Time      uint64 // unix time in nanoseconds
+	Direction uint   // CaptureIngress or CaptureEgress
+	Peer      discover.NodeID
+	Protocol  string
+	Version   uint
+	Code      uint64 // relative to the protocol's offset
+	Payload   []byte
+}
+
+func (r *CaptureRecord) String() string {
+	dir := "<-"
+	if r.Direction == CaptureEgress {
+		dir = "->"
+	}
+	return fmt.Sprintf("%v %x %s %s/%d code %d (%d bytes)",
+		time.Unix(0, int64(r.Time)), r.Peer[:8], dir, r.Protocol, r.Version, r.Code, len(r.Payload))
+}
+
+// CaptureWriter writes capture records to an underlying writer.
+// Records are written as a sequence of RLP lists. A CaptureWriter
+// may be shared by many peers and protocols.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewCaptureWriter creates a capture writer that writes to w.
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{w: w}
+}
+
+// Err returns the first write error encountered, if any.
+// Capturing stops after a write error but the wrapped
+// connections keep working.
+func (cw *CaptureWriter) Err() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.err
+}
+
+func (cw *CaptureWriter) write(rec *CaptureRecord) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err == nil {
+		cw.err = rlp.Encode(cw.w, rec)
+	}
+}
+
+// Protocol returns a copy of proto whose message stream
+// is recorded to the capture writer.
+func (cw *CaptureWriter) Protocol(proto Protocol) Protocol {
+	run := proto.Run
+	proto.Run = func(peer *Peer, rw MsgReadWriter) error {
+		return run(peer, cw.Wrap(rw, peer.ID(), proto.Name, proto.Version))
+	}
+	return proto
+}
+
+// Wrap returns a MsgReadWriter that records all messages passing
+// through rw. The remaining arguments are stored in each record.
+func (cw *CaptureWriter) Wrap(rw MsgReadWriter, peer discover.NodeID, proto string, version uint) MsgReadWriter {
+	return &captureRW{rw: rw, cw: cw, peer: peer, proto: proto, version: version}
+}
+
+type captureRW struct {
+	rw      MsgReadWriter
+	cw      *CaptureWriter
+	peer    discover.NodeID
+	proto   string
+	version uint
+}
+
+func (c *captureRW) ReadMsg() (Msg, error) {
+	msg, err := c.rw.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return msg, err
+	}
+	c.record(CaptureIngress, msg.Code, payload)
+	msg.Payload = bytes.NewReader(payload)
+	return msg, nil
+}
+
+func (c *captureRW) WriteMsg(msg Msg) error {
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = bytes.NewReader(payload)
+	if err := c.rw.WriteMsg(msg); err != nil {
+		return err
+	}
+	c.record(CaptureEgress, msg.Code, payload)
+	return nil
+}
+
+func (c *captureRW) record(dir uint, code uint64, payload []byte) {
+	c.cw.write(&CaptureRecord{
+		Time:      uint64(time.Now().UnixNano()),
+		Direction: dir,
+		Peer:      c.peer,
+		Protocol:  c.proto,
+		Version:   c.version,
+		Code:      code,
+		Payload:   payload,
+	})
+}
+
+// CaptureReader reads records written by a CaptureWriter.
+type CaptureReader struct {
+	s *rlp.Stream
+}
+
+// NewCaptureReader creates a reader for the capture data in r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{s: rlp.NewStream(r, 0)}
+}
+
+// Next returns the next record. It returns io.EOF
+// at the end of the input.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	rec := new(CaptureRecord)
+	if err := cr.s.Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ReadCapture reads all records from r.
+func ReadCapture(r io.Reader) ([]*CaptureRecord, error) {
+	var (
+		cr   = NewCaptureReader(r)
+		recs []*CaptureRecord
+	)
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// Replay runs proto against the recorded message stream of a single
+// peer. The ingress records of the peer that belong to proto and its
+// version are fed into the protocol handler in their original order
+// through a MsgPipe. Messages sent by the handler are collected and
+// returned as egress records, so they can be compared against the
+// capture.
+//
+// Replay returns when all recorded ingress messages have been
+// delivered and the handler has stopped sending, or when the handler
+// returns. The idle duration determines how long Replay waits for
+// further output from the handler after the last delivery.
+func Replay(recs []*CaptureRecord, peer discover.NodeID, proto Protocol, idle time.Duration) ([]*CaptureRecord, error) {
+	var (
+		rw, handlerRW = MsgPipe()
+		p             = NewPeer(peer, "replay", []Cap{proto.cap()})
+		runErr        = make(chan error, 1)
+		out           []*CaptureRecord
+		outMu         sync.Mutex
+		readDone      = make(chan struct{})
+	)
+	defer rw.Close()
+	go func() { runErr <- proto.Run(p, handlerRW) }()
+
+	// Collect everything the handler sends.
+	lastOut := make(chan struct{}, 1)
+	go func() {
+		defer close(readDone)
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return
+			}
+			payload, _ := ioutil.ReadAll(msg.Payload)
+			outMu.Lock()
+			out = append(out, &CaptureRecord{
+				Time:      uint64(time.Now().UnixNano()),
+				Direction: CaptureEgress,
+				Peer:      peer,
+				Protocol:  proto.Name,
+				Version:   proto.Version,
+				Code:      msg.Code,
+				Payload:   payload,
+			})
+			outMu.Unlock()
+			select {
+			case lastOut <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	collected := func() []*CaptureRecord {
+		rw.Close()
+		<-readDone
+		outMu.Lock()
+		defer outMu.Unlock()
+		return out
+	}
+	for _, rec := range recs {
+		if rec.Direction != CaptureIngress || rec.Peer != peer || rec.Protocol != proto.Name || rec.Version != proto.Version {
+			continue
+		}
+		msg := Msg{Code: rec.Code, Size: uint32(len(rec.Payload)), Payload: bytes.NewReader(rec.Payload)}
+		werr := make(chan error, 1)
+		go func() { werr <- rw.WriteMsg(msg) }()
+		select {
+		case err := <-werr:
+			if err != nil {
+				return collected(), err
+			}
+		case err := <-runErr:
+			return collected(), err
+		}
+	}
+	// Wait until the handler has been quiet for the idle duration.
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-lastOut:
+			timer.Reset(idle)
+		case <-timer.C:
+			return collected(), nil
+		case err := <-runErr:
+			return collected(), err
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// echoProtocol sends back every message it receives with code + 1.
+var echoProtocol = Protocol{
+	Name:    "echo",
+	Version: 1,
+	Length:  10,
+	Run: func(p *Peer, rw MsgReadWriter) error {
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(msg.Payload)
+			if err != nil {
+				return err
+			}
+			msg.Code++
+			msg.Payload = bytes.NewReader(data)
+			if err := rw.WriteMsg(msg); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+func TestCaptureRoundtrip(t *testing.T) {
+	var (
+		buf      = new(bytes.Buffer)
+		cw       = NewCaptureWriter(buf)
+		id       = randomID()
+		rw1, rw2 = MsgPipe()
+	)
+	captured := cw.Wrap(rw2, id, echoProtocol.Name, echoProtocol.Version)
+	done := make(chan struct{})
+	go func() {
+		echoProtocol.Run(NewPeer(id, "test", nil), captured)
+		close(done)
+	}()
+
+	for i := uint64(0); i < 3; i++ {
+		if err := Send(rw1, i*2, []uint{uint(i)}); err != nil {
+			t.Fatalf("send error: %v", err)
+		}
+		if err := ExpectMsg(rw1, i*2+1, []uint{uint(i)}); err != nil {
+			t.Fatalf("echo mismatch: %v", err)
+		}
+	}
+	rw1.Close()
+	<-done
+	if err := cw.Err(); err != nil {
+		t.Fatalf("capture write error: %v", err)
+	}
+
+	recs, err := ReadCapture(buf)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if len(recs) != 6 {
+		t.Fatalf("wrong number of records: got %d, want 6", len(recs))
+	}
+	for i, rec := range recs {
+		wantDir := uint(CaptureIngress)
+		if i%2 == 1 {
+			wantDir = CaptureEgress
+		}
+		if rec.Direction != wantDir || rec.Code != uint64(i) || rec.Peer != id || rec.Protocol != "echo" || rec.Version != 1 {
+			t.Errorf("record %d mismatch: %v", i, rec)
+		}
+	}
+
+	// Replaying the capture should reproduce the egress records.
+	out, err := Replay(recs, id, echoProtocol, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("wrong number of replay records: got %d, want 3", len(out))
+	}
+	for i, rec := range out {
+		want := recs[i*2+1]
+		if rec.Code != want.Code || !reflect.DeepEqual(rec.Payload, want.Payload) {
+			t.Errorf("replay record %d mismatch:\ngot  %v %x\nwant %v %x", i, rec, rec.Payload, want, want.Payload)
+		}
+	}
+
+	// Records of a different protocol version must not be replayed.
+	otherVersion := echoProtocol
+	otherVersion.Version++
+	out, err = Replay(recs, id, otherVersion, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("records of version %d replayed into version %d: %v", echoProtocol.Version, otherVersion.Version, out)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
 const (
@@ -37,6 +38,8 @@ type dialstate struct {
 	lookupBuf   []*discover.Node // current discovery lookup results
 	randomNodes []*discover.Node // filled from Table
 	static      map[discover.NodeID]*discover.Node
+	bans        *discover.BanList // may be nil
+	netrestrict *netutil.Netlist  // may be nil
 	hist        *dialHistory
 }
 
@@ -103,6 +106,10 @@ func (s *dialstate) addStatic(n *discover.Node) {
 	s.static[n.ID] = n
 }
 
+func (s *dialstate) removeStatic(n *discover.Node) {
+	delete(s.static, n.ID)
+}
+
 func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now time.Time) []task {
 	var newtasks []task
 	addDial := func(flag connFlag, n *discover.Node) bool {
@@ -110,6 +117,12 @@ func (s *dialstate) newTasks(nRunning int, peers map[discover.NodeID]*Peer, now
 		if dialing || peers[n.ID] != nil || s.hist.contains(n.ID) {
 			return false
 		}
+		if s.bans.Banned(n.ID, n.IP) != nil {
+			return false
+		}
+		if s.netrestrict != nil && !s.netrestrict.Contains(n.IP) {
+			return false
+		}
 		s.dialing[n.ID] = flag
 		newtasks = append(newtasks, &dialTask{flags: flag, dest: n})
 		return true
@@ -190,9 +203,17 @@ func (s *dialstate) taskDone(t task, now time.Time) {
 }
 
 func (t *dialTask) Do(srv *Server) {
-	addr := &net.TCPAddr{IP: t.dest.IP, Port: int(t.dest.TCP)}
 	glog.V(logger.Debug).Infof("dialing %v\n", t.dest)
-	fd, err := srv.Dialer.Dial("tcp", addr.String())
+	var (
+		fd  net.Conn
+		err error
+	)
+	if srv.NodeDialer != nil {
+		fd, err = srv.NodeDialer.Dial(t.dest)
+	} else {
+		addr := &net.TCPAddr{IP: t.dest.IP, Port: int(t.dest.TCP)}
+		fd, err = srv.Dialer.Dial("tcp", addr.String())
+	}
 	if err != nil {
 		glog.V(logger.Detail).Infof("dial error: %v", err)
 		return
@@ -2,12 +2,14 @@ package p2p
 
 import (
 	"encoding/binary"
+	"net"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
 func init() {
@@ -458,6 +460,32 @@ func TestDialStateCache(t *testing.T) {
 	})
 }
 
+// This test checks that nodes outside the netrestrict list are not dialed.
+func TestDialStateNetRestrict(t *testing.T) {
+	nodes := []*discover.Node{
+		{ID: uintID(1), IP: net.ParseIP("127.0.0.1")},
+		{ID: uintID(2), IP: net.ParseIP("127.0.0.2")},
+		{ID: uintID(3), IP: net.ParseIP("10.0.0.1")},
+		{ID: uintID(4), IP: net.ParseIP("192.168.0.1")},
+	}
+	restrict := new(netutil.Netlist)
+	restrict.Add("127.0.0.0/8")
+	ds := newDialState(nodes, fakeTable{}, 0)
+	ds.netrestrict = restrict
+
+	runDialTest(t, dialtest{
+		init: ds,
+		rounds: []round{
+			{
+				new: []task{
+					&dialTask{staticDialedConn, nodes[0]},
+					&dialTask{staticDialedConn, nodes[1]},
+				},
+			},
+		},
+	})
+}
+
 // compares task lists but doesn't care about the order.
 func sametasks(a, b []task) bool {
 	if len(a) != len(b) {
@@ -0,0 +1,225 @@
+package discover
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBanListClosed is returned when modifying a ban list after
+// its database has been closed.
+var ErrBanListClosed = errors.New("ban list closed")
+
+// Ban prevents a node or a network from connecting.
+// Exactly one of ID and Net is set.
+type Ban struct {
+	ID      NodeID     // banned node, zero for network bans
+	Net     *net.IPNet // banned network, nil for node bans
+	Expires time.Time  // zero for bans that never expire
+	Reason  string
+}
+
+// ParseBan creates a ban for the given target, which can be an enode
+// URL, a hex node ID, an IP address or a network in CIDR notation.
+// The returned ban never expires.
+func ParseBan(target string) (*Ban, error) {
+	target = strings.TrimSpace(target)
+	switch {
+	case strings.HasPrefix(target, "enode://"):
+		n, err := ParseNode(target)
+		if err != nil {
+			return nil, err
+		}
+		return &Ban{ID: n.ID}, nil
+	case strings.Contains(target, "/"):
+		_, ipnet, err := net.ParseCIDR(target)
+		if err != nil {
+			return nil, err
+		}
+		return &Ban{Net: ipnet}, nil
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &Ban{Net: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	id, err := HexID(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ban target %q (want enode URL, node ID, IP or CIDR)", target)
+	}
+	return &Ban{ID: id}, nil
+}
+
+// Target returns the banned node ID or network as a string.
+func (b *Ban) Target() string {
+	if b.Net != nil {
+		return b.Net.String()
+	}
+	return fmt.Sprintf("%x", b.ID[:])
+}
+
+func (b *Ban) String() string {
+	s := "ban " + b.Target()
+	if !b.Expires.IsZero() {
+		s += " until " + b.Expires.String()
+	}
+	if b.Reason != "" {
+		s += ": " + b.Reason
+	}
+	return s
+}
+
+// Matches reports whether the ban applies to a node
+// with the given ID and IP address. The IP may be nil.
+func (b *Ban) Matches(id NodeID, ip net.IP) bool {
+	if b.Net != nil {
+		return ip != nil && b.Net.Contains(ip)
+	}
+	return b.ID == id
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Expires.IsZero() && !now.Before(b.Expires)
+}
+
+// BanList holds the active bans. Bans are stored in the node database
+// and survive restarts. All methods are safe for concurrent use.
+// Banned, List and Close may be called on a nil BanList, which bans
+// nothing. After Close, the list keeps answering Banned and List from
+// memory but can no longer be modified.
+type BanList struct {
+	mu     sync.Mutex
+	db     *nodeDB
+	bans   map[string]*Ban // keyed by target
+	own    bool            // true if db was opened by OpenBanList
+	closed bool            // set by Close, db must not be used anymore
+}
+
+// OpenBanList opens the ban list stored in the node database at the
+// given path. It is meant for use when the discovery table, which
+// holds the database otherwise, is not running. If path is empty,
+// bans are kept in memory only.
+func OpenBanList(path string, self NodeID) (*BanList, error) {
+	db, err := newNodeDB(path, Version, self)
+	if err != nil {
+		return nil, err
+	}
+	bl := newBanList(db)
+	bl.own = true
+	return bl, nil
+}
+
+func newBanList(db *nodeDB) *BanList {
+	bl := &BanList{db: db, bans: make(map[string]*Ban)}
+	for _, b := range db.bans() {
+		bl.bans[b.Target()] = b
+	}
+	return bl
+}
+
+// Close releases the database if it was opened by OpenBanList.
+// It must also be called before a database shared with the
+// discovery table is closed.
+func (bl *BanList) Close() {
+	if bl == nil {
+		return
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if !bl.closed && bl.own {
+		bl.db.close()
+	}
+	bl.closed = true
+}
+
+// Add adds a ban, replacing any previous ban of the same target.
+func (bl *BanList) Add(b *Ban) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.closed {
+		return ErrBanListClosed
+	}
+	bl.bans[b.Target()] = b
+	return bl.db.storeBan(b)
+}
+
+// Remove lifts the ban of the given target.
+// It returns false if the target was not banned.
+func (bl *BanList) Remove(b *Ban) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.closed {
+		return false, ErrBanListClosed
+	}
+	target := b.Target()
+	if _, ok := bl.bans[target]; !ok {
+		return false, nil
+	}
+	delete(bl.bans, target)
+	return true, bl.db.deleteBan(b)
+}
+
+// List returns all bans that have not expired, ordered by target.
+func (bl *BanList) List() []*Ban {
+	if bl == nil {
+		return nil
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.expire(time.Now())
+	list := make([]*Ban, 0, len(bl.bans))
+	for _, b := range bl.bans {
+		list = append(list, b)
+	}
+	sort.Sort(bansByTarget(list))
+	return list
+}
+
+// Banned returns the ban matching a node with the given ID and IP
+// address, or nil if the node is not banned. The IP may be nil.
+func (bl *BanList) Banned(id NodeID, ip net.IP) *Ban {
+	if bl == nil {
+		return nil
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	now := time.Now()
+	for target, b := range bl.bans {
+		if b.Matches(id, ip) {
+			if !b.expired(now) {
+				return b
+			}
+			bl.drop(target, b)
+		}
+	}
+	return nil
+}
+
+// expire removes expired bans. It must be called with bl.mu held.
+func (bl *BanList) expire(now time.Time) {
+	for target, b := range bl.bans {
+		if b.expired(now) {
+			bl.drop(target, b)
+		}
+	}
+}
+
+// drop removes an expired ban. It must be called with bl.mu held.
+func (bl *BanList) drop(target string, b *Ban) {
+	delete(bl.bans, target)
+	if !bl.closed {
+		bl.db.deleteBan(b)
+	}
+}
+
+type bansByTarget []*Ban
+
+func (l bansByTarget) Len() int           { return len(l) }
+func (l bansByTarget) Less(i, j int) bool { return l[i].Target() < l[j].Target() }
+func (l bansByTarget) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
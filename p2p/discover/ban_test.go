@@ -0,0 +1,154 @@
+package discover
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var parseBanTests = []struct {
+	target     string
+	wantTarget string
+	wantError  bool
+}{
+	{
+		target:     "enode://1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439@127.0.0.1:30303",
+		wantTarget: "1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439",
+	},
+	{
+		target:     "0x1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439",
+		wantTarget: "1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439",
+	},
+	{target: "10.1.2.3", wantTarget: "10.1.2.3/32"},
+	{target: "10.1.2.3/16", wantTarget: "10.1.0.0/16"},
+	{target: "2001:db8::1", wantTarget: "2001:db8::1/128"},
+	{target: "10.1.2.3/33", wantError: true},
+	{target: "foo", wantError: true},
+}
+
+func TestParseBan(t *testing.T) {
+	for _, tt := range parseBanTests {
+		b, err := ParseBan(tt.target)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("%q: no error, got ban %v", tt.target, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.target, err)
+			continue
+		}
+		if b.Target() != tt.wantTarget {
+			t.Errorf("%q: target mismatch: got %q, want %q", tt.target, b.Target(), tt.wantTarget)
+		}
+	}
+}
+
+func TestBanListMatch(t *testing.T) {
+	bl, _ := OpenBanList("", NodeID{})
+	defer bl.Close()
+
+	var (
+		bannedID = MustHexID("0x1dd9d65c4552b5eb43d5ad55a2ee3f56c6cbc1c64a5c8d659f51fcd51bace24351232b8d7821617d2b29b54b81cdefb9b3e9c37d7fd5f63270bcc9e1a6f6a439")
+		otherID  = MustHexID("0xca634cae0d49acb401d8a4c6b6fe8c55b70d115bf400769cc1400f3258cd31387574077f301b421bc84df7266c44e9e6d569fc56be00812904767bf5ccd1fc7f")
+		ban1     = &Ban{ID: bannedID}
+		ban2, _  = ParseBan("192.168.0.0/16")
+		expired  = &Ban{Net: &net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}, Expires: time.Now().Add(-time.Second)}
+	)
+	for _, b := range []*Ban{ban1, ban2, expired} {
+		if err := bl.Add(b); err != nil {
+			t.Fatalf("failed to add %v: %v", b, err)
+		}
+	}
+
+	if b := bl.Banned(bannedID, nil); b != ban1 {
+		t.Errorf("banned ID: got %v, want %v", b, ban1)
+	}
+	if b := bl.Banned(otherID, net.ParseIP("192.168.4.5")); b != ban2 {
+		t.Errorf("banned IP: got %v, want %v", b, ban2)
+	}
+	if b := bl.Banned(otherID, net.ParseIP("10.0.0.1")); b != nil {
+		t.Errorf("expired ban matched: %v", b)
+	}
+	if b := bl.Banned(otherID, net.ParseIP("127.0.0.1")); b != nil {
+		t.Errorf("unbanned node matched: %v", b)
+	}
+	if list := bl.List(); len(list) != 2 {
+		t.Errorf("wrong number of active bans: got %d, want 2", len(list))
+	}
+
+	if ok, err := bl.Remove(&Ban{ID: bannedID}); !ok || err != nil {
+		t.Errorf("Remove returned %t, %v", ok, err)
+	}
+	if b := bl.Banned(bannedID, nil); b != nil {
+		t.Errorf("removed ban still matches: %v", b)
+	}
+	if ok, _ := bl.Remove(&Ban{ID: bannedID}); ok {
+		t.Errorf("second Remove returned true")
+	}
+}
+
+func TestBanListPersistency(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodedb-")
+	if err != nil {
+		t.Fatalf("failed to create temporary data folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+	path := filepath.Join(root, "database")
+
+	ban, _ := ParseBan("192.168.0.0/16")
+	ban.Reason = "test"
+	ban.Expires = time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+
+	bl, err := OpenBanList(path, NodeID{})
+	if err != nil {
+		t.Fatalf("failed to open ban list: %v", err)
+	}
+	if err := bl.Add(ban); err != nil {
+		t.Fatalf("failed to add ban: %v", err)
+	}
+	bl.Close()
+
+	bl, err = OpenBanList(path, NodeID{})
+	if err != nil {
+		t.Fatalf("failed to reopen ban list: %v", err)
+	}
+	defer bl.Close()
+	list := bl.List()
+	if len(list) != 1 {
+		t.Fatalf("wrong number of bans after reopen: got %d, want 1", len(list))
+	}
+	if list[0].Target() != ban.Target() || !list[0].Expires.Equal(ban.Expires) || list[0].Reason != ban.Reason {
+		t.Errorf("ban mismatch after reopen:\ngot  %v\nwant %v", list[0], ban)
+	}
+}
+
+func TestBanListClosed(t *testing.T) {
+	bl, err := OpenBanList("", NodeID{})
+	if err != nil {
+		t.Fatalf("failed to open ban list: %v", err)
+	}
+	ban, _ := ParseBan("10.0.0.0/8")
+	if err := bl.Add(ban); err != nil {
+		t.Fatalf("failed to add ban: %v", err)
+	}
+	bl.Close()
+	bl.Close()
+
+	if err := bl.Add(&Ban{ID: NodeID{1}}); err != ErrBanListClosed {
+		t.Errorf("Add after Close returned %v, want %v", err, ErrBanListClosed)
+	}
+	if _, err := bl.Remove(ban); err != ErrBanListClosed {
+		t.Errorf("Remove after Close returned %v, want %v", err, ErrBanListClosed)
+	}
+	if b := bl.Banned(NodeID{}, net.ParseIP("10.1.2.3")); b == nil {
+		t.Errorf("ban not matched after Close")
+	}
+	if list := bl.List(); len(list) != 1 {
+		t.Errorf("wrong number of bans after Close: got %d, want 1", len(list))
+	}
+}
@@ -43,6 +43,7 @@ type nodeDB struct {
 var (
 	nodeDBVersionKey = []byte("version") // Version of the database to flush if changes
 	nodeDBItemPrefix = []byte("n:")      // Identifier to prefix node entries with
+	nodeDBBanPrefix  = []byte("ban:")    // Identifier to prefix ban entries with
 
 	nodeDBDiscoverRoot      = ":discover"
 	nodeDBDiscoverPing      = nodeDBDiscoverRoot + ":lastping"
@@ -286,6 +287,62 @@ func (db *nodeDB) updateFindFails(id NodeID, fails int) error {
 	return db.storeInt64(makeKey(id, nodeDBDiscoverFindFails), int64(fails))
 }
 
+// storedBan is the database representation of a ban.
+// The banned target is part of the key.
+type storedBan struct {
+	Expires uint64 // unix time, zero for bans that never expire
+	Reason  string
+}
+
+func banKey(b *Ban) []byte {
+	return append(nodeDBBanPrefix, b.Target()...)
+}
+
+// storeBan inserts or replaces a ban.
+func (db *nodeDB) storeBan(b *Ban) error {
+	stored := storedBan{Reason: b.Reason}
+	if !b.Expires.IsZero() {
+		stored.Expires = uint64(b.Expires.Unix())
+	}
+	blob, err := rlp.EncodeToBytes(&stored)
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(banKey(b), blob, nil)
+}
+
+// deleteBan removes a ban.
+func (db *nodeDB) deleteBan(b *Ban) error {
+	return db.lvl.Delete(banKey(b), nil)
+}
+
+// bans retrieves all stored bans, including expired ones.
+func (db *nodeDB) bans() []*Ban {
+	it := db.lvl.NewIterator(util.BytesPrefix(nodeDBBanPrefix), nil)
+	defer it.Release()
+
+	var bans []*Ban
+	for it.Next() {
+		target := string(it.Key()[len(nodeDBBanPrefix):])
+		b, err := ParseBan(target)
+		if err != nil {
+			glog.V(logger.Warn).Infof("invalid ban target %q in database: %v", target, err)
+			continue
+		}
+		var stored storedBan
+		if err := rlp.DecodeBytes(it.Value(), &stored); err != nil {
+			glog.V(logger.Warn).Infof("failed to decode ban RLP: %v", err)
+			continue
+		}
+		if stored.Expires != 0 {
+			b.Expires = time.Unix(int64(stored.Expires), 0)
+		}
+		b.Reason = stored.Reason
+		bans = append(bans, b)
+	}
+	return bans
+}
+
 // querySeeds retrieves a batch of nodes to be used as potential seed servers
 // during bootstrapping the node into the network.
 //
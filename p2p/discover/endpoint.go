@@ -0,0 +1,151 @@
+package discover
+
+import (
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+)
+
+const (
+	voteExpiry = 10 * time.Minute // votes older than this are discarded
+	maxVoters  = 200              // maximum number of tracked votes
+)
+
+// EndpointPolicy configures detection of the local node's external IP
+// address. Every pong packet tells us the address at which the remote
+// node saw our ping. The address of the local node is changed when
+// enough distinct nodes agree on a new one.
+type EndpointPolicy struct {
+	// MinVotes is the number of recent votes required before the
+	// address is changed. A zero value disables detection.
+	MinVotes int
+
+	// Majority is the fraction of recent votes that must agree on
+	// the new address, e.g. 0.5 for a simple majority.
+	Majority float64
+}
+
+// DefaultEndpointPolicy is the policy of new tables.
+var DefaultEndpointPolicy = EndpointPolicy{MinVotes: 10, Majority: 0.5}
+
+type endpointVote struct {
+	ip   net.IP
+	time time.Time
+}
+
+// endpointVotes collects statements about the local node's
+// external IP. Each remote node has at most one vote.
+type endpointVotes struct {
+	policy EndpointPolicy
+	votes  map[NodeID]endpointVote
+}
+
+func newEndpointVotes(policy EndpointPolicy) *endpointVotes {
+	return &endpointVotes{policy: policy, votes: make(map[NodeID]endpointVote)}
+}
+
+// add records the IP reported by a remote node. It returns
+// the IP that is agreed on by a majority, or nil if there is none.
+func (v *endpointVotes) add(from NodeID, ip net.IP, now time.Time) net.IP {
+	if v.policy.MinVotes <= 0 {
+		return nil
+	}
+	v.expire(now)
+	if _, ok := v.votes[from]; !ok && len(v.votes) >= maxVoters {
+		v.dropOldest()
+	}
+	v.votes[from] = endpointVote{ip, now}
+	if len(v.votes) < v.policy.MinVotes {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	var best net.IP
+	var bestCount int
+	for _, vote := range v.votes {
+		key := vote.ip.String()
+		counts[key]++
+		if counts[key] > bestCount {
+			best, bestCount = vote.ip, counts[key]
+		}
+	}
+	if float64(bestCount) <= v.policy.Majority*float64(len(v.votes)) {
+		return nil
+	}
+	return best
+}
+
+func (v *endpointVotes) expire(now time.Time) {
+	for id, vote := range v.votes {
+		if now.Sub(vote.time) > voteExpiry {
+			delete(v.votes, id)
+		}
+	}
+}
+
+func (v *endpointVotes) dropOldest() {
+	var (
+		oldest NodeID
+		otime  time.Time
+	)
+	for id, vote := range v.votes {
+		if otime.IsZero() || vote.time.Before(otime) {
+			oldest, otime = id, vote.time
+		}
+	}
+	delete(v.votes, oldest)
+}
+
+// SetEndpointPolicy changes the policy of external IP detection.
+// Previously collected votes are discarded. Detection is always
+// disabled if the external IP was configured manually.
+func (tab *Table) SetEndpointPolicy(policy EndpointPolicy) {
+	tab.selfMu.Lock()
+	defer tab.selfMu.Unlock()
+	if tab.votes != nil {
+		tab.votes = newEndpointVotes(policy)
+	}
+}
+
+// OnEndpointChange sets a function that is called in its own
+// goroutine whenever the external IP of the local node changes.
+// The argument is the local node with the new address.
+func (tab *Table) OnEndpointChange(fn func(*Node)) {
+	tab.selfMu.Lock()
+	defer tab.selfMu.Unlock()
+	tab.endpointHook = fn
+}
+
+// voteEndpoint records the IP at which the remote node at addr
+// saw the local node.
+func (tab *Table) voteEndpoint(from NodeID, addr *net.UDPAddr, ip net.IP) {
+	if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+		return
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	// Nodes on the Internet can't see our local network address.
+	if netutil.IsLAN(ip) && !netutil.IsLAN(addr.IP) {
+		return
+	}
+
+	tab.selfMu.Lock()
+	if tab.votes == nil {
+		tab.selfMu.Unlock()
+		return
+	}
+	newip := tab.votes.add(from, ip, time.Now())
+	if newip == nil || newip.Equal(tab.self.IP) {
+		tab.selfMu.Unlock()
+		return
+	}
+	tab.self.IP = newip
+	self, hook := *tab.self, tab.endpointHook
+	tab.selfMu.Unlock()
+
+	if hook != nil {
+		go hook(&self)
+	}
+}
@@ -0,0 +1,99 @@
+package discover
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEndpointVotes(t *testing.T) {
+	var (
+		v    = newEndpointVotes(EndpointPolicy{MinVotes: 3, Majority: 0.5})
+		now  = time.Now()
+		ipA  = net.ParseIP("1.1.1.1")
+		ipB  = net.ParseIP("2.2.2.2")
+		node = func(i byte) NodeID { return NodeID{i} }
+	)
+	// Not enough votes yet.
+	if ip := v.add(node(1), ipA, now); ip != nil {
+		t.Fatalf("got %v after one vote", ip)
+	}
+	if ip := v.add(node(2), ipA, now); ip != nil {
+		t.Fatalf("got %v after two votes", ip)
+	}
+	// A repeated vote from the same node doesn't count twice.
+	if ip := v.add(node(2), ipA, now); ip != nil {
+		t.Fatalf("got %v after repeated vote", ip)
+	}
+	if ip := v.add(node(3), ipA, now); !ip.Equal(ipA) {
+		t.Fatalf("got %v after three votes, want %v", ip, ipA)
+	}
+	// The result changes once a majority reports a different address.
+	v.add(node(4), ipB, now)
+	if ip := v.add(node(5), ipB, now); !ip.Equal(ipA) {
+		t.Fatalf("got %v with two of five votes for %v, want %v", ip, ipB, ipA)
+	}
+	if ip := v.add(node(1), ipB, now); !ip.Equal(ipB) {
+		t.Fatalf("got %v after majority switched, want %v", ip, ipB)
+	}
+	// Old votes expire.
+	later := now.Add(voteExpiry + time.Second)
+	if ip := v.add(node(6), ipA, later); ip != nil {
+		t.Fatalf("got %v after expiry", ip)
+	}
+	if len(v.votes) != 1 {
+		t.Errorf("%d votes left after expiry, want 1", len(v.votes))
+	}
+}
+
+func TestEndpointVotesLimit(t *testing.T) {
+	v := newEndpointVotes(EndpointPolicy{MinVotes: 1, Majority: 0.5})
+	now := time.Now()
+	for i := 0; i < maxVoters+10; i++ {
+		var id NodeID
+		id[0], id[1] = byte(i), byte(i>>8)
+		v.add(id, net.ParseIP("1.1.1.1"), now.Add(time.Duration(i)))
+	}
+	if len(v.votes) != maxVoters {
+		t.Errorf("got %d votes, want %d", len(v.votes), maxVoters)
+	}
+	if _, ok := v.votes[NodeID{}]; ok {
+		t.Errorf("oldest vote was not dropped")
+	}
+}
+
+func TestTable_voteEndpoint(t *testing.T) {
+	tab := newTable(nil, NodeID{}, &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, "")
+	tab.SetEndpointPolicy(EndpointPolicy{MinVotes: 2, Majority: 0.5})
+	changed := make(chan *Node, 1)
+	tab.OnEndpointChange(func(n *Node) { changed <- n })
+
+	var (
+		ext    = net.ParseIP("1.2.3.4")
+		remote = &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 30303}
+	)
+	// Internet hosts can't report our local network address.
+	tab.voteEndpoint(NodeID{1}, remote, net.ParseIP("10.0.0.2"))
+	tab.voteEndpoint(NodeID{2}, remote, net.ParseIP("10.0.0.2"))
+	tab.voteEndpoint(NodeID{3}, remote, ext)
+	tab.voteEndpoint(NodeID{4}, remote, ext)
+	select {
+	case n := <-changed:
+		if !n.IP.Equal(ext) {
+			t.Errorf("hook got IP %v, want %v", n.IP, ext)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("endpoint change hook not called")
+	}
+	if ip := tab.Self().IP; !ip.Equal(ext) {
+		t.Errorf("Self().IP is %v, want %v", ip, ext)
+	}
+
+	// Further votes for the same address don't trigger the hook.
+	tab.voteEndpoint(NodeID{5}, remote, ext)
+	select {
+	case n := <-changed:
+		t.Errorf("unexpected endpoint change to %v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
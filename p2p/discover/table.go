@@ -18,6 +18,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
 const (
@@ -30,11 +31,44 @@ const (
 	maxFindnodeFailures = 5
 )
 
+// IPLimits restrict the number of table entries that share an IP
+// subnet, making it harder for a single host or network to fill the
+// table with its own nodes. A zero limit disables the check. Nodes
+// with local network addresses are exempt.
+type IPLimits struct {
+	BucketSubnet uint // prefix length of subnets within a bucket
+	BucketLimit  uint // maximum number of nodes per subnet in a bucket
+	TableSubnet  uint // prefix length of subnets across the table
+	TableLimit   uint // maximum number of nodes per subnet in the table
+}
+
+// DefaultIPLimits are the limits of new tables.
+var DefaultIPLimits = IPLimits{
+	BucketSubnet: 24,
+	BucketLimit:  2,
+	TableSubnet:  24,
+	TableLimit:   10,
+}
+
+// TableStats contains statistics about the content of the table.
+type TableStats struct {
+	Buckets       [nBuckets]int   // number of nodes in each bucket, indexed by log distance
+	Subnets       map[string]uint // number of nodes in each subnet of the table limit
+	BucketRejects uint64          // nodes rejected because of the bucket subnet limit
+	TableRejects  uint64          // nodes rejected because of the table subnet limit
+}
+
 type Table struct {
 	mutex   sync.Mutex        // protects buckets, their content, and nursery
 	buckets [nBuckets]*bucket // index of known nodes by distance
 	nursery []*Node           // bootstrap nodes
 	db      *nodeDB           // database of known nodes
+	bans    *BanList          // bans, stored in db
+
+	limits        IPLimits
+	ips           netutil.DistinctNetSet // subnets of all entries
+	bucketRejects uint64
+	tableRejects  uint64
 
 	bondmu    sync.Mutex
 	bonding   map[NodeID]*bondproc
@@ -44,6 +78,10 @@ type Table struct {
 
 	net  transport
 	self *Node // metadata of the local node
+
+	selfMu       sync.Mutex     // protects self.IP, votes and endpointHook
+	votes        *endpointVotes // nil if the external IP is fixed
+	endpointHook func(*Node)
 }
 
 type bondproc struct {
@@ -68,6 +106,7 @@ type transport interface {
 type bucket struct {
 	lastLookup time.Time
 	entries    []*Node
+	ips        netutil.DistinctNetSet // subnets of entries
 }
 
 func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string) *Table {
@@ -80,7 +119,9 @@ func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string
 	tab := &Table{
 		net:       t,
 		db:        db,
+		bans:      newBanList(db),
 		self:      newNode(ourID, ourAddr.IP, uint16(ourAddr.Port), uint16(ourAddr.Port)),
+		votes:     newEndpointVotes(DefaultEndpointPolicy),
 		bonding:   make(map[NodeID]*bondproc),
 		bondslots: make(chan struct{}, maxBondingPingPongs),
 	}
@@ -90,13 +131,18 @@ func newTable(t transport, ourID NodeID, ourAddr *net.UDPAddr, nodeDBPath string
 	for i := range tab.buckets {
 		tab.buckets[i] = new(bucket)
 	}
+	tab.SetIPLimits(DefaultIPLimits)
 	return tab
 }
 
-// Self returns the local node.
-// The returned node should not be modified by the caller.
+// Self returns the local node. Its IP address reflects the
+// external address detected by discovery. The returned node
+// is a copy and can be modified by the caller.
 func (tab *Table) Self() *Node {
-	return tab.self
+	tab.selfMu.Lock()
+	defer tab.selfMu.Unlock()
+	self := *tab.self
+	return &self
 }
 
 // ReadRandomNodes fills the given slice with random nodes from the
@@ -146,9 +192,60 @@ func randUint(max uint32) uint32 {
 	return binary.BigEndian.Uint32(b[:]) % max
 }
 
+// Bans returns the ban list stored in the table's node database.
+func (tab *Table) Bans() *BanList {
+	return tab.bans
+}
+
+// SetIPLimits changes the subnet limits of the table. Entries that
+// exceed the new limits are kept, but no further nodes from their
+// subnets are added until enough of them have been removed.
+func (tab *Table) SetIPLimits(limits IPLimits) {
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+
+	tab.limits = limits
+	tab.ips = netutil.DistinctNetSet{Subnet: limits.TableSubnet, Limit: ^uint(0)}
+	for _, b := range tab.buckets {
+		b.ips = netutil.DistinctNetSet{Subnet: limits.BucketSubnet, Limit: ^uint(0)}
+		for _, n := range b.entries {
+			if !ipLimitExempt(n.IP) {
+				tab.ips.Add(n.IP)
+				b.ips.Add(n.IP)
+			}
+		}
+		b.ips.Limit = ipLimit(limits.BucketLimit)
+	}
+	tab.ips.Limit = ipLimit(limits.TableLimit)
+}
+
+func ipLimit(limit uint) uint {
+	if limit == 0 {
+		return ^uint(0)
+	}
+	return limit
+}
+
+// Stats returns statistics about the table content.
+func (tab *Table) Stats() *TableStats {
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+
+	stats := &TableStats{
+		Subnets:       tab.ips.Counts(),
+		BucketRejects: tab.bucketRejects,
+		TableRejects:  tab.tableRejects,
+	}
+	for i, b := range tab.buckets {
+		stats.Buckets[i] = len(b.entries)
+	}
+	return stats
+}
+
 // Close terminates the network listener and flushes the node database.
 func (tab *Table) Close() {
 	tab.net.close()
+	tab.bans.Close()
 	tab.db.close()
 }
 
@@ -389,7 +486,7 @@ func (tab *Table) bond(pinged bool, id NodeID, addr *net.UDPAddr, tcpPort uint16
 		defer tab.mutex.Unlock()
 
 		b := tab.buckets[logdist(tab.self.sha, node.sha)]
-		if !b.bump(node) {
+		if !tab.bump(b, node) {
 			tab.pingreplace(node, b)
 		}
 		tab.db.updateFindFails(id, 0)
@@ -420,12 +517,17 @@ func (tab *Table) pingpong(w *bondproc, pinged bool, id NodeID, addr *net.UDPAdd
 }
 
 func (tab *Table) pingreplace(new *Node, b *bucket) {
+	if !tab.addIP(b, new.IP) {
+		return
+	}
 	if len(b.entries) == bucketSize {
 		oldest := b.entries[bucketSize-1]
 		if err := tab.ping(oldest.ID, oldest.addr()); err == nil {
 			// The node responded, we don't need to replace it.
+			tab.removeIP(b, new.IP)
 			return
 		}
+		tab.removeIP(b, oldest.IP)
 	} else {
 		// Add a slot at the end so the last entry doesn't
 		// fall off when adding the new node.
@@ -469,7 +571,7 @@ outer:
 				continue outer
 			}
 		}
-		if len(bucket.entries) < bucketSize {
+		if len(bucket.entries) < bucketSize && tab.addIP(bucket, n.IP) {
 			bucket.entries = append(bucket.entries, n)
 			if tab.nodeAddedHook != nil {
 				tab.nodeAddedHook(n)
@@ -487,12 +589,68 @@ func (tab *Table) del(node *Node) {
 	bucket := tab.buckets[logdist(tab.self.sha, node.sha)]
 	for i := range bucket.entries {
 		if bucket.entries[i].ID == node.ID {
+			tab.removeIP(bucket, bucket.entries[i].IP)
 			bucket.entries = append(bucket.entries[:i], bucket.entries[i+1:]...)
 			return
 		}
 	}
 }
 
+// bump moves n to the front of b if it is already in the bucket.
+// If the node's IP has changed and the new IP exceeds the subnet
+// limits, the entry keeps its old endpoint. The caller must hold
+// tab.mutex.
+func (tab *Table) bump(b *bucket, n *Node) bool {
+	for _, e := range b.entries {
+		if e.ID != n.ID {
+			continue
+		}
+		if !e.IP.Equal(n.IP) {
+			tab.removeIP(b, e.IP)
+			if !tab.addIP(b, n.IP) {
+				tab.addIP(b, e.IP)
+				n = e
+			}
+		}
+		return b.bump(n)
+	}
+	return false
+}
+
+// addIP accounts for a new entry with the given IP in b. It returns
+// false if the IP exceeds the subnet limits. The caller must hold
+// tab.mutex.
+func (tab *Table) addIP(b *bucket, ip net.IP) bool {
+	if ipLimitExempt(ip) {
+		return true
+	}
+	if !tab.ips.Add(ip) {
+		tab.tableRejects++
+		glog.V(logger.Detail).Infof("Rejecting %v: too many table entries in subnet", ip)
+		return false
+	}
+	if !b.ips.Add(ip) {
+		tab.ips.Remove(ip)
+		tab.bucketRejects++
+		glog.V(logger.Detail).Infof("Rejecting %v: too many bucket entries in subnet", ip)
+		return false
+	}
+	return true
+}
+
+// removeIP undoes addIP. The caller must hold tab.mutex.
+func (tab *Table) removeIP(b *bucket, ip net.IP) {
+	if ipLimitExempt(ip) {
+		return
+	}
+	tab.ips.Remove(ip)
+	b.ips.Remove(ip)
+}
+
+func ipLimitExempt(ip net.IP) bool {
+	return ip == nil || netutil.IsLAN(ip)
+}
+
 func (b *bucket) bump(n *Node) bool {
 	for i := range b.entries {
 		if b.entries[i].ID == n.ID {
@@ -71,6 +71,52 @@ func TestTable_pingReplace(t *testing.T) {
 	doit(false, false)
 }
 
+func TestTable_IPLimits(t *testing.T) {
+	tab := newTable(nil, NodeID{}, &net.UDPAddr{}, "")
+	tab.SetIPLimits(IPLimits{BucketSubnet: 24, BucketLimit: 2, TableSubnet: 16, TableLimit: 3})
+	nodeWithIP := func(ld int, ip string) *Node {
+		n := nodeAtDistance(tab.self.sha, ld)
+		n.IP = net.ParseIP(ip)
+		return n
+	}
+
+	// The third node of 1.2.3.0/24 exceeds the bucket limit.
+	tab.add([]*Node{nodeWithIP(200, "1.2.3.1"), nodeWithIP(200, "1.2.3.2"), nodeWithIP(200, "1.2.3.3")})
+	if n := len(tab.buckets[200].entries); n != 2 {
+		t.Errorf("wrong bucket size after bucket limit: got %d, want 2", n)
+	}
+	// The fourth node of 1.2.0.0/16 exceeds the table limit.
+	tab.add([]*Node{nodeWithIP(201, "1.2.4.1"), nodeWithIP(202, "1.2.4.2")})
+	if n := tab.len(); n != 3 {
+		t.Errorf("wrong table size after table limit: got %d, want 3", n)
+	}
+	// Nodes from other subnets and local networks are still accepted.
+	tab.add([]*Node{nodeWithIP(200, "5.6.7.8"), nodeWithIP(200, "10.0.0.1"), nodeWithIP(200, "10.0.0.2"), nodeWithIP(200, "10.0.0.3")})
+	if n := len(tab.buckets[200].entries); n != 6 {
+		t.Errorf("wrong bucket size after adding other subnets: got %d, want 6", n)
+	}
+
+	stats := tab.Stats()
+	if stats.BucketRejects != 1 || stats.TableRejects != 1 {
+		t.Errorf("wrong reject counts: got %d bucket, %d table, want 1, 1", stats.BucketRejects, stats.TableRejects)
+	}
+	if stats.Buckets[200] != 6 || stats.Buckets[201] != 1 {
+		t.Errorf("wrong bucket counts: got %d, %d, want 6, 1", stats.Buckets[200], stats.Buckets[201])
+	}
+	wantSubnets := map[string]uint{"1.2.0.0/16": 3, "5.6.0.0/16": 1}
+	if !reflect.DeepEqual(stats.Subnets, wantSubnets) {
+		t.Errorf("wrong subnets: got %v, want %v", stats.Subnets, wantSubnets)
+	}
+
+	// Removing a node frees up its slot.
+	tab.del(tab.buckets[200].entries[0])
+	n := nodeWithIP(200, "1.2.3.3")
+	tab.add([]*Node{n})
+	if !contains(tab.buckets[200].entries, n.ID) {
+		t.Errorf("node not added after removal")
+	}
+}
+
 func TestBucket_bumpNoDuplicates(t *testing.T) {
 	t.Parallel()
 	cfg := &quick.Config{
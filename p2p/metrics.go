@@ -0,0 +1,118 @@
+package p2p
+
+import "sync"
+
+// TrafficStats contains ingress and egress counters.
+type TrafficStats struct {
+	IngressBytes   uint64
+	IngressPackets uint64
+	EgressBytes    uint64
+	EgressPackets  uint64
+}
+
+func (s *TrafficStats) addIngress(size uint64) {
+	s.IngressBytes += size
+	s.IngressPackets++
+}
+
+func (s *TrafficStats) addEgress(size uint64) {
+	s.EgressBytes += size
+	s.EgressPackets++
+}
+
+// Traffic is a snapshot of the traffic counters of a peer or server.
+//
+// Total counts RLPx frames as they appear on the wire, including
+// framing overhead. Protocols counts the payload size of messages
+// handled by each subprotocol, keyed by protocol name and by message
+// code relative to the protocol's offset.
+type Traffic struct {
+	Total     TrafficStats
+	Protocols map[string]map[uint64]TrafficStats
+}
+
+// trafficMeter counts traffic of a single connection or, if it is the
+// parent of other meters, the aggregate of all of them.
+// It is safe for concurrent use.
+type trafficMeter struct {
+	parent *trafficMeter // receives all updates as well, may be nil
+
+	mu     sync.Mutex
+	total  TrafficStats
+	protos map[string]map[uint64]*TrafficStats
+}
+
+func newTrafficMeter(parent *trafficMeter) *trafficMeter {
+	return &trafficMeter{parent: parent, protos: make(map[string]map[uint64]*TrafficStats)}
+}
+
+// frameIn records an ingress frame of the given wire size.
+func (m *trafficMeter) frameIn(size uint64) {
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.total.addIngress(size)
+		m.mu.Unlock()
+	}
+}
+
+// frameOut records an egress frame of the given wire size.
+func (m *trafficMeter) frameOut(size uint64) {
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.total.addEgress(size)
+		m.mu.Unlock()
+	}
+}
+
+// msgIn records an ingress subprotocol message.
+func (m *trafficMeter) msgIn(proto string, code uint64, size uint32) {
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.codeStats(proto, code).addIngress(uint64(size))
+		m.mu.Unlock()
+	}
+}
+
+// msgOut records an egress subprotocol message.
+func (m *trafficMeter) msgOut(proto string, code uint64, size uint32) {
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.codeStats(proto, code).addEgress(uint64(size))
+		m.mu.Unlock()
+	}
+}
+
+// codeStats returns the counters for a message code.
+// It must be called with m.mu held.
+func (m *trafficMeter) codeStats(proto string, code uint64) *TrafficStats {
+	codes := m.protos[proto]
+	if codes == nil {
+		codes = make(map[uint64]*TrafficStats)
+		m.protos[proto] = codes
+	}
+	s := codes[code]
+	if s == nil {
+		s = new(TrafficStats)
+		codes[code] = s
+	}
+	return s
+}
+
+// snapshot returns a copy of the current counters.
+func (m *trafficMeter) snapshot() Traffic {
+	t := Traffic{Protocols: make(map[string]map[uint64]TrafficStats)}
+	if m == nil {
+		return t
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.Total = m.total
+	for name, codes := range m.protos {
+		cp := make(map[uint64]TrafficStats, len(codes))
+		for code, s := range codes {
+			cp[code] = *s
+		}
+		t.Protocols[name] = cp
+	}
+	return t
+}
@@ -120,6 +120,13 @@ func ExtIP(ip net.IP) Interface {
 	return extIP(ip)
 }
 
+// IsExtIP reports whether m was created by ExtIP,
+// i.e. whether the external address was configured manually.
+func IsExtIP(m Interface) bool {
+	_, ok := m.(extIP)
+	return ok
+}
+
 type extIP net.IP
 
 func (n extIP) ExternalIP() (net.IP, error) { return net.IP(n), nil }
@@ -0,0 +1,176 @@
+// Package netutil contains extensions to the net package.
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Netlist is a list of IP networks.
+type Netlist []net.IPNet
+
+// ParseNetlist parses a comma-separated list of CIDR masks.
+// Whitespace and extra commas are ignored.
+func ParseNetlist(s string) (*Netlist, error) {
+	ws := strings.NewReplacer(" ", "", "\n", "", "\t", "")
+	masks := strings.Split(ws.Replace(s), ",")
+	l := make(Netlist, 0, len(masks))
+	for _, mask := range masks {
+		if mask == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(mask)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, *n)
+	}
+	return &l, nil
+}
+
+// Add parses a CIDR mask and appends it to the list. It panics for
+// invalid masks and is intended to be used for setting up static lists.
+func (l *Netlist) Add(cidr string) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	*l = append(*l, *n)
+}
+
+// Contains reports whether the given IP is contained in the list.
+func (l *Netlist) Contains(ip net.IP) bool {
+	if l == nil {
+		return false
+	}
+	for _, net := range *l {
+		if net.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the list as a comma-separated list of CIDR masks.
+func (l Netlist) String() string {
+	masks := make([]string, len(l))
+	for i, n := range l {
+		masks[i] = n.String()
+	}
+	return strings.Join(masks, ",")
+}
+
+var lan4, lan6 Netlist
+
+func init() {
+	// Lists from RFC 5735, RFC 5156 and RFC 4193.
+	lan4.Add("0.0.0.0/8")      // "This" network
+	lan4.Add("10.0.0.0/8")     // Private Use
+	lan4.Add("127.0.0.0/8")    // Loopback
+	lan4.Add("169.254.0.0/16") // Link Local
+	lan4.Add("172.16.0.0/12")  // Private Use
+	lan4.Add("192.168.0.0/16") // Private Use
+	lan6.Add("::1/128")        // Loopback
+	lan6.Add("fe80::/10")      // Link Local
+	lan6.Add("fc00::/7")       // Unique-Local
+}
+
+// IsLAN reports whether an IP is a local network address.
+func IsLAN(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		return lan4.Contains(ip4)
+	}
+	return lan6.Contains(ip)
+}
+
+// DistinctNetSet tracks IPs, ensuring that at most Limit
+// of them fall into the same network range.
+type DistinctNetSet struct {
+	Subnet uint // number of common prefix bits
+	Limit  uint // maximum number of IPs in each subnet
+
+	members map[string]uint
+}
+
+// Add adds an IP address to the set. It returns false (and doesn't add the IP) if the
+// number of existing IPs in the defined range exceeds the limit.
+func (s *DistinctNetSet) Add(ip net.IP) bool {
+	key := s.key(ip)
+	if s.members[key] >= s.Limit {
+		return false
+	}
+	if s.members == nil {
+		s.members = make(map[string]uint)
+	}
+	s.members[key]++
+	return true
+}
+
+// Remove removes an IP from the set.
+func (s *DistinctNetSet) Remove(ip net.IP) {
+	key := s.key(ip)
+	if n, ok := s.members[key]; ok {
+		if n == 1 {
+			delete(s.members, key)
+		} else {
+			s.members[key] = n - 1
+		}
+	}
+}
+
+// Contains reports whether the given IP is contained in the set.
+func (s DistinctNetSet) Contains(ip net.IP) bool {
+	_, ok := s.members[s.key(ip)]
+	return ok
+}
+
+// Len returns the number of tracked IPs.
+func (s DistinctNetSet) Len() int {
+	n := uint(0)
+	for _, i := range s.members {
+		n += i
+	}
+	return int(n)
+}
+
+// Counts returns the number of tracked IPs in each subnet,
+// keyed by the subnet in CIDR notation.
+func (s DistinctNetSet) Counts() map[string]uint {
+	counts := make(map[string]uint, len(s.members))
+	for key, n := range s.members {
+		counts[key] = n
+	}
+	return counts
+}
+
+// key returns the subnet of ip in CIDR notation. The prefix length is
+// applied to the 4-byte form of IPv4 addresses and to the 16-byte form
+// of all other addresses.
+func (s DistinctNetSet) key(ip net.IP) string {
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	prefix := int(s.Subnet)
+	if prefix > bits {
+		prefix = bits
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// String implements fmt.Stringer.
+func (s DistinctNetSet) String() string {
+	keys := make([]string, 0, len(s.members))
+	for key := range s.members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", key, s.members[key])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,118 @@
+package netutil
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetlist(t *testing.T) {
+	var tests = []struct {
+		input    string
+		wantErr  error
+		wantList *Netlist
+	}{
+		{
+			input:    "",
+			wantList: &Netlist{},
+		},
+		{
+			input:    "127.0.0.0/8",
+			wantErr:  nil,
+			wantList: &Netlist{{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}},
+		},
+		{
+			input:   "127.0.0.0/44",
+			wantErr: &net.ParseError{Type: "CIDR address", Text: "127.0.0.0/44"},
+		},
+		{
+			input: "127.0.0.0/16, 23.23.23.23/24,",
+			wantList: &Netlist{
+				{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(16, 32)},
+				{IP: net.IP{23, 23, 23, 0}, Mask: net.CIDRMask(24, 32)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		l, err := ParseNetlist(test.input)
+		if !reflect.DeepEqual(err, test.wantErr) {
+			t.Errorf("%q: got error %q, want %q", test.input, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(l, test.wantList) {
+			t.Errorf("%q: got %v, want %v", test.input, l, test.wantList)
+		}
+	}
+}
+
+func TestNetlistContains(t *testing.T) {
+	var l Netlist
+	l.Add("127.0.0.0/8")
+	l.Add("10.0.0.0/8")
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3"} {
+		if !l.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s not contained in %v", ip, l)
+		}
+	}
+	for _, ip := range []string{"192.168.0.1", "11.0.0.1"} {
+		if l.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s contained in %v", ip, l)
+		}
+	}
+	if (*Netlist)(nil).Contains(net.ParseIP("127.0.0.1")) {
+		t.Error("nil list contains IP")
+	}
+}
+
+func TestIsLAN(t *testing.T) {
+	lan := []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "172.16.5.5", "::1", "fe80::1"}
+	wan := []string{"1.2.3.4", "8.8.8.8", "172.32.0.1", "2001:db8::1"}
+	for _, s := range lan {
+		if !IsLAN(net.ParseIP(s)) {
+			t.Errorf("IsLAN(%s) returned false", s)
+		}
+	}
+	for _, s := range wan {
+		if IsLAN(net.ParseIP(s)) {
+			t.Errorf("IsLAN(%s) returned true", s)
+		}
+	}
+}
+
+func TestDistinctNetSet(t *testing.T) {
+	set := DistinctNetSet{Subnet: 24, Limit: 2}
+	ips := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.3.1", true},
+		{"1.2.3.2", true},
+		{"1.2.3.3", false}, // third in 1.2.3.0/24
+		{"1.2.4.1", true},
+		{"::ffff:1.2.3.4", false}, // IPv4-mapped address of the same subnet
+		{"2001:db8::1", true},
+	}
+	for _, tt := range ips {
+		if ok := set.Add(net.ParseIP(tt.ip)); ok != tt.want {
+			t.Errorf("Add(%s) returned %t, want %t", tt.ip, ok, tt.want)
+		}
+	}
+	if n := set.Len(); n != 4 {
+		t.Errorf("Len() = %d, want 4", n)
+	}
+	want := map[string]uint{"1.2.3.0/24": 2, "1.2.4.0/24": 1, "2001:d00::/24": 1}
+	if counts := set.Counts(); !reflect.DeepEqual(counts, want) {
+		t.Errorf("Counts() = %v, want %v", counts, want)
+	}
+
+	set.Remove(net.ParseIP("1.2.3.1"))
+	if !set.Add(net.ParseIP("1.2.3.3")) {
+		t.Errorf("Add after Remove failed")
+	}
+	set.Remove(net.ParseIP("1.2.4.1"))
+	if set.Contains(net.ParseIP("1.2.4.9")) {
+		t.Errorf("set still contains 1.2.4.0/24 after Remove")
+	}
+}
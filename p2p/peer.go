@@ -16,7 +16,9 @@ import (
 )
 
 const (
-	baseProtocolVersion    = 4
+	baseProtocolVersion    = 5
+	minProtocolVersion     = 4 // oldest base protocol version we can talk to
+	snappyProtocolVersion  = 5 // first base protocol version with compression
 	baseProtocolLength     = uint64(16)
 	baseProtocolMaxMsgSize = 2 * 1024
 
@@ -51,6 +53,8 @@ type Peer struct {
 	protoErr chan error
 	closed   chan struct{}
 	disc     chan DiscReason
+
+	ban func(discover.NodeID, time.Duration, string) // set by Server
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -83,6 +87,11 @@ func (p *Peer) RemoteAddr() net.Addr {
 	return p.rw.fd.RemoteAddr()
 }
 
+// Traffic returns a snapshot of the peer's traffic counters.
+func (p *Peer) Traffic() Traffic {
+	return p.rw.meter.snapshot()
+}
+
 // LocalAddr returns the local address of the network connection.
 func (p *Peer) LocalAddr() net.Addr {
 	return p.rw.fd.LocalAddr()
@@ -97,6 +106,16 @@ func (p *Peer) Disconnect(reason DiscReason) {
 	}
 }
 
+// Ban disconnects the peer and prevents its node ID from connecting
+// again for the given duration. A zero duration bans it permanently.
+// Protocol handlers can use Ban to punish protocol violations.
+func (p *Peer) Ban(d time.Duration, reason string) {
+	if p.ban != nil {
+		p.ban(p.ID(), d, reason)
+	}
+	p.Disconnect(DiscUselessPeer)
+}
+
 // String implements fmt.Stringer.
 func (p *Peer) String() string {
 	return fmt.Sprintf("Peer %x %v", p.rw.id[:8], p.RemoteAddr())
@@ -104,6 +123,9 @@ func (p *Peer) String() string {
 
 func newPeer(conn *conn, protocols []Protocol) *Peer {
 	protomap := matchProtocols(protocols, conn.caps, conn)
+	for _, proto := range protomap {
+		proto.meter = conn.meter
+	}
 	p := &Peer{
 		rw:       conn,
 		running:  protomap,
@@ -230,14 +252,20 @@ func countMatchingProtocols(protocols []Protocol, caps []Cap) int {
 }
 
 // matchProtocols creates structures for matching named subprotocols.
+// If several versions of a protocol are shared, the highest one is used.
 func matchProtocols(protocols []Protocol, caps []Cap, rw MsgReadWriter) map[string]*protoRW {
-	sort.Sort(capsByName(caps))
+	sort.Sort(capsByNameAndVersion(caps))
 	offset := baseProtocolLength
 	result := make(map[string]*protoRW)
 outer:
 	for _, cap := range caps {
 		for _, proto := range protocols {
-			if proto.Name == cap.Name && proto.Version == cap.Version && result[cap.Name] == nil {
+			if proto.Name == cap.Name && proto.Version == cap.Version {
+				// Caps are sorted by version, so any earlier match
+				// is an older version. Release its message codes.
+				if old := result[cap.Name]; old != nil {
+					offset -= old.Length
+				}
 				result[cap.Name] = &protoRW{Protocol: proto, offset: offset, in: make(chan Msg), w: rw}
 				offset += proto.Length
 				continue outer
@@ -284,20 +312,27 @@ type protoRW struct {
 	closed <-chan struct{}
 	offset uint64
 	w      MsgWriter
+	meter  *trafficMeter // may be nil
 }
 
 func (rw *protoRW) WriteMsg(msg Msg) error {
 	if msg.Code >= rw.Length {
 		return newPeerError(errInvalidMsgCode, "not handled")
 	}
+	code, size := msg.Code, msg.Size
 	msg.Code += rw.offset
-	return rw.w.WriteMsg(msg)
+	if err := rw.w.WriteMsg(msg); err != nil {
+		return err
+	}
+	rw.meter.msgOut(rw.Name, code, size)
+	return nil
 }
 
 func (rw *protoRW) ReadMsg() (Msg, error) {
 	select {
 	case msg := <-rw.in:
 		msg.Code -= rw.offset
+		rw.meter.msgIn(rw.Name, msg.Code, msg.Size)
 		return msg, nil
 	case <-rw.closed:
 		return Msg{}, io.EOF
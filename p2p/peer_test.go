@@ -102,6 +102,49 @@ func TestPeerProtoEncodeMsg(t *testing.T) {
 	}
 }
 
+func TestPeerTrafficMeter(t *testing.T) {
+	done := make(chan struct{})
+	proto := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			if err := ExpectMsg(rw, 2, []uint{1}); err != nil {
+				t.Error(err)
+			}
+			if err := SendItems(rw, 3, "foo"); err != nil {
+				t.Error(err)
+			}
+			close(done)
+			return nil
+		},
+	}
+	fd1, fd2 := net.Pipe()
+	parent := newTrafficMeter(nil)
+	c1 := &conn{fd: fd1, transport: newTestTransport(randomID(), fd1), meter: newTrafficMeter(parent)}
+	c2 := &conn{fd: fd2, transport: newTestTransport(randomID(), fd2)}
+	c1.caps, c2.caps = []Cap{proto.cap()}, []Cap{proto.cap()}
+	peer := newPeer(c1, []Protocol{proto})
+	go peer.run()
+	defer c2.close(errors.New("test done"))
+
+	Send(c2, baseProtocolLength+2, []uint{1})
+	if err := ExpectMsg(c2, baseProtocolLength+3, []string{"foo"}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := map[uint64]TrafficStats{
+		2: {IngressBytes: 2, IngressPackets: 1},
+		3: {EgressBytes: 5, EgressPackets: 1},
+	}
+	if got := peer.Traffic().Protocols["a"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("peer traffic mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+	if got := parent.snapshot().Protocols["a"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregate traffic mismatch:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
 func TestPeerPing(t *testing.T) {
 	closer, rw, _, _ := testPeer(nil)
 	defer closer()
@@ -196,3 +239,69 @@ func TestNewPeer(t *testing.T) {
 
 	p.Disconnect(DiscAlreadyConnected) // Should not hang
 }
+
+func TestMatchProtocols(t *testing.T) {
+	tests := []struct {
+		local  []Protocol
+		remote []Cap
+		match  map[string]protoRW
+	}{
+		{
+			// No shared protocols.
+			local:  []Protocol{{Name: "a", Version: 1, Length: 1}},
+			remote: []Cap{{"b", 1}},
+			match:  map[string]protoRW{},
+		},
+		{
+			// Only exact name and version matches are used.
+			local:  []Protocol{{Name: "a", Version: 1, Length: 1}, {Name: "b", Version: 1, Length: 1}},
+			remote: []Cap{{"a", 2}, {"b", 1}},
+			match:  map[string]protoRW{"b": {Protocol: Protocol{Version: 1}, offset: baseProtocolLength}},
+		},
+		{
+			// Offsets are assigned in name order.
+			local:  []Protocol{{Name: "b", Version: 1, Length: 3}, {Name: "a", Version: 1, Length: 2}},
+			remote: []Cap{{"b", 1}, {"a", 1}},
+			match: map[string]protoRW{
+				"a": {Protocol: Protocol{Version: 1}, offset: baseProtocolLength},
+				"b": {Protocol: Protocol{Version: 1}, offset: baseProtocolLength + 2},
+			},
+		},
+		{
+			// The highest shared version wins and releases
+			// the message codes of older versions.
+			local: []Protocol{
+				{Name: "a", Version: 1, Length: 5},
+				{Name: "a", Version: 2, Length: 7},
+				{Name: "a", Version: 3, Length: 9},
+				{Name: "b", Version: 1, Length: 2},
+			},
+			remote: []Cap{{"b", 1}, {"a", 2}, {"a", 1}, {"a", 4}},
+			match: map[string]protoRW{
+				"a": {Protocol: Protocol{Version: 2}, offset: baseProtocolLength},
+				"b": {Protocol: Protocol{Version: 1}, offset: baseProtocolLength + 7},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		result := matchProtocols(tt.local, tt.remote, nil)
+		if len(result) != len(tt.match) {
+			t.Errorf("test %d: matched %d protocols, want %d", i, len(result), len(tt.match))
+			continue
+		}
+		for name, want := range tt.match {
+			got := result[name]
+			if got == nil {
+				t.Errorf("test %d: protocol %q not matched", i, name)
+				continue
+			}
+			if got.Version != want.Version {
+				t.Errorf("test %d: %q version mismatch: got %d, want %d", i, name, got.Version, want.Version)
+			}
+			if got.offset != want.offset {
+				t.Errorf("test %d: %q offset mismatch: got %d, want %d", i, name, got.offset, want.offset)
+			}
+		}
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
+	mrand "math/rand"
 	"net"
 	"sync"
 	"time"
@@ -22,8 +25,13 @@ import (
 	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/syndtr/gosnappy/snappy"
 )
 
+// errPlainMessageTooLarge is returned if a decompressed message length exceeds
+// the allowed 24 bits (i.e. length >= 16MB).
+var errPlainMessageTooLarge = errors.New("message length >= 16MB")
+
 const (
 	maxUint24 = ^uint32(0) >> 8
 
@@ -52,7 +60,8 @@ const (
 // rlpx is the transport protocol used by actual (non-test) connections.
 // It wraps the frame encoder with locks and read/write deadlines.
 type rlpx struct {
-	fd net.Conn
+	fd    net.Conn
+	meter *trafficMeter // counts frames once the handshake is done, may be nil
 
 	rmu, wmu sync.Mutex
 	rw       *rlpxFrameRW
@@ -108,6 +117,10 @@ func (t *rlpx) doProtoHandshake(our *protoHandshake) (their *protoHandshake, err
 	if err := <-werr; err != nil {
 		return nil, fmt.Errorf("write error: %v", err)
 	}
+	// If both sides support it, compress all subsequent messages.
+	if our.Version >= snappyProtocolVersion && their.Version >= snappyProtocolVersion {
+		t.rw.snappy = true
+	}
 	return their, nil
 }
 
@@ -136,7 +149,7 @@ func readProtocolHandshake(rw MsgReader, our *protoHandshake) (*protoHandshake,
 		return nil, err
 	}
 	// validate handshake info
-	if hs.Version != our.Version {
+	if hs.Version < minProtocolVersion {
 		return nil, DiscIncompatibleVersion
 	}
 	if (hs.ID == discover.NodeID{}) {
@@ -160,6 +173,7 @@ func (t *rlpx) doEncHandshake(prv *ecdsa.PrivateKey, dial *discover.Node) (disco
 	}
 	t.wmu.Lock()
 	t.rw = newRLPXFrameRW(t.fd, sec)
+	t.rw.meter = t.meter
 	t.wmu.Unlock()
 	return sec.RemoteID, nil
 }
@@ -222,6 +236,29 @@ func (h *encHandshake) ecdhShared(prv *ecdsa.PrivateKey) ([]byte, error) {
 	return ecies.ImportECDSA(prv).GenerateShared(h.remotePub, sskLen, sskLen)
 }
 
+// RLPx v4 handshake auth (defined in EIP-8).
+type authMsgV4 struct {
+	gotPlain bool // whether read packet had plain format.
+
+	Signature       [sigLen]byte
+	InitiatorPubkey [pubLen]byte
+	Nonce           [shaLen]byte
+	Version         uint
+
+	// Ignore additional fields (forward-compatibility)
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// RLPx v4 handshake response (defined in EIP-8).
+type authRespV4 struct {
+	RandomPubkey [pubLen]byte
+	Nonce        [shaLen]byte
+	Version      uint
+
+	// Ignore additional fields (forward-compatibility)
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
 // initiatorEncHandshake negotiates a session token on conn.
 // it should be called on the dialing side of the connection.
 //
@@ -232,22 +269,29 @@ func initiatorEncHandshake(conn io.ReadWriter, prv *ecdsa.PrivateKey, remoteID d
 	if err != nil {
 		return s, err
 	}
-	auth, err := h.authMsg(prv, token)
+	authMsg, err := h.makeAuthMsg(prv, token)
+	if err != nil {
+		return s, err
+	}
+	// Send the auth message in the pre-EIP-8 format, which all nodes
+	// understand. Nodes supporting EIP-8 reply in the same format.
+	authPacket, err := authMsg.sealPlain(h)
 	if err != nil {
 		return s, err
 	}
-	if _, err = conn.Write(auth); err != nil {
+	if _, err = conn.Write(authPacket); err != nil {
 		return s, err
 	}
 
-	response := make([]byte, encAuthRespLen)
-	if _, err = io.ReadFull(conn, response); err != nil {
+	authRespMsg := new(authRespV4)
+	authRespPacket, err := readHandshakeMsg(authRespMsg, encAuthRespLen, prv, conn)
+	if err != nil {
 		return s, err
 	}
-	if err := h.decodeAuthResp(response, prv); err != nil {
+	if err := h.handleAuthResp(authRespMsg); err != nil {
 		return s, err
 	}
-	return h.secrets(auth, response)
+	return h.secrets(authPacket, authRespPacket)
 }
 
 func newInitiatorHandshake(remoteID discover.NodeID) (*encHandshake, error) {
@@ -275,9 +319,8 @@ func newInitiatorHandshake(remoteID discover.NodeID) (*encHandshake, error) {
 	return h, nil
 }
 
-// authMsg creates an encrypted initiator handshake message.
-func (h *encHandshake) authMsg(prv *ecdsa.PrivateKey, token []byte) ([]byte, error) {
-	var tokenFlag byte
+// makeAuthMsg creates the initiator handshake message.
+func (h *encHandshake) makeAuthMsg(prv *ecdsa.PrivateKey, token []byte) (*authMsgV4, error) {
 	if token == nil {
 		// no session token found means we need to generate shared secret.
 		// ecies shared secret is used as initial session token for new peers
@@ -286,9 +329,6 @@ func (h *encHandshake) authMsg(prv *ecdsa.PrivateKey, token []byte) ([]byte, err
 		if token, err = h.ecdhShared(prv); err != nil {
 			return nil, err
 		}
-	} else {
-		// for known peers, we use stored token from the previous session
-		tokenFlag = 0x01
 	}
 
 	// sign known message:
@@ -300,32 +340,19 @@ func (h *encHandshake) authMsg(prv *ecdsa.PrivateKey, token []byte) ([]byte, err
 		return nil, err
 	}
 
-	// encode auth message
-	// signature || sha3(ecdhe-random-pubk) || pubk || nonce || token-flag
-	msg := make([]byte, authMsgLen)
-	n := copy(msg, signature)
-	n += copy(msg[n:], crypto.Sha3(exportPubkey(&h.randomPrivKey.PublicKey)))
-	n += copy(msg[n:], crypto.FromECDSAPub(&prv.PublicKey)[1:])
-	n += copy(msg[n:], h.initNonce)
-	msg[n] = tokenFlag
-
-	// encrypt auth message using remote-pubk
-	return ecies.Encrypt(rand.Reader, h.remotePub, msg, nil, nil)
+	msg := new(authMsgV4)
+	copy(msg.Signature[:], signature)
+	copy(msg.InitiatorPubkey[:], crypto.FromECDSAPub(&prv.PublicKey)[1:])
+	copy(msg.Nonce[:], h.initNonce)
+	msg.Version = 4
+	return msg, nil
 }
 
-// decodeAuthResp decode an encrypted authentication response message.
-func (h *encHandshake) decodeAuthResp(auth []byte, prv *ecdsa.PrivateKey) error {
-	msg, err := crypto.Decrypt(prv, auth)
-	if err != nil {
-		return fmt.Errorf("could not decrypt auth response (%v)", err)
-	}
-	h.respNonce = msg[pubLen : pubLen+shaLen]
-	h.remoteRandomPub, err = importPublicKey(msg[:pubLen])
-	if err != nil {
-		return err
-	}
-	// ignore token flag for now
-	return nil
+// handleAuthResp processes the receiver's handshake response.
+func (h *encHandshake) handleAuthResp(msg *authRespV4) (err error) {
+	h.respNonce = msg.Nonce[:]
+	h.remoteRandomPub, err = importPublicKey(msg.RandomPubkey[:])
+	return err
 }
 
 // receiverEncHandshake negotiates a session token on conn.
@@ -335,56 +362,61 @@ func (h *encHandshake) decodeAuthResp(auth []byte, prv *ecdsa.PrivateKey) error
 // token is the token from a previous session with this node.
 func receiverEncHandshake(conn io.ReadWriter, prv *ecdsa.PrivateKey, token []byte) (s secrets, err error) {
 	// read remote auth sent by initiator.
-	auth := make([]byte, encAuthMsgLen)
-	if _, err := io.ReadFull(conn, auth); err != nil {
+	authMsg := new(authMsgV4)
+	authPacket, err := readHandshakeMsg(authMsg, encAuthMsgLen, prv, conn)
+	if err != nil {
 		return s, err
 	}
-	h, err := decodeAuthMsg(prv, token, auth)
-	if err != nil {
+	h := new(encHandshake)
+	if err := h.handleAuthMsg(authMsg, prv, token); err != nil {
 		return s, err
 	}
 
-	// send auth response
-	resp, err := h.authResp(prv, token)
+	// send auth response in the format of the auth message
+	authRespMsg, err := h.makeAuthResp()
 	if err != nil {
 		return s, err
 	}
-	if _, err = conn.Write(resp); err != nil {
-		return s, err
+	var authRespPacket []byte
+	if authMsg.gotPlain {
+		authRespPacket, err = authRespMsg.sealPlain(h)
+	} else {
+		authRespPacket, err = sealEIP8(authRespMsg, h)
 	}
-
-	return h.secrets(auth, resp)
-}
-
-func decodeAuthMsg(prv *ecdsa.PrivateKey, token []byte, auth []byte) (*encHandshake, error) {
-	var err error
-	h := new(encHandshake)
-	// generate random keypair for session
-	h.randomPrivKey, err = ecies.GenerateKey(rand.Reader, crypto.S256(), nil)
 	if err != nil {
-		return nil, err
-	}
-	// generate random nonce
-	h.respNonce = make([]byte, shaLen)
-	if _, err = rand.Read(h.respNonce); err != nil {
-		return nil, err
+		return s, err
 	}
-
-	msg, err := crypto.Decrypt(prv, auth)
-	if err != nil {
-		return nil, fmt.Errorf("could not decrypt auth message (%v)", err)
+	if _, err = conn.Write(authRespPacket); err != nil {
+		return s, err
 	}
+	return h.secrets(authPacket, authRespPacket)
+}
 
-	// decode message parameters
-	// signature || sha3(ecdhe-random-pubk) || pubk || nonce || token-flag
-	h.initNonce = msg[authMsgLen-shaLen-1 : authMsgLen-1]
-	copy(h.remoteID[:], msg[sigLen+shaLen:sigLen+shaLen+pubLen])
+// handleAuthMsg processes the initiator's handshake message.
+func (h *encHandshake) handleAuthMsg(msg *authMsgV4, prv *ecdsa.PrivateKey, token []byte) error {
+	// Import the remote identity.
+	h.initNonce = msg.Nonce[:]
+	h.remoteID = msg.InitiatorPubkey
 	rpub, err := h.remoteID.Pubkey()
 	if err != nil {
-		return nil, fmt.Errorf("bad remoteID: %#v", err)
+		return fmt.Errorf("bad remoteID: %#v", err)
 	}
 	h.remotePub = ecies.ImportECDSAPublic(rpub)
 
+	// Generate random keypair and nonce for the session.
+	if h.randomPrivKey == nil {
+		h.randomPrivKey, err = ecies.GenerateKey(rand.Reader, crypto.S256(), nil)
+		if err != nil {
+			return err
+		}
+	}
+	if h.respNonce == nil {
+		h.respNonce = make([]byte, shaLen)
+		if _, err = rand.Read(h.respNonce); err != nil {
+			return err
+		}
+	}
+
 	// recover remote random pubkey from signed message.
 	if token == nil {
 		// TODO: it is an error if the initiator has a token and we don't. check that.
@@ -393,32 +425,120 @@ func decodeAuthMsg(prv *ecdsa.PrivateKey, token []byte, auth []byte) (*encHandsh
 		// ecies shared secret is used as initial session token for new peers.
 		// generate shared key from prv and remote pubkey.
 		if token, err = h.ecdhShared(prv); err != nil {
-			return nil, err
+			return err
 		}
 	}
 	signedMsg := xor(token, h.initNonce)
-	remoteRandomPub, err := secp256k1.RecoverPubkey(signedMsg, msg[:sigLen])
+	remoteRandomPub, err := secp256k1.RecoverPubkey(signedMsg, msg.Signature[:])
 	if err != nil {
-		return nil, err
+		return err
 	}
 	h.remoteRandomPub, _ = importPublicKey(remoteRandomPub)
-	return h, nil
+	return nil
 }
 
-// authResp generates the encrypted authentication response message.
-func (h *encHandshake) authResp(prv *ecdsa.PrivateKey, token []byte) ([]byte, error) {
-	// responder auth message
-	// E(remote-pubk, ecdhe-random-pubk || nonce || 0x0)
-	resp := make([]byte, authRespLen)
-	n := copy(resp, exportPubkey(&h.randomPrivKey.PublicKey))
-	n += copy(resp[n:], h.respNonce)
-	if token == nil {
-		resp[n] = 0
-	} else {
-		resp[n] = 1
+// makeAuthResp creates the receiver's handshake response.
+func (h *encHandshake) makeAuthResp() (*authRespV4, error) {
+	msg := new(authRespV4)
+	copy(msg.RandomPubkey[:], exportPubkey(&h.randomPrivKey.PublicKey))
+	copy(msg.Nonce[:], h.respNonce)
+	msg.Version = 4
+	return msg, nil
+}
+
+// sealPlain encrypts the auth message in the pre-EIP-8 format:
+// signature || sha3(ecdhe-random-pubk) || pubk || nonce || token-flag
+func (msg *authMsgV4) sealPlain(h *encHandshake) ([]byte, error) {
+	buf := make([]byte, authMsgLen)
+	n := copy(buf, msg.Signature[:])
+	n += copy(buf[n:], crypto.Sha3(exportPubkey(&h.randomPrivKey.PublicKey)))
+	n += copy(buf[n:], msg.InitiatorPubkey[:])
+	n += copy(buf[n:], msg.Nonce[:])
+	buf[n] = 0 // token-flag
+	return ecies.Encrypt(rand.Reader, h.remotePub, buf, nil, nil)
+}
+
+func (msg *authMsgV4) decodePlain(input []byte) {
+	n := copy(msg.Signature[:], input)
+	n += shaLen // skip sha3(initiator-ephemeral-pubk)
+	n += copy(msg.InitiatorPubkey[:], input[n:])
+	copy(msg.Nonce[:], input[n:])
+	msg.Version = 4
+	msg.gotPlain = true
+}
+
+// sealPlain encrypts the auth response in the pre-EIP-8 format:
+// ecdhe-random-pubk || nonce || token-flag
+func (msg *authRespV4) sealPlain(h *encHandshake) ([]byte, error) {
+	buf := make([]byte, authRespLen)
+	n := copy(buf, msg.RandomPubkey[:])
+	copy(buf[n:], msg.Nonce[:])
+	return ecies.Encrypt(rand.Reader, h.remotePub, buf, nil, nil)
+}
+
+func (msg *authRespV4) decodePlain(input []byte) {
+	n := copy(msg.RandomPubkey[:], input)
+	copy(msg.Nonce[:], input[n:])
+	msg.Version = 4
+}
+
+var padSpace = make([]byte, 300)
+
+// sealEIP8 encrypts a handshake message in the EIP-8 format. The RLP
+// encoding of msg is followed by random padding and encrypted. The
+// packet is prefixed with its big-endian 16 bit size, which is also
+// authenticated by ECIES.
+func sealEIP8(msg interface{}, h *encHandshake) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := rlp.Encode(buf, msg); err != nil {
+		return nil, err
+	}
+	// pad with random amount of data. the amount needs to be at least 100 bytes to make
+	// the message distinguishable from pre-EIP-8 handshakes.
+	pad := padSpace[:mrand.Intn(len(padSpace)-100)+100]
+	buf.Write(pad)
+	prefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(prefix, uint16(buf.Len()+eciesBytes))
+
+	enc, err := ecies.Encrypt(rand.Reader, h.remotePub, buf.Bytes(), nil, prefix)
+	return append(prefix, enc...), err
+}
+
+type plainDecoder interface {
+	decodePlain([]byte)
+}
+
+// readHandshakeMsg reads an encrypted handshake message in either
+// format. plainSize is the size of the pre-EIP-8 encoding.
+func readHandshakeMsg(msg plainDecoder, plainSize int, prv *ecdsa.PrivateKey, r io.Reader) ([]byte, error) {
+	buf := make([]byte, plainSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return buf, err
+	}
+	// Attempt decoding pre-EIP-8 "plain" format.
+	key := ecies.ImportECDSA(prv)
+	if dec, err := key.Decrypt(rand.Reader, buf, nil, nil); err == nil {
+		msg.decodePlain(dec)
+		return buf, nil
+	}
+	// Could be EIP-8 format, try that.
+	prefix := buf[:2]
+	size := binary.BigEndian.Uint16(prefix)
+	if size < uint16(plainSize) {
+		return buf, fmt.Errorf("size underflow, need at least %d bytes", plainSize)
+	}
+	buf = append(buf, make([]byte, size-uint16(plainSize)+2)...)
+	if _, err := io.ReadFull(r, buf[plainSize:]); err != nil {
+		return buf, err
+	}
+	dec, err := key.Decrypt(rand.Reader, buf[2:], nil, prefix)
+	if err != nil {
+		return buf, fmt.Errorf("could not decrypt handshake message (%v)", err)
 	}
-	// encrypt using remote-pubk
-	return ecies.Encrypt(rand.Reader, h.remotePub, resp, nil, nil)
+	// Can't use rlp.DecodeBytes here because it rejects
+	// trailing data (forward-compatibility).
+	s := rlp.NewStream(bytes.NewReader(dec), 0)
+	return buf, s.Decode(msg)
 }
 
 // importPublicKey unmarshals 512 bit public keys.
@@ -473,6 +593,9 @@ type rlpxFrameRW struct {
 	macCipher  cipher.Block
 	egressMAC  hash.Hash
 	ingressMAC hash.Hash
+
+	meter  *trafficMeter // may be nil
+	snappy bool          // whether message payloads are compressed
 }
 
 func newRLPXFrameRW(conn io.ReadWriter, s secrets) *rlpxFrameRW {
@@ -500,6 +623,22 @@ func newRLPXFrameRW(conn io.ReadWriter, s secrets) *rlpxFrameRW {
 func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
 	ptype, _ := rlp.EncodeToBytes(msg.Code)
 
+	// if snappy is enabled, compress message now
+	if rw.snappy {
+		if msg.Size > maxUint24 {
+			return errPlainMessageTooLarge
+		}
+		payload, err := ioutil.ReadAll(msg.Payload)
+		if err != nil {
+			return err
+		}
+		if payload, err = snappy.Encode(nil, payload); err != nil {
+			return err
+		}
+		msg.Payload = bytes.NewReader(payload)
+		msg.Size = uint32(len(payload))
+	}
+
 	// write header
 	headbuf := make([]byte, 32)
 	fsize := uint32(len(ptype)) + msg.Size
@@ -535,8 +674,21 @@ func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
 	// frame content was written to it as well.
 	fmacseed := rw.egressMAC.Sum(nil)
 	mac := updateMAC(rw.egressMAC, rw.macCipher, fmacseed)
-	_, err := rw.conn.Write(mac)
-	return err
+	if _, err := rw.conn.Write(mac); err != nil {
+		return err
+	}
+	rw.meter.frameOut(frameWireSize(fsize))
+	return nil
+}
+
+// frameWireSize returns the number of bytes occupied on the
+// wire by a frame with the given content size.
+func frameWireSize(fsize uint32) uint64 {
+	size := uint64(fsize)
+	if padding := fsize % 16; padding > 0 {
+		size += uint64(16 - padding)
+	}
+	return 32 + size + 16 // header + padded content + frame MAC
 }
 
 func (rw *rlpxFrameRW) ReadMsg() (msg Msg, err error) {
@@ -577,6 +729,7 @@ func (rw *rlpxFrameRW) ReadMsg() (msg Msg, err error) {
 
 	// decrypt frame content
 	rw.dec.XORKeyStream(framebuf, framebuf)
+	rw.meter.frameIn(frameWireSize(fsize))
 
 	// decode message code
 	content := bytes.NewReader(framebuf[:fsize])
@@ -585,6 +738,25 @@ func (rw *rlpxFrameRW) ReadMsg() (msg Msg, err error) {
 	}
 	msg.Size = uint32(content.Len())
 	msg.Payload = content
+
+	// if snappy is enabled, verify and decompress message
+	if rw.snappy {
+		payload, err := ioutil.ReadAll(msg.Payload)
+		if err != nil {
+			return msg, err
+		}
+		size, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return msg, err
+		}
+		if size > int(maxUint24) {
+			return msg, errPlainMessageTooLarge
+		}
+		if payload, err = snappy.Decode(nil, payload); err != nil {
+			return msg, err
+		}
+		msg.Size, msg.Payload = uint32(size), bytes.NewReader(payload)
+	}
 	return msg, nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"reflect"
@@ -127,15 +128,260 @@ func testEncHandshake(token []byte) error {
 	return nil
 }
 
+// This test checks that the receiver answers an EIP-8
+// auth message in the EIP-8 format, so that both sides derive the
+// same secrets.
+func TestEncHandshakeEIP8Initiator(t *testing.T) {
+	var (
+		prv0, _  = crypto.GenerateKey()
+		prv1, _  = crypto.GenerateKey()
+		fd0, fd1 = net.Pipe()
+		result   = make(chan secrets, 1)
+	)
+	defer fd0.Close()
+	defer fd1.Close()
+	go func() {
+		s, err := receiverEncHandshake(fd1, prv1, nil)
+		if err != nil {
+			t.Errorf("receiver error: %v", err)
+		}
+		result <- s
+	}()
+
+	h, err := newInitiatorHandshake(discover.PubkeyID(&prv1.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMsg, err := h.makeAuthMsg(prv0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authPacket, err := sealEIP8(authMsg, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd0.Write(authPacket); err != nil {
+		t.Fatal(err)
+	}
+	authResp := new(authRespV4)
+	authRespPacket, err := readHandshakeMsg(authResp, encAuthRespLen, prv0, fd0)
+	if err != nil {
+		t.Fatalf("could not read auth response: %v", err)
+	}
+	if len(authRespPacket) <= encAuthRespLen {
+		t.Errorf("auth response has %d bytes, want more than %d (EIP-8 format)", len(authRespPacket), encAuthRespLen)
+	}
+	if err := h.handleAuthResp(authResp); err != nil {
+		t.Fatal(err)
+	}
+	s0, err := h.secrets(authPacket, authRespPacket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1 := <-result
+	if !bytes.Equal(s0.AES, s1.AES) || !bytes.Equal(s0.MAC, s1.MAC) {
+		t.Errorf("secret mismatch:\n initiator: %x %x\n receiver: %x %x", s0.AES, s0.MAC, s1.AES, s1.MAC)
+	}
+	if !bytes.Equal(s0.EgressMAC.Sum(nil), s1.IngressMAC.Sum(nil)) {
+		t.Errorf("egress MAC of initiator doesn't match ingress MAC of receiver")
+	}
+}
+
+// This test checks that auth messages of future versions
+// with additional list elements are accepted.
+func TestHandshakeForwardCompatibility(t *testing.T) {
+	type authMsgV5 struct {
+		Signature       [sigLen]byte
+		InitiatorPubkey [pubLen]byte
+		Nonce           [shaLen]byte
+		Version         uint
+		Extra1          uint
+		Extra2          []byte
+	}
+	var (
+		prv, _ = crypto.GenerateKey()
+		h      = &encHandshake{remotePub: ecies.ImportECDSAPublic(&prv.PublicKey)}
+		msg    = &authMsgV5{Version: 5, Extra1: 3, Extra2: []byte("future")}
+	)
+	rand.Read(msg.Signature[:])
+	rand.Read(msg.InitiatorPubkey[:])
+	rand.Read(msg.Nonce[:])
+	packet, err := sealEIP8(msg, h)
+	if err != nil {
+		t.Fatalf("seal error: %v", err)
+	}
+
+	dec := new(authMsgV4)
+	read, err := readHandshakeMsg(dec, encAuthMsgLen, prv, bytes.NewReader(packet))
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if !bytes.Equal(read, packet) {
+		t.Errorf("read packet doesn't match sent packet")
+	}
+	if dec.gotPlain {
+		t.Errorf("message decoded as plain format")
+	}
+	if dec.Signature != msg.Signature || dec.InitiatorPubkey != msg.InitiatorPubkey || dec.Nonce != msg.Nonce {
+		t.Errorf("field mismatch:\ngot  %s\nwant %s", spew.Sdump(dec), spew.Sdump(msg))
+	}
+	if dec.Version != 5 || len(dec.Rest) != 2 {
+		t.Errorf("got version %d with %d extra elements, want version 5 with 2", dec.Version, len(dec.Rest))
+	}
+
+	// Packets with a modified size prefix are rejected.
+	packet[1]++
+	if _, err := readHandshakeMsg(new(authMsgV4), encAuthMsgLen, prv, bytes.NewReader(packet)); err == nil {
+		t.Errorf("no error for packet with modified size prefix")
+	}
+}
+
+// Inputs and derived secrets of the handshake test vectors in EIP-8.
+var (
+	eip8KeyA, _   = crypto.HexToECDSA("49a7b37aa6f6645917e7b807e9d1c00d4fa71f18343b0d4122a4d2df64dd6fee")
+	eip8KeyB, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	eip8EphA, _   = crypto.HexToECDSA("869d6ecf5211f1cc60418a13b9d870b22959d0c16f02bec714c960dd2298a32d")
+	eip8EphB, _   = crypto.HexToECDSA("e238eb8e04fee6511ab04c6dd3c89ce097b11f25d584863ac2b6d5b35b1847e4")
+	eip8NonceA    = unhex("7e968bba13b6c50e2c4cd7f241cc0d64d1ac25c7f5952df231ac6a2bda8ee5d6")
+	eip8NonceB    = unhex("559aead08264d5795d3909718cdd05abd49572e84fe55590eef31a88a08fdffd")
+	eip8Signature = unhex("299ca6acfd35e3d72d8ba3d1e2b60b5561d5af5218eb5bc182045769eb4226910a301acae3b369fffc4a4899d6b02531e89fd4fe36a2cf0d93607ba470b50f7800")
+	eip8AES       = unhex("80e8632c05fed6fc2a13b0f8d31a3cf645366239170ea067065aba8e28bac487")
+	eip8MAC       = unhex("2ea74ec5dae199227dff1af715362700e989d889d7a493cb0639691efb8e5f98")
+)
+
+// This test runs the EIP-8 test vectors through both message formats.
+// ECIES encryption is randomized, so the packets are sealed here from the
+// vector inputs; the decoded messages and the derived secrets must match
+// the values given in the EIP.
+func TestHandshakeEIP8Vectors(t *testing.T) {
+	authTests := []struct {
+		plain   bool
+		version uint
+		rest    []rlp.RawValue
+	}{
+		{plain: true, version: 4},                                       // Auth₁, RLPx v4 plain encoding
+		{version: 4, rest: []rlp.RawValue{}},                            // Auth₂, EIP-8 encoding
+		{version: 56, rest: []rlp.RawValue{{0x01}, {0xC2, 0x02, 0x03}}}, // Auth₃, additional list elements
+	}
+	ackTests := []struct {
+		plain   bool
+		version uint
+		rest    []rlp.RawValue
+	}{
+		{plain: true, version: 4},            // Ack₁, RLPx v4 plain encoding
+		{version: 4, rest: []rlp.RawValue{}}, // Ack₂, EIP-8 encoding
+		{version: 57, rest: []rlp.RawValue{{0x06}, {0xC2, 0x07, 0x08}, {0x81, 0xFA}}}, // Ack₃, additional list elements
+	}
+	var (
+		initiator = &encHandshake{remotePub: ecies.ImportECDSAPublic(&eip8KeyB.PublicKey), randomPrivKey: ecies.ImportECDSA(eip8EphA)}
+		receiver  = &encHandshake{remotePub: ecies.ImportECDSAPublic(&eip8KeyA.PublicKey), randomPrivKey: ecies.ImportECDSA(eip8EphB)}
+		ephPubB   = exportPubkey(&receiver.randomPrivKey.PublicKey)
+	)
+	for i, tt := range authTests {
+		want := &authMsgV4{Version: tt.version, Rest: tt.rest, gotPlain: tt.plain}
+		copy(want.Signature[:], eip8Signature)
+		copy(want.InitiatorPubkey[:], crypto.FromECDSAPub(&eip8KeyA.PublicKey)[1:])
+		copy(want.Nonce[:], eip8NonceA)
+
+		var packet []byte
+		var err error
+		if tt.plain {
+			packet, err = want.sealPlain(initiator)
+		} else {
+			packet, err = sealEIP8(want, initiator)
+		}
+		if err != nil {
+			t.Fatalf("auth %d: seal error: %v", i+1, err)
+		}
+		msg := new(authMsgV4)
+		read, err := readHandshakeMsg(msg, encAuthMsgLen, eip8KeyB, bytes.NewReader(packet))
+		if err != nil {
+			t.Errorf("auth %d: read error: %v", i+1, err)
+			continue
+		}
+		if !bytes.Equal(read, packet) {
+			t.Errorf("auth %d: read packet doesn't match sent packet", i+1)
+		}
+		if !reflect.DeepEqual(msg, want) {
+			t.Errorf("auth %d: message mismatch:\ngot  %s\nwant %s", i+1, spew.Sdump(msg), spew.Sdump(want))
+		}
+
+		// The receiver must derive the secrets given in the EIP.
+		h := &encHandshake{randomPrivKey: receiver.randomPrivKey, respNonce: eip8NonceB}
+		if err := h.handleAuthMsg(msg, eip8KeyB, nil); err != nil {
+			t.Errorf("auth %d: handleAuthMsg error: %v", i+1, err)
+			continue
+		}
+		if !reflect.DeepEqual(h.remoteRandomPub, &initiator.randomPrivKey.PublicKey) {
+			t.Errorf("auth %d: recovered wrong ephemeral key", i+1)
+		}
+		s, err := h.secrets(packet, nil)
+		if err != nil {
+			t.Errorf("auth %d: secrets error: %v", i+1, err)
+			continue
+		}
+		if !bytes.Equal(s.AES, eip8AES) {
+			t.Errorf("auth %d: AES secret mismatch:\ngot  %x\nwant %x", i+1, s.AES, eip8AES)
+		}
+		if !bytes.Equal(s.MAC, eip8MAC) {
+			t.Errorf("auth %d: MAC secret mismatch:\ngot  %x\nwant %x", i+1, s.MAC, eip8MAC)
+		}
+	}
+	for i, tt := range ackTests {
+		want := &authRespV4{Version: tt.version, Rest: tt.rest}
+		copy(want.RandomPubkey[:], ephPubB)
+		copy(want.Nonce[:], eip8NonceB)
+
+		var packet []byte
+		var err error
+		if tt.plain {
+			packet, err = want.sealPlain(receiver)
+		} else {
+			packet, err = sealEIP8(want, receiver)
+		}
+		if err != nil {
+			t.Fatalf("ack %d: seal error: %v", i+1, err)
+		}
+		msg := new(authRespV4)
+		read, err := readHandshakeMsg(msg, encAuthRespLen, eip8KeyA, bytes.NewReader(packet))
+		if err != nil {
+			t.Errorf("ack %d: read error: %v", i+1, err)
+			continue
+		}
+		if tt.plain != (len(read) == encAuthRespLen) {
+			t.Errorf("ack %d: packet has %d bytes, plain format: %t", i+1, len(read), tt.plain)
+		}
+		if !reflect.DeepEqual(msg, want) {
+			t.Errorf("ack %d: message mismatch:\ngot  %s\nwant %s", i+1, spew.Sdump(msg), spew.Sdump(want))
+		}
+
+		// The initiator must derive the secrets given in the EIP.
+		h := &encHandshake{initiator: true, randomPrivKey: initiator.randomPrivKey, initNonce: eip8NonceA}
+		if err := h.handleAuthResp(msg); err != nil {
+			t.Errorf("ack %d: handleAuthResp error: %v", i+1, err)
+			continue
+		}
+		s, err := h.secrets(nil, packet)
+		if err != nil {
+			t.Errorf("ack %d: secrets error: %v", i+1, err)
+			continue
+		}
+		if !bytes.Equal(s.AES, eip8AES) || !bytes.Equal(s.MAC, eip8MAC) {
+			t.Errorf("ack %d: secret mismatch:\ngot  %x %x\nwant %x %x", i+1, s.AES, s.MAC, eip8AES, eip8MAC)
+		}
+	}
+}
+
 func TestProtocolHandshake(t *testing.T) {
 	var (
 		prv0, _ = crypto.GenerateKey()
 		node0   = &discover.Node{ID: discover.PubkeyID(&prv0.PublicKey), IP: net.IP{1, 2, 3, 4}, TCP: 33}
-		hs0     = &protoHandshake{Version: 3, ID: node0.ID, Caps: []Cap{{"a", 0}, {"b", 2}}}
+		hs0     = &protoHandshake{Version: baseProtocolVersion, ID: node0.ID, Caps: []Cap{{"a", 0}, {"b", 2}}}
 
 		prv1, _ = crypto.GenerateKey()
 		node1   = &discover.Node{ID: discover.PubkeyID(&prv1.PublicKey), IP: net.IP{5, 6, 7, 8}, TCP: 44}
-		hs1     = &protoHandshake{Version: 3, ID: node1.ID, Caps: []Cap{{"c", 1}, {"d", 3}}}
+		hs1     = &protoHandshake{Version: baseProtocolVersion, ID: node1.ID, Caps: []Cap{{"c", 1}, {"d", 3}}}
 
 		fd0, fd1 = net.Pipe()
 		wg       sync.WaitGroup
@@ -164,6 +410,9 @@ func TestProtocolHandshake(t *testing.T) {
 			t.Errorf("dial side proto handshake mismatch:\ngot: %s\nwant: %s\n", spew.Sdump(phs), spew.Sdump(hs1))
 			return
 		}
+		if !snappyEnabled(rlpx) {
+			t.Errorf("dial side snappy compression not enabled")
+		}
 		rlpx.close(DiscQuitting)
 	}()
 	go func() {
@@ -196,6 +445,10 @@ func TestProtocolHandshake(t *testing.T) {
 	wg.Wait()
 }
 
+func snappyEnabled(t transport) bool {
+	return t.(*rlpx).rw.snappy
+}
+
 func TestProtocolHandshakeErrors(t *testing.T) {
 	our := &protoHandshake{Version: 3, Caps: []Cap{{"foo", 2}, {"bar", 3}}, Name: "quux"}
 	id := randomID()
@@ -226,12 +479,12 @@ func TestProtocolHandshakeErrors(t *testing.T) {
 		},
 		{
 			code: handshakeMsg,
-			msg:  &protoHandshake{Version: 9944, ID: id},
+			msg:  &protoHandshake{Version: minProtocolVersion - 1, ID: id},
 			err:  DiscIncompatibleVersion,
 		},
 		{
 			code: handshakeMsg,
-			msg:  &protoHandshake{Version: 3},
+			msg:  &protoHandshake{Version: baseProtocolVersion},
 			err:  DiscInvalidIdentity,
 		},
 	}
@@ -301,6 +554,37 @@ func (h fakeHash) Size() int           { return len(h) }
 func (h fakeHash) Sum(b []byte) []byte { return append(b, h...) }
 
 func TestRLPXFrameRW(t *testing.T) {
+	conn := new(bytes.Buffer)
+	rw1, rw2 := newTestFrameRWPair(conn)
+
+	// send some messages
+	for i := 0; i < 10; i++ {
+		// write message into conn buffer
+		wmsg := []interface{}{"foo", "bar", strings.Repeat("test", i)}
+		err := Send(rw1, uint64(i), wmsg)
+		if err != nil {
+			t.Fatalf("WriteMsg error (i=%d): %v", i, err)
+		}
+
+		// read message that rw1 just wrote
+		msg, err := rw2.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg error (i=%d): %v", i, err)
+		}
+		if msg.Code != uint64(i) {
+			t.Fatalf("msg code mismatch: got %d, want %d", msg.Code, i)
+		}
+		payload, _ := ioutil.ReadAll(msg.Payload)
+		wantPayload, _ := rlp.EncodeToBytes(wmsg)
+		if !bytes.Equal(payload, wantPayload) {
+			t.Fatalf("msg payload mismatch:\ngot  %x\nwant %x", payload, wantPayload)
+		}
+	}
+}
+
+// newTestFrameRWPair creates two frame readers/writers with matching
+// secrets. Messages written by one side can be read by the other.
+func newTestFrameRWPair(conn io.ReadWriter) (*rlpxFrameRW, *rlpxFrameRW) {
 	var (
 		aesSecret      = make([]byte, 16)
 		macSecret      = make([]byte, 16)
@@ -310,8 +594,6 @@ func TestRLPXFrameRW(t *testing.T) {
 	for _, s := range [][]byte{aesSecret, macSecret, egressMACinit, ingressMACinit} {
 		rand.Read(s)
 	}
-	conn := new(bytes.Buffer)
-
 	s1 := secrets{
 		AES:        aesSecret,
 		MAC:        macSecret,
@@ -320,7 +602,6 @@ func TestRLPXFrameRW(t *testing.T) {
 	}
 	s1.EgressMAC.Write(egressMACinit)
 	s1.IngressMAC.Write(ingressMACinit)
-	rw1 := newRLPXFrameRW(conn, s1)
 
 	s2 := secrets{
 		AES:        aesSecret,
@@ -330,29 +611,42 @@ func TestRLPXFrameRW(t *testing.T) {
 	}
 	s2.EgressMAC.Write(ingressMACinit)
 	s2.IngressMAC.Write(egressMACinit)
-	rw2 := newRLPXFrameRW(conn, s2)
+	return newRLPXFrameRW(conn, s1), newRLPXFrameRW(conn, s2)
+}
 
-	// send some messages
-	for i := 0; i < 10; i++ {
-		// write message into conn buffer
-		wmsg := []interface{}{"foo", "bar", strings.Repeat("test", i)}
-		err := Send(rw1, uint64(i), wmsg)
-		if err != nil {
-			t.Fatalf("WriteMsg error (i=%d): %v", i, err)
-		}
+func TestRLPXFrameRWSnappy(t *testing.T) {
+	conn := new(bytes.Buffer)
+	rw1, rw2 := newTestFrameRWPair(conn)
+	rw1.snappy, rw2.snappy = true, true
 
-		// read message that rw1 just wrote
-		msg, err := rw2.ReadMsg()
-		if err != nil {
-			t.Fatalf("ReadMsg error (i=%d): %v", i, err)
-		}
-		if msg.Code != uint64(i) {
-			t.Fatalf("msg code mismatch: got %d, want %d", msg.Code, i)
-		}
-		payload, _ := ioutil.ReadAll(msg.Payload)
-		wantPayload, _ := rlp.EncodeToBytes(wmsg)
-		if !bytes.Equal(payload, wantPayload) {
-			t.Fatalf("msg payload mismatch:\ngot  %x\nwant %x", payload, wantPayload)
-		}
+	wmsg := []interface{}{strings.Repeat("compressible", 1000)}
+	wantPayload, _ := rlp.EncodeToBytes(wmsg)
+	if err := Send(rw1, 8, wmsg); err != nil {
+		t.Fatalf("WriteMsg error: %v", err)
+	}
+	if conn.Len() >= len(wantPayload) {
+		t.Errorf("frame not compressed: %d bytes on the wire for %d byte payload", conn.Len(), len(wantPayload))
+	}
+	msg, err := rw2.ReadMsg()
+	if err != nil {
+		t.Fatalf("ReadMsg error: %v", err)
+	}
+	if msg.Code != 8 || msg.Size != uint32(len(wantPayload)) {
+		t.Errorf("msg mismatch: got code %d, size %d, want code 8, size %d", msg.Code, msg.Size, len(wantPayload))
+	}
+	payload, _ := ioutil.ReadAll(msg.Payload)
+	if !bytes.Equal(payload, wantPayload) {
+		t.Errorf("msg payload mismatch:\ngot  %x\nwant %x", payload, wantPayload)
+	}
+
+	// The decompressed size is checked before decompressing.
+	// This payload claims to decompress to 16MB.
+	rw1.snappy = false
+	huge := []byte{0x80, 0x80, 0x80, 0x08}
+	if err := rw1.WriteMsg(Msg{Code: 8, Size: uint32(len(huge)), Payload: bytes.NewReader(huge)}); err != nil {
+		t.Fatalf("WriteMsg error: %v", err)
+	}
+	if _, err := rw2.ReadMsg(); err != errPlainMessageTooLarge {
+		t.Errorf("ReadMsg returned %v, want %v", err, errPlainMessageTooLarge)
 	}
 }
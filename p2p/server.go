@@ -8,10 +8,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
 )
 
 const (
@@ -22,6 +24,11 @@ const (
 	// Maximum number of concurrently handshaking inbound connections.
 	maxAcceptConns = 50
 
+	// Maximum number of concurrently handshaking inbound connections
+	// from trusted node IPs that are accepted when all regular slots
+	// are taken.
+	maxTrustedAcceptConns = 5
+
 	// Maximum number of concurrently dialing outbound connections.
 	maxActiveDialTasks = 16
 
@@ -33,7 +40,10 @@ const (
 	frameWriteTimeout = 20 * time.Second
 )
 
-var errServerStopped = errors.New("server stopped")
+var (
+	errServerStopped = errors.New("server stopped")
+	errBanned        = errors.New("node is banned")
+)
 
 var srvjslog = logger.NewJsonLogger()
 
@@ -72,7 +82,9 @@ type Server struct {
 	StaticNodes []*discover.Node
 
 	// Trusted nodes are used as pre-configured connections which are always
-	// allowed to connect, even above the peer limit.
+	// allowed to connect, even above the peer limit and the pending
+	// handshake limit. More trusted nodes can be added while the server
+	// is running using AddTrustedPeer.
 	TrustedNodes []*discover.Node
 
 	// NodeDatabase is the path to the database containing the previously seen
@@ -81,7 +93,8 @@ type Server struct {
 
 	// Protocols should contain the protocols supported
 	// by the server. Matching protocols are launched for
-	// each peer.
+	// each peer. If multiple versions of a protocol match,
+	// only the highest one is launched.
 	Protocols []Protocol
 
 	// If ListenAddr is set to a non-nil address, the server
@@ -101,9 +114,36 @@ type Server struct {
 	// is used to dial outbound peer connections.
 	Dialer *net.Dialer
 
+	// If NodeDialer is set to a non-nil value, it is used instead
+	// of Dialer. This allows connections over transports other
+	// than TCP, e.g. in-memory connections in simulations.
+	NodeDialer NodeDialer
+
+	// If EventMux is set to a non-nil value, a PeerEvent is posted
+	// to it whenever a peer is added or dropped, and an
+	// ExternalAddrEvent whenever discovery detects a new external
+	// address. Note that posting blocks until all subscribers have
+	// received the event.
+	EventMux *event.TypeMux
+
 	// If NoDial is true, the server will not dial any peers.
 	NoDial bool
 
+	// If NetRestrict is set to a non-nil value, connections are only
+	// made to and accepted from nodes within the given networks. The
+	// restriction also applies to nodes found by discovery.
+	NetRestrict *netutil.Netlist
+
+	// DiscoveryIPLimits restricts the number of discovery table
+	// entries per IP subnet. If nil, discover.DefaultIPLimits apply.
+	DiscoveryIPLimits *discover.IPLimits
+
+	// DiscoveryEndpointPolicy configures external address detection
+	// from the endpoints reported by discovery peers. If nil,
+	// discover.DefaultEndpointPolicy applies. Detection is disabled
+	// if NAT is an ExtIP.
+	DiscoveryEndpointPolicy *discover.EndpointPolicy
+
 	// Hooks for testing. These are useful because we can inhibit
 	// the whole protocol stack.
 	newTransport func(net.Conn) transport
@@ -115,6 +155,12 @@ type Server struct {
 	ntab         discoverTable
 	listener     net.Listener
 	ourHandshake *protoHandshake
+	traffic      *trafficMeter // aggregate of all peer meters
+
+	bans *discover.BanList
+
+	trustMu sync.Mutex // protects trusted
+	trusted map[discover.NodeID]*discover.Node
 
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
@@ -122,6 +168,7 @@ type Server struct {
 
 	quit          chan struct{}
 	addstatic     chan *discover.Node
+	removestatic  chan *discover.Node
 	posthandshake chan *conn
 	addpeer       chan *conn
 	delpeer       chan *Peer
@@ -130,6 +177,31 @@ type Server struct {
 
 type peerOpFunc func(map[discover.NodeID]*Peer)
 
+// NodeDialer establishes connections to other nodes.
+type NodeDialer interface {
+	Dial(*discover.Node) (net.Conn, error)
+}
+
+// PeerEventType is the type of a PeerEvent.
+type PeerEventType int
+
+const (
+	PeerEventAdd  PeerEventType = iota // peer has passed all handshakes
+	PeerEventDrop                      // peer has disconnected
+)
+
+// PeerEvent is posted to Server.EventMux when a peer is added or dropped.
+type PeerEvent struct {
+	Type PeerEventType
+	Peer discover.NodeID
+}
+
+// ExternalAddrEvent is posted to Server.EventMux when discovery
+// detects a change of the local node's external address.
+type ExternalAddrEvent struct {
+	Node *discover.Node // the local node with its new address
+}
+
 type connFlag int
 
 const (
@@ -149,6 +221,7 @@ type conn struct {
 	id    discover.NodeID // valid after the encryption handshake
 	caps  []Cap           // valid after the protocol handshake
 	name  string          // valid after the protocol handshake
+	meter *trafficMeter   // traffic counters of this connection, may be nil
 }
 
 type transport interface {
@@ -194,6 +267,15 @@ func (f connFlag) String() string {
 	return s
 }
 
+// remoteIP returns the IP address of the remote end,
+// or nil if it is not a TCP connection.
+func (c *conn) remoteIP() net.IP {
+	if addr, ok := c.fd.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
 func (c *conn) is(f connFlag) bool {
 	return c.flags&f != 0
 }
@@ -227,6 +309,12 @@ func (srv *Server) PeerCount() int {
 	return count
 }
 
+// Traffic returns a snapshot of the traffic counters aggregated
+// over all connections since the server was started.
+func (srv *Server) Traffic() Traffic {
+	return srv.traffic.snapshot()
+}
+
 // AddPeer connects to the given node and maintains the connection until the
 // server is shut down. If the connection fails for any reason, the server will
 // attempt to reconnect the peer.
@@ -237,6 +325,120 @@ func (srv *Server) AddPeer(node *discover.Node) {
 	}
 }
 
+// RemovePeer disconnects from the given node and removes it from the
+// list of static nodes, so it will no longer be redialed.
+func (srv *Server) RemovePeer(node *discover.Node) {
+	select {
+	case srv.removestatic <- node:
+	case <-srv.quit:
+	}
+}
+
+// AddTrustedPeer marks the given node as trusted. Connections to
+// trusted nodes are accepted even if the server is at its peer limit.
+// Connections that are already established are not affected.
+// It can be called before the server is started.
+func (srv *Server) AddTrustedPeer(node *discover.Node) {
+	srv.trustMu.Lock()
+	defer srv.trustMu.Unlock()
+	if srv.trusted == nil {
+		srv.trusted = make(map[discover.NodeID]*discover.Node)
+	}
+	srv.trusted[node.ID] = node
+}
+
+// RemoveTrustedPeer removes the trusted mark of the given node.
+// Connections that are already established are not affected.
+func (srv *Server) RemoveTrustedPeer(node *discover.Node) {
+	srv.trustMu.Lock()
+	defer srv.trustMu.Unlock()
+	delete(srv.trusted, node.ID)
+}
+
+func (srv *Server) isTrusted(id discover.NodeID) bool {
+	srv.trustMu.Lock()
+	defer srv.trustMu.Unlock()
+	return srv.trusted[id] != nil
+}
+
+// isTrustedIP reports whether any trusted node is known to use the
+// given IP address. It is used to admit inbound connections before
+// the remote identity is known.
+func (srv *Server) isTrustedIP(ip net.IP) bool {
+	srv.trustMu.Lock()
+	defer srv.trustMu.Unlock()
+	for _, n := range srv.trusted {
+		if n.IP != nil && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ban adds a ban and disconnects all peers matching it.
+// Trusted nodes are exempt from bans.
+func (srv *Server) Ban(b *discover.Ban) error {
+	if srv.bans == nil {
+		return errServerStopped
+	}
+	glog.V(logger.Info).Infoln("Adding", b)
+	if err := srv.bans.Add(b); err != nil {
+		return err
+	}
+	for _, p := range srv.Peers() {
+		if !p.rw.is(trustedConn) && b.Matches(p.ID(), p.rw.remoteIP()) {
+			p.Disconnect(DiscUselessPeer)
+		}
+	}
+	return nil
+}
+
+// Unban lifts the ban of the given ban's target.
+// It returns false if the target was not banned.
+func (srv *Server) Unban(b *discover.Ban) (bool, error) {
+	if srv.bans == nil {
+		return false, errServerStopped
+	}
+	return srv.bans.Remove(b)
+}
+
+// Bans returns all active bans.
+func (srv *Server) Bans() []*discover.Ban {
+	return srv.bans.List()
+}
+
+// externalAddrChanged is called by the discovery table
+// when the external address of the local node changes.
+func (srv *Server) externalAddrChanged(self *discover.Node) {
+	glog.V(logger.Info).Infoln("External address changed:", self)
+	if srv.EventMux != nil {
+		srv.EventMux.Post(ExternalAddrEvent{Node: self})
+	}
+}
+
+// DiscoveryStats returns statistics about the discovery table.
+// It returns nil if discovery is not running.
+func (srv *Server) DiscoveryStats() *discover.TableStats {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if tab, ok := srv.ntab.(*discover.Table); ok {
+		return tab.Stats()
+	}
+	return nil
+}
+
+// banPeer bans the node ID of a connected peer.
+// It is called through Peer.Ban.
+func (srv *Server) banPeer(id discover.NodeID, d time.Duration, reason string) {
+	b := &discover.Ban{ID: id, Reason: reason}
+	if d > 0 {
+		b.Expires = time.Now().Add(d)
+	}
+	if err := srv.Ban(b); err != nil {
+		glog.V(logger.Warn).Infof("Could not ban %x: %v", id[:8], err)
+	}
+}
+
 // Self returns the local node's endpoint information.
 func (srv *Server) Self() *discover.Node {
 	srv.lock.Lock()
@@ -307,16 +509,35 @@ func (srv *Server) Start() (err error) {
 	srv.delpeer = make(chan *Peer)
 	srv.posthandshake = make(chan *conn)
 	srv.addstatic = make(chan *discover.Node)
+	srv.removestatic = make(chan *discover.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
+	srv.traffic = newTrafficMeter(nil)
+	for _, n := range srv.TrustedNodes {
+		srv.AddTrustedPeer(n)
+	}
 
 	// node table
 	if srv.Discovery {
-		ntab, err := discover.ListenUDP(srv.PrivateKey, srv.ListenAddr, srv.NAT, srv.NodeDatabase)
+		ntab, err := discover.ListenUDP(srv.PrivateKey, srv.ListenAddr, srv.NAT, srv.NodeDatabase, srv.NetRestrict)
 		if err != nil {
 			return err
 		}
+		if srv.DiscoveryIPLimits != nil {
+			ntab.SetIPLimits(*srv.DiscoveryIPLimits)
+		}
+		if srv.DiscoveryEndpointPolicy != nil {
+			ntab.SetEndpointPolicy(*srv.DiscoveryEndpointPolicy)
+		}
+		ntab.OnEndpointChange(srv.externalAddrChanged)
 		srv.ntab = ntab
+		srv.bans = ntab.Bans()
+	} else {
+		bans, err := discover.OpenBanList(srv.NodeDatabase, discover.PubkeyID(&srv.PrivateKey.PublicKey))
+		if err != nil {
+			return err
+		}
+		srv.bans = bans
 	}
 
 	dynPeers := srv.MaxPeers / 2
@@ -324,6 +545,8 @@ func (srv *Server) Start() (err error) {
 		dynPeers = 0
 	}
 	dialer := newDialState(srv.StaticNodes, srv.ntab, dynPeers)
+	dialer.bans = srv.bans
+	dialer.netrestrict = srv.NetRestrict
 
 	// handshake
 	srv.ourHandshake = &protoHandshake{Version: baseProtocolVersion, Name: srv.Name, ID: discover.PubkeyID(&srv.PrivateKey.PublicKey)}
@@ -372,25 +595,18 @@ type dialer interface {
 	newTasks(running int, peers map[discover.NodeID]*Peer, now time.Time) []task
 	taskDone(task, time.Time)
 	addStatic(*discover.Node)
+	removeStatic(*discover.Node)
 }
 
 func (srv *Server) run(dialstate dialer) {
 	defer srv.loopWG.Done()
 	var (
-		peers   = make(map[discover.NodeID]*Peer)
-		trusted = make(map[discover.NodeID]bool, len(srv.TrustedNodes))
+		peers = make(map[discover.NodeID]*Peer)
 
 		tasks        []task
 		pendingTasks []task
 		taskdone     = make(chan task, maxActiveDialTasks)
 	)
-	// Put trusted nodes into a map to speed up checks.
-	// Trusted peers are loaded on startup and cannot be
-	// modified while the server is running.
-	for _, n := range srv.TrustedNodes {
-		trusted[n.ID] = true
-	}
-
 	// Some task list helpers.
 	delTask := func(t task) {
 		for i := range tasks {
@@ -436,6 +652,14 @@ running:
 			// it will keep the node connected.
 			glog.V(logger.Detail).Infoln("<-addstatic:", n)
 			dialstate.addStatic(n)
+		case n := <-srv.removestatic:
+			// This channel is used by RemovePeer to remove a node
+			// from the static peer list and disconnect it.
+			glog.V(logger.Detail).Infoln("<-removestatic:", n)
+			dialstate.removeStatic(n)
+			if p, ok := peers[n.ID]; ok {
+				p.Disconnect(DiscRequested)
+			}
 		case op := <-srv.peerOp:
 			// This channel is used by Peers and PeerCount.
 			op(peers)
@@ -450,7 +674,7 @@ running:
 		case c := <-srv.posthandshake:
 			// A connection has passed the encryption handshake so
 			// the remote identity is known (but hasn't been verified yet).
-			if trusted[c.id] {
+			if srv.isTrusted(c.id) {
 				// Ensure that the trusted flag is set before checking against MaxPeers.
 				c.flags |= trustedConn
 			}
@@ -467,6 +691,7 @@ running:
 			} else {
 				// The handshakes are done and it passed all checks.
 				p := newPeer(c, srv.Protocols)
+				p.ban = srv.banPeer
 				peers[c.id] = p
 				go srv.runPeer(p)
 			}
@@ -485,6 +710,7 @@ running:
 	if srv.ntab != nil {
 		srv.ntab.Close()
 	}
+	srv.bans.Close()
 	// Disconnect all peers.
 	for _, p := range peers {
 		p.Disconnect(DiscQuitting)
@@ -516,6 +742,8 @@ func (srv *Server) encHandshakeChecks(peers map[discover.NodeID]*Peer, c *conn)
 		return DiscTooManyPeers
 	case peers[c.id] != nil:
 		return DiscAlreadyConnected
+	case !c.is(trustedConn) && srv.bans.Banned(c.id, c.remoteIP()) != nil:
+		return errBanned
 	case c.id == srv.Self().ID:
 		return DiscSelf
 	default:
@@ -540,21 +768,60 @@ func (srv *Server) listenLoop() {
 	for i := 0; i < tokens; i++ {
 		slots <- struct{}{}
 	}
+	// Connections from the IP of a trusted node may bypass the regular
+	// slots, but the IP is shared by anyone behind the same address, so
+	// the number of such bypasses is bounded separately.
+	trustedSlots := make(chan struct{}, maxTrustedAcceptConns)
+	for i := 0; i < maxTrustedAcceptConns; i++ {
+		trustedSlots <- struct{}{}
+	}
 
 	for {
-		<-slots
 		fd, err := srv.listener.Accept()
 		if err != nil {
 			return
 		}
+		// Reject connections that do not match NetRestrict.
+		if srv.NetRestrict != nil {
+			if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok && !srv.NetRestrict.Contains(tcp.IP) {
+				glog.V(logger.Debug).Infof("Rejected conn %v because it is not whitelisted in NetRestrict", fd.RemoteAddr())
+				fd.Close()
+				continue
+			}
+		}
 		glog.V(logger.Debug).Infof("Accepted conn %v\n", fd.RemoteAddr())
+
+		// Take a slot. If all slots are taken, connections
+		// from trusted node IPs take one of the trusted slots.
+		slot := slots
+		select {
+		case <-slots:
+		default:
+			if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok && srv.isTrustedIP(tcp.IP) {
+				select {
+				case <-trustedSlots:
+					slot = trustedSlots
+				case <-slots:
+				}
+			} else {
+				<-slots
+			}
+		}
 		go func() {
 			srv.setupConn(fd, inboundConn, nil)
-			slots <- struct{}{}
+			slot <- struct{}{}
 		}()
 	}
 }
 
+// ServeConn runs the handshakes on an inbound connection that was
+// accepted outside of the server's listener and attempts to add it as
+// a peer. It returns when the connection has been added as a peer or
+// the handshakes have failed.
+func (srv *Server) ServeConn(fd net.Conn) {
+	srv.setupConn(fd, inboundConn, nil)
+}
+
 // setupConn runs the handshakes and attempts to add the connection
 // as a peer. It returns when the connection has been added as a peer
 // or the handshakes have failed.
@@ -564,6 +831,10 @@ func (srv *Server) setupConn(fd net.Conn, flags connFlag, dialDest *discover.Nod
 	running := srv.running
 	srv.lock.Unlock()
 	c := &conn{fd: fd, transport: srv.newTransport(fd), flags: flags, cont: make(chan error)}
+	c.meter = newTrafficMeter(srv.traffic)
+	if t, ok := c.transport.(*rlpx); ok {
+		t.meter = c.meter
+	}
 	if !running {
 		c.close(errServerStopped)
 		return
@@ -639,10 +910,16 @@ func (srv *Server) runPeer(p *Peer) {
 	if srv.newPeerHook != nil {
 		srv.newPeerHook(p)
 	}
+	if srv.EventMux != nil {
+		srv.EventMux.Post(PeerEvent{Type: PeerEventAdd, Peer: p.ID()})
+	}
 	discreason := p.run()
 	// Note: run waits for existing peers to be sent on srv.delpeer
 	// before returning, so this send should not select on srv.quit.
 	srv.delpeer <- p
+	if srv.EventMux != nil {
+		srv.EventMux.Post(PeerEvent{Type: PeerEventDrop, Peer: p.ID()})
+	}
 
 	glog.V(logger.Debug).Infof("Removed %v (%v)\n", p, discreason)
 	srvjslog.LogJson(&logger.P2PDisconnected{
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -232,6 +233,8 @@ func (tg taskgen) taskDone(t task, now time.Time) {
 }
 func (tg taskgen) addStatic(*discover.Node) {
 }
+func (tg taskgen) removeStatic(*discover.Node) {
+}
 
 type testTask struct {
 	index  int
@@ -285,6 +288,255 @@ func TestServerAtCap(t *testing.T) {
 		t.Error("Server did not set trusted flag")
 	}
 
+	// Mark a node as trusted at runtime and try again.
+	id := randomID()
+	srv.AddTrustedPeer(&discover.Node{ID: id})
+	c = newconn(id)
+	if err := srv.checkpoint(c, srv.posthandshake); err != nil {
+		t.Error("unexpected error for runtime-trusted conn @posthandshake:", err)
+	}
+	if !c.is(trustedConn) {
+		t.Error("Server did not set trusted flag for runtime-trusted conn")
+	}
+	// Remove the trusted mark. The node should be rejected again.
+	srv.RemoveTrustedPeer(&discover.Node{ID: id})
+	c = newconn(id)
+	if err := srv.checkpoint(c, srv.posthandshake); err != DiscTooManyPeers {
+		t.Error("wrong error for insert after RemoveTrustedPeer:", err)
+	}
+}
+
+// This test checks that trusted nodes can be added before the
+// server is started and are kept when it starts.
+func TestServerAddTrustedBeforeStart(t *testing.T) {
+	id := randomID()
+	srv := &Server{PrivateKey: newkey(), MaxPeers: 10, NoDial: true}
+	srv.AddTrustedPeer(&discover.Node{ID: id})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	if !srv.isTrusted(id) {
+		t.Error("trusted node added before Start was dropped")
+	}
+}
+
+// This test checks that RemovePeer disconnects the node.
+func TestServerRemovePeer(t *testing.T) {
+	connected := make(chan *Peer)
+	remid := randomID()
+	srv := startTestServer(t, remid, func(p *Peer) { connected <- p })
+	defer close(connected)
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(1 * time.Second):
+		t.Fatal("server did not accept within one second")
+	}
+	srv.RemovePeer(&discover.Node{ID: remid})
+
+	deadline := time.Now().Add(1 * time.Second)
+	for srv.PeerCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("peer not disconnected after RemovePeer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// blockingTransport holds the encryption handshake until release is closed.
+type blockingTransport struct {
+	transport
+	started, release chan struct{}
+}
+
+func (c *blockingTransport) doEncHandshake(prv *ecdsa.PrivateKey, dialDest *discover.Node) (discover.NodeID, error) {
+	close(c.started)
+	<-c.release
+	return c.transport.doEncHandshake(prv, dialDest)
+}
+
+// This test checks that inbound connections from trusted nodes are
+// accepted even if all pending handshake slots are taken.
+func TestServerListenTrustedAtCap(t *testing.T) {
+	var (
+		connected = make(chan *Peer, 2)
+		started   = make(chan struct{})
+		release   = make(chan struct{})
+		trustedID = randomID()
+		accepted  int32
+	)
+	srv := &Server{
+		Name:            "test",
+		MaxPeers:        1,
+		MaxPendingPeers: 1,
+		ListenAddr:      "127.0.0.1:0",
+		PrivateKey:      newkey(),
+		NoDial:          true,
+		newPeerHook:     func(p *Peer) { connected <- p },
+		newTransport: func(fd net.Conn) transport {
+			if atomic.AddInt32(&accepted, 1) == 1 {
+				return &blockingTransport{newTestTransport(randomID(), fd), started, release}
+			}
+			return newTestTransport(trustedID, fd)
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+	defer close(release)
+
+	// Take the only pending handshake slot.
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case <-started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("server did not accept within one second")
+	}
+
+	// A connection from a trusted node must not wait for the slot.
+	srv.AddTrustedPeer(&discover.Node{ID: trustedID, IP: net.ParseIP("127.0.0.1")})
+	conn, err = net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case p := <-connected:
+		if p.ID() != trustedID {
+			t.Errorf("connected peer mismatch: have %x, want %x", p.ID(), trustedID)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("trusted connection not accepted while slots are taken")
+	}
+}
+
+// This test checks that the number of handshakes bypassing the pending
+// handshake slots from a trusted node's IP is bounded.
+func TestServerListenTrustedIPLimit(t *testing.T) {
+	var (
+		started  = make(chan struct{}, maxTrustedAcceptConns+2)
+		release  = make(chan struct{})
+		accepted int32
+	)
+	srv := &Server{
+		Name:            "test",
+		MaxPeers:        1,
+		MaxPendingPeers: 1,
+		ListenAddr:      "127.0.0.1:0",
+		PrivateKey:      newkey(),
+		NoDial:          true,
+		newTransport: func(fd net.Conn) transport {
+			atomic.AddInt32(&accepted, 1)
+			c := &blockingTransport{newTestTransport(randomID(), fd), make(chan struct{}), release}
+			go func() {
+				<-c.started
+				started <- struct{}{}
+			}()
+			return c
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+	defer close(release)
+
+	// Every connection comes from the IP of a trusted node, but none of
+	// them is the trusted node itself.
+	srv.AddTrustedPeer(&discover.Node{ID: randomID(), IP: net.ParseIP("127.0.0.1")})
+
+	// Take the regular slot and all trusted slots.
+	for i := 0; i < maxTrustedAcceptConns+2; i++ {
+		conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+		if err != nil {
+			t.Fatalf("could not dial: %v", err)
+		}
+		defer conn.Close()
+	}
+	for i := 0; i < maxTrustedAcceptConns+1; i++ {
+		select {
+		case <-started:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("handshake %d not started within one second", i)
+		}
+	}
+	// The last connection must wait for a slot.
+	select {
+	case <-started:
+		t.Errorf("handshake started with all slots taken")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n := atomic.LoadInt32(&accepted); n != maxTrustedAcceptConns+1 {
+		t.Errorf("accepted %d connections, want %d", n, maxTrustedAcceptConns+1)
+	}
+}
+
+// This test checks that banned nodes are rejected
+// after the encryption handshake.
+func TestServerBan(t *testing.T) {
+	srv := &Server{PrivateKey: newkey(), MaxPeers: 10, NoDial: true}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	id := randomID()
+	newconn := func() *conn {
+		fd, _ := net.Pipe()
+		return &conn{fd: fd, transport: newTestTransport(id, fd), flags: inboundConn, id: id, cont: make(chan error)}
+	}
+	if err := srv.Ban(&discover.Ban{ID: id, Expires: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("could not ban: %v", err)
+	}
+	if err := srv.checkpoint(newconn(), srv.posthandshake); err != errBanned {
+		t.Errorf("wrong error for banned conn: %v", err)
+	}
+	if bans := srv.Bans(); len(bans) != 1 || bans[0].ID != id {
+		t.Errorf("wrong ban list: %v", bans)
+	}
+	if ok, err := srv.Unban(&discover.Ban{ID: id}); !ok || err != nil {
+		t.Errorf("Unban returned %t, %v", ok, err)
+	}
+	if err := srv.checkpoint(newconn(), srv.posthandshake); err != nil {
+		t.Errorf("unexpected error after unban: %v", err)
+	}
+}
+
+// This test checks that the ban list can't be modified
+// after the server has stopped.
+func TestServerBanAfterStop(t *testing.T) {
+	srv := &Server{PrivateKey: newkey(), MaxPeers: 10, NoDial: true}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	if err := srv.Ban(&discover.Ban{ID: randomID()}); err != nil {
+		t.Fatalf("could not ban: %v", err)
+	}
+	srv.Stop()
+
+	if err := srv.Ban(&discover.Ban{ID: randomID()}); err != discover.ErrBanListClosed {
+		t.Errorf("Ban after Stop returned %v, want %v", err, discover.ErrBanListClosed)
+	}
+	if _, err := srv.Unban(&discover.Ban{ID: randomID()}); err != discover.ErrBanListClosed {
+		t.Errorf("Unban after Stop returned %v, want %v", err, discover.ErrBanListClosed)
+	}
+	if bans := srv.Bans(); len(bans) != 1 {
+		t.Errorf("wrong ban list after Stop: %v", bans)
+	}
 }
 
 func TestServerSetupConn(t *testing.T) {
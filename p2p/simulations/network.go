@@ -0,0 +1,397 @@
+// Package simulations runs networks of p2p servers in a single process.
+//
+// Nodes of a simulated network are regular p2p.Server instances that
+// talk to each other over in-memory connections instead of TCP. The
+// network decides which nodes can reach each other and can inject
+// faults such as latency, dropped connections and partitions. Peer
+// connections and protocol messages are reported as events, which
+// makes it possible to test multi-node protocol behaviour in go test.
+package simulations
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+var (
+	errUnknownNode   = errors.New("unknown node")
+	errUnreachable   = errors.New("node is in another partition")
+	errNetworkClosed = errors.New("network is shut down")
+	errDropped       = errors.New("connection dropped by simulation")
+)
+
+// ConnEvent is posted when a node adds or drops a peer.
+type ConnEvent struct {
+	Node, Peer discover.NodeID
+	Up         bool // true if the peer was added
+}
+
+// MsgEvent is posted when a protocol handler of a node
+// sends or receives a message.
+type MsgEvent struct {
+	Node, Peer discover.NodeID
+	Protocol   string
+	Code       uint64
+	Size       uint32
+	Received   bool // false for sent messages
+}
+
+// NodeConfig configures a simulated node.
+type NodeConfig struct {
+	// Name is the node name advertised in the protocol handshake.
+	Name string
+
+	// PrivateKey is the node key. A random key is generated if nil.
+	PrivateKey *ecdsa.PrivateKey
+
+	// Protocols are the protocols run by the node.
+	Protocols []p2p.Protocol
+
+	// MaxPeers limits the number of peers. Zero means no practical limit.
+	MaxPeers int
+}
+
+// Node is a member of a simulated network.
+type Node struct {
+	ID     discover.NodeID
+	Server *p2p.Server
+
+	net *Network
+	mux *event.TypeMux // p2p.PeerEvents of Server
+}
+
+// Node returns the discovery node of n. It can be
+// used to add n as a peer of another node.
+func (n *Node) Node() *discover.Node {
+	return &discover.Node{ID: n.ID}
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("node %x", n.ID[:8])
+}
+
+// Network is a set of nodes connected over in-memory connections.
+// All methods are safe for concurrent use.
+type Network struct {
+	// Events carries ConnEvents and MsgEvents for all nodes.
+	// Note that posting blocks until all subscribers have received
+	// the event, so subscribers must keep reading or unsubscribe.
+	Events event.TypeMux
+
+	mu        sync.Mutex
+	nodes     []*Node
+	byID      map[discover.NodeID]*Node
+	conns     map[*conn]struct{}
+	partition map[discover.NodeID]int // partition number of each node, if partitioned
+	latency   time.Duration
+	dropRate  float64
+	rand      *rand.Rand
+	closed    bool
+}
+
+// NewNetwork creates an empty network. The seed initializes the random
+// source used for fault injection and random topologies, so that
+// simulations can be reproduced.
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		byID:  make(map[discover.NodeID]*Node),
+		conns: make(map[*conn]struct{}),
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NewNode creates and starts a node.
+func (nw *Network) NewNode(config NodeConfig) (*Node, error) {
+	key := config.PrivateKey
+	if key == nil {
+		var err error
+		if key, err = crypto.GenerateKey(); err != nil {
+			return nil, err
+		}
+	}
+	maxPeers := config.MaxPeers
+	if maxPeers == 0 {
+		maxPeers = 1000
+	}
+	node := &Node{ID: discover.PubkeyID(&key.PublicKey), net: nw, mux: new(event.TypeMux)}
+	protocols := make([]p2p.Protocol, len(config.Protocols))
+	for i, proto := range config.Protocols {
+		protocols[i] = nw.wrapProtocol(node, proto)
+	}
+	node.Server = &p2p.Server{
+		PrivateKey: key,
+		Name:       config.Name,
+		MaxPeers:   maxPeers,
+		Protocols:  protocols,
+		NodeDialer: dialer{nw, node},
+		EventMux:   node.mux,
+	}
+
+	nw.mu.Lock()
+	if nw.closed {
+		nw.mu.Unlock()
+		return nil, errNetworkClosed
+	}
+	if nw.byID[node.ID] != nil {
+		nw.mu.Unlock()
+		return nil, fmt.Errorf("duplicate %v", node)
+	}
+	nw.nodes = append(nw.nodes, node)
+	nw.byID[node.ID] = node
+	nw.mu.Unlock()
+
+	sub := node.mux.Subscribe(p2p.PeerEvent{})
+	go nw.forwardPeerEvents(node, sub)
+	if err := node.Server.Start(); err != nil {
+		node.mux.Stop()
+		return nil, err
+	}
+	return node, nil
+}
+
+// NewNodes creates and starts n nodes with the given configuration.
+// The configuration's private key is ignored.
+func (nw *Network) NewNodes(n int, config NodeConfig) ([]*Node, error) {
+	config.PrivateKey = nil
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		var err error
+		if nodes[i], err = nw.NewNode(config); err != nil {
+			return nodes[:i], err
+		}
+	}
+	return nodes, nil
+}
+
+func (nw *Network) forwardPeerEvents(node *Node, sub event.Subscription) {
+	for ev := range sub.Chan() {
+		pe := ev.(p2p.PeerEvent)
+		nw.Events.Post(ConnEvent{Node: node.ID, Peer: pe.Peer, Up: pe.Type == p2p.PeerEventAdd})
+	}
+}
+
+// Nodes returns all nodes of the network in creation order.
+func (nw *Network) Nodes() []*Node {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return append([]*Node(nil), nw.nodes...)
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (nw *Network) Node(id discover.NodeID) *Node {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return nw.byID[id]
+}
+
+// Shutdown stops all nodes. Subscriptions to Events are closed.
+func (nw *Network) Shutdown() {
+	nw.mu.Lock()
+	nw.closed = true
+	nodes := nw.nodes
+	nw.mu.Unlock()
+
+	// Stop the event mux first so stopping servers
+	// doesn't block on subscribers that don't read.
+	nw.Events.Stop()
+	for _, node := range nodes {
+		node.Server.Stop()
+		node.mux.Stop()
+	}
+}
+
+// Connect makes a maintain a connection to b. The connection is
+// re-established by a whenever it drops, unless a and b are in
+// different partitions.
+func (nw *Network) Connect(a, b *Node) {
+	a.Server.AddPeer(b.Node())
+}
+
+// Disconnect drops the connection between a and b and
+// stops both nodes from re-establishing it.
+func (nw *Network) Disconnect(a, b *Node) {
+	a.Server.RemovePeer(b.Node())
+	b.Server.RemovePeer(a.Node())
+}
+
+// SetLatency delays every write on all connections by d.
+func (nw *Network) SetLatency(d time.Duration) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.latency = d
+}
+
+// SetDropRate sets the probability with which any write on
+// any connection fails and closes the connection.
+func (nw *Network) SetDropRate(p float64) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.dropRate = p
+}
+
+// Partition splits the network into the given groups of nodes.
+// Nodes in different groups cannot reach each other and existing
+// connections between them are closed. Nodes that are not part of
+// any group can only reach each other.
+func (nw *Network) Partition(groups ...[]*Node) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.partition = make(map[discover.NodeID]int)
+	for i, group := range groups {
+		for _, node := range group {
+			nw.partition[node.ID] = i + 1
+		}
+	}
+	for c := range nw.conns {
+		if !nw.reachable(c.local, c.remote) {
+			delete(nw.conns, c)
+			c.Conn.Close()
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (nw *Network) Heal() {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.partition = nil
+}
+
+// reachable reports whether a connection between
+// a and b is allowed. It must be called with nw.mu held.
+func (nw *Network) reachable(a, b discover.NodeID) bool {
+	return nw.partition == nil || nw.partition[a] == nw.partition[b]
+}
+
+// dialer implements p2p.NodeDialer for a node.
+type dialer struct {
+	net  *Network
+	from *Node
+}
+
+func (d dialer) Dial(dest *discover.Node) (net.Conn, error) {
+	return d.net.dial(d.from, dest.ID)
+}
+
+// dial connects from to the node with the given ID.
+// The remote end of the connection is served by the
+// destination node's server.
+func (nw *Network) dial(from *Node, to discover.NodeID) (net.Conn, error) {
+	nw.mu.Lock()
+	if nw.closed {
+		nw.mu.Unlock()
+		return nil, errNetworkClosed
+	}
+	dest := nw.byID[to]
+	if dest == nil {
+		nw.mu.Unlock()
+		return nil, errUnknownNode
+	}
+	if !nw.reachable(from.ID, to) {
+		nw.mu.Unlock()
+		return nil, errUnreachable
+	}
+	p1, p2 := net.Pipe()
+	c1 := &conn{Conn: p1, net: nw, local: from.ID, remote: to}
+	c2 := &conn{Conn: p2, net: nw, local: to, remote: from.ID}
+	nw.conns[c1] = struct{}{}
+	nw.conns[c2] = struct{}{}
+	nw.mu.Unlock()
+
+	go dest.Server.ServeConn(c2)
+	return c1, nil
+}
+
+// faults returns the current latency and whether the next write
+// should be dropped.
+func (nw *Network) faults() (time.Duration, bool) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	drop := nw.dropRate > 0 && nw.rand.Float64() < nw.dropRate
+	return nw.latency, drop
+}
+
+func (nw *Network) removeConn(c *conn) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.conns, c)
+}
+
+// conn is one end of an in-memory connection.
+// It applies the network's fault settings to writes.
+type conn struct {
+	net.Conn
+	net           *Network
+	local, remote discover.NodeID
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	latency, drop := c.net.faults()
+	if drop {
+		c.Close()
+		return 0, errDropped
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *conn) Close() error {
+	c.net.removeConn(c)
+	return c.Conn.Close()
+}
+
+// wrapProtocol returns a copy of proto that reports
+// the messages handled by node as MsgEvents.
+func (nw *Network) wrapProtocol(node *Node, proto p2p.Protocol) p2p.Protocol {
+	run := proto.Run
+	proto.Run = func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+		return run(peer, &eventRW{MsgReadWriter: rw, net: nw, node: node.ID, peer: peer.ID(), proto: proto.Name})
+	}
+	return proto
+}
+
+type eventRW struct {
+	p2p.MsgReadWriter
+	net        *Network
+	node, peer discover.NodeID
+	proto      string
+}
+
+func (rw *eventRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err == nil {
+		rw.post(msg, true)
+	}
+	return msg, err
+}
+
+func (rw *eventRW) WriteMsg(msg p2p.Msg) error {
+	if err := rw.MsgReadWriter.WriteMsg(msg); err != nil {
+		return err
+	}
+	rw.post(msg, false)
+	return nil
+}
+
+func (rw *eventRW) post(msg p2p.Msg, received bool) {
+	rw.net.Events.Post(MsgEvent{
+		Node:     rw.node,
+		Peer:     rw.peer,
+		Protocol: rw.proto,
+		Code:     msg.Code,
+		Size:     msg.Size,
+		Received: received,
+	})
+}
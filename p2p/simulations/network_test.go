@@ -0,0 +1,176 @@
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// pingProtocol sends a single message to every peer
+// and then reads messages until the peer disconnects.
+var pingProtocol = p2p.Protocol{
+	Name:    "ping",
+	Version: 1,
+	Length:  1,
+	Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		if err := p2p.Send(rw, 0, []uint{1}); err != nil {
+			return err
+		}
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return err
+			}
+			msg.Discard()
+		}
+	},
+}
+
+func newTestNetwork(t *testing.T, n int) (*Network, []*Node) {
+	nw := NewNetwork(1)
+	nodes, err := nw.NewNodes(n, NodeConfig{Protocols: []p2p.Protocol{pingProtocol}})
+	if err != nil {
+		nw.Shutdown()
+		t.Fatalf("could not create nodes: %v", err)
+	}
+	return nw, nodes
+}
+
+// waitConns waits until up and down ConnEvents have been
+// received on sub. Other events are ignored.
+func waitConns(t *testing.T, sub event.Subscription, up, down int) {
+	timeout := time.After(5 * time.Second)
+	for up > 0 || down > 0 {
+		select {
+		case ev := <-sub.Chan():
+			if ce, ok := ev.(ConnEvent); ok {
+				if ce.Up {
+					up--
+				} else {
+					down--
+				}
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for events: %d up and %d down missing", up, down)
+		}
+	}
+}
+
+func checkPeerCounts(t *testing.T, nodes []*Node, want int) {
+	for _, node := range nodes {
+		if n := node.Server.PeerCount(); n != want {
+			t.Errorf("%v has %d peers, want %d", node, n, want)
+		}
+	}
+}
+
+func TestNetworkRing(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 5)
+	defer nw.Shutdown()
+	sub := nw.Events.Subscribe(ConnEvent{})
+	defer sub.Unsubscribe()
+
+	nw.ConnectRing(nodes)
+	// Each connection is reported by both ends.
+	waitConns(t, sub, 2*len(nodes), 0)
+	checkPeerCounts(t, nodes, 2)
+}
+
+func TestNetworkPartition(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 4)
+	defer nw.Shutdown()
+	sub := nw.Events.Subscribe(ConnEvent{})
+	defer sub.Unsubscribe()
+
+	nw.ConnectFull(nodes)
+	waitConns(t, sub, 12, 0)
+
+	// Split the network in half. The four connections
+	// between the halves should be dropped at both ends.
+	nw.Partition(nodes[:2], nodes[2:])
+	waitConns(t, sub, 0, 8)
+	checkPeerCounts(t, nodes, 1)
+
+	// The dropped nodes must not be able to reconnect.
+	if _, err := nw.dial(nodes[0], nodes[2].ID); err != errUnreachable {
+		t.Errorf("dial across partition returned %v, want %v", err, errUnreachable)
+	}
+	nw.Heal()
+	if _, err := nw.dial(nodes[0], discover.NodeID{}); err != errUnknownNode {
+		t.Errorf("dial to unknown node returned %v, want %v", err, errUnknownNode)
+	}
+}
+
+func TestNetworkDisconnect(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 2)
+	defer nw.Shutdown()
+	sub := nw.Events.Subscribe(ConnEvent{})
+	defer sub.Unsubscribe()
+
+	nw.Connect(nodes[0], nodes[1])
+	waitConns(t, sub, 2, 0)
+	nw.Disconnect(nodes[0], nodes[1])
+	waitConns(t, sub, 0, 2)
+	checkPeerCounts(t, nodes, 0)
+}
+
+func TestNetworkMsgEvents(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 2)
+	defer nw.Shutdown()
+	sub := nw.Events.Subscribe(MsgEvent{})
+	defer sub.Unsubscribe()
+
+	nw.Connect(nodes[0], nodes[1])
+
+	// Both nodes send one ping, which is received by the other.
+	type key struct {
+		node     discover.NodeID
+		received bool
+	}
+	want := map[key]bool{
+		{nodes[0].ID, false}: true,
+		{nodes[0].ID, true}:  true,
+		{nodes[1].ID, false}: true,
+		{nodes[1].ID, true}:  true,
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case ev := <-sub.Chan():
+			me := ev.(MsgEvent)
+			if me.Protocol != "ping" || me.Code != 0 {
+				t.Fatalf("unexpected event: %+v", me)
+			}
+			delete(want, key{me.Node, me.Received})
+		case <-timeout:
+			t.Fatalf("timed out waiting for message events, missing %v", want)
+		}
+	}
+}
+
+func TestNetworkDropRate(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 2)
+	defer nw.Shutdown()
+	sub := nw.Events.Subscribe(ConnEvent{})
+	defer sub.Unsubscribe()
+
+	// With a drop rate of one, the first handshake
+	// write fails and no connection is established.
+	nw.SetDropRate(1)
+	nw.Connect(nodes[0], nodes[1])
+	select {
+	case ev := <-sub.Chan():
+		t.Fatalf("unexpected event: %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+	checkPeerCounts(t, nodes, 0)
+
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if len(nw.conns) != 0 {
+		t.Errorf("%d connections left open", len(nw.conns))
+	}
+}
@@ -0,0 +1,66 @@
+package simulations
+
+// The functions in this file connect nodes in common topologies.
+// Connections are established asynchronously. Use the network's
+// ConnEvents to wait for them.
+
+// ConnectRing connects each node to its successor,
+// and the last node to the first.
+func (nw *Network) ConnectRing(nodes []*Node) {
+	if len(nodes) < 2 {
+		return
+	}
+	for i := range nodes {
+		nw.Connect(nodes[i], nodes[(i+1)%len(nodes)])
+	}
+}
+
+// ConnectChain connects each node to its successor.
+func (nw *Network) ConnectChain(nodes []*Node) {
+	for i := 1; i < len(nodes); i++ {
+		nw.Connect(nodes[i-1], nodes[i])
+	}
+}
+
+// ConnectStar connects all nodes to center.
+func (nw *Network) ConnectStar(center *Node, nodes []*Node) {
+	for _, node := range nodes {
+		if node != center {
+			nw.Connect(node, center)
+		}
+	}
+}
+
+// ConnectFull connects every node to every other node.
+func (nw *Network) ConnectFull(nodes []*Node) {
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			nw.Connect(nodes[i], nodes[j])
+		}
+	}
+}
+
+// ConnectRandom connects each node to degree other nodes chosen at
+// random using the network's random source. Nodes may end up with
+// more than degree peers because connections are symmetric.
+func (nw *Network) ConnectRandom(nodes []*Node, degree int) {
+	if degree >= len(nodes) {
+		nw.ConnectFull(nodes)
+		return
+	}
+	for i, node := range nodes {
+		nw.mu.Lock()
+		perm := nw.rand.Perm(len(nodes))
+		nw.mu.Unlock()
+		n := 0
+		for _, j := range perm {
+			if n == degree {
+				break
+			}
+			if j != i {
+				nw.Connect(node, nodes[j])
+				n++
+			}
+		}
+	}
+}
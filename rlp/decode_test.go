@@ -7,7 +7,9 @@ import (
 	"io"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestStreamKind(t *testing.T) {
@@ -287,6 +289,40 @@ type recstruct struct {
 	Child *recstruct `rlp:"nil"`
 }
 
+type tailRaw struct {
+	A    uint
+	Tail []uint `rlp:"tail"`
+}
+
+type tailAndIgnoredField struct {
+	A    uint
+	Tail []uint `rlp:"tail"`
+	B    uint   `rlp:"-"`
+}
+
+type hasIgnoredField struct {
+	A uint
+	B uint `rlp:"-"`
+	C uint
+}
+
+type optionalFields struct {
+	A uint
+	B uint `rlp:"optional"`
+	C uint `rlp:"optional"`
+}
+
+type optionalAndTailField struct {
+	A    uint
+	B    uint   `rlp:"optional"`
+	Tail []uint `rlp:"tail"`
+}
+
+type optionalPtrField struct {
+	A uint
+	B *[3]byte `rlp:"optional"`
+}
+
 var (
 	veryBigInt = big.NewInt(0).Add(
 		big.NewInt(0).Lsh(big.NewInt(0xFFFFFFFFFFFFFF), 16),
@@ -295,6 +331,11 @@ var (
 )
 
 var decodeTests = []decodeTest{
+	// booleans
+	{input: "01", ptr: new(bool), value: true},
+	{input: "80", ptr: new(bool), value: false},
+	{input: "02", ptr: new(bool), error: "rlp: invalid boolean value: 2"},
+
 	// integers
 	{input: "05", ptr: new(uint32), value: uint32(5)},
 	{input: "80", ptr: new(uint32), value: uint32(0)},
@@ -374,6 +415,108 @@ var decodeTests = []decodeTest{
 		value: recstruct{1, &recstruct{2, &recstruct{3, nil}}},
 	},
 
+	// struct tag "tail"
+	{
+		input: "C3010203",
+		ptr:   new(tailRaw),
+		value: tailRaw{A: 1, Tail: []uint{2, 3}},
+	},
+	{
+		input: "C101",
+		ptr:   new(tailRaw),
+		value: tailRaw{A: 1, Tail: []uint{}},
+	},
+	{
+		input: "C0",
+		ptr:   new(tailRaw),
+		error: "rlp: too few elements for rlp.tailRaw",
+	},
+	{
+		input: "C3010203",
+		ptr:   new(tailAndIgnoredField),
+		value: tailAndIgnoredField{A: 1, Tail: []uint{2, 3}},
+	},
+
+	// struct tag "-"
+	{
+		input: "C20102",
+		ptr:   &hasIgnoredField{B: 9},
+		value: hasIgnoredField{A: 1, B: 9, C: 2},
+	},
+
+	// struct tag "optional"
+	{
+		input: "C101",
+		ptr:   new(optionalFields),
+		value: optionalFields{1, 0, 0},
+	},
+	{
+		input: "C20102",
+		ptr:   new(optionalFields),
+		value: optionalFields{1, 2, 0},
+	},
+	{
+		input: "C3010203",
+		ptr:   new(optionalFields),
+		value: optionalFields{1, 2, 3},
+	},
+	{
+		input: "C401020304",
+		ptr:   new(optionalFields),
+		error: "rlp: input list has too many elements for rlp.optionalFields",
+	},
+	{
+		input: "C101",
+		ptr:   &optionalFields{A: 9, B: 8, C: 7},
+		value: optionalFields{1, 0, 0},
+	},
+	{
+		input: "C101",
+		ptr:   new(optionalAndTailField),
+		value: optionalAndTailField{A: 1},
+	},
+	{
+		input: "C401020304",
+		ptr:   new(optionalAndTailField),
+		value: optionalAndTailField{A: 1, B: 2, Tail: []uint{3, 4}},
+	},
+	{
+		input: "C101",
+		ptr:   new(optionalPtrField),
+		value: optionalPtrField{A: 1},
+	},
+	{
+		input: "C50183010203",
+		ptr:   new(optionalPtrField),
+		value: optionalPtrField{A: 1, B: &[3]byte{1, 2, 3}},
+	},
+
+	// struct tag errors
+	{
+		input: "C0",
+		ptr: new(struct {
+			A uint
+			B []uint `rlp:"tail"`
+			C uint
+		}),
+		error: `rlp: invalid struct tag "tail" for struct { A uint; B []uint "rlp:\"tail\""; C uint }.B (must be on last field)`,
+	},
+	{
+		input: "C0",
+		ptr: new(struct {
+			A uint `rlp:"tail"`
+		}),
+		error: `rlp: invalid struct tag "tail" for struct { A uint "rlp:\"tail\"" }.A (field type is not slice)`,
+	},
+	{
+		input: "C0",
+		ptr: new(struct {
+			A uint
+			B []byte `rlp:"tail"`
+		}),
+		error: `rlp: invalid struct tag "tail" for struct { A uint; B []uint8 "rlp:\"tail\"" }.B (field type is byte slice)`,
+	},
+
 	// struct errors
 	{
 		input: "C0",
@@ -498,6 +641,21 @@ func TestDecodeWithNonByteReader(t *testing.T) {
 	})
 }
 
+// Readers may return io.EOF along with the last bytes of their input.
+// Large strings are read directly from the reader, not via the buffer.
+func TestDecodeWithDataErrReader(t *testing.T) {
+	want := bytes.Repeat([]byte{0xaa}, 10000)
+	enc, _ := EncodeToBytes(want)
+
+	var got []byte
+	if err := Decode(iotest.DataErrReader(bytes.NewReader(enc)), &got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoded value mismatch")
+	}
+}
+
 func TestDecodeStreamReset(t *testing.T) {
 	s := NewStream(nil, 0)
 	runTests(t, func(input []byte, into interface{}) error {
@@ -693,7 +851,7 @@ func encodeTestSlice(n uint) []byte {
 }
 
 func unhex(str string) []byte {
-	b, err := hex.DecodeString(str)
+	b, err := hex.DecodeString(strings.Replace(str, " ", "", -1))
 	if err != nil {
 		panic(fmt.Sprintf("invalid hex string: %q", str))
 	}
@@ -5,12 +5,9 @@ import (
 	"io"
 	"math/big"
 	"reflect"
+	"sync"
 )
 
-// TODO: put encbufs in a sync.Pool.
-//     Doing that requires zeroing the buffers after use.
-//     encReader will need to drop it's buffer when done.
-
 var (
 	// Common encoded values.
 	// These are useful when implementing EncodeRLP.
@@ -91,20 +88,28 @@ func (e flatenc) EncodeRLP(out io.Writer) error {
 // if the array has element type byte).
 //
 // Struct values are encoded as an RLP list of all their encoded
-// public fields. Recursive struct types are supported.
+// public fields. Recursive struct types are supported. Fields tagged
+// with `rlp:"-"` are skipped. Trailing fields tagged `rlp:"optional"`
+// are omitted while they hold the zero value, and the elements of a
+// slice field tagged `rlp:"tail"` are appended to the enclosing list.
 //
 // To encode slices and arrays, the elements are encoded as an RLP
 // list of the value's elements. Note that arrays and slices with
 // element type uint8 or byte are always encoded as an RLP string.
 //
+// A RawValue is written to the output as-is. It must hold valid RLP.
+//
 // A Go string is encoded as an RLP string.
 //
 // An unsigned integer value is encoded as an RLP string. Zero always
 // encodes as an empty RLP string. Encode also supports *big.Int.
 //
+// Boolean values are encoded as the unsigned integers zero (false) and
+// one (true).
+//
 // An interface value encodes as the value contained in the interface.
 //
-// Boolean values are not supported, nor are signed integers, floating
+// Signed integers are not supported, nor are floating
 // point numbers, maps, channels and functions.
 func Encode(w io.Writer, val interface{}) error {
 	if outer, ok := w.(*encbuf); ok {
@@ -112,7 +117,9 @@ func Encode(w io.Writer, val interface{}) error {
 		// Avoid copying by writing to the outer encbuf directly.
 		return outer.encode(val)
 	}
-	eb := newencbuf()
+	eb := encbufPool.Get().(*encbuf)
+	defer encbufPool.Put(eb)
+	eb.reset()
 	if err := eb.encode(val); err != nil {
 		return err
 	}
@@ -122,7 +129,9 @@ func Encode(w io.Writer, val interface{}) error {
 // EncodeBytes returns the RLP encoding of val.
 // Please see the documentation of Encode for the encoding rules.
 func EncodeToBytes(val interface{}) ([]byte, error) {
-	eb := newencbuf()
+	eb := encbufPool.Get().(*encbuf)
+	defer encbufPool.Put(eb)
+	eb.reset()
 	if err := eb.encode(val); err != nil {
 		return nil, err
 	}
@@ -135,18 +144,20 @@ func EncodeToBytes(val interface{}) ([]byte, error) {
 //
 // Please see the documentation of Encode for the encoding rules.
 func EncodeToReader(val interface{}) (size int, r io.Reader, err error) {
-	eb := newencbuf()
+	eb := encbufPool.Get().(*encbuf)
+	eb.reset()
 	if err := eb.encode(val); err != nil {
+		encbufPool.Put(eb)
 		return 0, nil, err
 	}
 	return eb.size(), &encReader{buf: eb}, nil
 }
 
 type encbuf struct {
-	str     []byte      // string data, contains everything except list headers
-	lheads  []*listhead // all list headers
-	lhsize  int         // sum of sizes of all encoded list headers
-	sizebuf []byte      // 9-byte auxiliary buffer for uint encoding
+	str     []byte     // string data, contains everything except list headers
+	lheads  []listhead // all list headers
+	lhsize  int        // sum of sizes of all encoded list headers
+	sizebuf []byte     // 9-byte auxiliary buffer for uint encoding
 }
 
 type listhead struct {
@@ -182,8 +193,81 @@ func puthead(buf []byte, smalltag, largetag byte, size uint64) int {
 	}
 }
 
-func newencbuf() *encbuf {
-	return &encbuf{sizebuf: make([]byte, 9)}
+// AppendUint64 appends the RLP encoding of i to b
+// and returns the extended buffer.
+func AppendUint64(b []byte, i uint64) []byte {
+	switch {
+	case i == 0:
+		return append(b, 0x80)
+	case i < 128:
+		// fits single byte
+		return append(b, byte(i))
+	default:
+		size := intsize(i)
+		b = append(b, 0x80+byte(size))
+		return appendint(b, i, size)
+	}
+}
+
+// AppendBytes appends the RLP encoding of the byte string s to b
+// and returns the extended buffer.
+func AppendBytes(b []byte, s []byte) []byte {
+	if len(s) == 1 && s[0] <= 0x7F {
+		// fits single byte, no string header
+		return append(b, s[0])
+	}
+	b = appendStringHeader(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// AppendListHeader appends the header of an RLP list whose
+// encoded content is size bytes long to b and returns the extended
+// buffer. The caller must append exactly size bytes of encoded
+// list elements after the header.
+func AppendListHeader(b []byte, size int) []byte {
+	return appendHeader(b, 0xC0, 0xF7, uint64(size))
+}
+
+func appendStringHeader(b []byte, size uint64) []byte {
+	return appendHeader(b, 0x80, 0xB7, size)
+}
+
+// appendHeader is the append-style variant of puthead.
+func appendHeader(b []byte, smalltag, largetag byte, size uint64) []byte {
+	if size < 56 {
+		return append(b, smalltag+byte(size))
+	}
+	sizesize := intsize(size)
+	b = append(b, largetag+byte(sizesize))
+	return appendint(b, size, sizesize)
+}
+
+// appendint appends the size lowest bytes of i in big endian
+// byte order to b.
+func appendint(b []byte, i uint64, size int) []byte {
+	for shift := uint(size-1) * 8; ; shift -= 8 {
+		b = append(b, byte(i>>shift))
+		if shift == 0 {
+			return b
+		}
+	}
+}
+
+// encbufs are pooled.
+var encbufPool = sync.Pool{
+	New: func() interface{} { return &encbuf{sizebuf: make([]byte, 9)} },
+}
+
+// reset clears the buffer so it can be reused. The backing
+// arrays of str and lheads are retained.
+func (w *encbuf) reset() {
+	w.lhsize = 0
+	if w.str != nil {
+		w.str = w.str[:0]
+	}
+	if w.lheads != nil {
+		w.lheads = w.lheads[:0]
+	}
 }
 
 // encbuf implements io.Writer so it can be passed it into EncodeRLP.
@@ -202,33 +286,22 @@ func (w *encbuf) encode(val interface{}) error {
 }
 
 func (w *encbuf) encodeStringHeader(size int) {
-	if size < 56 {
-		w.str = append(w.str, 0x80+byte(size))
-	} else {
-		// TODO: encode to w.str directly
-		sizesize := putint(w.sizebuf[1:], uint64(size))
-		w.sizebuf[0] = 0xB7 + byte(sizesize)
-		w.str = append(w.str, w.sizebuf[:sizesize+1]...)
-	}
+	w.str = appendStringHeader(w.str, uint64(size))
 }
 
 func (w *encbuf) encodeString(b []byte) {
-	if len(b) == 1 && b[0] <= 0x7F {
-		// fits single byte, no string header
-		w.str = append(w.str, b[0])
-	} else {
-		w.encodeStringHeader(len(b))
-		w.str = append(w.str, b...)
-	}
+	w.str = AppendBytes(w.str, b)
 }
 
-func (w *encbuf) list() *listhead {
-	lh := &listhead{offset: len(w.str), size: w.lhsize}
-	w.lheads = append(w.lheads, lh)
-	return lh
+// list starts a new list. The returned index must be
+// passed to listEnd when the list is complete.
+func (w *encbuf) list() int {
+	w.lheads = append(w.lheads, listhead{offset: len(w.str), size: w.lhsize})
+	return len(w.lheads) - 1
 }
 
-func (w *encbuf) listEnd(lh *listhead) {
+func (w *encbuf) listEnd(index int) {
+	lh := &w.lheads[index]
 	lh.size = w.size() - lh.offset - lh.size
 	if lh.size < 56 {
 		w.lhsize += 1 // length encoded into kind tag
@@ -295,6 +368,13 @@ type encReader struct {
 func (r *encReader) Read(b []byte) (n int, err error) {
 	for {
 		if r.piece = r.next(); r.piece == nil {
+			// Put the encode buffer back into the pool at EOF when it
+			// is first encountered. Subsequent calls still return EOF
+			// as the error but the buffer is no longer valid.
+			if r.buf != nil {
+				encbufPool.Put(r.buf)
+				r.buf = nil
+			}
 			return n, io.EOF
 		}
 		nn := copy(b[n:], r.piece)
@@ -313,6 +393,9 @@ func (r *encReader) Read(b []byte) (n int, err error) {
 // it returns nil at EOF.
 func (r *encReader) next() []byte {
 	switch {
+	case r.buf == nil:
+		return nil
+
 	case r.piece != nil:
 		// There is still data available for reading.
 		return r.piece
@@ -348,9 +431,11 @@ var (
 )
 
 // makeWriter creates a writer function for the given type.
-func makeWriter(typ reflect.Type) (writer, error) {
+func makeWriter(typ reflect.Type, ts tags) (writer, error) {
 	kind := typ.Kind()
 	switch {
+	case typ == rawValueType:
+		return writeRawValue, nil
 	case typ.Implements(encoderInterface):
 		return writeEncoder, nil
 	case kind != reflect.Ptr && reflect.PtrTo(typ).Implements(encoderInterface):
@@ -363,6 +448,8 @@ func makeWriter(typ reflect.Type) (writer, error) {
 		return writeBigIntNoPtr, nil
 	case isUint(kind):
 		return writeUint, nil
+	case kind == reflect.Bool:
+		return writeBool, nil
 	case kind == reflect.String:
 		return writeString, nil
 	case kind == reflect.Slice && isByte(typ.Elem()):
@@ -370,7 +457,7 @@ func makeWriter(typ reflect.Type) (writer, error) {
 	case kind == reflect.Array && isByte(typ.Elem()):
 		return writeByteArray, nil
 	case kind == reflect.Slice || kind == reflect.Array:
-		return makeSliceWriter(typ)
+		return makeSliceWriter(typ, ts)
 	case kind == reflect.Struct:
 		return makeStructWriter(typ)
 	case kind == reflect.Ptr:
@@ -384,18 +471,21 @@ func isByte(typ reflect.Type) bool {
 	return typ.Kind() == reflect.Uint8 && !typ.Implements(encoderInterface)
 }
 
+func writeRawValue(val reflect.Value, w *encbuf) error {
+	w.str = append(w.str, val.Bytes()...)
+	return nil
+}
+
 func writeUint(val reflect.Value, w *encbuf) error {
-	i := val.Uint()
-	if i == 0 {
-		w.str = append(w.str, 0x80)
-	} else if i < 128 {
-		// fits single byte
-		w.str = append(w.str, byte(i))
+	w.str = AppendUint64(w.str, val.Uint())
+	return nil
+}
+
+func writeBool(val reflect.Value, w *encbuf) error {
+	if val.Bool() {
+		w.str = append(w.str, 0x01)
 	} else {
-		// TODO: encode int to w.str directly
-		s := putint(w.sizebuf[1:], i)
-		w.sizebuf[0] = 0x80 + byte(s)
-		w.str = append(w.str, w.sizebuf[:s+1]...)
+		w.str = append(w.str, 0x80)
 	}
 	return nil
 }
@@ -492,20 +582,25 @@ func writeInterface(val reflect.Value, w *encbuf) error {
 	return ti.writer(eval, w)
 }
 
-func makeSliceWriter(typ reflect.Type) (writer, error) {
+func makeSliceWriter(typ reflect.Type, ts tags) (writer, error) {
 	etypeinfo, err := cachedTypeInfo1(typ.Elem(), tags{})
 	if err != nil {
 		return nil, err
 	}
 	writer := func(val reflect.Value, w *encbuf) error {
-		lh := w.list()
+		var lh int
+		if !ts.tail {
+			lh = w.list()
+		}
 		vlen := val.Len()
 		for i := 0; i < vlen; i++ {
 			if err := etypeinfo.writer(val.Index(i), w); err != nil {
 				return err
 			}
 		}
-		w.listEnd(lh)
+		if !ts.tail {
+			w.listEnd(lh)
+		}
 		return nil
 	}
 	return writer, nil
@@ -516,9 +611,16 @@ func makeStructWriter(typ reflect.Type) (writer, error) {
 	if err != nil {
 		return nil, err
 	}
+	firstOpt := firstOptionalField(fields)
 	writer := func(val reflect.Value, w *encbuf) error {
+		// Trailing optional fields are omitted if they and all
+		// fields following them are zero.
+		end := len(fields)
+		for end > firstOpt && isZeroValue(val.Field(fields[end-1].index)) {
+			end--
+		}
 		lh := w.list()
-		for _, f := range fields {
+		for _, f := range fields[:end] {
 			if err := f.info.writer(val.Field(f.index), w); err != nil {
 				return err
 			}
@@ -529,6 +631,41 @@ func makeStructWriter(typ reflect.Type) (writer, error) {
 	return writer, nil
 }
 
+// isZeroValue reports whether v holds the zero value of its type.
+// Empty slices are considered zero as they encode like nil slices.
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isZeroValue(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isZeroValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 func makePtrWriter(typ reflect.Type) (writer, error) {
 	etypeinfo, err := cachedTypeInfo1(typ.Elem(), tags{})
 	if err != nil {
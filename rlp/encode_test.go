@@ -55,6 +55,10 @@ type encTest struct {
 }
 
 var encTests = []encTest{
+	// booleans
+	{val: true, output: "01"},
+	{val: false, output: "80"},
+
 	// integers
 	{val: uint32(0), output: "80"},
 	{val: uint32(127), output: "7F"},
@@ -188,6 +192,27 @@ var encTests = []encTest{
 	{val: simplestruct{A: 3, B: "foo"}, output: "C50383666F6F"},
 	{val: &recstruct{5, nil}, output: "C205C0"},
 	{val: &recstruct{5, &recstruct{4, &recstruct{3, nil}}}, output: "C605C404C203C0"},
+	{val: &tailRaw{A: 1, Tail: []uint{}}, output: "C101"},
+	{val: &tailRaw{A: 1, Tail: []uint{2, 3}}, output: "C3010203"},
+	{val: &tailAndIgnoredField{A: 1, Tail: []uint{2, 3}, B: 4}, output: "C3010203"},
+	{val: &hasIgnoredField{A: 1, B: 2, C: 3}, output: "C20103"},
+	{val: &optionalFields{A: 1}, output: "C101"},
+	{val: &optionalFields{A: 1, B: 2}, output: "C20102"},
+	{val: &optionalFields{A: 1, C: 3}, output: "C3018003"},
+	{val: &optionalAndTailField{A: 1, Tail: []uint{5, 6}}, output: "C401800506"},
+	{val: &optionalPtrField{A: 1}, output: "C101"},
+	{val: &optionalPtrField{A: 1, B: &[3]byte{1, 2, 3}}, output: "C50183010203"},
+	{val: &struct {
+		A uint `rlp:"bogus"`
+	}{}, error: `rlp: unknown struct tag "bogus" on struct { A uint "rlp:\"bogus\"" }.A`},
+	{val: &struct {
+		A uint `rlp:"optional"`
+		B uint
+	}{}, error: `rlp: struct field struct { A uint "rlp:\"optional\""; B uint }.B needs "optional" tag`},
+	{val: &struct {
+		A uint
+		B []byte `rlp:"tail"`
+	}{}, error: `rlp: invalid struct tag "tail" for struct { A uint; B []uint8 "rlp:\"tail\"" }.B (field type is byte slice)`},
 
 	// flat
 	{val: Flat(uint(1)), error: "rlp.Flat: uint did not encode as list"},
@@ -295,3 +320,140 @@ func TestEncodeToReaderPiecewise(t *testing.T) {
 		return output, nil
 	})
 }
+
+// This test verifies that encoding with a pooled buffer does not
+// leak data from earlier encodings into the output.
+func TestEncodeToBytesReuse(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		long, _ := EncodeToBytes([]string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+		short, _ := EncodeToBytes([]uint{1})
+		if want := unhex("C101"); !bytes.Equal(short, want) {
+			t.Fatalf("short output mismatch after %d iterations: got %X, want %X", i, short, want)
+		}
+		if len(long) != 63 {
+			t.Fatalf("long output has wrong length %d", len(long))
+		}
+	}
+}
+
+func TestAppendUint64(t *testing.T) {
+	tests := []uint64{0, 1, 0x7F, 0x80, 0xFF, 0x100, 0xFFFFFF, 0xFFFFFFFF, 0x0102030405060708, 0xFFFFFFFFFFFFFFFF}
+	for _, i := range tests {
+		want, _ := EncodeToBytes(i)
+		prefix := []byte{0xAA}
+		if got := AppendUint64(prefix, i); !bytes.Equal(got[1:], want) || got[0] != 0xAA {
+			t.Errorf("AppendUint64(%d) = %X, want %X", i, got[1:], want)
+		}
+	}
+}
+
+func TestAppendBytes(t *testing.T) {
+	tests := [][]byte{
+		nil,
+		{0},
+		{0x7F},
+		{0x80},
+		[]byte("dog"),
+		bytes.Repeat([]byte{0xAB}, 55),
+		bytes.Repeat([]byte{0xAB}, 56),
+		bytes.Repeat([]byte{0xAB}, 1024),
+	}
+	for _, s := range tests {
+		want, _ := EncodeToBytes(s)
+		if got := AppendBytes(nil, s); !bytes.Equal(got, want) {
+			t.Errorf("AppendBytes(%X) = %X, want %X", s, got, want)
+		}
+	}
+}
+
+func TestAppendListHeader(t *testing.T) {
+	tests := []interface{}{
+		[]uint{},
+		[]uint{1, 2, 3},
+		[]string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		make([][]byte, 300),
+	}
+	for _, v := range tests {
+		want, _ := EncodeToBytes(v)
+		_, content, _, err := Split(want)
+		if err != nil {
+			t.Fatalf("can't split %X: %v", want, err)
+		}
+		got := append(AppendListHeader(nil, len(content)), content...)
+		if !bytes.Equal(got, want) {
+			t.Errorf("AppendListHeader mismatch for %v:\ngot  %X\nwant %X", v, got, want)
+		}
+	}
+}
+
+type benchStruct struct {
+	Nonce    uint64
+	Price    *big.Int
+	GasLimit *big.Int
+	To       [20]byte
+	Value    *big.Int
+	Data     []byte
+	Children []benchChild
+}
+
+type benchChild struct {
+	A, B uint
+	C    string
+}
+
+func BenchmarkEncodeStruct(b *testing.B) {
+	val := &benchStruct{
+		Nonce:    42,
+		Price:    big.NewInt(50000000000),
+		GasLimit: big.NewInt(21000),
+		Value:    new(big.Int).Lsh(big.NewInt(1), 70),
+		Data:     make([]byte, 100),
+		Children: []benchChild{{1, 2, "foo"}, {3, 4, "bar"}},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeToBytes(val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeBytesSlice(b *testing.B) {
+	val := make([][]byte, 17)
+	for i := range val {
+		val[i] = bytes.Repeat([]byte{byte(i)}, 32)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeToBytes(val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeToWriter(b *testing.B) {
+	val := []interface{}{uint(1), "foo", []uint{1, 2, 3}, make([]byte, 64)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Encode(ioutil.Discard, val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendUint64(b *testing.B) {
+	buf := make([]byte, 0, 16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendUint64(buf[:0], uint64(i))
+	}
+}
+
+func BenchmarkAppendBytes(b *testing.B) {
+	data := make([]byte, 32)
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendBytes(buf[:0], data)
+	}
+}
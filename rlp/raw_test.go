@@ -0,0 +1,183 @@
+package rlp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCountValues(t *testing.T) {
+	tests := []struct {
+		input string // note: spaces in input are stripped by unhex
+		count int
+		err   error
+	}{
+		// simple cases
+		{"", 0, nil},
+		{"00", 1, nil},
+		{"80", 1, nil},
+		{"C0", 1, nil},
+		{"01 02 03", 3, nil},
+		{"01 C406070809 02", 3, nil},
+		{"820101 820202 8403030303 04", 4, nil},
+
+		// size errors
+		{"8142", 0, ErrCanonSize},
+		{"01 01 8142", 0, ErrCanonSize},
+		{"02 84020202", 0, ErrValueTooLarge},
+	}
+	for i, test := range tests {
+		count, err := CountValues(unhex(test.input))
+		if count != test.count {
+			t.Errorf("test %d: count mismatch, got %d want %d\ninput: %s", i, count, test.count, test.input)
+		}
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("test %d: err mismatch, got %q want %q\ninput: %s", i, err, test.err, test.input)
+		}
+	}
+}
+
+func TestSplitTypes(t *testing.T) {
+	if _, _, err := SplitString(unhex("C100")); err != ErrExpectedString {
+		t.Errorf("SplitString returned %q, want %q", err, ErrExpectedString)
+	}
+	if _, _, err := SplitList(unhex("01")); err != ErrExpectedList {
+		t.Errorf("SplitString returned %q, want %q", err, ErrExpectedList)
+	}
+	if _, _, err := SplitList(unhex("81FF")); err != ErrExpectedList {
+		t.Errorf("SplitString returned %q, want %q", err, ErrExpectedList)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		input     string
+		kind      Kind
+		val, rest string
+		err       error
+	}{
+		{input: "01FFFF", kind: Byte, val: "01", rest: "FFFF"},
+		{input: "80FFFF", kind: String, val: "", rest: "FFFF"},
+		{input: "C3010203", kind: List, val: "010203"},
+
+		{input: "", err: io.ErrUnexpectedEOF},
+		{input: "8141", err: ErrCanonSize, rest: "8141"},
+		{input: "B800", err: ErrCanonSize, rest: "B800"},
+		{input: "B802FFFF", err: ErrCanonSize, rest: "B802FFFF"},
+		{input: "B90000", err: ErrCanonSize, rest: "B90000"},
+		{input: "B90055", err: ErrCanonSize, rest: "B90055"},
+		{input: "BA0002FFFF", err: ErrCanonSize, rest: "BA0002FFFF"},
+		{input: "F800", err: ErrCanonSize, rest: "F800"},
+		{input: "F90000", err: ErrCanonSize, rest: "F90000"},
+		{input: "F90055", err: ErrCanonSize, rest: "F90055"},
+		{input: "FA0002FFFF", err: ErrCanonSize, rest: "FA0002FFFF"},
+
+		{input: "81", err: ErrValueTooLarge, rest: "81"},
+		{input: "8501010101", err: ErrValueTooLarge, rest: "8501010101"},
+		{input: "C60607080902", err: ErrValueTooLarge, rest: "C60607080902"},
+
+		// size check overflow
+		{input: "BFFFFFFFFFFFFFFFFF", err: ErrValueTooLarge, rest: "BFFFFFFFFFFFFFFFFF"},
+		{input: "FFFFFFFFFFFFFFFFFF", err: ErrValueTooLarge, rest: "FFFFFFFFFFFFFFFFFF"},
+
+		{
+			input: "B838FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			err:   ErrValueTooLarge,
+			rest:  "B838FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		},
+		{
+			input: "F838FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			err:   ErrValueTooLarge,
+			rest:  "F838FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		},
+
+		// a few bigger values, just for kicks
+		{
+			input: "F839FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			kind:  List,
+			val:   "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+			rest:  "",
+		},
+	}
+
+	for i, test := range tests {
+		kind, val, rest, err := Split(unhex(test.input))
+		if kind != test.kind {
+			t.Errorf("test %d: kind mismatch: got %v, want %v", i, kind, test.kind)
+		}
+		if !bytes.Equal(val, unhex(test.val)) {
+			t.Errorf("test %d: val mismatch: got %x, want %s", i, val, test.val)
+		}
+		if !bytes.Equal(rest, unhex(test.rest)) {
+			t.Errorf("test %d: rest mismatch: got %x, want %s", i, rest, test.rest)
+		}
+		if err != test.err {
+			t.Errorf("test %d: error mismatch: got %q, want %q", i, err, test.err)
+		}
+	}
+}
+
+func TestReadSize(t *testing.T) {
+	tests := []struct {
+		input string
+		slen  byte
+		size  uint64
+		err   error
+	}{
+		{input: "", slen: 1, err: io.ErrUnexpectedEOF},
+		{input: "FF", slen: 2, err: io.ErrUnexpectedEOF},
+		{input: "00", slen: 1, err: ErrCanonSize},
+		{input: "36", slen: 1, err: ErrCanonSize},
+		{input: "37", slen: 1, err: ErrCanonSize},
+		{input: "38", slen: 1, size: 0x38},
+		{input: "FF", slen: 1, size: 0xFF},
+		{input: "FFFF", slen: 2, size: 0xFFFF},
+		{input: "FFFFFF", slen: 3, size: 0xFFFFFF},
+		{input: "FFFFFFFF", slen: 4, size: 0xFFFFFFFF},
+		{input: "FFFFFFFFFF", slen: 5, size: 0xFFFFFFFFFF},
+		{input: "FFFFFFFFFFFF", slen: 6, size: 0xFFFFFFFFFFFF},
+		{input: "FFFFFFFFFFFFFF", slen: 7, size: 0xFFFFFFFFFFFFFF},
+		{input: "FFFFFFFFFFFFFFFF", slen: 8, size: 0xFFFFFFFFFFFFFFFF},
+		{input: "0102", slen: 2, size: 0x0102},
+		{input: "010203", slen: 3, size: 0x010203},
+		{input: "01020304", slen: 4, size: 0x01020304},
+		{input: "0102030405", slen: 5, size: 0x0102030405},
+		{input: "010203040506", slen: 6, size: 0x010203040506},
+		{input: "01020304050607", slen: 7, size: 0x01020304050607},
+		{input: "0102030405060708", slen: 8, size: 0x0102030405060708},
+	}
+
+	for _, test := range tests {
+		size, err := readSize(unhex(test.input), test.slen)
+		if err != test.err {
+			t.Errorf("readSize(%s, %d): error mismatch: got %q, want %q", test.input, test.slen, err, test.err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("readSize(%s, %d): size mismatch: got %#x, want %#x", test.input, test.slen, size, test.size)
+		}
+	}
+}
+
+func TestRawValueRoundtrip(t *testing.T) {
+	type withRaw struct {
+		A   uint
+		Raw RawValue
+	}
+	input := unhex("C801C6820102C20304")
+	var v withRaw
+	if err := DecodeBytes(input, &v); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if want := unhex("C6820102C20304"); !bytes.Equal(v.Raw, want) {
+		t.Errorf("raw value mismatch: got %x, want %x", []byte(v.Raw), want)
+	}
+	output, err := EncodeToBytes(&v)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Errorf("re-encoding mismatch: got %x, want %x", output, input)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -25,15 +26,22 @@ var (
 	AdminMapping = map[string]adminhandler{
 		//		"admin_startRPC": (*adminApi).StartRPC,
 		//		"admin_stopRPC":  (*adminApi).StopRPC,
-		"admin_addPeer":         (*adminApi).AddPeer,
-		"admin_peers":           (*adminApi).Peers,
-		"admin_nodeInfo":        (*adminApi).NodeInfo,
-		"admin_exportChain":     (*adminApi).ExportChain,
-		"admin_importChain":     (*adminApi).ImportChain,
-		"admin_verbosity":       (*adminApi).Verbosity,
-		"admin_chainSyncStatus": (*adminApi).ChainSyncStatus,
-		"admin_setSolc":         (*adminApi).SetSolc,
-		"admin_datadir":         (*adminApi).DataDir,
+		"admin_addPeer":           (*adminApi).AddPeer,
+		"admin_removePeer":        (*adminApi).RemovePeer,
+		"admin_addTrustedPeer":    (*adminApi).AddTrustedPeer,
+		"admin_removeTrustedPeer": (*adminApi).RemoveTrustedPeer,
+		"admin_banPeer":           (*adminApi).BanPeer,
+		"admin_unbanPeer":         (*adminApi).UnbanPeer,
+		"admin_bans":              (*adminApi).Bans,
+		"admin_discoveryStats":    (*adminApi).DiscoveryStats,
+		"admin_peers":             (*adminApi).Peers,
+		"admin_nodeInfo":          (*adminApi).NodeInfo,
+		"admin_exportChain":       (*adminApi).ExportChain,
+		"admin_importChain":       (*adminApi).ImportChain,
+		"admin_verbosity":         (*adminApi).Verbosity,
+		"admin_chainSyncStatus":   (*adminApi).ChainSyncStatus,
+		"admin_setSolc":           (*adminApi).SetSolc,
+		"admin_datadir":           (*adminApi).DataDir,
 	}
 )
 
@@ -92,11 +100,96 @@ func (self *adminApi) AddPeer(req *shared.Request) (interface{}, error) {
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
 
-	err := self.ethereum.AddPeer(args.Url)
-	if err == nil {
-		return true, nil
+	if err := self.ethereum.AddPeer(args.Url); err != nil {
+		return false, err
+	}
+	if args.Persist {
+		if err := self.ethereum.SaveStaticNodes(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (self *adminApi) RemovePeer(req *shared.Request) (interface{}, error) {
+	args := new(AddPeerArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, shared.NewDecodeParamError(err.Error())
+	}
+
+	if err := self.ethereum.RemovePeer(args.Url); err != nil {
+		return false, err
+	}
+	if args.Persist {
+		if err := self.ethereum.SaveStaticNodes(); err != nil {
+			return false, err
+		}
 	}
-	return false, err
+	return true, nil
+}
+
+func (self *adminApi) AddTrustedPeer(req *shared.Request) (interface{}, error) {
+	args := new(AddPeerArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, shared.NewDecodeParamError(err.Error())
+	}
+
+	if err := self.ethereum.AddTrustedPeer(args.Url); err != nil {
+		return false, err
+	}
+	if args.Persist {
+		if err := self.ethereum.SaveTrustedNodes(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (self *adminApi) RemoveTrustedPeer(req *shared.Request) (interface{}, error) {
+	args := new(AddPeerArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, shared.NewDecodeParamError(err.Error())
+	}
+
+	if err := self.ethereum.RemoveTrustedPeer(args.Url); err != nil {
+		return false, err
+	}
+	if args.Persist {
+		if err := self.ethereum.SaveTrustedNodes(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (self *adminApi) BanPeer(req *shared.Request) (interface{}, error) {
+	args := new(BanPeerArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, shared.NewDecodeParamError(err.Error())
+	}
+
+	duration := time.Duration(args.Seconds) * time.Second
+	if err := self.ethereum.BanPeer(args.Target, duration, args.Reason); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (self *adminApi) UnbanPeer(req *shared.Request) (interface{}, error) {
+	args := new(BanPeerArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, shared.NewDecodeParamError(err.Error())
+	}
+
+	return self.ethereum.UnbanPeer(args.Target)
+}
+
+func (self *adminApi) Bans(req *shared.Request) (interface{}, error) {
+	return self.ethereum.Bans(), nil
+}
+
+func (self *adminApi) DiscoveryStats(req *shared.Request) (interface{}, error) {
+	return self.ethereum.DiscoveryStats(), nil
 }
 
 func (self *adminApi) Peers(req *shared.Request) (interface{}, error) {
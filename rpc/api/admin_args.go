@@ -6,8 +6,12 @@ import (
 	"github.com/ethereum/go-ethereum/rpc/shared"
 )
 
+// AddPeerArgs are the arguments of the admin methods that modify the
+// static and trusted node lists. If Persist is set, the modified list
+// is written back to the node's data directory.
 type AddPeerArgs struct {
-	Url string
+	Url     string
+	Persist bool
 }
 
 func (args *AddPeerArgs) UnmarshalJSON(b []byte) (err error) {
@@ -16,7 +20,7 @@ func (args *AddPeerArgs) UnmarshalJSON(b []byte) (err error) {
 		return shared.NewDecodeParamError(err.Error())
 	}
 
-	if len(obj) != 1 {
+	if len(obj) < 1 || len(obj) > 2 {
 		return shared.NewDecodeParamError("Expected enode as argument")
 	}
 
@@ -26,6 +30,14 @@ func (args *AddPeerArgs) UnmarshalJSON(b []byte) (err error) {
 	}
 	args.Url = urlstr
 
+	if len(obj) > 1 {
+		persist, ok := obj[1].(bool)
+		if !ok {
+			return shared.NewInvalidTypeError("persist", "not a boolean")
+		}
+		args.Persist = persist
+	}
+
 	return nil
 }
 
@@ -95,3 +107,50 @@ func (args *SetSolcArgs) UnmarshalJSON(b []byte) (err error) {
 
 	return shared.NewInvalidTypeError("path", "not a string")
 }
+
+// BanPeerArgs are the arguments of admin_banPeer and admin_unbanPeer.
+// The target is an enode URL, a node ID, an IP address or a network in
+// CIDR notation. Seconds and Reason are optional and ignored by
+// admin_unbanPeer. A ban without duration never expires.
+type BanPeerArgs struct {
+	Target  string
+	Seconds int64
+	Reason  string
+}
+
+func (args *BanPeerArgs) UnmarshalJSON(b []byte) (err error) {
+	var obj []interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return shared.NewDecodeParamError(err.Error())
+	}
+
+	if len(obj) < 1 || len(obj) > 3 {
+		return shared.NewDecodeParamError("Expected ban target as argument")
+	}
+
+	target, ok := obj[0].(string)
+	if !ok {
+		return shared.NewInvalidTypeError("target", "not a string")
+	}
+	args.Target = target
+
+	if len(obj) > 1 {
+		seconds, err := numString(obj[1])
+		if err != nil {
+			return shared.NewInvalidTypeError("seconds", "not a number")
+		}
+		if seconds.Sign() < 0 {
+			return shared.NewValidationError("seconds", "must not be negative")
+		}
+		args.Seconds = seconds.Int64()
+	}
+	if len(obj) > 2 {
+		reason, ok := obj[2].(string)
+		if !ok {
+			return shared.NewInvalidTypeError("reason", "not a string")
+		}
+		args.Reason = reason
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc/shared"
+)
+
+func TestBanPeerArgs(t *testing.T) {
+	input := `["10.0.0.0/8", 3600, "spam"]`
+	args := new(BanPeerArgs)
+	if err := json.Unmarshal([]byte(input), &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Target != "10.0.0.0/8" || args.Seconds != 3600 || args.Reason != "spam" {
+		t.Errorf("args mismatch: %+v", args)
+	}
+}
+
+func TestBanPeerArgsNegativeSeconds(t *testing.T) {
+	input := `["10.0.0.0/8", -1]`
+	args := new(BanPeerArgs)
+	err := json.Unmarshal([]byte(input), &args)
+	if _, ok := err.(*shared.ValidationError); !ok {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
@@ -12,6 +12,41 @@ web3._extend({
 			inputFormatter: [web3._extend.utils.formatInputString],
 			outputFormatter: web3._extend.formatters.formatOutputBool
 		}),
+		new web3._extend.Method({
+			name: 'removePeer',
+			call: 'admin_removePeer',
+			params: 2,
+			inputFormatter: [web3._extend.utils.formatInputString, web3._extend.utils.formatInputBool],
+			outputFormatter: web3._extend.formatters.formatOutputBool
+		}),
+		new web3._extend.Method({
+			name: 'addTrustedPeer',
+			call: 'admin_addTrustedPeer',
+			params: 2,
+			inputFormatter: [web3._extend.utils.formatInputString, web3._extend.utils.formatInputBool],
+			outputFormatter: web3._extend.formatters.formatOutputBool
+		}),
+		new web3._extend.Method({
+			name: 'removeTrustedPeer',
+			call: 'admin_removeTrustedPeer',
+			params: 2,
+			inputFormatter: [web3._extend.utils.formatInputString, web3._extend.utils.formatInputBool],
+			outputFormatter: web3._extend.formatters.formatOutputBool
+		}),
+		new web3._extend.Method({
+			name: 'banPeer',
+			call: 'admin_banPeer',
+			params: 3,
+			inputFormatter: [web3._extend.utils.formatInputString, web3._extend.utils.formatInputInt, web3._extend.utils.formatInputString],
+			outputFormatter: web3._extend.formatters.formatOutputBool
+		}),
+		new web3._extend.Method({
+			name: 'unbanPeer',
+			call: 'admin_unbanPeer',
+			params: 1,
+			inputFormatter: [web3._extend.utils.formatInputString],
+			outputFormatter: web3._extend.formatters.formatOutputBool
+		}),
 		new web3._extend.Method({
 			name: 'exportChain',
 			call: 'admin_exportChain',
@@ -53,6 +88,16 @@ web3._extend({
 			getter: 'admin_peers',
 			outputFormatter: function(obj) { return obj; }
 		}),
+		new web3._extend.Property({
+			name: 'bans',
+			getter: 'admin_bans',
+			outputFormatter: function(obj) { return obj; }
+		}),
+		new web3._extend.Property({
+			name: 'discoveryStats',
+			getter: 'admin_discoveryStats',
+			outputFormatter: function(obj) { return obj; }
+		}),
 		new web3._extend.Property({
 			name: 'datadir',
 			getter: 'admin_datadir',
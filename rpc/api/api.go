@@ -25,6 +25,11 @@ var (
 		AdminApiName, EthApiName, DebugApiName, MinerApiName, NetApiName,
 		ShhApiName, TxPoolApiName, PersonalApiName, Web3ApiName,
 	}, ",")
+
+	// List with all API's which are offered over the WebSocket interface by default
+	DefaultWsApis = strings.Join([]string{
+		EthApiName, NetApiName, Web3ApiName,
+	}, ",")
 )
 
 // Ethereum RPC API interface
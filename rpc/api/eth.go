@@ -34,6 +34,7 @@ var (
 		"eth_protocolVersion":                   (*ethApi).ProtocolVersion,
 		"eth_coinbase":                          (*ethApi).Coinbase,
 		"eth_mining":                            (*ethApi).IsMining,
+		"eth_syncing":                           (*ethApi).IsSyncing,
 		"eth_gasPrice":                          (*ethApi).GasPrice,
 		"eth_getStorage":                        (*ethApi).GetStorage,
 		"eth_storageAt":                         (*ethApi).GetStorage,
@@ -124,7 +125,12 @@ func (self *ethApi) GetBalance(req *shared.Request) (interface{}, error) {
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
 
-	return self.xeth.AtStateNum(args.BlockNumber).BalanceAt(args.Address), nil
+	state := self.xeth.AtStateNum(args.BlockNumber)
+	balance := state.BalanceAt(args.Address)
+	if err := state.State().Error(); err != nil {
+		return nil, err
+	}
+	return balance, nil
 }
 
 func (self *ethApi) ProtocolVersion(req *shared.Request) (interface{}, error) {
@@ -139,6 +145,20 @@ func (self *ethApi) IsMining(req *shared.Request) (interface{}, error) {
 	return self.xeth.IsMining(), nil
 }
 
+// IsSyncing returns false once the local chain caught up with the highest known
+// block, otherwise the block numbers the sync started at, reached and targets.
+func (self *ethApi) IsSyncing(req *shared.Request) (interface{}, error) {
+	progress := self.xeth.SyncProgress()
+	if progress.Current >= progress.Height {
+		return false, nil
+	}
+	return map[string]interface{}{
+		"startingBlock": newHexNum(progress.Origin),
+		"currentBlock":  newHexNum(progress.Current),
+		"highestBlock":  newHexNum(progress.Height),
+	}, nil
+}
+
 func (self *ethApi) GasPrice(req *shared.Request) (interface{}, error) {
 	return newHexNum(xeth.DefaultGasPrice().Bytes()), nil
 }
@@ -149,7 +169,12 @@ func (self *ethApi) GetStorage(req *shared.Request) (interface{}, error) {
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
 
-	return self.xeth.AtStateNum(args.BlockNumber).State().SafeGet(args.Address).Storage(), nil
+	state := self.xeth.AtStateNum(args.BlockNumber).State()
+	storage := state.SafeGet(args.Address).Storage()
+	if err := state.Error(); err != nil {
+		return nil, err
+	}
+	return storage, nil
 }
 
 func (self *ethApi) GetStorageAt(req *shared.Request) (interface{}, error) {
@@ -158,7 +183,12 @@ func (self *ethApi) GetStorageAt(req *shared.Request) (interface{}, error) {
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
 
-	return self.xeth.AtStateNum(args.BlockNumber).StorageAt(args.Address, args.Key), nil
+	state := self.xeth.AtStateNum(args.BlockNumber)
+	value := state.StorageAt(args.Address, args.Key)
+	if err := state.State().Error(); err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
 func (self *ethApi) GetTransactionCount(req *shared.Request) (interface{}, error) {
@@ -167,7 +197,11 @@ func (self *ethApi) GetTransactionCount(req *shared.Request) (interface{}, error
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
 
-	count := self.xeth.AtStateNum(args.BlockNumber).TxCountAt(args.Address)
+	state := self.xeth.AtStateNum(args.BlockNumber)
+	count := state.TxCountAt(args.Address)
+	if err := state.State().Error(); err != nil {
+		return nil, err
+	}
 	return newHexNum(big.NewInt(int64(count)).Bytes()), nil
 }
 
@@ -232,7 +266,11 @@ func (self *ethApi) GetData(req *shared.Request) (interface{}, error) {
 	if err := self.codec.Decode(req.Params, &args); err != nil {
 		return nil, shared.NewDecodeParamError(err.Error())
 	}
-	v := self.xeth.AtStateNum(args.BlockNumber).CodeAtBytes(args.Address)
+	state := self.xeth.AtStateNum(args.BlockNumber)
+	v := state.CodeAtBytes(args.Address)
+	if err := state.State().Error(); err != nil {
+		return nil, err
+	}
 	return newHexData(v), nil
 }
 
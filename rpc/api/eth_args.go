@@ -862,3 +862,59 @@ func (args *SubmitWorkArgs) UnmarshalJSON(b []byte) (err error) {
 
 	return nil
 }
+
+type SubscribeArgs struct {
+	Kind   string
+	Filter *BlockFilterArgs
+}
+
+func (args *SubscribeArgs) UnmarshalJSON(b []byte) (err error) {
+	var obj []json.RawMessage
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return shared.NewDecodeParamError(err.Error())
+	}
+
+	if len(obj) < 1 {
+		return shared.NewInsufficientParamsError(len(obj), 1)
+	}
+
+	if err = json.Unmarshal(obj[0], &args.Kind); err != nil {
+		return shared.NewInvalidTypeError("kind", "not a string")
+	}
+
+	// the optional log filter is parsed the same way as for eth_newFilter
+	if len(obj) > 1 {
+		filter, err := json.Marshal(obj[1:2])
+		if err != nil {
+			return shared.NewDecodeParamError(err.Error())
+		}
+		args.Filter = new(BlockFilterArgs)
+		if err = json.Unmarshal(filter, args.Filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type UnsubscribeArgs struct {
+	Id string
+}
+
+func (args *UnsubscribeArgs) UnmarshalJSON(b []byte) (err error) {
+	var obj []interface{}
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return shared.NewDecodeParamError(err.Error())
+	}
+
+	if len(obj) < 1 {
+		return shared.NewInsufficientParamsError(len(obj), 1)
+	}
+
+	var ok bool
+	if args.Id, ok = obj[0].(string); !ok {
+		return shared.NewInvalidTypeError("id", "not a string")
+	}
+
+	return nil
+}
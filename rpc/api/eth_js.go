@@ -1,3 +1,19 @@
 package api
 
-// JS api provided by web3.js
+// JS api provided by web3.js, extended by the calls it doesn't know yet
+const Eth_JS = `
+web3._extend({
+	property: 'eth',
+	methods:
+	[
+	],
+	properties:
+	[
+		new web3._extend.Property({
+			name: 'syncing',
+			getter: 'eth_syncing',
+			outputFormatter: function(obj) { return obj; }
+		})
+	]
+});
+`
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc/codec"
+	"github.com/ethereum/go-ethereum/rpc/shared"
+)
+
+// Event kinds accepted by eth_subscribe
+const (
+	NewHeadsSubscription            = "newHeads"
+	LogsSubscription                = "logs"
+	PendingTransactionsSubscription = "newPendingTransactions"
+	SyncingSubscription             = "syncing"
+	RejectedChainsSubscription      = "rejectedChains"
+)
+
+// Subscriptions tracks the eth_subscribe subscriptions installed by a single
+// client connection and forwards the matching chain events to it.
+type Subscriptions struct {
+	mux    *event.TypeMux
+	codec  codec.ApiCoder
+	notify func(id string, result interface{})
+
+	lock sync.Mutex
+	subs map[string]event.Subscription
+}
+
+// NewSubscriptions creates an empty subscription set on top of the given event
+// mux. Results are delivered through notify, which is called from the event
+// loop of the subscription and must therefore not block.
+func NewSubscriptions(mux *event.TypeMux, coder codec.Codec, notify func(id string, result interface{})) *Subscriptions {
+	return &Subscriptions{
+		mux:    mux,
+		codec:  coder.New(nil),
+		notify: notify,
+		subs:   make(map[string]event.Subscription),
+	}
+}
+
+// Subscribe handles eth_subscribe, returning the id of the new subscription.
+func (self *Subscriptions) Subscribe(req *shared.Request) (interface{}, error) {
+	args := new(SubscribeArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, err
+	}
+
+	var (
+		sub     event.Subscription
+		convert func(interface{}) interface{}
+	)
+	switch args.Kind {
+	case NewHeadsSubscription:
+		sub = self.mux.Subscribe(core.ChainHeadEvent{})
+		convert = func(ev interface{}) interface{} {
+			return NewBlockRes(ev.(core.ChainHeadEvent).Block, false)
+		}
+
+	case LogsSubscription:
+		filter := core.NewFilter(nil)
+		if args.Filter != nil {
+			filter.SetAddress(toAddresses(args.Filter.Address))
+			filter.SetTopics(toTopics(args.Filter.Topics))
+		}
+		sub = self.mux.Subscribe(core.ChainEvent{})
+		convert = func(ev interface{}) interface{} {
+			if logs := filter.FilterLogs(ev.(core.ChainEvent).Logs); len(logs) > 0 {
+				return NewLogsRes(logs)
+			}
+			return nil
+		}
+
+	case PendingTransactionsSubscription:
+		sub = self.mux.Subscribe(core.TxPreEvent{})
+		convert = func(ev interface{}) interface{} {
+			return ev.(core.TxPreEvent).Tx.Hash().Hex()
+		}
+
+	case SyncingSubscription:
+		sub = self.mux.Subscribe(downloader.StartEvent{}, downloader.ProgressEvent{}, downloader.DoneEvent{}, downloader.FailedEvent{})
+		convert = func(ev interface{}) interface{} {
+			var progress downloader.SyncProgress
+			switch ev := ev.(type) {
+			case downloader.StartEvent:
+				progress = ev.SyncProgress
+			case downloader.ProgressEvent:
+				progress = ev.SyncProgress
+			default:
+				return false
+			}
+			return map[string]interface{}{
+				"syncing": true,
+				"status": map[string]interface{}{
+					"startingBlock": newHexNum(progress.Origin),
+					"currentBlock":  newHexNum(progress.Current),
+					"highestBlock":  newHexNum(progress.Height),
+				},
+			}
+		}
+
+	case RejectedChainsSubscription:
+		sub = self.mux.Subscribe(core.ReorgRejectedEvent{})
+		convert = func(ev interface{}) interface{} {
+			rejected := ev.(core.ReorgRejectedEvent)
+			return map[string]interface{}{
+				"number": newHexNum(rejected.Block.Number()),
+				"hash":   newHexData(rejected.Block.Hash()),
+				"reason": rejected.Err.Error(),
+			}
+		}
+
+	default:
+		return nil, shared.NewValidationError("kind", "unknown subscription type "+args.Kind)
+	}
+
+	id := newSubscriptionId()
+
+	self.lock.Lock()
+	self.subs[id] = sub
+	self.lock.Unlock()
+
+	go func() {
+		for ev := range sub.Chan() {
+			if res := convert(ev); res != nil {
+				self.notify(id, res)
+			}
+		}
+	}()
+
+	return id, nil
+}
+
+// Unsubscribe handles eth_unsubscribe, reporting whether the subscription existed.
+func (self *Subscriptions) Unsubscribe(req *shared.Request) (interface{}, error) {
+	args := new(UnsubscribeArgs)
+	if err := self.codec.Decode(req.Params, &args); err != nil {
+		return nil, err
+	}
+
+	self.lock.Lock()
+	sub, ok := self.subs[args.Id]
+	delete(self.subs, args.Id)
+	self.lock.Unlock()
+
+	if ok {
+		sub.Unsubscribe()
+	}
+	return ok, nil
+}
+
+// Close removes all subscriptions, it's called when the connection is dropped.
+func (self *Subscriptions) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for id, sub := range self.subs {
+		sub.Unsubscribe()
+		delete(self.subs, id)
+	}
+}
+
+// newSubscriptionId generates a random hex identifier for a subscription.
+func newSubscriptionId() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return common.ToHex(id[:])
+}
+
+func toAddresses(a []string) []common.Address {
+	addrs := make([]common.Address, len(a))
+	for i, addr := range a {
+		addrs[i] = common.HexToAddress(addr)
+	}
+	return addrs
+}
+
+func toTopics(t [][]string) [][]common.Hash {
+	topics := make([][]common.Hash, len(t))
+	for i, iv := range t {
+		topics[i] = make([]common.Hash, len(iv))
+		for j, jv := range iv {
+			topics[i][j] = common.HexToHash(jv)
+		}
+	}
+	return topics
+}
@@ -39,6 +39,7 @@ var (
 			"protocolVersion",
 			"coinbase",
 			"mining",
+			"syncing",
 			"gasPrice",
 			"getStorage",
 			"storageAt",
@@ -165,6 +166,8 @@ func Javascript(name string) string {
 		return Admin_JS
 	case DebugApiName:
 		return Debug_JS
+	case EthApiName:
+		return Eth_JS
 	case MinerApiName:
 		return Miner_JS
 	case NetApiName:
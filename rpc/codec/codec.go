@@ -13,6 +13,8 @@ type Codec int
 type ApiCoder interface {
 	// Parse message to request from underlying stream
 	ReadRequest() (*shared.Request, error)
+	// Parse a single request or a batch of requests from underlying stream
+	ReadRequests() ([]*shared.Request, bool, error)
 	// Parse response message from underlying stream
 	ReadResponse() (interface{}, error)
 	// Encode response to encoded form in underlying stream
@@ -1,6 +1,7 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/json"
 	"net"
 
@@ -37,6 +38,34 @@ func (self *JsonCodec) ReadRequest() (*shared.Request, error) {
 	return nil, err
 }
 
+// Parse the next request or batch of requests from conn, reporting whether it
+// was a batch. Batch elements which aren't valid requests are returned as nil,
+// so they can be answered individually.
+func (self *JsonCodec) ReadRequests() ([]*shared.Request, bool, error) {
+	var raw json.RawMessage
+	if err := self.d.Decode(&raw); err != nil {
+		return nil, false, err
+	}
+	if data := bytes.TrimLeft(raw, " \t\r\n"); len(data) > 0 && data[0] == '[' {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return nil, true, err
+		}
+		reqs := make([]*shared.Request, len(elems))
+		for i, elem := range elems {
+			if err := json.Unmarshal(elem, &reqs[i]); err != nil {
+				reqs[i] = nil
+			}
+		}
+		return reqs, true, nil
+	}
+	req := shared.Request{}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, false, err
+	}
+	return []*shared.Request{&req}, false, nil
+}
+
 func (self *JsonCodec) ReadResponse() (interface{}, error) {
 	var err error
 	buf := make([]byte, MAX_RESPONSE_SIZE)
@@ -1,7 +1,40 @@
 package comms
 
+import (
+	"github.com/ethereum/go-ethereum/rpc/shared"
+)
+
 type EthereumClient interface {
 	Close()
 	Send(interface{}) error
 	Recv() (interface{}, error)
 }
+
+// handleRequests executes a single request or a batch read from a connection
+// and returns the response to send, or nil if the client doesn't expect one
+// because it only sent notifications. Invalid elements of a batch are nil and
+// answered with an error.
+func handleRequests(execute func(*shared.Request) (interface{}, error), limits shared.BatchLimits, reqs []*shared.Request, batch bool) interface{} {
+	call := func(req *shared.Request) interface{} {
+		res, err := execute(req)
+		if req.Id == nil {
+			return nil
+		}
+		return shared.NewRpcResponse(req.Id, req.Jsonrpc, res, err)
+	}
+	if !batch {
+		return call(reqs[0])
+	}
+	id := func(i int) interface{} {
+		if reqs[i] == nil {
+			return nil
+		}
+		return reqs[i].Id
+	}
+	return shared.ExecuteBatch(len(reqs), limits, id, func(i int) interface{} {
+		if reqs[i] == nil {
+			return &shared.ErrorResponse{Jsonrpc: "2.0", Id: nil, Error: &shared.ErrorObject{Code: -32600, Message: "Invalid request"}}
+		}
+		return call(reqs[i])
+	})
+}
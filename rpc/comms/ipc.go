@@ -3,10 +3,12 @@ package comms
 import (
 	"github.com/ethereum/go-ethereum/rpc/api"
 	"github.com/ethereum/go-ethereum/rpc/codec"
+	"github.com/ethereum/go-ethereum/rpc/shared"
 )
 
 type IpcConfig struct {
-	Endpoint string
+	Endpoint    string
+	BatchLimits shared.BatchLimits
 }
 
 type ipcClient struct {
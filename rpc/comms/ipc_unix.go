@@ -11,7 +11,6 @@ import (
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/rpc/api"
 	"github.com/ethereum/go-ethereum/rpc/codec"
-	"github.com/ethereum/go-ethereum/rpc/shared"
 )
 
 func newIpcClient(cfg IpcConfig, codec codec.Codec) (*ipcClient, error) {
@@ -44,7 +43,7 @@ func startIpc(cfg IpcConfig, codec codec.Codec, api api.EthereumApi) error {
 				codec := codec.New(conn)
 
 				for {
-					req, err := codec.ReadRequest()
+					reqs, batch, err := codec.ReadRequests()
 					if err == io.EOF {
 						codec.Close()
 						return
@@ -54,10 +53,10 @@ func startIpc(cfg IpcConfig, codec codec.Codec, api api.EthereumApi) error {
 						return
 					}
 
-					var rpcResponse interface{}
-					res, err := api.Execute(req)
-
-					rpcResponse = shared.NewRpcResponse(req.Id, req.Jsonrpc, res, err)
+					rpcResponse := handleRequests(api.Execute, cfg.BatchLimits, reqs, batch)
+					if rpcResponse == nil {
+						continue
+					}
 					err = codec.WriteResponse(rpcResponse)
 					if err != nil {
 						glog.V(logger.Error).Infof("IPC send err - %v\n", err)
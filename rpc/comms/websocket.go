@@ -0,0 +1,204 @@
+package comms
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Minimal RFC 6455 server side framing, sufficient to carry JSON-RPC messages.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsMaxFrameSize = 1024 * 1024 // Maximum payload accepted in a single client frame
+)
+
+// frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var (
+	errWsHandshake     = errors.New("invalid websocket handshake")
+	errWsUnmasked      = errors.New("unmasked websocket client frame")
+	errWsFrameTooLarge = errors.New("websocket frame too large")
+	errWsOpcode        = errors.New("unknown websocket opcode")
+)
+
+// wsConn exposes the payload of the data frames received over a websocket as
+// a plain byte stream and sends every write as a single text frame, allowing
+// the regular codecs to run on top of it.
+type wsConn struct {
+	net.Conn
+	r   *bufio.Reader
+	buf []byte // unread remainder of the last data frame
+
+	wlock sync.Mutex
+}
+
+// wsUpgrade validates the websocket handshake request and takes over the
+// underlying connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errWsHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errWsHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, errWsHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errWsHandshake
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: conn, r: rw.Reader}, nil
+}
+
+// wsAcceptKey computes the handshake response for the client's key.
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains checks whether a comma separated header contains a token.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read returns data frame payloads, answering control frames on the way.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case wsContinuation, wsText, wsBinary:
+			c.buf = payload
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, err
+			}
+		case wsPong:
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return 0, io.EOF
+		default:
+			return 0, errWsOpcode
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends p as a single text frame.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsText, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	// clients are required to mask every frame they send
+	if head[1]&0x80 == 0 {
+		return 0, nil, errWsUnmasked
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > wsMaxFrameSize {
+		return 0, nil, errWsFrameTooLarge
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0f, payload, nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+
+	frame := make([]byte, 2, 10+len(payload))
+	frame[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame[1] = 127
+		frame = append(frame, ext[:]...)
+	}
+	_, err := c.Conn.Write(append(frame, payload...))
+	return err
+}
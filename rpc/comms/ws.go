@@ -0,0 +1,159 @@
+package comms
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/rpc/api"
+	"github.com/ethereum/go-ethereum/rpc/codec"
+	"github.com/ethereum/go-ethereum/rpc/shared"
+)
+
+const (
+	wsNotificationBuffer = 256 // Notifications queued per connection before it's considered stalled
+)
+
+type WsConfig struct {
+	ListenAddress string
+	ListenPort    uint
+	Origins       string // Space separated list of allowed browser origins, * allows all
+	BatchLimits   shared.BatchLimits
+}
+
+type wsHandler struct {
+	codec   codec.Codec
+	mux     *event.TypeMux
+	api     api.EthereumApi
+	origins []string
+	limits  shared.BatchLimits
+}
+
+// Start WebSocket server, serving the given apis and pushing eth_subscribe
+// notifications from the event mux
+func StartWs(cfg WsConfig, codec codec.Codec, mux *event.TypeMux, apis ...api.EthereumApi) error {
+	offeredApi := api.Merge(apis...)
+
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.ListenAddress, cfg.ListenPort))
+	if err != nil {
+		return err
+	}
+	go http.Serve(l, newWsHandler(cfg, codec, mux, offeredApi))
+
+	glog.V(logger.Info).Infof("WebSocket service started (%s)\n", l.Addr())
+
+	return nil
+}
+
+func newWsHandler(cfg WsConfig, codec codec.Codec, mux *event.TypeMux, api api.EthereumApi) *wsHandler {
+	return &wsHandler{
+		codec:   codec,
+		mux:     mux,
+		api:     api,
+		origins: strings.Fields(cfg.Origins),
+		limits:  cfg.BatchLimits,
+	}
+}
+
+// allowed checks whether a browser with the given origin may connect.
+func (self *wsHandler) allowed(origin string) bool {
+	for _, allowed := range self.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Non-browser clients don't send an origin and are always accepted
+	if origin := r.Header.Get("Origin"); origin != "" && !self.allowed(origin) {
+		glog.V(logger.Debug).Infof("WebSocket connection from origin %s rejected\n", origin)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn, err := wsUpgrade(w, r)
+	if err != nil {
+		glog.V(logger.Debug).Infof("WebSocket upgrade failed - %v\n", err)
+		return
+	}
+	self.serve(conn)
+}
+
+// serve runs the request loop of a single connection. Subscription
+// notifications are queued and written by a separate goroutine so that a
+// slow client never blocks the event mux; a client falling too far behind
+// is disconnected.
+func (self *wsHandler) serve(conn net.Conn) {
+	var (
+		codec  = self.codec.New(conn)
+		lock   sync.Mutex
+		notifs = make(chan interface{}, wsNotificationBuffer)
+		quit   = make(chan struct{})
+	)
+	defer codec.Close()
+
+	send := func(msg interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return codec.WriteResponse(msg)
+	}
+	subs := api.NewSubscriptions(self.mux, self.codec, func(id string, result interface{}) {
+		select {
+		case notifs <- shared.NewNotification(id, result):
+		default:
+			glog.V(logger.Warn).Infof("WebSocket client too slow, dropping connection\n")
+			codec.Close()
+		}
+	})
+	defer subs.Close()
+	defer close(quit)
+
+	go func() {
+		for {
+			select {
+			case msg := <-notifs:
+				if err := send(msg); err != nil {
+					codec.Close()
+					return
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	execute := func(req *shared.Request) (interface{}, error) {
+		switch req.Method {
+		case "eth_subscribe":
+			return subs.Subscribe(req)
+		case "eth_unsubscribe":
+			return subs.Unsubscribe(req)
+		}
+		return self.api.Execute(req)
+	}
+	for {
+		reqs, batch, err := codec.ReadRequests()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			glog.V(logger.Debug).Infof("WebSocket recv err - %v\n", err)
+			return
+		}
+
+		res := handleRequests(execute, self.limits, reqs, batch)
+		if res == nil {
+			continue
+		}
+		if err := send(res); err != nil {
+			glog.V(logger.Debug).Infof("WebSocket send err - %v\n", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package comms
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc/api"
+	"github.com/ethereum/go-ethereum/rpc/codec"
+)
+
+// testWsClient is a bare bones websocket client speaking JSON-RPC.
+type testWsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWs performs the websocket handshake against the test server, returning
+// the HTTP response and, if the upgrade succeeded, the connected client.
+func dialWs(t *testing.T, server *httptest.Server, origin string) (*http.Response, *testWsClient) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to send handshake: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return res, nil
+	}
+	if have, want := res.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; have != want {
+		t.Fatalf("accept key mismatch: have %s, want %s", have, want)
+	}
+	return res, &testWsClient{conn: conn, r: r}
+}
+
+// send writes msg as a masked text frame, split in two to exercise continuations.
+func (c *testWsClient) send(t *testing.T, msg string) {
+	mask := []byte{1, 2, 3, 4}
+	half := len(msg) / 2
+	for i, part := range []string{msg[:half], msg[half:]} {
+		head := byte(wsContinuation)
+		if i == 0 {
+			head = wsText
+		} else {
+			head |= 0x80
+		}
+		frame := []byte{head, 0x80 | byte(len(part))}
+		frame = append(frame, mask...)
+		for j := 0; j < len(part); j++ {
+			frame = append(frame, part[j]^mask[j%4])
+		}
+		if _, err := c.conn.Write(frame); err != nil {
+			t.Fatalf("failed to send frame: %v", err)
+		}
+	}
+}
+
+// recv reads a single server frame and decodes it into a generic JSON object.
+func (c *testWsClient) recv(t *testing.T) map[string]interface{} {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msg map[string]interface{}
+	if payload := c.readFrame(t); json.Unmarshal(payload, &msg) != nil {
+		t.Fatalf("invalid message %q", payload)
+	}
+	return msg
+}
+
+// readFrame reads the payload of a single unmasked server frame.
+func (c *testWsClient) readFrame(t *testing.T) []byte {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("failed to read payload: %v", err)
+	}
+	return payload
+}
+
+func newTestWsServer(origins string, mux *event.TypeMux) *httptest.Server {
+	handler := newWsHandler(WsConfig{Origins: origins}, codec.JSON, mux, api.Merge(api.NewWeb3Api(nil, codec.JSON)))
+	return httptest.NewServer(handler)
+}
+
+func TestWsOrigins(t *testing.T) {
+	server := newTestWsServer("http://allowed.com", new(event.TypeMux))
+	defer server.Close()
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://allowed.com", http.StatusSwitchingProtocols},
+		{"http://denied.com", http.StatusForbidden},
+	}
+	for i, tt := range tests {
+		res, client := dialWs(t, server, tt.origin)
+		if res.StatusCode != tt.status {
+			t.Errorf("test %d: status mismatch: have %d, want %d", i, res.StatusCode, tt.status)
+		}
+		if client != nil {
+			client.conn.Close()
+		}
+	}
+}
+
+func TestWsSubscriptions(t *testing.T) {
+	mux := new(event.TypeMux)
+	server := newTestWsServer("", mux)
+	defer server.Close()
+
+	_, client := dialWs(t, server, "")
+	if client == nil {
+		t.Fatalf("websocket upgrade failed")
+	}
+	defer client.conn.Close()
+
+	// Regular API methods are served as over IPC
+	client.send(t, `{"jsonrpc":"2.0","id":1,"method":"modules","params":[]}`)
+	if modules, ok := client.recv(t)["result"].(map[string]interface{}); !ok || modules[api.Web3ApiName] == nil {
+		t.Fatalf("modules mismatch: %v", modules)
+	}
+	// New heads are pushed to the subscriber
+	client.send(t, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newHeads"]}`)
+	res := client.recv(t)
+	id, ok := res["result"].(string)
+	if !ok {
+		t.Fatalf("subscription failed: %v", res)
+	}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(7)})
+	mux.Post(core.ChainHeadEvent{Block: block})
+
+	notif := client.recv(t)
+	if notif["method"] != "eth_subscription" {
+		t.Fatalf("expected notification, got %v", notif)
+	}
+	params := notif["params"].(map[string]interface{})
+	if params["subscription"] != id {
+		t.Errorf("subscription id mismatch: have %v, want %s", params["subscription"], id)
+	}
+	if number := params["result"].(map[string]interface{})["number"]; number != "0x7" {
+		t.Errorf("head number mismatch: have %v, want 0x7", number)
+	}
+	// Chains refused by the reorg limits are reported
+	client.send(t, `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["rejectedChains"]}`)
+	if res := client.recv(t); res["result"] == nil {
+		t.Fatalf("rejected chains subscription failed: %v", res)
+	}
+	mux.Post(core.ReorgRejectedEvent{Block: block, Err: errors.New("reorg too deep")})
+	notif = client.recv(t)
+	if result := notif["params"].(map[string]interface{})["result"].(map[string]interface{}); result["number"] != "0x7" || result["reason"] != "reorg too deep" {
+		t.Errorf("rejected chain mismatch: %v", result)
+	}
+	// Unknown subscription types are rejected
+	client.send(t, `{"jsonrpc":"2.0","id":4,"method":"eth_subscribe","params":["unknown"]}`)
+	if res := client.recv(t); res["error"] == nil {
+		t.Errorf("unknown subscription accepted: %v", res)
+	}
+	// Unsubscribing stops the notifications
+	client.send(t, `{"jsonrpc":"2.0","id":5,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	if res := client.recv(t); res["result"] != true {
+		t.Fatalf("unsubscribe failed: %v", res)
+	}
+	mux.Post(core.ChainHeadEvent{Block: block})
+	client.send(t, `{"jsonrpc":"2.0","id":6,"method":"modules","params":[]}`)
+	if res := client.recv(t); res["id"] != float64(6) {
+		t.Fatalf("notification received after unsubscribe: %v", res)
+	}
+}
+
+func TestWsBatch(t *testing.T) {
+	server := newTestWsServer("", new(event.TypeMux))
+	defer server.Close()
+
+	_, client := dialWs(t, server, "")
+	if client == nil {
+		t.Fatalf("websocket upgrade failed")
+	}
+	defer client.conn.Close()
+
+	// Notifications are executed but not answered
+	client.send(t, `[{"jsonrpc":"2.0","id":1,"method":"modules"},{"jsonrpc":"2.0","method":"modules"},{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["unknown"]}]`)
+	res := client.recvBatch(t)
+	if len(res) != 2 {
+		t.Fatalf("response count mismatch: have %d, want 2", len(res))
+	}
+	if res[0]["id"] != float64(1) || res[0]["result"] == nil {
+		t.Errorf("first response mismatch: %v", res[0])
+	}
+	if res[1]["id"] != float64(2) || res[1]["error"] == nil {
+		t.Errorf("second response mismatch: %v", res[1])
+	}
+	// A lone notification gets no reply at all
+	client.send(t, `{"jsonrpc":"2.0","method":"modules"}`)
+	client.send(t, `{"jsonrpc":"2.0","id":3,"method":"modules"}`)
+	if res := client.recv(t); res["id"] != float64(3) {
+		t.Fatalf("notification answered: %v", res)
+	}
+	// Invalid elements are answered individually without dropping the connection
+	client.send(t, `[{"jsonrpc":"2.0","id":4,"method":"modules"},null,1]`)
+	res = client.recvBatch(t)
+	if len(res) != 3 {
+		t.Fatalf("response count mismatch: have %d, want 3", len(res))
+	}
+	if res[0]["id"] != float64(4) || res[0]["result"] == nil {
+		t.Errorf("valid element response mismatch: %v", res[0])
+	}
+	for i, r := range res[1:] {
+		if err, ok := r["error"].(map[string]interface{}); !ok || err["code"] != float64(-32600) || r["id"] != nil {
+			t.Errorf("invalid element %d response mismatch: %v", i+1, r)
+		}
+	}
+	client.send(t, `{"jsonrpc":"2.0","id":5,"method":"modules"}`)
+	if res := client.recv(t); res["id"] != float64(5) {
+		t.Fatalf("connection unusable after invalid batch elements: %v", res)
+	}
+}
+
+// recvBatch reads a single server frame holding a batch response.
+func (c *testWsClient) recvBatch(t *testing.T) []map[string]interface{} {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var batch []map[string]interface{}
+	if err := json.Unmarshal(c.readFrame(t), &batch); err != nil {
+		t.Fatalf("invalid batch: %v", err)
+	}
+	return batch
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/rpc/shared"
 	"github.com/ethereum/go-ethereum/xeth"
 	"github.com/rs/cors"
 )
@@ -43,9 +44,9 @@ func Start(pipe *xeth.XEth, config RpcConfig) error {
 		opts.AllowedOrigins = strings.Split(config.CorsDomain, " ")
 
 		c := cors.New(opts)
-		handler = newStoppableHandler(c.Handler(JSONRPC(pipe)), l.stop)
+		handler = newStoppableHandler(c.Handler(JSONRPC(pipe, config.BatchLimits)), l.stop)
 	} else {
-		handler = newStoppableHandler(JSONRPC(pipe), l.stop)
+		handler = newStoppableHandler(JSONRPC(pipe, config.BatchLimits), l.stop)
 	}
 
 	go http.Serve(l, handler)
@@ -62,8 +63,9 @@ func Stop() error {
 	return nil
 }
 
-// JSONRPC returns a handler that implements the Ethereum JSON-RPC API.
-func JSONRPC(pipe *xeth.XEth) http.Handler {
+// JSONRPC returns a handler that implements the Ethereum JSON-RPC API, with
+// the given limits applied to batch requests.
+func JSONRPC(pipe *xeth.XEth, limits shared.BatchLimits) http.Handler {
 	api := NewEthereumApi(pipe)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -82,6 +84,7 @@ func JSONRPC(pipe *xeth.XEth) http.Handler {
 		if err != nil {
 			jsonerr := &RpcErrorObject{-32700, "Could not read request body"}
 			send(w, &RpcErrorResponse{Jsonrpc: jsonrpcver, Id: nil, Error: jsonerr})
+			return
 		}
 
 		// Try to parse the request as a single
@@ -94,32 +97,38 @@ func JSONRPC(pipe *xeth.XEth) http.Handler {
 			return
 		}
 
-		// Try to parse the request to batch
-		var reqBatch []RpcRequest
-		if err := json.Unmarshal(body, &reqBatch); err == nil {
-			// Build response batch
-			resBatch := make([]*interface{}, len(reqBatch))
-			resCount := 0
-
-			for i, request := range reqBatch {
-				response := RpcResponse(api, &request)
-				// this leaves nil entries in the response batch for later removal
-				if request.Id != nil {
-					resBatch[i] = response
-					resCount = resCount + 1
+		// Try to parse the request to batch, decoding each element on its own so
+		// invalid ones can be answered individually
+		var elems []json.RawMessage
+		if err := json.Unmarshal(body, &elems); err == nil {
+			reqBatch := make([]*RpcRequest, len(elems))
+			for i, elem := range elems {
+				if err := json.Unmarshal(elem, &reqBatch[i]); err != nil {
+					reqBatch[i] = nil
 				}
 			}
-
-			// make response omitting nil entries
-			respBatchComp := make([]*interface{}, resCount)
-			for _, v := range resBatch {
-				if v != nil {
-					respBatchComp[len(respBatchComp)-resCount] = v
-					resCount = resCount - 1
+			// Execute the calls concurrently, omitting notifications from the response
+			id := func(i int) interface{} {
+				if reqBatch[i] == nil {
+					return nil
 				}
+				return reqBatch[i].Id
+			}
+			resBatch := shared.ExecuteBatch(len(reqBatch), limits, id, func(i int) interface{} {
+				request := reqBatch[i]
+				if request == nil {
+					jsonerr := &RpcErrorObject{-32600, "Invalid request"}
+					return &RpcErrorResponse{Jsonrpc: jsonrpcver, Id: nil, Error: jsonerr}
+				}
+				response := RpcResponse(api, request)
+				if request.Id == nil {
+					return nil
+				}
+				return response
+			})
+			if resBatch != nil {
+				send(w, resBatch)
 			}
-
-			send(w, respBatchComp)
 			return
 		}
 
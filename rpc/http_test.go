@@ -0,0 +1,43 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc/shared"
+)
+
+// Tests that invalid elements of an HTTP batch request are answered
+// individually, next to the responses of the valid ones.
+func TestHTTPBatchInvalidElement(t *testing.T) {
+	server := httptest.NewServer(JSONRPC(nil, shared.DefaultBatchLimits))
+	defer server.Close()
+
+	body := `[1, {"jsonrpc":"2.0","method":"web3_sha3","params":["0x68656c6c6f20776f726c64"],"id":64}]`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var results []struct {
+		Id     interface{}
+		Result string
+		Error  *RpcErrorObject
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("batch response not decodable: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("response count mismatch: have %d, want 2", len(results))
+	}
+	if results[0].Error == nil || results[0].Error.Code != -32600 {
+		t.Errorf("invalid element not rejected: %+v", results[0])
+	}
+	if want := "0x47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad"; results[1].Error != nil || results[1].Result != want {
+		t.Errorf("valid element mismatch: have %+v, want result %s", results[1], want)
+	}
+}
@@ -94,7 +94,7 @@ func (self *Jeth) SendIpc(call otto.FunctionCall) (response otto.Value) {
 		return self.err(call, -32700, err.Error(), nil)
 	}
 
-	client, err := comms.NewIpcClient(comms.IpcConfig{self.ipcpath}, codec.JSON)
+	client, err := comms.NewIpcClient(comms.IpcConfig{Endpoint: self.ipcpath}, codec.JSON)
 	if err != nil {
 		fmt.Println("Unable to connect to geth.")
 		return self.err(call, -32603, err.Error(), -1)
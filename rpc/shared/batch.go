@@ -0,0 +1,124 @@
+package shared
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	DefaultBatchRequestLimit  = 1000             // Default maximum number of calls in a batch
+	DefaultBatchResponseLimit = 25 * 1024 * 1024 // Default maximum encoded size of a batch response
+
+	batchWorkers = 16 // Number of calls of a batch executed concurrently
+)
+
+// Limits applied to JSON-RPC batch requests, a zero value disables the limit
+type BatchLimits struct {
+	Requests     int
+	ResponseSize int
+}
+
+var DefaultBatchLimits = BatchLimits{
+	Requests:     DefaultBatchRequestLimit,
+	ResponseSize: DefaultBatchResponseLimit,
+}
+
+// ExecuteBatch runs the n calls of a batch concurrently. The call function
+// executes the i'th request and returns its response; notifications return a
+// nil response and are left out of the result. The encoded responses are
+// returned in request order. Once the responses exceed the size limit, no
+// further calls are started, and calls beyond the limit are answered with an
+// error carrying the id reported by the id function, or left out if that's nil.
+// The returned value is nil if there's nothing to send back.
+func ExecuteBatch(n int, limits BatchLimits, id func(i int) interface{}, call func(i int) interface{}) interface{} {
+	if n == 0 {
+		return newBatchError(-32600, "empty batch")
+	}
+	if limits.Requests > 0 && n > limits.Requests {
+		return newBatchError(-32600, "batch too large")
+	}
+
+	var (
+		encoded = make([][]byte, n)
+		done    = make([]bool, n)
+		next    = make(chan int, n)
+		pend    sync.WaitGroup
+
+		lock    sync.Mutex
+		size    int
+		stopped bool
+	)
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+
+	workers := batchWorkers
+	if n < workers {
+		workers = n
+	}
+	pend.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer pend.Done()
+			for i := range next {
+				// Don't start any more calls once the size limit was hit
+				lock.Lock()
+				skip := stopped
+				lock.Unlock()
+				if skip {
+					continue
+				}
+				response := call(i)
+				if response != nil {
+					enc, err := json.Marshal(response)
+					if err != nil {
+						glog.V(logger.Error).Infof("Error encoding batch response: %v\n", err)
+						enc, _ = json.Marshal(&ErrorResponse{Jsonrpc: "2.0", Id: id(i), Error: &ErrorObject{-32603, err.Error()}})
+					}
+					encoded[i] = enc
+				}
+				lock.Lock()
+				done[i] = true
+				size += len(encoded[i])
+				stopped = limits.ResponseSize > 0 && size > limits.ResponseSize
+				lock.Unlock()
+			}
+		}()
+	}
+	pend.Wait()
+
+	// Collect the responses in order, enforcing the size limit
+	var (
+		results  []json.RawMessage
+		exceeded bool
+	)
+	size = 0
+	for i := 0; i < n; i++ {
+		enc := encoded[i]
+		if done[i] && enc == nil {
+			continue
+		}
+		if !done[i] && id(i) == nil {
+			continue
+		}
+		exceeded = exceeded || !done[i] || (limits.ResponseSize > 0 && size+len(enc) > limits.ResponseSize)
+		if exceeded {
+			enc, _ = json.Marshal(&ErrorResponse{Jsonrpc: "2.0", Id: id(i), Error: &ErrorObject{-32003, "response size limit exceeded"}})
+		}
+		size += len(enc)
+		results = append(results, enc)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return results
+}
+
+// newBatchError creates the response for a batch rejected as a whole.
+func newBatchError(code int, msg string) *ErrorResponse {
+	return &ErrorResponse{Jsonrpc: "2.0", Id: nil, Error: &ErrorObject{code, msg}}
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBatchId returns the id of every even call, odd calls are notifications.
+func testBatchId(i int) interface{} {
+	if i%2 == 1 {
+		return nil
+	}
+	return i
+}
+
+// testBatchCall answers every even call after a delay shrinking with its index,
+// so later calls tend to finish first.
+func testBatchCall(i int) interface{} {
+	time.Sleep(time.Duration(10-i%10) * time.Millisecond)
+	if i%2 == 1 {
+		return nil
+	}
+	return &SuccessResponse{Jsonrpc: "2.0", Id: i, Result: strings.Repeat("x", 10)}
+}
+
+func TestExecuteBatch(t *testing.T) {
+	// Responses are returned in order, without the notifications
+	res, ok := ExecuteBatch(40, BatchLimits{}, testBatchId, testBatchCall).([]json.RawMessage)
+	if !ok || len(res) != 20 {
+		t.Fatalf("response count mismatch: have %v, want 20", res)
+	}
+	for i, enc := range res {
+		var response SuccessResponse
+		if err := json.Unmarshal(enc, &response); err != nil {
+			t.Fatalf("response %d: invalid encoding: %v", i, err)
+		}
+		if response.Id != float64(2*i) {
+			t.Errorf("response %d: id mismatch: have %v, want %d", i, response.Id, 2*i)
+		}
+	}
+	// Batches of notifications have no response
+	if res := ExecuteBatch(1, BatchLimits{}, testBatchId, func(int) interface{} { return nil }); res != nil {
+		t.Errorf("notification batch answered: %v", res)
+	}
+	// Empty and oversized batches are rejected as a whole
+	if res, ok := ExecuteBatch(0, BatchLimits{}, testBatchId, testBatchCall).(*ErrorResponse); !ok || res.Error.Code != -32600 {
+		t.Errorf("empty batch not rejected: %v", res)
+	}
+	if res, ok := ExecuteBatch(11, BatchLimits{Requests: 10}, testBatchId, testBatchCall).(*ErrorResponse); !ok || res.Error.Code != -32600 {
+		t.Errorf("oversized batch not rejected: %v", res)
+	}
+}
+
+func TestExecuteBatchResponseLimit(t *testing.T) {
+	single, _ := json.Marshal(&SuccessResponse{Jsonrpc: "2.0", Id: 0, Result: strings.Repeat("x", 10)})
+
+	res := ExecuteBatch(10, BatchLimits{ResponseSize: 3 * len(single)}, testBatchId, testBatchCall).([]json.RawMessage)
+	if len(res) != 5 {
+		t.Fatalf("response count mismatch: have %d, want 5", len(res))
+	}
+	for i, enc := range res {
+		var response ErrorResponse
+		json.Unmarshal(enc, &response)
+
+		if failed := response.Error != nil; failed != (i >= 3) {
+			t.Errorf("response %d: failure mismatch: have %v, want %v", i, failed, i >= 3)
+		}
+		if response.Error != nil && response.Error.Code != -32003 {
+			t.Errorf("response %d: error code mismatch: have %d, want -32003", i, response.Error.Code)
+		}
+		if response.Id != float64(2*i) {
+			t.Errorf("response %d: id mismatch: have %v, want %d", i, response.Id, 2*i)
+		}
+	}
+}
+
+// Tests that no further calls are started once the responses exceed the size
+// limit, the skipped calls being answered with an error.
+func TestExecuteBatchStopsAtLimit(t *testing.T) {
+	single, _ := json.Marshal(&SuccessResponse{Jsonrpc: "2.0", Id: 0, Result: strings.Repeat("x", 10)})
+
+	var calls int32
+	call := func(i int) interface{} {
+		atomic.AddInt32(&calls, 1)
+		return testBatchCall(i)
+	}
+	res := ExecuteBatch(200, BatchLimits{ResponseSize: 3 * len(single)}, testBatchId, call).([]json.RawMessage)
+	if n := atomic.LoadInt32(&calls); n > 4*batchWorkers {
+		t.Errorf("too many calls executed: have %d, want at most %d", n, 4*batchWorkers)
+	}
+	if len(res) != 100 {
+		t.Fatalf("response count mismatch: have %d, want 100", len(res))
+	}
+	for i, enc := range res[3:] {
+		var response ErrorResponse
+		if json.Unmarshal(enc, &response); response.Error == nil || response.Error.Code != -32003 {
+			t.Errorf("response %d: expected size limit error, got %s", i+3, enc)
+		}
+		if response.Id != float64(2*(i+3)) {
+			t.Errorf("response %d: id mismatch: have %v, want %d", i+3, response.Id, 2*(i+3))
+		}
+	}
+}
@@ -62,3 +62,24 @@ func NewRpcResponse(id interface{}, jsonrpcver string, reply interface{}, err er
 	glog.V(logger.Detail).Infof("Generated response: %T %s", response, response)
 	return &response
 }
+
+// RPC notification pushed to a subscriber
+type Notification struct {
+	Jsonrpc string              `json:"jsonrpc"`
+	Method  string              `json:"method"`
+	Params  *SubscriptionResult `json:"params"`
+}
+
+// Subscription notification payload
+type SubscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+func NewNotification(subscription string, result interface{}) *Notification {
+	return &Notification{
+		Jsonrpc: "2.0",
+		Method:  "eth_subscription",
+		Params:  &SubscriptionResult{Subscription: subscription, Result: result},
+	}
+}
@@ -33,6 +33,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc/shared"
 )
 
 type hexdata struct {
@@ -168,6 +169,7 @@ type RpcConfig struct {
 	ListenAddress string
 	ListenPort    uint
 	CorsDomain    string
+	BatchLimits   shared.BatchLimits
 }
 
 type InvalidTypeError struct {
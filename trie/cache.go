@@ -48,3 +48,14 @@ func (self *Cache) Copy() *Cache {
 func (self *Cache) Reset() {
 	//self.store = make(map[string][]byte)
 }
+
+// OdrBackend is a Backend able to retrieve missing trie nodes on demand, e.g.
+// from the network. Tries on top of it resolve their root node lazily and ask
+// for the nodes on the path of a key before accessing it.
+type OdrBackend interface {
+	Backend
+
+	// RetrieveProof makes the nodes on the path of key in the trie with the
+	// given root available from the backend, retrieving them if necessary.
+	RetrieveProof(root, key []byte) error
+}
@@ -25,6 +25,9 @@ func (self *Iterator) Next() bool {
 		self.Key = make([]byte, 32)
 	}
 
+	if hash, ok := self.trie.root.(*HashNode); ok {
+		self.trie.root = self.trie.trans(hash)
+	}
 	key := RemTerm(CompactHexDecode(string(self.Key)))
 	k := self.next(self.trie.root, key, isIterStart)
 
@@ -0,0 +1,155 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Prove constructs a Merkle proof for key. The result contains the encoded
+// nodes on the path to the value of key, starting with the root node. If the
+// trie holds no value for key, the proof contains the nodes along the longest
+// existing prefix of the key, proving its absence. An error is returned if a
+// node on the path is not available from the trie's backend.
+func (self *Trie) Prove(key []byte) ([][]byte, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Hashing the trie stores all modified nodes in the cache
+	root := self.Hash()
+	if self.root == nil {
+		return nil, nil
+	}
+	var proof [][]byte
+	resolve := func(hash []byte) ([]byte, error) {
+		blob := self.cache.Get(hash)
+		if len(blob) == 0 && bytes.Equal(hash, root) {
+			// Root nodes shorter than a hash are never stored
+			blob = common.Encode(self.root.RlpData())
+		}
+		if len(blob) == 0 {
+			return nil, fmt.Errorf("missing trie node %x", hash)
+		}
+		proof = append(proof, blob)
+		return blob, nil
+	}
+	if _, err := walkProof(root, CompactHexDecode(string(key)), resolve); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Prove constructs a Merkle proof for key, see Trie.Prove.
+func (self *SecureTrie) Prove(key []byte) ([][]byte, error) {
+	return self.Trie.Prove(crypto.Sha3(key))
+}
+
+// VerifyProof checks a Merkle proof created by Prove against the trie root
+// hash. It returns the value of key, which is nil if the proof shows that the
+// key is not in the trie. An error is returned if the proof is incomplete or
+// its nodes don't hash to the expected references.
+func VerifyProof(root common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	nodes := make(map[common.Hash][]byte, len(proof))
+	for _, blob := range proof {
+		nodes[crypto.Sha3Hash(blob)] = blob
+	}
+	resolve := func(hash []byte) ([]byte, error) {
+		blob, ok := nodes[common.BytesToHash(hash)]
+		if !ok {
+			return nil, fmt.Errorf("proof node %x missing", hash)
+		}
+		return blob, nil
+	}
+	if root == emptyRoot {
+		return nil, nil
+	}
+	return walkProof(root[:], CompactHexDecode(string(key)), resolve)
+}
+
+// walkProof follows the nibble key from the root node down to its value,
+// resolving the hash references on the way.
+func walkProof(root []byte, key []byte, resolve func([]byte) ([]byte, error)) ([]byte, error) {
+	for hash := root; ; {
+		blob, err := resolve(hash)
+		if err != nil {
+			return nil, err
+		}
+		var value []byte
+		if value, hash, key, err = descendNode(blob, key); err != nil || hash == nil {
+			return value, err
+		}
+	}
+}
+
+// descendNode follows the nibble key into an encoded trie node. It returns
+// either the value of the key, or the hash of the next node on its path along
+// with the remaining key. If both are nil, the key is not in the trie.
+func descendNode(blob []byte, key []byte) (value, next, rest []byte, err error) {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid trie node: %v", err)
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid trie node: %v", err)
+	}
+	switch count {
+	case 2:
+		// Short node, either a leaf or an extension to a child node
+		kbuf, ref, err := rlp.SplitString(elems)
+		if err != nil || len(kbuf) == 0 {
+			return nil, nil, nil, fmt.Errorf("invalid short node key %x", kbuf)
+		}
+		nibbles := CompactDecode(string(kbuf))
+		if len(key) < len(nibbles) || !bytes.Equal(nibbles, key[:len(nibbles)]) {
+			return nil, nil, nil, nil
+		}
+		if nibbles[len(nibbles)-1] == 16 {
+			value, _, err := rlp.SplitString(ref)
+			return value, nil, nil, err
+		}
+		return descendRef(ref, key[len(nibbles):])
+
+	case 17:
+		// Full node, descend into the child selected by the next nibble
+		for i := byte(0); i < key[0]; i++ {
+			if _, elems, err = splitRaw(elems); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid full node: %v", err)
+			}
+		}
+		if key[0] == 16 {
+			value, _, err := rlp.SplitString(elems)
+			return value, nil, nil, err
+		}
+		ref, _, err := splitRaw(elems)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid full node: %v", err)
+		}
+		return descendRef(ref, key[1:])
+
+	default:
+		return nil, nil, nil, fmt.Errorf("invalid trie node: %d elements", count)
+	}
+}
+
+// descendRef follows the key into a child reference, which is either empty, the
+// hash of the child node or the child node itself if its encoding is short.
+func descendRef(ref []byte, key []byte) (value, next, rest []byte, err error) {
+	kind, content, _, err := rlp.Split(ref)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid node reference: %v", err)
+	}
+	switch {
+	case kind == rlp.List:
+		return descendNode(ref, key)
+	case len(content) == 0:
+		return nil, nil, nil, nil
+	case len(content) == len(common.Hash{}):
+		return nil, content, key, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("invalid node reference %x", content)
+	}
+}
@@ -0,0 +1,210 @@
+package trie
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"errors"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestProof(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := common.BytesToHash(trie.Hash())
+	for _, kv := range vals {
+		proof, err := trie.Prove(kv.k)
+		if err != nil {
+			t.Fatalf("prove %x: %v", kv.k, err)
+		}
+		val, err := VerifyProof(root, kv.k, proof)
+		if err != nil {
+			t.Fatalf("verify %x: %v\nraw proof: %x", kv.k, err, proof)
+		}
+		if !bytes.Equal(val, kv.v) {
+			t.Fatalf("verified value mismatch for key %x: have %x, want %x", kv.k, val, kv.v)
+		}
+	}
+}
+
+func TestProofAbsent(t *testing.T) {
+	trie, _ := randomTrie(500)
+	root := common.BytesToHash(trie.Hash())
+	for i := 0; i < 100; i++ {
+		key := randBytes(32)
+		proof, err := trie.Prove(key)
+		if err != nil {
+			t.Fatalf("prove %x: %v", key, err)
+		}
+		val, err := VerifyProof(root, key, proof)
+		if err != nil {
+			t.Fatalf("verify %x: %v", key, err)
+		}
+		if val != nil {
+			t.Fatalf("verified value for absent key %x: %x", key, val)
+		}
+	}
+}
+
+func TestProofSmallTrie(t *testing.T) {
+	trie := NewEmpty()
+	trie.UpdateString("k", "v")
+	root := common.BytesToHash(trie.Hash())
+
+	proof, err := trie.Prove([]byte("k"))
+	if err != nil {
+		t.Fatalf("prove: %v", err)
+	}
+	if val, err := VerifyProof(root, []byte("k"), proof); err != nil || string(val) != "v" {
+		t.Fatalf("verify: got %q, %v", val, err)
+	}
+}
+
+func TestBadProof(t *testing.T) {
+	trie, vals := randomTrie(800)
+	root := common.BytesToHash(trie.Hash())
+	for _, kv := range vals {
+		proof, _ := trie.Prove(kv.k)
+		if len(proof) == 0 {
+			t.Fatal("zero length proof")
+		}
+		// Dropping or corrupting a node must break the proof
+		i := mrand.Intn(len(proof))
+		dropped := append(append([][]byte{}, proof[:i]...), proof[i+1:]...)
+		if _, err := VerifyProof(root, kv.k, dropped); err == nil {
+			t.Fatalf("expected proof with dropped node %d to fail for key %x", i, kv.k)
+		}
+		corrupt := append([][]byte{}, proof...)
+		corrupt[i] = common.CopyBytes(corrupt[i])
+		corrupt[i][len(corrupt[i])-1] ^= 0xff
+		if val, err := VerifyProof(root, kv.k, corrupt); err == nil && bytes.Equal(val, kv.v) {
+			t.Fatalf("expected proof with corrupt node %d to fail for key %x", i, kv.k)
+		}
+	}
+}
+
+func TestProofMissingNode(t *testing.T) {
+	db := make(Db)
+	trie := New(nil, db)
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		keys = append(keys, randBytes(32))
+		trie.Update(keys[i], randBytes(20))
+	}
+	trie.Commit()
+
+	// Delete a node from the database and load the trie again
+	for k := range db {
+		if !bytes.Equal([]byte(k), trie.Hash()) {
+			delete(db, k)
+			break
+		}
+	}
+	trie = New(trie.Hash(), db)
+	failed := false
+	for _, key := range keys {
+		if _, err := trie.Prove(key); err != nil {
+			failed = true
+			break
+		}
+	}
+	if !failed {
+		t.Fatal("no proof failed despite missing node")
+	}
+}
+
+// odrDb is an ODR backend serving missing nodes from a remote database.
+type odrDb struct {
+	Db
+	remote    Db
+	retrieved int
+	err       error // returned instead of retrieving if set
+}
+
+func (db *odrDb) RetrieveProof(root, key []byte) error {
+	if db.err != nil {
+		return db.err
+	}
+	proof, err := New(root, db.remote).Prove(key)
+	if err != nil {
+		return err
+	}
+	for _, node := range proof {
+		db.Put(crypto.Sha3(node), node)
+	}
+	db.retrieved++
+	return nil
+}
+
+func TestOdrBackend(t *testing.T) {
+	remote := make(Db)
+	trie := New(nil, remote)
+	vals := make(map[string][]byte)
+	for i := 0; i < 200; i++ {
+		k, v := randBytes(32), randBytes(20)
+		trie.Update(k, v)
+		vals[string(k)] = v
+	}
+	trie.Commit()
+	root := trie.Hash()
+
+	local := &odrDb{Db: make(Db), remote: remote}
+	odrTrie := New(root, local)
+	if local.retrieved != 0 {
+		t.Fatal("nodes retrieved before the trie was accessed")
+	}
+	for k, v := range vals {
+		if have := odrTrie.Get([]byte(k)); !bytes.Equal(have, v) {
+			t.Fatalf("value mismatch for key %x: have %x, want %x", k, have, v)
+		}
+	}
+	if !bytes.Equal(odrTrie.Hash(), root) {
+		t.Fatalf("root mismatch: have %x, want %x", odrTrie.Hash(), root)
+	}
+	if err := odrTrie.Error(); err != nil {
+		t.Fatalf("unexpected retrieval error: %v", err)
+	}
+
+	// Retrieval failures must be reported instead of reading as missing values
+	failure := errors.New("network failure")
+	odrTrie = New(root, &odrDb{Db: make(Db), remote: remote, err: failure})
+	for k := range vals {
+		if have := odrTrie.Get([]byte(k)); have != nil {
+			t.Fatalf("value found without retrieval: %x", have)
+		}
+		break
+	}
+	if err := odrTrie.Error(); err != failure {
+		t.Fatalf("retrieval error mismatch: have %v, want %v", err, failure)
+	}
+	if err := odrTrie.Copy().Error(); err != failure {
+		t.Fatalf("retrieval error lost on copy: have %v", err)
+	}
+}
+
+func randomTrie(n int) (*Trie, map[string]*kv) {
+	trie := NewEmpty()
+	vals := make(map[string]*kv)
+	for i := byte(0); i < 100; i++ {
+		value := &kv{common.LeftPadBytes([]byte{i}, 32), []byte{i}, false}
+		value2 := &kv{common.LeftPadBytes([]byte{i + 10}, 32), []byte{i}, false}
+		trie.Update(value.k, value.v)
+		trie.Update(value2.k, value2.v)
+		vals[string(value.k)] = value
+		vals[string(value2.k)] = value2
+	}
+	for i := 0; i < n; i++ {
+		value := &kv{randBytes(32), randBytes(20), false}
+		trie.Update(value.k, value.v)
+		vals[string(value.k)] = value
+	}
+	return trie, vals
+}
+
+func randBytes(n int) []byte {
+	r := make([]byte, n)
+	crand.Read(r)
+	return r
+}
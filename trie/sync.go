@@ -0,0 +1,331 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
+)
+
+var (
+	// ErrNotRequested is returned by the trie sync when it's requested to
+	// process a node it did not request.
+	ErrNotRequested = errors.New("not requested")
+
+	// ErrAlreadyProcessed is returned by the trie sync when it's requested to
+	// process a node it already processed previously.
+	ErrAlreadyProcessed = errors.New("already processed")
+)
+
+var (
+	emptyRoot  = crypto.Sha3Hash(common.Encode("")) // Root hash of an empty trie
+	emptyState = crypto.Sha3Hash(nil)               // Hash of an empty raw state entry (e.g. no code)
+)
+
+// request represents a scheduled or already in-flight state retrieval request.
+type request struct {
+	hash common.Hash // Hash of the node data content to retrieve
+	data []byte      // Data content of the node, cached until all subtrees complete
+	raw  bool        // Whether this is a raw entry (code) or a trie node
+
+	parents []*request // Parent state nodes referencing this entry (notify all upon completion)
+	depth   int        // Depth level within the trie the node is located to prioritise BFS
+	deps    int        // Number of dependencies before allowed to commit this node
+
+	callback SyncLeafCallback // Callback to invoke if a leaf node it reached on this branch
+}
+
+// SyncResult is a simple list to return missing nodes along with their request
+// hashes.
+type SyncResult struct {
+	Hash common.Hash // Hash of the originally unknown trie node
+	Data []byte      // Data content of the retrieved node
+}
+
+// SyncLeafCallback is a callback type invoked when a trie sync reaches a
+// leaf node. It's used by state syncing to check if the leaf node requires some
+// further data syncing.
+type SyncLeafCallback func(leaf []byte, parent common.Hash) error
+
+// Sync is the main state trie synchronisation scheduler, which provides yet
+// unknown trie hashes to retrieve, accepts node data associated with said hashes
+// and reconstructs the trie step by step until all is done. Nodes are retrieved
+// breadth first, and only committed to the database once their entire subtrie
+// is present, so an interrupted sync can be resumed by starting a new one: any
+// node found in the database is known to be complete and is skipped.
+type Sync struct {
+	database common.Database          // State database for storing all the assembled node data
+	requests map[common.Hash]*request // Pending requests pertaining to a key hash
+	queue    *prque.Prque             // Priority queue with the pending requests
+}
+
+// NewSync creates a new trie data download scheduler.
+func NewSync(root common.Hash, database common.Database, callback SyncLeafCallback) *Sync {
+	ts := &Sync{
+		database: database,
+		requests: make(map[common.Hash]*request),
+		queue:    prque.New(),
+	}
+	ts.AddSubTrie(root, 0, common.Hash{}, callback)
+	return ts
+}
+
+// AddSubTrie registers a new trie to the sync code, rooted at the designated
+// parent. The parent will not be committed until the sub-trie completes.
+func (s *Sync) AddSubTrie(root common.Hash, depth int, parent common.Hash, callback SyncLeafCallback) {
+	// Short circuit if the trie is empty or already known
+	if root == emptyRoot || s.known(root) {
+		return
+	}
+	// Assemble the new sub-trie sync request
+	req := &request{
+		hash:     root,
+		depth:    depth,
+		callback: callback,
+	}
+	s.link(req, parent)
+	s.schedule(req, parent)
+}
+
+// AddRawEntry schedules the direct retrieval of a state entry that should not be
+// interpreted as a trie node, but rather accepted and stored into the database
+// as is. This method's goal is to support misc state metadata retrievals (e.g.
+// contract code).
+func (s *Sync) AddRawEntry(hash common.Hash, depth int, parent common.Hash) {
+	// Short circuit if the entry is empty or already known
+	if hash == emptyState || s.known(hash) {
+		return
+	}
+	// Assemble the new sub-trie sync request
+	req := &request{
+		hash:  hash,
+		raw:   true,
+		depth: depth,
+	}
+	s.link(req, parent)
+	s.schedule(req, parent)
+}
+
+// Missing retrieves the known missing nodes from the trie for retrieval.
+func (s *Sync) Missing(max int) []common.Hash {
+	requests := []common.Hash{}
+	for !s.queue.Empty() && (max == 0 || len(requests) < max) {
+		requests = append(requests, s.queue.PopItem().(common.Hash))
+	}
+	return requests
+}
+
+// Process injects a batch of retrieved trie nodes data, returning the index of
+// the first one that failed processing, if any.
+func (s *Sync) Process(results []SyncResult) (int, error) {
+	for i, item := range results {
+		// If the item was not requested, bail out
+		request := s.requests[item.Hash]
+		if request == nil {
+			return i, ErrNotRequested
+		}
+		if request.data != nil {
+			return i, ErrAlreadyProcessed
+		}
+		// Make sure the delivered data matches the requested hash
+		if crypto.Sha3Hash(item.Data) != item.Hash {
+			return i, fmt.Errorf("invalid node data for %x", item.Hash[:4])
+		}
+		// If the item is a raw entry request, commit directly
+		if request.raw {
+			request.data = item.Data
+			s.commit(request)
+			continue
+		}
+		// Decode the node data content and update the request
+		request.data = item.Data
+
+		// Create and schedule a request for all the children nodes
+		requests, err := s.children(request)
+		if err != nil {
+			return i, err
+		}
+		request.deps += len(requests)
+		for _, child := range requests {
+			s.schedule(child, request.hash)
+		}
+		if request.deps == 0 {
+			s.commit(request)
+		}
+	}
+	return 0, nil
+}
+
+// Pending returns the number of state entries currently pending for download.
+func (s *Sync) Pending() int {
+	return len(s.requests)
+}
+
+// link registers an externally added request as a dependency of its parent, so
+// the parent is only committed after the request completes.
+func (s *Sync) link(req *request, parent common.Hash) {
+	if parent != (common.Hash{}) {
+		ancestor := s.requests[parent]
+		if ancestor == nil {
+			panic(fmt.Sprintf("sub-trie ancestor not found: %x", parent))
+		}
+		ancestor.deps++
+	}
+}
+
+// schedule inserts a new state retrieval request into the fetch queue. If there
+// is already a pending request for this node, the new request will be discarded
+// and only a parent reference added to the old one.
+func (s *Sync) schedule(req *request, parent common.Hash) {
+	// Link the request to its parent, if any
+	if parent != (common.Hash{}) {
+		req.parents = append(req.parents, s.requests[parent])
+	}
+	// If we're already requesting this node, add a new reference and stop
+	if old, ok := s.requests[req.hash]; ok {
+		old.parents = append(old.parents, req.parents...)
+		return
+	}
+	// Schedule the request for future retrieval
+	s.queue.Push(req.hash, float32(-req.depth))
+	s.requests[req.hash] = req
+}
+
+// children retrieves all the missing children of a state trie entry for future
+// retrieval scheduling.
+func (s *Sync) children(req *request) ([]*request, error) {
+	var requests []*request
+	err := s.walk(req, req.data, req.depth, func(hash common.Hash, depth int) {
+		// Skip any subtries already completed in a previous sync
+		if s.known(hash) {
+			return
+		}
+		requests = append(requests, &request{
+			hash:     hash,
+			depth:    depth,
+			callback: req.callback,
+		})
+	})
+	return requests, err
+}
+
+// walk iterates over the references of an RLP encoded trie node, descending
+// into the nodes embedded within their parents. Hash references are reported
+// to the schedule function, and leaf values are passed to the request callback.
+func (s *Sync) walk(req *request, blob []byte, depth int, schedule func(common.Hash, int)) error {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return fmt.Errorf("invalid trie node %x: %v", req.hash[:4], err)
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return fmt.Errorf("invalid trie node %x: %v", req.hash[:4], err)
+	}
+	switch count {
+	case 2:
+		// Short node, either a leaf or an extension to a child node
+		key, rest, err := rlp.SplitString(elems)
+		if err != nil || len(key) == 0 {
+			return fmt.Errorf("invalid short node %x: key %x", req.hash[:4], key)
+		}
+		nibbles := CompactDecode(string(key))
+		if nibbles[len(nibbles)-1] == 16 {
+			value, _, err := rlp.SplitString(rest)
+			if err != nil {
+				return fmt.Errorf("invalid leaf in node %x: %v", req.hash[:4], err)
+			}
+			return s.leaf(req, value)
+		}
+		return s.walkRef(req, rest, depth+len(nibbles), schedule)
+
+	case 17:
+		// Full node, iterate over all the children and the value slot
+		for i := 0; i < 16; i++ {
+			ref, rest, err := splitRaw(elems)
+			if err != nil {
+				return fmt.Errorf("invalid full node %x: %v", req.hash[:4], err)
+			}
+			if err := s.walkRef(req, ref, depth+1, schedule); err != nil {
+				return err
+			}
+			elems = rest
+		}
+		value, _, err := rlp.SplitString(elems)
+		if err != nil {
+			return fmt.Errorf("invalid full node %x value: %v", req.hash[:4], err)
+		}
+		if len(value) > 0 {
+			return s.leaf(req, value)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("invalid trie node %x: %d elements", req.hash[:4], count)
+	}
+}
+
+// walkRef processes a child reference of a trie node, which is either empty, a
+// hash of the child node or the child node itself if its encoding is short.
+func (s *Sync) walkRef(req *request, ref []byte, depth int, schedule func(common.Hash, int)) error {
+	kind, content, _, err := rlp.Split(ref)
+	if err != nil {
+		return fmt.Errorf("invalid reference in node %x: %v", req.hash[:4], err)
+	}
+	switch {
+	case kind == rlp.List:
+		return s.walk(req, ref, depth, schedule)
+	case len(content) == 0:
+		return nil
+	case len(content) == len(common.Hash{}):
+		schedule(common.BytesToHash(content), depth)
+		return nil
+	default:
+		return fmt.Errorf("invalid reference in node %x: %x", req.hash[:4], content)
+	}
+}
+
+// leaf passes a leaf value reached during the trie walk to the request's
+// callback, if any.
+func (s *Sync) leaf(req *request, value []byte) error {
+	if req.callback == nil {
+		return nil
+	}
+	return req.callback(value, req.hash)
+}
+
+// known checks whether a node is already present in the database. As nodes are
+// only committed with their subtries complete, a present node needs no syncing.
+func (s *Sync) known(hash common.Hash) bool {
+	blob, _ := s.database.Get(hash.Bytes())
+	return len(blob) > 0
+}
+
+// commit finalizes a retrieval request and stores it into the database. If any
+// of the referencing parent requests complete due to this commit, they are also
+// committed themselves.
+func (s *Sync) commit(req *request) {
+	// Write the node content to disk
+	s.database.Put(req.hash[:], req.data)
+	delete(s.requests, req.hash)
+
+	// Check all parents for completion
+	for _, parent := range req.parents {
+		parent.deps--
+		if parent.deps == 0 {
+			s.commit(parent)
+		}
+	}
+}
+
+// splitRaw splits the first RLP value off the given list content, returning it
+// along with its encoding header.
+func splitRaw(b []byte) (elem, rest []byte, err error) {
+	_, _, rest, err = rlp.Split(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b[:len(b)-len(rest)], rest, nil
+}
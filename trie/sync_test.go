@@ -0,0 +1,344 @@
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// makeTestTrie create a sample test trie to test node-wise reconstruction.
+func makeTestTrie() (*ethdb.MemDatabase, *Trie, map[string][]byte) {
+	// Create an empty trie
+	db, _ := ethdb.NewMemDatabase()
+	trie := New(nil, db)
+
+	// Fill it with some arbitrary data
+	content := make(map[string][]byte)
+	for i := byte(0); i < 255; i++ {
+		key, val := common.LeftPadBytes([]byte{1, i}, 32), []byte{i}
+		content[string(key)] = val
+		trie.Update(key, val)
+
+		key, val = common.LeftPadBytes([]byte{2, i}, 32), []byte{i}
+		content[string(key)] = val
+		trie.Update(key, val)
+	}
+	trie.Commit()
+
+	// Return the generated trie
+	return db, trie, content
+}
+
+// checkTrieContents cross references a reconstructed trie with an expected data
+// content map.
+func checkTrieContents(t *testing.T, db Backend, root []byte, content map[string][]byte) {
+	trie := New(root, db)
+	for key, val := range content {
+		if have := trie.Get([]byte(key)); !bytes.Equal(have, val) {
+			t.Errorf("entry %x: content mismatch: have %x, want %x", key, have, val)
+		}
+	}
+}
+
+// syncTrieResults retrieves the data of the requested hashes from the source
+// database.
+func syncTrieResults(t *testing.T, db *ethdb.MemDatabase, hashes []common.Hash) []SyncResult {
+	results := make([]SyncResult, len(hashes))
+	for i, hash := range hashes {
+		data, err := db.Get(hash.Bytes())
+		if err != nil || len(data) == 0 {
+			t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+		}
+		results[i] = SyncResult{Hash: hash, Data: data}
+	}
+	return results
+}
+
+// Tests that an empty trie is not scheduled for syncing.
+func TestEmptyTrieSync(t *testing.T) {
+	emptyA := New(nil, Db(make(map[string][]byte)))
+	emptyB := New(emptyRoot.Bytes(), Db(make(map[string][]byte)))
+
+	for i, trie := range []*Trie{emptyA, emptyB} {
+		db, _ := ethdb.NewMemDatabase()
+		if req := NewSync(common.BytesToHash(trie.Hash()), db, nil).Missing(1); len(req) != 0 {
+			t.Errorf("test %d: content requested for empty trie: %v", i, req)
+		}
+	}
+}
+
+// Tests that given a root hash, a trie can sync iteratively on a single thread,
+// requesting retrieval tasks and returning all of them in one go.
+func TestIterativeTrieSyncIndividual(t *testing.T) { testIterativeTrieSync(t, 1) }
+func TestIterativeTrieSyncBatched(t *testing.T)    { testIterativeTrieSync(t, 100) }
+
+func testIterativeTrieSync(t *testing.T, batch int) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	queue := append([]common.Hash{}, sched.Missing(batch)...)
+	for len(queue) > 0 {
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = append(queue[:0], sched.Missing(batch)...)
+	}
+	if pending := sched.Pending(); pending != 0 {
+		t.Fatalf("sync finished with %d pending entries", pending)
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// Tests that the trie scheduler can correctly reconstruct the state even if only
+// partial results are returned, and the others sent only later.
+func TestIterativeDelayedTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	queue := append([]common.Hash{}, sched.Missing(10000)...)
+	for len(queue) > 0 {
+		// Sync only half of the scheduled nodes
+		results := syncTrieResults(t, srcDb, queue[:len(queue)/2+1])
+		if index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = append(queue[len(results):], sched.Missing(10000)...)
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// Tests that given a root hash, a trie can sync iteratively on a single thread,
+// requesting retrieval tasks and returning all of them in one go, however in a
+// random order.
+func TestIterativeRandomTrieSyncIndividual(t *testing.T) { testIterativeRandomTrieSync(t, 1) }
+func TestIterativeRandomTrieSyncBatched(t *testing.T)    { testIterativeRandomTrieSync(t, 100) }
+
+func testIterativeRandomTrieSync(t *testing.T, batch int) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	queue := make(map[common.Hash]struct{})
+	for _, hash := range sched.Missing(batch) {
+		queue[hash] = struct{}{}
+	}
+	for len(queue) > 0 {
+		// Fetch all the queued nodes in a random order (map iteration)
+		hashes := make([]common.Hash, 0, len(queue))
+		for hash, _ := range queue {
+			hashes = append(hashes, hash)
+		}
+		if index, err := sched.Process(syncTrieResults(t, srcDb, hashes)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = make(map[common.Hash]struct{})
+		for _, hash := range sched.Missing(batch) {
+			queue[hash] = struct{}{}
+		}
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// Tests that the trie nodes are scheduled breadth first, so no node is requested
+// before all the nodes closer to the root are.
+func TestBreadthFirstTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, _ := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	depth := 0
+	for queue := sched.Missing(1); len(queue) > 0; queue = sched.Missing(1) {
+		req := sched.requests[queue[0]]
+		if req.depth < depth {
+			t.Fatalf("node %x at depth %d requested after depth %d", queue[0][:4], req.depth, depth)
+		}
+		depth = req.depth
+
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+	}
+}
+
+// Tests that the trie scheduler can correctly reconstruct the state even if only
+// partial results are returned (Even those randomly), others sent only later.
+func TestDuplicateAvoidanceTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	requested := make(map[common.Hash]struct{})
+	queue := append([]common.Hash{}, sched.Missing(0)...)
+	for len(queue) > 0 {
+		for _, hash := range queue {
+			if _, ok := requested[hash]; ok {
+				t.Errorf("hash %x already requested once", hash)
+			}
+			requested[hash] = struct{}{}
+		}
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = append(queue[:0], sched.Missing(0)...)
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// Tests that invalid or unrequested node data is rejected without corrupting
+// the sync.
+func TestInvalidTrieSyncData(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	queue := sched.Missing(0)
+	if _, err := sched.Process([]SyncResult{{Hash: common.Hash{1}, Data: []byte{1}}}); err != ErrNotRequested {
+		t.Errorf("unrequested node error mismatch: have %v, want %v", err, ErrNotRequested)
+	}
+	if _, err := sched.Process([]SyncResult{{Hash: queue[0], Data: []byte{1}}}); err == nil {
+		t.Errorf("node with invalid content accepted")
+	}
+	for len(queue) > 0 {
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		queue = sched.Missing(0)
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// Tests that at any point in time during a sync, only complete sub-tries are in
+// the database.
+func TestIncompleteTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, _ := makeTestTrie()
+
+	// Create a destination trie and sync with the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(common.BytesToHash(srcTrie.Root()), dstDb, nil)
+
+	added := []common.Hash{}
+	queue := append([]common.Hash{}, sched.Missing(1)...)
+	for len(queue) > 0 {
+		// Process each of the trie nodes
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		for _, hash := range queue {
+			if data, _ := dstDb.Get(hash.Bytes()); len(data) > 0 {
+				added = append(added, hash)
+			}
+		}
+		// Check that all known sub-tries in the synced trie is complete
+		for _, root := range added {
+			if err := checkTrieComplete(dstDb, root); err != nil {
+				t.Fatalf("trie inconsistent: %v", err)
+			}
+		}
+		// Fetch the next batch to retrieve
+		queue = append(queue[:0], sched.Missing(1)...)
+	}
+	if data, _ := dstDb.Get(srcTrie.Root()); len(data) == 0 {
+		t.Fatalf("trie root not committed after sync")
+	}
+}
+
+// Tests that a sync interrupted midway can be resumed with a new scheduler on
+// the same database, without retrieving any of the completed sub-tries again.
+func TestResumeTrieSync(t *testing.T) {
+	// Create a random trie to copy
+	srcDb, srcTrie, srcData := makeTestTrie()
+	root := common.BytesToHash(srcTrie.Root())
+
+	// Sync a part of the trie and abandon the scheduler
+	dstDb, _ := ethdb.NewMemDatabase()
+	sched := NewSync(root, dstDb, nil)
+
+	committed := 0
+	for queue := sched.Missing(4); committed == 0; queue = sched.Missing(4) {
+		if len(queue) == 0 {
+			t.Fatalf("trie synced before interruption")
+		}
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		for _, hash := range queue {
+			if data, _ := dstDb.Get(hash.Bytes()); len(data) > 0 {
+				committed++
+			}
+		}
+	}
+	if data, _ := dstDb.Get(root.Bytes()); len(data) > 0 {
+		t.Fatalf("trie root committed before interruption")
+	}
+	// Resume the sync with a fresh scheduler and make sure no completed node is
+	// requested again
+	sched = NewSync(root, dstDb, nil)
+	for queue := sched.Missing(0); len(queue) > 0; queue = sched.Missing(0) {
+		for _, hash := range queue {
+			if data, _ := dstDb.Get(hash.Bytes()); len(data) > 0 {
+				t.Errorf("completed node %x requested again", hash[:4])
+			}
+		}
+		if index, err := sched.Process(syncTrieResults(t, srcDb, queue)); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+	}
+	// Cross check that the two tries are in sync
+	checkTrieContents(t, dstDb, srcTrie.Root(), srcData)
+}
+
+// checkTrieComplete iterates over a sub-trie and ensures that all its nodes are
+// present in the database.
+func checkTrieComplete(db *ethdb.MemDatabase, root common.Hash) error {
+	data, _ := db.Get(root.Bytes())
+	if len(data) == 0 {
+		return errMissingNode(root)
+	}
+	sched := &Sync{database: db, requests: make(map[common.Hash]*request)}
+
+	var missing error
+	err := sched.walk(&request{hash: root}, data, 0, func(hash common.Hash, depth int) {
+		if missing == nil {
+			missing = checkTrieComplete(db, hash)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return missing
+}
+
+// errMissingNode is returned by checkTrieComplete for a node not in the database.
+type errMissingNode common.Hash
+
+func (e errMissingNode) Error() string {
+	return "missing trie node " + common.Hash(e).Hex()
+}
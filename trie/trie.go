@@ -27,6 +27,11 @@ type Trie struct {
 	roothash []byte
 	cache    *Cache
 
+	// On demand retrieval of missing nodes, see OdrBackend.
+	odr     OdrBackend
+	odrRoot []byte
+	odrErr  error // first retrieval failure
+
 	revisions *list.List
 }
 
@@ -38,7 +43,14 @@ func New(root []byte, backend Backend) *Trie {
 		trie.cache = NewCache(backend)
 	}
 
-	if root != nil {
+	if odr, ok := backend.(OdrBackend); ok && root != nil {
+		// The root node may need to be retrieved, so leave it unresolved
+		// until the trie is accessed.
+		if hash := common.BytesToHash(root); hash != emptyRoot && hash != (common.Hash{}) {
+			trie.odr, trie.odrRoot = odr, root
+			trie.root = NewHash(root, trie)
+		}
+	} else if root != nil {
 		value := common.NewValueFromBytes(trie.cache.Get(root))
 		trie.root = trie.mknode(value)
 	}
@@ -46,6 +58,29 @@ func New(root []byte, backend Backend) *Trie {
 	return trie
 }
 
+// retrieve asks the ODR backend, if any, for the nodes on the path of key
+// in the trie the instance was created with, and resolves the root node.
+// Nodes that can't be retrieved read as empty, the failure is reported by
+// Error.
+func (self *Trie) retrieve(key []byte) {
+	if self.odr != nil {
+		if err := self.odr.RetrieveProof(self.odrRoot, key); err != nil && self.odrErr == nil {
+			self.odrErr = err
+		}
+	}
+	if hash, ok := self.root.(*HashNode); ok {
+		self.root = self.trans(hash)
+	}
+}
+
+// Error returns the first failure to retrieve missing nodes from the ODR
+// backend. Values read from the trie after a failure may be missing.
+func (self *Trie) Error() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.odrErr
+}
+
 func (self *Trie) Iterator() *Iterator {
 	return NewIterator(self)
 }
@@ -55,6 +90,7 @@ func (self *Trie) Copy() *Trie {
 	copy(cpy, self.roothash)
 	trie := New(nil, nil)
 	trie.cache = self.cache.Copy()
+	trie.odr, trie.odrRoot, trie.odrErr = self.odr, self.odrRoot, self.odrErr
 	if self.root != nil {
 		trie.root = self.root.Copy(trie)
 	}
@@ -114,6 +150,7 @@ func (self *Trie) Update(key, value []byte) Node {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	self.retrieve(key)
 	k := CompactHexDecode(string(key))
 
 	if len(value) != 0 {
@@ -130,6 +167,7 @@ func (self *Trie) Get(key []byte) []byte {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	self.retrieve(key)
 	k := CompactHexDecode(string(key))
 
 	n := self.get(self.root, k)
@@ -145,6 +183,7 @@ func (self *Trie) Delete(key []byte) Node {
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
+	self.retrieve(key)
 	k := CompactHexDecode(string(key))
 	self.root = self.delete(self.root, k)
 
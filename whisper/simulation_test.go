@@ -0,0 +1,58 @@
+package whisper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// Tests that messages are relayed across multiple hops.
+func TestChainRouting(t *testing.T) {
+	const n = 4
+	net := simulations.NewNetwork(1)
+	defer net.Shutdown()
+
+	whispers := make([]*Whisper, n)
+	nodes := make([]*simulations.Node, n)
+	for i := range nodes {
+		whispers[i] = New()
+		whispers[i].Start()
+		defer whispers[i].Stop()
+
+		node, err := net.NewNode(simulations.NodeConfig{Protocols: []p2p.Protocol{whispers[i].Protocol()}})
+		if err != nil {
+			t.Fatalf("failed to create node %d: %v", i, err)
+		}
+		nodes[i] = node
+	}
+	net.ConnectChain(nodes)
+
+	// Watch for the message at the far end of the chain.
+	sender, recipient := whispers[0], whispers[n-1]
+	recipientId := recipient.NewIdentity()
+	done := make(chan struct{})
+	recipient.Watch(Filter{
+		To: &recipientId.PublicKey,
+		Fn: func(msg *Message) { close(done) },
+	})
+
+	msg := NewMessage([]byte("multi-hop whisper"))
+	envelope, err := msg.Wrap(DefaultPoW, Options{
+		From: sender.NewIdentity(),
+		To:   &recipientId.PublicKey,
+		TTL:  DefaultTTL,
+	})
+	if err != nil {
+		t.Fatalf("failed to wrap message: %v", err)
+	}
+	if err := sender.Send(envelope); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message receive timeout")
+	}
+}
@@ -18,6 +18,12 @@ func (self *State) State() *state.StateDB {
 	return self.state
 }
 
+// Error returns the failure to retrieve state from the network of a light
+// client, see state.StateDB.Error.
+func (self *State) Error() error {
+	return self.state.Error()
+}
+
 func (self *State) Get(addr string) *Object {
 	return &Object{self.state.GetStateObject(common.HexToAddress(addr))}
 }
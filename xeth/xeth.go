@@ -17,6 +17,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/event/filter"
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
@@ -296,18 +297,33 @@ func (self *XEth) getBlockByHeight(height int64) *types.Block {
 
 func (self *XEth) BlockByHash(strHash string) *Block {
 	hash := common.HexToHash(strHash)
-	block := self.backend.ChainManager().GetBlock(hash)
+	block := self.withBody(self.backend.ChainManager().GetBlock(hash))
 
 	return NewBlock(block)
 }
 
 func (self *XEth) EthBlockByHash(strHash string) *types.Block {
 	hash := common.HexToHash(strHash)
-	block := self.backend.ChainManager().GetBlock(hash)
+	block := self.withBody(self.backend.ChainManager().GetBlock(hash))
 
 	return block
 }
 
+// withBody retrieves the body of a header-only block of a light client. Blocks
+// are returned unchanged if not running in light mode or if retrieval fails.
+func (self *XEth) withBody(block *types.Block) *types.Block {
+	client := self.backend.LightClient()
+	if client == nil || block == nil {
+		return block
+	}
+	full, err := client.RetrieveBlock(block)
+	if err != nil {
+		glog.V(logger.Debug).Infof("block #%v body retrieval failed: %v\n", block.Number(), err)
+		return block
+	}
+	return full
+}
+
 func (self *XEth) EthTransactionByHash(hash string) (tx *types.Transaction, blhash common.Hash, blnum *big.Int, txi uint64) {
 	// Due to increasing return params and need to determine if this is from transaction pool or
 	// some chain, this probably needs to be refactored for more expressiveness
@@ -344,11 +360,11 @@ func (self *XEth) EthTransactionByHash(hash string) (tx *types.Transaction, blha
 }
 
 func (self *XEth) BlockByNumber(num int64) *Block {
-	return NewBlock(self.getBlockByHeight(num))
+	return NewBlock(self.withBody(self.getBlockByHeight(num)))
 }
 
 func (self *XEth) EthBlockByNumber(num int64) *types.Block {
-	return self.getBlockByHeight(num)
+	return self.withBody(self.getBlockByHeight(num))
 }
 
 func (self *XEth) CurrentBlock() *types.Block {
@@ -356,6 +372,13 @@ func (self *XEth) CurrentBlock() *types.Block {
 }
 
 func (self *XEth) GetBlockReceipts(bhash common.Hash) (receipts types.Receipts, err error) {
+	if client := self.backend.LightClient(); client != nil {
+		block := self.backend.ChainManager().GetBlock(bhash)
+		if block == nil {
+			return nil, fmt.Errorf("unknown block %x", bhash)
+		}
+		return client.RetrieveReceipts(block)
+	}
 	return self.backend.BlockProcessor().GetBlockReceipts(bhash)
 }
 
@@ -431,6 +454,11 @@ func (self *XEth) IsMining() bool {
 	return self.backend.IsMining()
 }
 
+// SyncProgress retrieves the boundaries of the current or last block sync.
+func (self *XEth) SyncProgress() downloader.SyncProgress {
+	return self.backend.Downloader().Progress()
+}
+
 func (self *XEth) HashRate() int64 {
 	return self.backend.Miner().HashRate()
 }